@@ -0,0 +1,86 @@
+package all712
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is the machine-readable reason of a failed verification or settlement,
+// what goes into invalidReason and errorReason
+type ErrorCode string
+
+const (
+	// the payment
+	CodeInvalidPayload    ErrorCode = "invalid_payload" // malformed payload or requirements
+	CodeInvalidVersion    ErrorCode = "invalid_x402_version"
+	CodeUnsupportedScheme ErrorCode = "unsupported_scheme"
+	CodeInvalidNetwork    ErrorCode = "invalid_network"
+	CodeInvalidSignature  ErrorCode = "invalid_signature"
+	CodeAmountMismatch    ErrorCode = "amount_mismatch"    // authorized amount is not the required one
+	CodeRecipientMismatch ErrorCode = "recipient_mismatch" // authorization does not pay payTo
+	CodeNotYetValid       ErrorCode = "not_yet_valid"
+	CodeExpired           ErrorCode = "expired"
+	CodeNonceUsed         ErrorCode = "nonce_used"
+	CodeInsufficientFunds ErrorCode = "insufficient_funds"
+	CodeNoAllowance       ErrorCode = "insufficient_allowance" // the payer did not approve Permit2 for the amount
+	CodeMarkupNotCovered  ErrorCode = "markup_not_covered"     // the amount leaves no facilitator its markup
+	CodeSettlementReverts ErrorCode = "settlement_reverts"     // the settlement transaction would revert
+
+	// the facilitator
+	CodeRPCUnavailable         ErrorCode = "rpc_unavailable"
+	CodeFacilitatorUnavailable ErrorCode = "facilitator_unavailable" // no wallet free to settle
+	CodeFeeTooHigh             ErrorCode = "fee_too_high"            // gas would cost more than the payment allows
+	CodeUnexpected             ErrorCode = "unexpected_error"
+)
+
+// Error carries the code along with the details for humans
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+// Errorf makes an Error of the code, the format takes %w like fmt.Errorf
+func Errorf(code ErrorCode, format string, args ...any) *Error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// WithCode gives err the code, unless it already has one
+func WithCode(code ErrorCode, err error) error {
+	var e *Error
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+	return &Error{Code: code, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return string(e.Code)
+	}
+	return string(e.Code) + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is matches the bare code errors: errors.Is(err, &Error{Code: CodeNonceUsed})
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Err == nil && t.Code == e.Code
+}
+
+// CodeOf is the code of the error, CodeUnexpected if it has none
+func CodeOf(err error) ErrorCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return CodeUnexpected
+}
+
+// Details is the error without its code in front
+func Details(err error) string {
+	if e, ok := err.(*Error); ok && e.Err != nil {
+		return e.Err.Error()
+	}
+	return err.Error()
+}
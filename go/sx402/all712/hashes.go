@@ -9,20 +9,38 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
+// built once at startup, so a request never gets to mustNewType
+var (
+	addressType = mustNewType("address")
+	uint256Type = mustNewType("uint256")
+	bytes32Type = mustNewType("bytes32")
+)
+
 var eip712DomainTypeHash = crypto.Keccak256Hash([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)"))
 var transferTypeHash = crypto.Keccak256Hash([]byte("TransferWithAuthorization(address from,address to,uint256 value,uint256 validAfter,uint256 validBefore,bytes32 nonce)"))
 var permitTypeHash = crypto.Keccak256Hash([]byte("Permit(address owner,address spender,uint256 value,uint256 nonce,uint256 deadline)"))
 
+// Permit2 has a domain without version, and its witness transfers add the witness type to a fixed stub
+var permit2DomainTypeHash = crypto.Keccak256Hash([]byte("EIP712Domain(string name,uint256 chainId,address verifyingContract)"))
+var tokenPermissionsTypeHash = crypto.Keccak256Hash([]byte("TokenPermissions(address token,uint256 amount)"))
+var paymentWitnessTypeHash = crypto.Keccak256Hash([]byte("PaymentWitness(address payTo,string resource)"))
+
+// Permit2WitnessTypeString goes along with the signature to permitWitnessTransferFrom, referenced types in alphabetical order
+const Permit2WitnessTypeString = "PaymentWitness witness)PaymentWitness(address payTo,string resource)TokenPermissions(address token,uint256 amount)"
+
+var permit2WitnessTransferTypeHash = crypto.Keccak256Hash([]byte(
+	"PermitWitnessTransferFrom(TokenPermissions permitted,address spender,uint256 nonce,uint256 deadline," + Permit2WitnessTypeString))
+
 func EIP3009TransferHash(from, to, tokenAddress common.Address, value, after, before, chainID *big.Int, nonce [32]byte, name, version string) ([]byte, error) {
 
 	// Encode struct hash
 	arguments := abi.Arguments{
-		{Type: mustNewType("address")},
-		{Type: mustNewType("address")},
-		{Type: mustNewType("uint256")},
-		{Type: mustNewType("uint256")},
-		{Type: mustNewType("uint256")},
-		{Type: mustNewType("bytes32")},
+		{Type: addressType},
+		{Type: addressType},
+		{Type: uint256Type},
+		{Type: uint256Type},
+		{Type: uint256Type},
+		{Type: bytes32Type},
 	}
 
 	packed, err := arguments.Pack(
@@ -40,12 +58,15 @@ func EIP3009TransferHash(from, to, tokenAddress common.Address, value, after, be
 	structHash := crypto.Keccak256Hash(append(transferTypeHash.Bytes(), packed...))
 	//structHash := crypto.Keccak256Hash(packed)
 	// EIP-712 domain separator
-	domainSeparator := MakeDomainSeparator(name, version, chainID, tokenAddress)
+	separator, err := domainSeparator(name, version, chainID, tokenAddress)
+	if err != nil {
+		return nil, err
+	}
 
 	// Final digest (EIP-191)
 	digestBytes := crypto.Keccak256(
 		[]byte("\x19\x01"),
-		domainSeparator.Bytes(),
+		separator.Bytes(),
 		structHash.Bytes(),
 	)
 	return digestBytes, nil
@@ -58,11 +79,11 @@ func EIP712PermitHash(owner, spender, tokenAddress common.Address,
 
 	// Encode struct hash
 	arguments := abi.Arguments{
-		{Type: mustNewType("address")}, //owner
-		{Type: mustNewType("address")}, //spender
-		{Type: mustNewType("uint256")}, //value
-		{Type: mustNewType("uint256")}, //nonce
-		{Type: mustNewType("uint256")}, //deadline
+		{Type: addressType}, //owner
+		{Type: addressType}, //spender
+		{Type: uint256Type}, //value
+		{Type: uint256Type}, //nonce
+		{Type: uint256Type}, //deadline
 	}
 
 	packed, err := arguments.Pack(
@@ -79,18 +100,30 @@ func EIP712PermitHash(owner, spender, tokenAddress common.Address,
 	structHash := crypto.Keccak256Hash(append(permitTypeHash.Bytes(), packed...))
 	//structHash := crypto.Keccak256Hash(packed)
 	// EIP-712 domain separator
-	domainSeparator := MakeDomainSeparator(name, version, chainID, tokenAddress)
+	separator, err := domainSeparator(name, version, chainID, tokenAddress)
+	if err != nil {
+		return nil, err
+	}
 
 	// Final digest (EIP-191)
 	digestBytes := crypto.Keccak256(
 		[]byte("\x19\x01"),
-		domainSeparator.Bytes(),
+		separator.Bytes(),
 		structHash.Bytes(),
 	)
 	return digestBytes, nil
 }
 
+// MakeDomainSeparator logs and returns the zero hash if the domain cannot be packed (e.g. no chainID)
 func MakeDomainSeparator(name, version string, chainID *big.Int, verifyingContract common.Address) common.Hash {
+	hash, err := domainSeparator(name, version, chainID, verifyingContract)
+	if err != nil {
+		log.Println(err)
+	}
+	return hash
+}
+
+func domainSeparator(name, version string, chainID *big.Int, verifyingContract common.Address) (common.Hash, error) {
 	// keccak256("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)")
 	//typeHash := crypto.Keccak256Hash([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)"))
 
@@ -99,10 +132,10 @@ func MakeDomainSeparator(name, version string, chainID *big.Int, verifyingContra
 
 	arguments := abi.Arguments{
 
-		{Type: mustNewType("bytes32")},
-		{Type: mustNewType("bytes32")},
-		{Type: mustNewType("uint256")},
-		{Type: mustNewType("address")},
+		{Type: bytes32Type},
+		{Type: bytes32Type},
+		{Type: uint256Type},
+		{Type: addressType},
 	}
 
 	packed, err := arguments.Pack(
@@ -112,10 +145,10 @@ func MakeDomainSeparator(name, version string, chainID *big.Int, verifyingContra
 		verifyingContract,
 	)
 	if err != nil {
-		log.Fatalf("Domain separator packing failed: %v", err)
+		return common.Hash{}, Errorf(CodeInvalidPayload, "domain separator packing failed: %w", err)
 	}
 
-	return crypto.Keccak256Hash(append(eip712DomainTypeHash.Bytes(), packed...))
+	return crypto.Keccak256Hash(append(eip712DomainTypeHash.Bytes(), packed...)), nil
 }
 func CrossChainTransferAuthorizationHash(
 	from, to, tokenAddress common.Address,
@@ -132,14 +165,14 @@ func CrossChainTransferAuthorizationHash(
 
 	// Solidity-style uint16 is packed as uint256 in abi.Pack
 	arguments := abi.Arguments{
-		{Type: mustNewType("address")}, // from
-		{Type: mustNewType("address")}, // to
-		{Type: mustNewType("uint256")}, // amount
-		{Type: mustNewType("uint256")}, // minimalAmount
-		{Type: mustNewType("uint256")}, // destinationChain
-		{Type: mustNewType("uint256")}, // validAfter
-		{Type: mustNewType("uint256")}, // validBefore
-		{Type: mustNewType("bytes32")}, // nonce
+		{Type: addressType}, // from
+		{Type: addressType}, // to
+		{Type: uint256Type}, // amount
+		{Type: uint256Type}, // minimalAmount
+		{Type: uint256Type}, // destinationChain
+		{Type: uint256Type}, // validAfter
+		{Type: uint256Type}, // validBefore
+		{Type: bytes32Type}, // nonce
 	}
 
 	packed, err := arguments.Pack(
@@ -162,14 +195,84 @@ func CrossChainTransferAuthorizationHash(
 	)
 
 	// Compute domain separator
-	domainSeparator := MakeDomainSeparator(name, version, chainID, tokenAddress)
+	separator, err := domainSeparator(name, version, chainID, tokenAddress)
+	if err != nil {
+		return nil, err
+	}
 
 	// Compute EIP-712 digest
 	digest := crypto.Keccak256(
 		[]byte("\x19\x01"),
-		domainSeparator.Bytes(),
+		separator.Bytes(),
 		structHash.Bytes(),
 	)
 
 	return digest, nil
 }
+
+// PaymentWitnessHash is the witness argument of permitWitnessTransferFrom
+func PaymentWitnessHash(witness PaymentWitness) (common.Hash, error) {
+	packed, err := abi.Arguments{{Type: bytes32Type}, {Type: addressType}, {Type: bytes32Type}}.Pack(
+		paymentWitnessTypeHash,
+		witness.PayTo,
+		crypto.Keccak256Hash([]byte(witness.Resource)),
+	)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(packed), nil
+}
+
+// Permit2WitnessTransferHash is the digest of a Permit2 PermitWitnessTransferFrom with a PaymentWitness.
+// The spender is whoever calls Permit2, the facilitator wallet.
+func Permit2WitnessTransferHash(token, spender, permit2 common.Address, amount, nonce, deadline, chainID *big.Int, witness PaymentWitness) ([]byte, error) {
+	permissions, err := abi.Arguments{{Type: bytes32Type}, {Type: addressType}, {Type: uint256Type}}.Pack(
+		tokenPermissionsTypeHash,
+		token,
+		amount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	witnessHash, err := PaymentWitnessHash(witness)
+	if err != nil {
+		return nil, err
+	}
+
+	arguments := abi.Arguments{
+		{Type: bytes32Type}, // type hash
+		{Type: bytes32Type}, // permitted
+		{Type: addressType}, // spender
+		{Type: uint256Type}, // nonce
+		{Type: uint256Type}, // deadline
+		{Type: bytes32Type}, // witness
+	}
+	packed, err := arguments.Pack(
+		permit2WitnessTransferTypeHash,
+		crypto.Keccak256Hash(permissions),
+		spender,
+		nonce,
+		deadline,
+		witnessHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	structHash := crypto.Keccak256Hash(packed)
+
+	domain, err := abi.Arguments{{Type: bytes32Type}, {Type: bytes32Type}, {Type: uint256Type}, {Type: addressType}}.Pack(
+		permit2DomainTypeHash,
+		crypto.Keccak256Hash([]byte("Permit2")),
+		chainID,
+		permit2,
+	)
+	if err != nil {
+		return nil, Errorf(CodeInvalidPayload, "domain separator packing failed: %w", err)
+	}
+
+	return crypto.Keccak256(
+		[]byte("\x19\x01"),
+		crypto.Keccak256(domain),
+		structHash.Bytes(),
+	), nil
+}
@@ -40,6 +40,10 @@ type Envelope struct {
 	X402Version         int                        `json:"x402Version"`
 	PaymentPayload      *PaymentPayload            `json:"paymentPayload"`
 	PaymentRequirements *types.PaymentRequirements `json:"paymentRequirements"`
+	// v2 only, the extensions the client sent along
+	Extensions map[string]json.RawMessage `json:"-"`
+	// upto schemes only, what the resource server settles for out of the authorized ceiling (MaxAmountRequired)
+	ConsumedAmount string `json:"consumedAmount,omitempty"`
 }
 
 type PaymentPayload struct {
@@ -125,3 +129,40 @@ func (ccam *CrossChainTransferMessage) Digest() ([]byte, error) {
 		ccam.Domain.Version,
 	)
 }
+
+// Permit2Message is a Uniswap Permit2 permitWitnessTransferFrom; it follows the Permit message design.
+// The witness binds the transfer to the payment, so the spender cannot send it anywhere else.
+type Permit2Message struct {
+	Domain    Domain          `json:"domain"` // name "Permit2", no version, verifyingContract is the Permit2 deployment
+	Message   Permit2Transfer `json:"message"`
+	Nonce     *big.Int        `json:"nonce"` // unordered, any unused bit of the owner's nonce bitmap
+	Signature string          `json:"signature,omitempty"`
+}
+
+type Permit2Transfer struct {
+	Owner    common.Address `json:"owner"`
+	Token    common.Address `json:"token"`
+	Amount   *big.Int       `json:"amount"`
+	Spender  common.Address `json:"spender"`
+	Deadline *big.Int       `json:"deadline"`
+	Witness  PaymentWitness `json:"witness"`
+}
+
+// PaymentWitness is what the payer pays for, and whom
+type PaymentWitness struct {
+	PayTo    common.Address `json:"payTo"`
+	Resource string         `json:"resource"`
+}
+
+func (p2 *Permit2Message) Digest() ([]byte, error) {
+	return Permit2WitnessTransferHash(
+		p2.Message.Token,
+		p2.Message.Spender,
+		p2.Domain.VerifyingContract,
+		p2.Message.Amount,
+		p2.Nonce,
+		p2.Message.Deadline,
+		p2.Domain.ChainID,
+		p2.Message.Witness,
+	)
+}
@@ -0,0 +1,196 @@
+package all712
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/coinbase/x402/go/pkg/types"
+)
+
+// x402 v2 moves the resource out of the requirements, renames maxAmountRequired to amount,
+// uses CAIP-2 network ids ("eip155:84532") and has the payload carry the requirements it accepted.
+// Internally everything stays v1 shaped: the decoders below translate, the encoders translate back.
+
+// The network table lives in evmbinding, which plugs these in.
+// Without it the networks go through untranslated.
+var NetworkCAIP2 = func(network string) (string, bool) { return "", false }
+var NetworkName = func(id string) (string, bool) { return id, false }
+
+// ResourceInfo is the paid resource, v2 keeps it next to the requirements
+type ResourceInfo struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+type PaymentRequirementsV2 struct {
+	Scheme            string           `json:"scheme"`
+	Network           string           `json:"network"`
+	Amount            string           `json:"amount"`
+	Asset             string           `json:"asset"`
+	PayTo             string           `json:"payTo"`
+	MaxTimeoutSeconds int              `json:"maxTimeoutSeconds"`
+	Extra             *json.RawMessage `json:"extra,omitempty"`
+}
+
+type PaymentPayloadV2 struct {
+	X402Version int                        `json:"x402Version"`
+	Resource    *ResourceInfo              `json:"resource,omitempty"`
+	Accepted    *PaymentRequirementsV2     `json:"accepted"`
+	Payload     json.RawMessage            `json:"payload"`
+	Extensions  map[string]json.RawMessage `json:"extensions,omitempty"`
+}
+
+// PaymentRequired is the v2 body of a 402, sent base64 encoded in the PAYMENT-REQUIRED header
+type PaymentRequired struct {
+	X402Version int                        `json:"x402Version"`
+	Error       string                     `json:"error,omitempty"`
+	Resource    *ResourceInfo              `json:"resource,omitempty"`
+	Accepts     []*PaymentRequirementsV2   `json:"accepts"`
+	Extensions  map[string]json.RawMessage `json:"extensions,omitempty"`
+}
+
+// ToV2 translates v1 requirements; the resource fields go to the ResourceInfo
+func ToV2(req *types.PaymentRequirements) (*PaymentRequirementsV2, *ResourceInfo) {
+	network := req.Network
+	if caip, ok := NetworkCAIP2(req.Network); ok {
+		network = caip
+	}
+	return &PaymentRequirementsV2{
+		Scheme:            req.Scheme,
+		Network:           network,
+		Amount:            req.MaxAmountRequired,
+		Asset:             req.Asset,
+		PayTo:             req.PayTo,
+		MaxTimeoutSeconds: req.MaxTimeoutSeconds,
+		Extra:             req.Extra,
+	}, &ResourceInfo{
+		URL:         req.Resource,
+		Description: req.Description,
+		MimeType:    req.MimeType,
+	}
+}
+
+// FromV2 translates v2 requirements back, with the network as a plain name
+func FromV2(req *PaymentRequirementsV2, resource *ResourceInfo) *types.PaymentRequirements {
+	network, _ := NetworkName(req.Network)
+	v1 := &types.PaymentRequirements{
+		Scheme:            req.Scheme,
+		Network:           network,
+		MaxAmountRequired: req.Amount,
+		Asset:             req.Asset,
+		PayTo:             req.PayTo,
+		MaxTimeoutSeconds: req.MaxTimeoutSeconds,
+		Extra:             req.Extra,
+	}
+	if resource != nil {
+		v1.Resource, v1.Description, v1.MimeType = resource.URL, resource.Description, resource.MimeType
+	}
+	return v1
+}
+
+// WireNetwork is the network as a client of the given version expects it
+func WireNetwork(version int, network string) string {
+	if version >= 2 {
+		if caip, ok := NetworkCAIP2(network); ok {
+			return caip
+		}
+	}
+	return network
+}
+
+// ParsePaymentPayload reads the payment a client sent, v1 or v2, into the v1 shape.
+// For v2 it also returns the requirements the client accepted and the resource it paid for.
+func ParsePaymentPayload(data []byte) (*PaymentPayload, *PaymentPayloadV2, error) {
+	var version struct {
+		X402Version int `json:"x402Version"`
+	}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, nil, err
+	}
+	if version.X402Version < 2 {
+		ppld := new(PaymentPayload)
+		err := json.Unmarshal(data, ppld)
+		return ppld, nil, err
+	}
+	v2 := new(PaymentPayloadV2)
+	if err := json.Unmarshal(data, v2); err != nil {
+		return nil, nil, err
+	}
+	if v2.Accepted == nil {
+		return nil, nil, fmt.Errorf("v2 payment payload without the accepted requirements")
+	}
+	network, _ := NetworkName(v2.Accepted.Network)
+	return &PaymentPayload{X402Version: v2.X402Version, Scheme: v2.Accepted.Scheme, Network: network, Payload: v2.Payload}, v2, nil
+}
+
+// the v2 body of /verify and /settle
+type envelopeV2 struct {
+	X402Version         int                    `json:"x402Version"`
+	PaymentPayload      *PaymentPayloadV2      `json:"paymentPayload"`
+	PaymentRequirements *PaymentRequirementsV2 `json:"paymentRequirements"`
+	ConsumedAmount      string                 `json:"consumedAmount,omitempty"`
+}
+
+// the default encoding, without the methods below
+type envelopeV1 Envelope
+
+func (env *Envelope) UnmarshalJSON(data []byte) error {
+	var version struct {
+		X402Version int `json:"x402Version"`
+	}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return err
+	}
+	if version.X402Version < 2 {
+		return json.Unmarshal(data, (*envelopeV1)(env))
+	}
+
+	v2 := new(envelopeV2)
+	if err := json.Unmarshal(data, v2); err != nil {
+		return err
+	}
+	if v2.PaymentPayload == nil || v2.PaymentRequirements == nil {
+		return fmt.Errorf("v2 envelope needs paymentPayload and paymentRequirements")
+	}
+	accepted := v2.PaymentPayload.Accepted
+	if accepted == nil {
+		accepted = v2.PaymentRequirements
+	}
+	network, _ := NetworkName(accepted.Network)
+	*env = Envelope{
+		X402Version: v2.X402Version,
+		PaymentPayload: &PaymentPayload{
+			X402Version: v2.PaymentPayload.X402Version,
+			Scheme:      accepted.Scheme,
+			Network:     network,
+			Payload:     v2.PaymentPayload.Payload,
+		},
+		PaymentRequirements: FromV2(v2.PaymentRequirements, v2.PaymentPayload.Resource),
+		Extensions:          v2.PaymentPayload.Extensions,
+		ConsumedAmount:      v2.ConsumedAmount,
+	}
+	return nil
+}
+
+func (env Envelope) MarshalJSON() ([]byte, error) {
+	if env.X402Version < 2 {
+		return json.Marshal(envelopeV1(env))
+	}
+	if env.PaymentPayload == nil || env.PaymentRequirements == nil {
+		return nil, fmt.Errorf("v2 envelope needs paymentPayload and paymentRequirements")
+	}
+	req, resource := ToV2(env.PaymentRequirements)
+	return json.Marshal(envelopeV2{
+		X402Version: env.X402Version,
+		PaymentPayload: &PaymentPayloadV2{
+			X402Version: env.PaymentPayload.X402Version,
+			Resource:    resource,
+			Accepted:    req,
+			Payload:     env.PaymentPayload.Payload,
+			Extensions:  env.Extensions,
+		},
+		PaymentRequirements: req,
+		ConsumedAmount:      env.ConsumedAmount,
+	})
+}
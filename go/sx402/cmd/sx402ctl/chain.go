@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/oftcc"
+)
+
+func token(network, asset string) (*oftcc.Oftcc, error) {
+	client, err := evmbinding.GetClientByNetwork(network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to rpc: %w", err)
+	}
+	return oftcc.NewOftcc(common.HexToAddress(asset), client)
+}
+
+func bigArg(name, value string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(value, 10)
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("bad -%s: %q", name, value)
+	}
+	return n, nil
+}
+
+func markup(args []string) error {
+	fs := flag.NewFlagSet("markup", flag.ExitOnError)
+	network := fs.String("network", "", "")
+	asset := fs.String("asset", "", "OFT3009CC token address")
+	of := fs.String("of", "", "facilitator account the markup is set for")
+	dstEid := fs.Uint("dstEid", 0, "LayerZero destination, 0 - the local markup")
+	fs.Parse(args)
+	if err := required(fs, "network", "asset", "of"); err != nil {
+		return err
+	}
+
+	var m *big.Int
+	var err error
+	if *dstEid == 0 {
+		m, err = evmbinding.GetMarkup(*network, *asset, *of)
+	} else {
+		m, err = evmbinding.GetDetailedMarkup(*network, *asset, uint32(*dstEid), *of)
+	}
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]any{"network": *network, "asset": *asset, "facilitator": *of, "dstEid": *dstEid, "markup": m.String()})
+}
+
+func setMarkup(args []string) error {
+	fs := flag.NewFlagSet("setmarkup", flag.ExitOnError)
+	network := fs.String("network", "", "")
+	asset := fs.String("asset", "", "OFT3009CC token address")
+	value := fs.String("markup", "", "in the smallest units of the token")
+	dstEid := fs.Uint("dstEid", 0, "LayerZero destination, 0 - the local markup")
+	wait := fs.Duration("wait", 2*time.Minute, "how long to wait for the transaction to be mined, 0 - do not wait")
+	keys := addKeyFlags(fs)
+	fs.Parse(args)
+	if err := required(fs, "network", "asset", "markup"); err != nil {
+		return err
+	}
+	amount, err := bigArg("markup", *value)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := oftcc.OftccMetaData.GetAbi()
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if *dstEid == 0 {
+		data, err = parsed.Pack("setLocalMarkup", amount)
+	} else {
+		data, err = parsed.Pack("setCrosschainMarkup", amount, uint32(*dstEid))
+	}
+	if err != nil {
+		return err
+	}
+	return transact(*network, *asset, data, keys, *wait)
+}
+
+func mint(args []string) error {
+	fs := flag.NewFlagSet("mint", flag.ExitOnError)
+	network := fs.String("network", "", "")
+	asset := fs.String("asset", "", "test token address")
+	to := fs.String("to", "", "recipient, default - the signer")
+	value := fs.String("amount", "", "in the smallest units of the token")
+	wait := fs.Duration("wait", 2*time.Minute, "how long to wait for the transaction to be mined, 0 - do not wait")
+	keys := addKeyFlags(fs)
+	fs.Parse(args)
+	if err := required(fs, "network", "asset", "amount"); err != nil {
+		return err
+	}
+	amount, err := bigArg("amount", *value)
+	if err != nil {
+		return err
+	}
+	recipient := common.HexToAddress(*to)
+	if len(*to) == 0 {
+		signer, err := keys.signer()
+		if err != nil {
+			return err
+		}
+		recipient = signer.Address()
+	}
+
+	parsed, err := oftcc.OftccMetaData.GetAbi()
+	if err != nil {
+		return err
+	}
+	data, err := parsed.Pack("mint", recipient, amount)
+	if err != nil {
+		return err
+	}
+	return transact(*network, *asset, data, keys, *wait)
+}
+
+// transact sends the call through the TxManager and waits for it to be mined
+func transact(network, to string, data []byte, keys *keyFlags, wait time.Duration) error {
+	signer, err := keys.signer()
+	if err != nil {
+		return err
+	}
+	if err := evmbinding.Simulate(context.Background(), network, signer.Address(), evmbinding.Call{To: common.HexToAddress(to), Data: data}); err != nil {
+		return fmt.Errorf("the call would fail: %w", err)
+	}
+
+	tm := evmbinding.Transactions()
+	events := make(chan evmbinding.TxEvent, 16)
+	tm.Listener = func(ev evmbinding.TxEvent) { events <- ev }
+	hash, err := tm.Send(evmbinding.TxRequest{Network: network, Signer: signer, To: common.HexToAddress(to), Data: data})
+	if err != nil {
+		return err
+	}
+	if wait == 0 {
+		return printJSON(map[string]any{"network": network, "tx": hash.Hex(), "explorer": evmbinding.ExplorerURL(network)})
+	}
+
+	timeout := time.After(wait)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Original != hash {
+				continue
+			}
+			switch ev.Kind {
+			case evmbinding.TxMined:
+				return printJSON(map[string]any{"network": network, "tx": ev.Hash.Hex(), "status": ev.Receipt.Status, "block": ev.Receipt.BlockNumber, "gasUsed": ev.Receipt.GasUsed})
+			case evmbinding.TxDropped:
+				return fmt.Errorf("transaction %s dropped: %s", hash.Hex(), ev.Reason)
+			}
+		case <-timeout:
+			return fmt.Errorf("transaction %s not mined within %v", hash.Hex(), wait)
+		}
+	}
+}
+
+func nonce(args []string) error {
+	fs := flag.NewFlagSet("nonce", flag.ExitOnError)
+	network := fs.String("network", "", "")
+	asset := fs.String("asset", "", "token address")
+	owner := fs.String("owner", "", "payer address")
+	auth := fs.String("auth", "", "EIP-3009 authorization nonce (0x, 32 bytes) to look up")
+	fs.Parse(args)
+	if err := required(fs, "network", "asset", "owner"); err != nil {
+		return err
+	}
+
+	result := map[string]any{"network": *network, "asset": *asset, "owner": *owner}
+	if permitNonce, err := evmbinding.PermitNonce(*network, *asset, *owner); err != nil {
+		result["permitNonceError"] = err.Error()
+	} else {
+		result["permitNonce"] = permitNonce.String()
+	}
+	// what the owner lets Permit2 move, the permit2 schemes need it to cover the amount
+	if client, err := evmbinding.GetClientByNetwork(*network); err == nil {
+		permit2, _ := evmbinding.Permit2Address(*network)
+		if allowance, err := evmbinding.CheckAllowance(client, common.HexToAddress(*asset), common.HexToAddress(*owner), permit2); err != nil {
+			result["permit2AllowanceError"] = err.Error()
+		} else {
+			result["permit2Allowance"] = allowance.String()
+		}
+	}
+	if len(*auth) > 0 {
+		bts, err := hexutil.Decode(*auth)
+		if err != nil || len(bts) != 32 {
+			return fmt.Errorf("bad -auth: %q", *auth)
+		}
+		t, err := token(*network, *asset)
+		if err != nil {
+			return err
+		}
+		used, err := t.AuthorizationState(&bind.CallOpts{Context: context.Background()}, common.HexToAddress(*owner), [32]byte(bts))
+		if err != nil {
+			return fmt.Errorf("authorizationState failed: %w", err)
+		}
+		result["authorizationUsed"] = used
+	}
+	return printJSON(result)
+}
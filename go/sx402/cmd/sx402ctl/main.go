@@ -0,0 +1,185 @@
+// sx402ctl does by hand what the facilitator and its clients do: signs payments, talks to
+// a facilitator, reads and sets the token markups, mints test tokens and decodes x402 headers.
+//
+//	sx402ctl [-networks file] <command> [flags]
+//
+// Commands that sign take the key from -key (a kmsclitool keyfile), -keystore (a geth keystore
+// directory) or the SX402_PRIVATE_KEY environment variable (hex). The password comes from
+// -password, SX402_PASSWORD or the terminal.
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/san-lab/sx402/evmbinding"
+	"golang.org/x/term"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"sign":      {"-req file [-index n] [-version 1|2]  sign a payment for a requirement (or a 402 body), print the header", sign},
+	"verify":    {"-facilitator url -payment header [-req file]  ask the facilitator to verify a payment", verify},
+	"settle":    {"-facilitator url -payment header [-req file]  ask the facilitator to settle a payment", settle},
+	"receipt":   {"-facilitator url -network n -tx hash [-wait d]  poll the facilitator for the receipt of a settlement", receipt},
+	"markup":    {"-network n -asset a -of address [-dstEid e]  read localMarkups, or markups to dstEid", markup},
+	"setmarkup": {"-network n -asset a -markup units [-dstEid e]  set the signer's local or cross-chain markup", setMarkup},
+	"mint":      {"-network n -asset a -to address -amount units  mint test tokens", mint},
+	"nonce":     {"-network n -asset a -owner address [-auth nonce]  permit nonce, Permit2 allowance, and authorizationState of an EIP-3009 nonce", nonce},
+	"decode":    {"[header]  decode an X-Payment, PAYMENT-SIGNATURE, PAYMENT-REQUIRED or payment response header (default - stdin)", decode},
+}
+
+// where the commands print, the tests swap it
+var out io.Writer = os.Stdout
+
+func main() {
+	log.SetFlags(0)
+	networks := flag.String("networks", "", "network file to load on top of config/networks.json")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if len(*networks) > 0 {
+		if err := evmbinding.LoadNetworks(*networks); err != nil {
+			log.Fatal(err)
+		}
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		log.Printf("unknown command: %s", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: sx402ctl [-networks file] <command> [flags]")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+// keyFlags are the flags of the commands that sign
+type keyFlags struct {
+	keyfile  *string
+	keystore *string
+	account  *string
+	password *string
+}
+
+func addKeyFlags(fs *flag.FlagSet) *keyFlags {
+	return &keyFlags{
+		keyfile:  fs.String("key", "", "kmsclitool keyfile"),
+		keystore: fs.String("keystore", "", "geth keystore directory"),
+		account:  fs.String("account", "", "account in the keystore (default - the first one)"),
+		password: fs.String("password", "", "keyfile/keystore password (default - $SX402_PASSWORD or asked)"),
+	}
+}
+
+func (kf *keyFlags) signer() (evmbinding.HashSigner, error) {
+	if len(*kf.keyfile) == 0 && len(*kf.keystore) == 0 {
+		hexkey := strings.TrimPrefix(os.Getenv("SX402_PRIVATE_KEY"), "0x")
+		if len(hexkey) == 0 {
+			return nil, fmt.Errorf("no key: use -key, -keystore or SX402_PRIVATE_KEY")
+		}
+		key, err := crypto.HexToECDSA(hexkey)
+		if err != nil {
+			return nil, fmt.Errorf("bad SX402_PRIVATE_KEY: %w", err)
+		}
+		return evmbinding.NewKeySigner(key), nil
+	}
+	password, err := kf.readPassword()
+	if err != nil {
+		return nil, err
+	}
+	if len(*kf.keyfile) > 0 {
+		return evmbinding.LoadKeyfile(*kf.keyfile, password)
+	}
+	signers, err := evmbinding.OpenKeystore(*kf.keystore, string(password))
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range signers {
+		if len(*kf.account) == 0 || s.Address() == common.HexToAddress(*kf.account) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("no account %s in %s", *kf.account, *kf.keystore)
+}
+
+func (kf *keyFlags) readPassword() ([]byte, error) {
+	if len(*kf.password) > 0 {
+		return []byte(*kf.password), nil
+	}
+	if env, ok := os.LookupEnv("SX402_PASSWORD"); ok {
+		return []byte(env), nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return password, err
+}
+
+// input is the argument, a file, or stdin for "-" and ""
+func input(arg string) ([]byte, error) {
+	if len(arg) == 0 || arg == "-" {
+		bts, err := io.ReadAll(bufio.NewReader(os.Stdin))
+		return []byte(strings.TrimSpace(string(bts))), err
+	}
+	if bts, err := os.ReadFile(arg); err == nil {
+		return []byte(strings.TrimSpace(string(bts))), nil
+	}
+	return []byte(arg), nil
+}
+
+// unbase64 takes base64 header values, with or without the "Name: " sign prints, and lets plain JSON through
+func unbase64(data []byte) []byte {
+	if name, value, found := strings.Cut(string(data), ": "); found && !strings.ContainsAny(name, "{[\"") {
+		data = []byte(value)
+	}
+	if bts, err := base64.StdEncoding.DecodeString(string(data)); err == nil {
+		return bts
+	}
+	return data
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func required(fs *flag.FlagSet, names ...string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, name := range names {
+		if !set[name] {
+			return fmt.Errorf("%s: -%s is required", fs.Name(), name)
+		}
+	}
+	return nil
+}
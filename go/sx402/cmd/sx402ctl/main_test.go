@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/san-lab/sx402/all712"
+)
+
+const testRequirement = `{"scheme":"exact","network":"base-sepolia","maxAmountRequired":"10000","payTo":"0xCEF702Bd69926B13ab7150624daA7aFEE0300786",
+	"maxTimeoutSeconds":60,"asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e","extra":{"name":"USDC","version":"2"}}`
+
+func captured(t *testing.T, run func() error) string {
+	buf := new(bytes.Buffer)
+	out = buf
+	defer func() { out = os.Stdout }()
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestSignAndDecode(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	t.Setenv("SX402_PRIVATE_KEY", hexutil.Encode(crypto.FromECDSA(key)))
+	reqFile := filepath.Join(t.TempDir(), "req.json")
+	os.WriteFile(reqFile, []byte(testRequirement), 0644)
+
+	for _, version := range []string{"1", "2"} {
+		header := captured(t, func() error { return sign([]string{"-req", reqFile, "-version", version}) })
+		if version == "1" && !strings.HasPrefix(header, "X-Payment: ") || version == "2" && !strings.HasPrefix(header, "PAYMENT-SIGNATURE: ") {
+			t.Errorf("v%s: wrong header %q", version, header)
+		}
+
+		// v2 payments carry their requirement, v1 ones are given it
+		reqArg := ""
+		if version == "1" {
+			reqArg = reqFile
+		}
+		env, err := envelope(strings.TrimSpace(header), reqArg, 0)
+		if err != nil {
+			t.Fatalf("v%s: %v", version, err)
+		}
+		exact := new(types.ExactEvmPayload)
+		json.Unmarshal(env.PaymentPayload.Payload, exact)
+		if env.PaymentRequirements.Network != "base-sepolia" || exact.Authorization.From != crypto.PubkeyToAddress(key.PublicKey).Hex() {
+			t.Errorf("v%s: wrong envelope %+v %+v", version, env.PaymentRequirements, exact.Authorization)
+		}
+
+		decoded := captured(t, func() error { return decode([]string{strings.TrimSpace(header)}) })
+		if !strings.Contains(decoded, `"x402Version": `+version) || !strings.Contains(decoded, `"signature"`) {
+			t.Errorf("v%s: decoded to %s", version, decoded)
+		}
+	}
+}
+
+func TestReadRequirement(t *testing.T) {
+	req := new(types.PaymentRequirements)
+	json.Unmarshal([]byte(testRequirement), req)
+	other := *req
+	other.Scheme, other.Network = "permit_USDC", "amoy"
+
+	pr := all712.PaymentRequired{X402Version: 2}
+	for _, r := range []*types.PaymentRequirements{req, &other} {
+		v2, resource := all712.ToV2(r)
+		pr.Accepts, pr.Resource = append(pr.Accepts, v2), resource
+	}
+	bts, _ := json.Marshal(pr)
+
+	got, err := readRequirement("PAYMENT-REQUIRED: "+base64.StdEncoding.EncodeToString(bts), 1)
+	if err != nil || got.Scheme != "permit_USDC" || got.Network != "amoy" || got.MaxAmountRequired != "10000" {
+		t.Errorf("wrong requirement from a PAYMENT-REQUIRED header: %+v %v", got, err)
+	}
+	if _, err := readRequirement(string(bts), 2); err == nil {
+		t.Error("read a requirement that is not there")
+	}
+}
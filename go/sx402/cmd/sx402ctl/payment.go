@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/facilitatorclient"
+	"github.com/san-lab/sx402/x402client"
+)
+
+// readRequirement takes a requirement, or one of the accepts of a 402 body (v1 or v2)
+func readRequirement(arg string, index int) (*types.PaymentRequirements, error) {
+	bts, err := input(arg)
+	if err != nil {
+		return nil, err
+	}
+	bts = unbase64(bts)
+	var body struct {
+		X402Version int                  `json:"x402Version"`
+		Accepts     []json.RawMessage    `json:"accepts"`
+		Resource    *all712.ResourceInfo `json:"resource"`
+	}
+	if err := json.Unmarshal(bts, &body); err != nil {
+		return nil, fmt.Errorf("bad requirement: %w", err)
+	}
+	if len(body.Accepts) == 0 {
+		req := new(types.PaymentRequirements)
+		return req, json.Unmarshal(bts, req)
+	}
+	if index < 0 || index >= len(body.Accepts) {
+		return nil, fmt.Errorf("no requirement #%v, there are %v", index, len(body.Accepts))
+	}
+	if body.X402Version < 2 {
+		req := new(types.PaymentRequirements)
+		return req, json.Unmarshal(body.Accepts[index], req)
+	}
+	v2 := new(all712.PaymentRequirementsV2)
+	if err := json.Unmarshal(body.Accepts[index], v2); err != nil {
+		return nil, err
+	}
+	return all712.FromV2(v2, body.Resource), nil
+}
+
+func sign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	reqArg := fs.String("req", "-", "requirement JSON, or a 402 body / PAYMENT-REQUIRED header: a file, the value or - for stdin")
+	index := fs.Int("index", 0, "which of the accepts of a 402 body")
+	version := fs.Int("version", 1, "x402 version of the header")
+	bps := fs.Int64("maxMarkupBps", 0, "the most a cross-chain payment may leave to the facilitator, in basis points (default 100)")
+	keys := addKeyFlags(fs)
+	fs.Parse(args)
+
+	req, err := readRequirement(*reqArg, *index)
+	if err != nil {
+		return err
+	}
+	signer, err := keys.signer()
+	if err != nil {
+		return err
+	}
+	t := &x402client.Transport{Payer: signer, MaxMarkupBps: *bps}
+	payload, err := t.Sign(req)
+	if err != nil {
+		return err
+	}
+	header, value, err := x402client.EncodePayment(req, payload, *version)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s: %s\n", header, value)
+	return nil
+}
+
+// envelope pairs the payment with its requirement; v2 payments carry their own
+func envelope(paymentArg, reqArg string, index int) (*all712.Envelope, error) {
+	bts, err := input(paymentArg)
+	if err != nil {
+		return nil, err
+	}
+	ppld, v2, err := all712.ParsePaymentPayload(unbase64(bts))
+	if err != nil {
+		return nil, fmt.Errorf("bad payment: %w", err)
+	}
+	env := &all712.Envelope{X402Version: ppld.X402Version, PaymentPayload: ppld}
+	switch {
+	case len(reqArg) > 0:
+		env.PaymentRequirements, err = readRequirement(reqArg, index)
+	case v2 != nil:
+		env.PaymentRequirements = all712.FromV2(v2.Accepted, v2.Resource)
+	default:
+		err = errors.New("a v1 payment needs its -req")
+	}
+	return env, err
+}
+
+func facilitatorFlags(fs *flag.FlagSet) func() *facilitatorclient.Client {
+	urls := fs.String("facilitator", "http://localhost:3010/facilitator", "comma separated facilitator URLs")
+	token := fs.String("token", "", "bearer token for the facilitator")
+	return func() *facilitatorclient.Client {
+		return facilitatorclient.New(strings.Split(*urls, ",")...).WithBearer(*token)
+	}
+}
+
+func verify(args []string) error {
+	return verifyOrSettle("verify", args)
+}
+
+func settle(args []string) error {
+	return verifyOrSettle("settle", args)
+}
+
+func verifyOrSettle(name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	client := facilitatorFlags(fs)
+	payment := fs.String("payment", "", "the payment header value (or the decoded JSON): a file, the value or - for stdin")
+	reqArg := fs.String("req", "", "requirement JSON or 402 body, needed for v1 payments")
+	index := fs.Int("index", 0, "which of the accepts of a 402 body")
+	timeout := fs.Duration("timeout", 2*time.Minute, "")
+	fs.Parse(args)
+	if err := required(fs, "payment"); err != nil {
+		return err
+	}
+
+	env, err := envelope(*payment, *reqArg, *index)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if name == "verify" {
+		verified, err := client().Verify(ctx, env)
+		if err != nil {
+			return err
+		}
+		return printJSON(verified)
+	}
+	settled, err := client().Settle(ctx, env)
+	if settled != nil {
+		printJSON(settled)
+	}
+	return err
+}
+
+// receipt statuses that will not change any more
+var finalStatus = map[string]bool{"found": true, "failed": true, "timeout": true}
+
+func receipt(args []string) error {
+	fs := flag.NewFlagSet("receipt", flag.ExitOnError)
+	client := facilitatorFlags(fs)
+	network := fs.String("network", "", "")
+	tx := fs.String("tx", "", "settlement transaction hash")
+	wait := fs.Duration("wait", 0, "keep polling until the receipt is final or this much time has passed")
+	interval := fs.Duration("interval", 3*time.Second, "polling interval")
+	fs.Parse(args)
+	if err := required(fs, "network", "tx"); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(*wait)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		rcpt, err := client().Receipt(ctx, *network, *tx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if finalStatus[rcpt.Status] || len(rcpt.Error) > 0 || time.Now().Add(*interval).After(deadline) {
+			return printJSON(rcpt)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// decode prints any of the base64 x402 headers as JSON
+func decode(args []string) error {
+	arg := ""
+	if len(args) > 0 {
+		arg = args[0]
+	}
+	bts, err := input(arg)
+	if err != nil {
+		return err
+	}
+	var v any
+	if err := json.Unmarshal(unbase64(bts), &v); err != nil {
+		return fmt.Errorf("not an x402 header: %w", err)
+	}
+	return printJSON(v)
+}
@@ -2,146 +2,41 @@ package evmbinding
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"maps"
 	"math/big"
-	"os"
-	"path/filepath"
 	"strings"
-	"sync"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/san-lab/sx402/all712"
 )
 
-const Base_sepolia = "base-sepolia"
-const Amoy = "amoy"
-const Sepolia = "sepolia"
-const Holesky = "holesky"
-const ZkSync_sepolia = "zksync-sepolia"
-const Arbitrum_sepolia = "arbitrum-sepolia"
-const OP_Sepolia = "op-sepolia"
-
-var rpcEndpoints = map[string]string{
-	Base_sepolia:     "https://sepolia.base.org",
-	Sepolia:          "https://ethereum-sepolia-rpc.publicnode.com",
-	Amoy:             "https://rpc-amoy.polygon.technology/",
-	Holesky:          "https://ethereum-holesky.publicnode.com",
-	ZkSync_sepolia:   "https://sepolia.era.zksync.dev",
-	Arbitrum_sepolia: "https://sepolia-rollup.arbitrum.io/rpc", // "https://arbitrum-sepolia.gateway.tenderly.co",
-	OP_Sepolia:       "https://optimism-sepolia.gateway.tenderly.co",
-}
-
-var ExplorerURLs = map[string]string{
-	Base_sepolia:     "https://sepolia.basescan.org", // Basescan (Etherscan-style)
-	Sepolia:          "https://sepolia.etherscan.io", // Official Sepolia Etherscan
-	Amoy:             "https://amoy.polygonscan.com", // Polygonscan for Amoy
-	Holesky:          "https://holesky.etherscan.io", // Etherscan for Holesky
-	ZkSync_sepolia:   "https://sepolia-era.zksync.network/",
-	Arbitrum_sepolia: "https://sepolia.arbiscan.io",
-	OP_Sepolia:       "https://sepolia-optimism.etherscan.io/",
-}
-
-func init() {
-	LoadConfigs()
-}
-
-func LoadConfigs() {
-	log.Println(LoadOverrides("config/rpcs.json", rpcEndpoints))
-	log.Println(LoadOverrides("config/explorers.json", ExplorerURLs))
-}
-
-func LoadOverrides(relativePath string, targetMap map[string]string) error {
-	// Absolute path from the working directory (project root)
-	absPath, err := filepath.Abs(relativePath)
-	if err != nil {
-		return fmt.Errorf("could not resolve path: %w", err)
-	}
-
-	data, err := os.ReadFile(absPath)
-	if err != nil {
-		return fmt.Errorf("could not read file %s: %w", absPath, err)
-	}
-
-	var overrides map[string]string
-	if err := json.Unmarshal(data, &overrides); err != nil {
-		return fmt.Errorf("invalid JSON in %s: %w", absPath, err)
-	}
-
-	maps.Copy(targetMap, overrides)
-
-	return nil
-}
-
-var ChainIDs = map[string]*big.Int{
-	Base_sepolia:     big.NewInt(84532),
-	Sepolia:          big.NewInt(11155111),
-	Amoy:             big.NewInt(80002),
-	Holesky:          big.NewInt(17000),
-	ZkSync_sepolia:   big.NewInt(300),
-	Arbitrum_sepolia: big.NewInt(421614),
-	OP_Sepolia:       big.NewInt(11155420),
-}
-
+// GetClientByNetwork returns the pooled client of the network. Do not Close it.
 func GetClientByNetwork(network string) (client *ethclient.Client, err error) {
-	url, ok := GetRPCEndpoint(network)
-	if !ok {
-		err = fmt.Errorf("Unknown network: %s", network)
-		return
-	}
-	return ethclient.Dial(url)
+	return pool.Client(network)
 }
 
 func GetlientByChainID(chainID *big.Int) (client *ethclient.Client, err error) {
-	network := ""
-	for k, v := range ChainIDs {
-		if v.Cmp(chainID) == 0 {
-			network = k
-			break
-		}
-	}
-	if len(network) == 0 {
+	network, ok := NetworkByChainID(chainID)
+	if !ok {
 		err = fmt.Errorf("Unsupported ChainID: %v", chainID)
 		return
 	}
-	return GetClientByNetwork(network)
-}
-
-func InitClients() map[string]*ethclient.Client {
-	clients := make(map[string]*ethclient.Client)
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-
-	for network, url := range rpcEndpoints {
-		wg.Add(1)
-		go func(network, url string) {
-			defer wg.Done()
-			client, err := ethclient.Dial(url)
-			if err != nil {
-				log.Printf("❌ Failed to connect to %s: %v", network, err)
-				return
-			}
-			log.Printf("✅ Connected to %s", network)
-			mu.Lock()
-			clients[network] = client
-			mu.Unlock()
-		}(network, url)
-	}
-
-	wg.Wait()
-	return clients
+	return GetClientByNetwork(network.Name)
 }
 
 func GetRPCEndpoint(network string) (string, bool) {
-	rpc, ok := rpcEndpoints[network]
-	return rpc, ok
+	n, ok := GetNetwork(network)
+	if !ok {
+		return "", false
+	}
+	return n.RPCURLs[0], true
 }
 
 func SendTransaction(client *ethclient.Client, signedTx *types.Transaction) (*common.Hash, error) {
@@ -165,7 +60,7 @@ func CheckTokenBalance(client *ethclient.Client, tokenAddress, ownerAddress comm
 	// Pack the input (balanceOf(address))
 	data, err := parsedABI.Pack("balanceOf", ownerAddress)
 	if err != nil {
-		log.Fatalf("Failed to pack data: %v", err)
+		return nil, fmt.Errorf("Failed to pack data: %w", err)
 	}
 
 	// Prepare the call message
@@ -179,7 +74,7 @@ func CheckTokenBalance(client *ethclient.Client, tokenAddress, ownerAddress comm
 	result, err := client.CallContract(ctx, msg, nil)
 	if err != nil {
 		log.Printf("Failed to call contract: %v\n", err)
-		return nil, err
+		return nil, callError(err)
 	}
 
 	// Unpack the result
@@ -197,10 +92,10 @@ func CheckAuthorizationState(client *ethclient.Client, tokenAddress, payer commo
 		return false, fmt.Errorf("Failed to parse ABI: %v", err)
 	}
 
-	// Pack the input (balanceOf(address))
+	// Pack the input (authorizationState(address, bytes32))
 	data, err := parsedABI.Pack("authorizationState", payer, nonce)
 	if err != nil {
-		log.Fatalf("Failed to pack data: %v", err)
+		return false, fmt.Errorf("Failed to pack data: %w", err)
 	}
 
 	// Prepare the call message
@@ -214,81 +109,92 @@ func CheckAuthorizationState(client *ethclient.Client, tokenAddress, payer commo
 	result, err := client.CallContract(ctx, msg, nil)
 	if err != nil {
 		log.Printf("Failed to call contract: %v", err)
-		return false, err
+		return false, callError(err)
 	}
 
 	// Unpack the result
-
 	unpacked, err := parsedABI.Unpack("authorizationState", result)
+	if err != nil {
+		return false, fmt.Errorf("Failed to unpack authorizationState: %w", err)
+	}
 	known, ok := unpacked[0].(bool)
 	if !ok {
-		log.Fatalf("Unpacked result is not a bool")
+		return false, fmt.Errorf("Unpacked result is not a bool")
 	}
 
-	return known, err
+	return known, nil
 }
 
+// callError marks the failures to reach the node; what the node answered stays as it is
+func callError(err error) error {
+	var answered rpc.Error
+	if errors.As(err, &answered) {
+		return err
+	}
+	return all712.WithCode(all712.CodeRPCUnavailable, err)
+}
+
+// TransferWithAuthorization submits the EIP-3009 transfer through the TxManager
 func TransferWithAuthorization(
-	client *ethclient.Client,
-	signer *ecdsa.PrivateKey,
+	network string,
+	signer Signer,
+	maxCost *big.Int,
 	token, from, to common.Address,
 	value, validAfter, validBefore *big.Int,
 	nonce, r, s [32]byte,
 	v byte,
 ) (*common.Hash, error) {
-	// Parse ABI
-	parsedABI, err := abi.JSON(strings.NewReader(trWithAuthABI))
+	call, err := TransferWithAuthorizationCall(token, from, to, value, validAfter, validBefore, nonce, r, s, v)
 	if err != nil {
 		return nil, err
 	}
 
-	// Pack the input (balanceOf(address))
-	data, err := parsedABI.Pack("transferWithAuthorization", from, to, value, validAfter, validBefore, nonce, v, r, s)
+	// Gas gets estimated by the tx manager
+	h, err := Transactions().Send(TxRequest{Network: network, Signer: signer, To: call.To, Data: call.Data, MaxCost: maxCost})
 	if err != nil {
-		return nil, fmt.Errorf("Failed to pack data: %w", err)
+		return nil, err
 	}
+	return &h, nil
 
-	facilAddress := crypto.PubkeyToAddress(signer.PublicKey)
+}
 
-	fromNonce, err := getNonce(context.Background(), client, facilAddress)
+// TransferWithAuthorizationCall is the settlement call of an EIP-3009 authorization
+func TransferWithAuthorizationCall(
+	token, from, to common.Address,
+	value, validAfter, validBefore *big.Int,
+	nonce, r, s [32]byte,
+	v byte,
+) (Call, error) {
+	parsedABI, err := abi.JSON(strings.NewReader(trWithAuthABI))
 	if err != nil {
-		return nil, err
+		return Call{}, err
 	}
 
-	gasPrice, err := client.SuggestGasPrice(context.Background())
+	data, err := parsedABI.Pack("transferWithAuthorization", from, to, value, validAfter, validBefore, nonce, v, r, s)
 	if err != nil {
-		log.Fatal(err)
+		return Call{}, fmt.Errorf("Failed to pack data: %w", err)
 	}
+	return Call{To: token, Data: data}, nil
+}
 
-	// Estimate gas
-	gasLimit := uint64(500000) // or estimate with client.EstimateGas()
-
-	tx := types.NewTransaction(
-		fromNonce,
-		token,
-		big.NewInt(0), // No ETH being sent
-		gasLimit,
-		gasPrice,
-		data,
-	)
-
-	chainID, err := client.ChainID(context.Background())
-	if err != nil {
-		return nil, fmt.Errorf("error recoovering ChainID: %w", err)
-	}
-	// Sign transaction
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), signer)
+// TransferWithAuthorizationBytesCall is the overload taking the signature as bytes, for the signatures
+// of wallet contracts (ERC-1271) that do not split into v, r, s
+func TransferWithAuthorizationBytesCall(
+	token, from, to common.Address,
+	value, validAfter, validBefore *big.Int,
+	nonce [32]byte,
+	signature []byte,
+) (Call, error) {
+	parsedABI, err := abi.JSON(strings.NewReader(trWithAuthBytesABI))
 	if err != nil {
-		return nil, fmt.Errorf("error signing transaction: %w", err)
+		return Call{}, err
 	}
 
-	err = client.SendTransaction(context.Background(), signedTx)
+	data, err := parsedABI.Pack("transferWithAuthorization", from, to, value, validAfter, validBefore, nonce, signature)
 	if err != nil {
-		return nil, fmt.Errorf("could not send tx: %v", err)
+		return Call{}, fmt.Errorf("Failed to pack data: %w", err)
 	}
-	h := signedTx.Hash()
-	return &h, nil
-
+	return Call{To: token, Data: data}, nil
 }
 
 const tokenABI = `[
@@ -331,3 +237,19 @@ const trWithAuthABI = `[{
 	"stateMutability": "nonpayable",
 	"type": "function"
   }]`
+
+const trWithAuthBytesABI = `[{
+	"inputs": [
+	  { "name": "from", "type": "address" },
+	  { "name": "to", "type": "address" },
+	  { "name": "value", "type": "uint256" },
+	  { "name": "validAfter", "type": "uint256" },
+	  { "name": "validBefore", "type": "uint256" },
+	  { "name": "nonce", "type": "bytes32" },
+	  { "name": "signature", "type": "bytes" }
+	],
+	"name": "transferWithAuthorization",
+	"outputs": [],
+	"stateMutability": "nonpayable",
+	"type": "function"
+  }]`
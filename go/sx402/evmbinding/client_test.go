@@ -16,7 +16,8 @@ var BaseSepoliaEURSMAddress = common.HexToAddress("0x7AE6D004cd29570974357f30c01
 var boss = common.HexToAddress("0xaab05558448C8a9597287Db9F61e2d751645B12a")
 
 func TestGetBalance(t *testing.T) {
-	client, _ := ethclient.Dial(rpcEndpoints["base-sepolia"])
+	url, _ := GetRPCEndpoint(Base_sepolia)
+	client, _ := ethclient.Dial(url)
 	b, e := CheckTokenBalance(client, BaseSepoliaEURSMAddress, boss)
 	if e != nil {
 		t.Error(e)
@@ -0,0 +1,136 @@
+package evmbinding
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ERC-1271 isValidSignature(bytes32,bytes) returns this selector for a good signature
+var erc1271MagicValue = common.FromHex("0x1626ba7e")
+
+var erc1271ABI, _ = abi.JSON(strings.NewReader(`[{"type":"function","name":"isValidSignature","stateMutability":"view",
+	"inputs":[{"name":"hash","type":"bytes32"},{"name":"signature","type":"bytes"}],"outputs":[{"name":"magicValue","type":"bytes4"}]}]`))
+
+// How long an answer of a wallet contract is reused; its owners may change
+var ERC1271CacheTTL = 10 * time.Minute
+
+type erc1271Key struct {
+	network   string
+	wallet    common.Address
+	digest    common.Hash
+	signature common.Hash
+}
+
+type erc1271Answer struct {
+	valid bool
+	at    time.Time
+}
+
+var erc1271Cache = struct {
+	sync.Mutex
+	answers map[erc1271Key]erc1271Answer
+}{answers: map[erc1271Key]erc1271Answer{}}
+
+// IsContract tells whether there is code at the address
+func IsContract(network string, address common.Address) (bool, error) {
+	client, err := GetClientByNetwork(network)
+	if err != nil {
+		return false, err
+	}
+	code, err := client.CodeAt(context.Background(), address, nil)
+	if err != nil {
+		return false, callError(err)
+	}
+	return len(code) > 0, nil
+}
+
+// IsValidERC1271Signature asks the wallet contract whether the signature of digest is its own,
+// the same staticcall the OFT3009CC tokens make. A call that reverts or answers garbage means no.
+// Answers are cached per (wallet, digest) and signature.
+func IsValidERC1271Signature(network string, wallet common.Address, digest [32]byte, signature []byte) (bool, error) {
+	key := erc1271Key{network, wallet, digest, crypto.Keccak256Hash(signature)}
+	return cachedSignatureCheck(key, func() (bool, error) {
+		client, err := GetClientByNetwork(network)
+		if err != nil {
+			return false, err
+		}
+		data, err := erc1271ABI.Pack("isValidSignature", digest, signature)
+		if err != nil {
+			return false, err
+		}
+		result, err := client.CallContract(context.Background(), ethereum.CallMsg{To: &wallet, Data: data}, nil)
+		if err != nil {
+			if _, reverted := revertData(err); !reverted && !strings.Contains(err.Error(), "execution reverted") {
+				return false, callError(err)
+			}
+			return false, nil
+		}
+		return isMagicValue(result), nil
+	})
+}
+
+// IsValidCounterfactualSignature is the ERC-1271 check of a wallet that is not deployed yet (EIP-6492):
+// the factory call and isValidSignature run in one eth_simulateV1 block, so the second sees the wallet
+// the first deploys. Nodes without eth_simulateV1 cannot tell.
+func IsValidCounterfactualSignature(network string, wallet common.Address, digest [32]byte, deploy Call, signature []byte) (bool, error) {
+	key := erc1271Key{network, wallet, digest, crypto.Keccak256Hash(deploy.To.Bytes(), deploy.Data, signature)}
+	return cachedSignatureCheck(key, func() (bool, error) {
+		client, err := GetClientByNetwork(network)
+		if err != nil {
+			return false, err
+		}
+		data, err := erc1271ABI.Pack("isValidSignature", digest, signature)
+		if err != nil {
+			return false, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		results, err := simulateCalls(ctx, client.Client(), common.Address{}, []Call{deploy, {To: wallet, Data: data}})
+		if errors.Is(err, ErrNoSimulateV1) {
+			return false, fmt.Errorf("%s cannot check the signatures of undeployed wallets: %w", network, err)
+		}
+		if err != nil {
+			return false, callError(err)
+		}
+		return results[0].Status == 1 && results[1].Status == 1 && isMagicValue(results[1].ReturnData), nil
+	})
+}
+
+// isMagicValue is the check of the tokens: the answer decoded as bytes32 is the isValidSignature selector
+func isMagicValue(result []byte) bool {
+	return len(result) >= 32 && bytes.Equal(result[:32], common.RightPadBytes(erc1271MagicValue, 32))
+}
+
+func cachedSignatureCheck(key erc1271Key, check func() (bool, error)) (bool, error) {
+	erc1271Cache.Lock()
+	answer, ok := erc1271Cache.answers[key]
+	erc1271Cache.Unlock()
+	if ok && time.Since(answer.at) < ERC1271CacheTTL {
+		return answer.valid, nil
+	}
+
+	valid, err := check()
+	if err != nil {
+		return false, err
+	}
+
+	erc1271Cache.Lock()
+	erc1271Cache.answers[key] = erc1271Answer{valid, time.Now()}
+	for k, a := range erc1271Cache.answers {
+		if time.Since(a.at) >= ERC1271CacheTTL {
+			delete(erc1271Cache.answers, k)
+		}
+	}
+	erc1271Cache.Unlock()
+	return valid, nil
+}
@@ -0,0 +1,78 @@
+package evmbinding
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// walletNode has a wallet contract at 0x77 that accepts the signature 0xaa.. and nothing else
+type walletNode struct {
+	calls atomic.Int64
+}
+
+func (n *walletNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	result := `"0x"`
+	switch req.Method {
+	case "eth_getCode":
+		if strings.Contains(string(req.Params[0]), "0000000000000000000000000000000000000077") {
+			result = `"0x6080"`
+		}
+	case "eth_call":
+		n.calls.Add(1)
+		if strings.Contains(string(req.Params[0]), strings.Repeat("aa", 70)) {
+			result = fmt.Sprintf("%q", hexutil.Encode(common.RightPadBytes(erc1271MagicValue, 32)))
+		} else {
+			result = `"0xffffffff00000000000000000000000000000000000000000000000000000000"`
+		}
+	}
+	fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":%s}`, req.ID, result)
+}
+
+func TestERC1271(t *testing.T) {
+	node := &walletNode{}
+	srv := httptest.NewServer(node)
+	t.Cleanup(srv.Close)
+	withTestNetwork(t, srv.URL)
+	saved := pool
+	pool = NewClientPool()
+	t.Cleanup(func() { pool = saved })
+
+	wallet, eoa := common.HexToAddress("0x77"), common.HexToAddress("0x78")
+	if is, err := IsContract("devnet", wallet); err != nil || !is {
+		t.Errorf("wallet has no code: %v", err)
+	}
+	if is, err := IsContract("devnet", eoa); err != nil || is {
+		t.Errorf("EOA has code: %v", err)
+	}
+
+	digest := common.HexToHash("0x1234")
+	good := common.FromHex("0x" + strings.Repeat("aa", 70))
+	for i := 0; i < 2; i++ {
+		if valid, err := IsValidERC1271Signature("devnet", wallet, digest, good); err != nil || !valid {
+			t.Errorf("good signature rejected: %v", err)
+		}
+	}
+	if node.calls.Load() != 1 {
+		t.Errorf("answer not cached, %v calls", node.calls.Load())
+	}
+	if valid, err := IsValidERC1271Signature("devnet", wallet, digest, []byte{1, 2, 3}); err != nil || valid {
+		t.Errorf("bad signature accepted: %v", err)
+	}
+	if node.calls.Load() != 2 {
+		t.Errorf("another signature served from the cache, %v calls", node.calls.Load())
+	}
+}
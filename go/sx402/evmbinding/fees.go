@@ -0,0 +1,171 @@
+package evmbinding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/san-lab/sx402/all712"
+)
+
+var ErrFeeTooHigh = all712.Errorf(all712.CodeFeeTooHigh, "gas cost exceeds the fee cap")
+
+const feeHistoryBlocks = 10
+
+// txFees is the pricing of one transaction; GasPrice is set for legacy ones, TipCap/FeeCap otherwise
+type txFees struct {
+	GasPrice *big.Int
+	TipCap   *big.Int
+	FeeCap   *big.Int
+}
+
+func (f txFees) legacy() bool {
+	return f.GasPrice != nil
+}
+
+// maxPerGas is what the tx may pay per unit of gas at worst
+func (f txFees) maxPerGas() *big.Int {
+	if f.legacy() {
+		return f.GasPrice
+	}
+	return f.FeeCap
+}
+
+func (f txFees) String() string {
+	if f.legacy() {
+		return fmt.Sprintf("gasPrice %v", f.GasPrice)
+	}
+	return fmt.Sprintf("tip %v, feeCap %v", f.TipCap, f.FeeCap)
+}
+
+func (f txFees) bump(percent int64) txFees {
+	up := func(x *big.Int) *big.Int {
+		if x == nil {
+			return nil
+		}
+		b := new(big.Int).Mul(x, big.NewInt(100+percent))
+		return b.Div(b, big.NewInt(100))
+	}
+	return txFees{GasPrice: up(f.GasPrice), TipCap: up(f.TipCap), FeeCap: up(f.FeeCap)}
+}
+
+// atLeast takes the higher of the two pricings, field by field
+func (f txFees) atLeast(o txFees) txFees {
+	higher := func(a, b *big.Int) *big.Int {
+		if a == nil || (b != nil && b.Cmp(a) > 0) {
+			return b
+		}
+		return a
+	}
+	return txFees{GasPrice: higher(f.GasPrice, o.GasPrice), TipCap: higher(f.TipCap, o.TipCap), FeeCap: higher(f.FeeCap, o.FeeCap)}
+}
+
+func feeConfig(network string) FeeConfig {
+	cfg := FeeConfig{}
+	if n, ok := GetNetwork(network); ok {
+		cfg = n.Fees
+	}
+	if cfg.TipPercentile == 0 {
+		cfg.TipPercentile = 50
+	}
+	if cfg.BaseFeeMultiplier == 0 {
+		cfg.BaseFeeMultiplier = 2
+	}
+	if cfg.GasMargin == 0 {
+		cfg.GasMargin = 1.2
+	}
+	if cfg.MaxFeeFraction == 0 {
+		cfg.MaxFeeFraction = 0.5
+	}
+	return cfg
+}
+
+// suggestFees prices a tx from eth_feeHistory: the next base fee with some headroom plus the
+// median (or configured percentile) tip of the last blocks. Chains without a base fee get a legacy gasPrice.
+func suggestFees(ctx context.Context, client txBackend, network string) (txFees, error) {
+	cfg := feeConfig(network)
+	if !cfg.Legacy {
+		hist, err := client.FeeHistory(ctx, feeHistoryBlocks, nil, []float64{cfg.TipPercentile})
+		if err == nil && len(hist.BaseFee) > 0 && hist.BaseFee[len(hist.BaseFee)-1] != nil && hist.BaseFee[len(hist.BaseFee)-1].Sign() > 0 {
+			baseFee := hist.BaseFee[len(hist.BaseFee)-1] // the one of the pending block
+			tip := medianReward(hist.Reward)
+			if tip.Sign() == 0 {
+				if suggested, err := client.SuggestGasTipCap(ctx); err == nil {
+					tip = suggested
+				}
+			}
+			feeCap, _ := new(big.Float).Mul(new(big.Float).SetInt(baseFee), big.NewFloat(cfg.BaseFeeMultiplier)).Int(nil)
+			return txFees{TipCap: tip, FeeCap: feeCap.Add(feeCap, tip)}, nil
+		}
+		log.Printf("no usable fee history on %s (%v), pricing legacy", network, err)
+	}
+	gasPrice, err := client.SuggestGasPrice(ctx)
+	if err != nil {
+		return txFees{}, callError(fmt.Errorf("error getting price suggestion: %w", err))
+	}
+	return txFees{GasPrice: gasPrice}, nil
+}
+
+func medianReward(rewards [][]*big.Int) *big.Int {
+	tips := []*big.Int{}
+	for _, r := range rewards {
+		if len(r) > 0 && r[0] != nil {
+			tips = append(tips, r[0])
+		}
+	}
+	if len(tips) == 0 {
+		return new(big.Int)
+	}
+	sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+	return new(big.Int).Set(tips[len(tips)/2])
+}
+
+// estimateGas asks the node and adds the network's safety margin
+func estimateGas(ctx context.Context, client txBackend, network string, msg ethereum.CallMsg) (uint64, error) {
+	gas, err := client.EstimateGas(ctx, msg)
+	if err != nil {
+		// a node that answers the estimation with an error is saying the call reverts
+		var answered rpc.Error
+		if errors.As(err, &answered) {
+			return 0, all712.Errorf(all712.CodeSettlementReverts, "gas estimation failed: %w", err)
+		}
+		return 0, callError(fmt.Errorf("gas estimation failed: %w", err))
+	}
+	return uint64(float64(gas) * feeConfig(network).GasMargin), nil
+}
+
+func newTx(chainID *big.Int, nonce uint64, to common.Address, value *big.Int, gas uint64, fees txFees, data []byte) *types.Transaction {
+	if fees.legacy() {
+		return types.NewTransaction(nonce, to, value, gas, fees.GasPrice, data)
+	}
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: fees.TipCap,
+		GasFeeCap: fees.FeeCap,
+		Gas:       gas,
+		To:        &to,
+		Value:     value,
+		Data:      data,
+	})
+}
+
+// GasBudget converts the fee cap of the network into wei, given the payment value in the reference currency.
+// It returns nil, no cap, when there is no reference price for the native currency of the network.
+func GasBudget(network string, paymentValue float64) *big.Int {
+	n, ok := GetNetwork(network)
+	if !ok || n.NativeCurrency.RefPrice == 0 {
+		return nil
+	}
+	native := paymentValue * feeConfig(network).MaxFeeFraction / n.NativeCurrency.RefPrice
+	unit := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n.NativeCurrency.Decimals)), nil))
+	wei, _ := new(big.Float).Mul(big.NewFloat(native), unit).Int(nil)
+	return wei
+}
@@ -17,7 +17,6 @@ func GetMarkup(network, asset, facilitator string) (markup *big.Int, err error)
 		err = fmt.Errorf("failed to connect to rpc: %w", err)
 		return
 	}
-	defer client.Close()
 
 	tokenAddress := common.HexToAddress(asset)
 	facAddress := common.HexToAddress(facilitator)
@@ -45,7 +44,6 @@ func GetDetailedMarkup(network, asset string, destChainId uint32, facilitator st
 		err = fmt.Errorf("failed to connect to rpc: %w", err)
 		return
 	}
-	defer client.Close()
 
 	tokenAddress := common.HexToAddress(asset)
 	facAddress := common.HexToAddress(facilitator)
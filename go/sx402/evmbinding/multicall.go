@@ -0,0 +1,152 @@
+package evmbinding
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// The canonical Multicall3 deployment, the same address on most chains; networks.json may say otherwise
+var Multicall3Canonical = common.HexToAddress("0xcA11bde05977b3631167028862bE2a173976CA11")
+
+var multicall3ABI, _ = abi.JSON(strings.NewReader(`[{"type":"function","name":"aggregate3","stateMutability":"payable",
+	"inputs":[{"name":"calls","type":"tuple[]","components":[
+		{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}]}],
+	"outputs":[{"name":"returnData","type":"tuple[]","components":[
+		{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}]}]}]`))
+
+// CallResult is the outcome of one of the calls aggregated by Multicall3
+type CallResult struct {
+	Success    bool
+	ReturnData []byte
+}
+
+type call3 struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+// Multicall3Address is where Multicall3 lives on the network
+func Multicall3Address(network string) (common.Address, bool) {
+	n, ok := GetNetwork(network)
+	if !ok {
+		return common.Address{}, false
+	}
+	if n.Multicall3 == nil {
+		return Multicall3Canonical, true
+	}
+	return *n.Multicall3, true
+}
+
+// The EIP-3009 settlement calls, both overloads. They check a signature of the payer and not who calls them,
+// which is what makes them safe to send through Multicall3: there msg.sender is Multicall3, not the facilitator.
+var transferWithAuthorizationSelectors = [][]byte{
+	crypto.Keccak256([]byte("transferWithAuthorization(address,address,uint256,uint256,uint256,bytes32,uint8,bytes32,bytes32)"))[:4],
+	crypto.Keccak256([]byte("transferWithAuthorization(address,address,uint256,uint256,uint256,bytes32,bytes)"))[:4],
+}
+
+var authorizationUsedTopic = crypto.Keccak256Hash([]byte("AuthorizationUsed(address,bytes32)"))
+
+// IsTransferWithAuthorization tells whether the call is an EIP-3009 transferWithAuthorization,
+// the only settlement that does not care about its msg.sender and so the only one that may be batched
+func IsTransferWithAuthorization(call Call) bool {
+	if len(call.Data) < 4 {
+		return false
+	}
+	for _, selector := range transferWithAuthorizationSelectors {
+		if bytes.Equal(call.Data[:4], selector) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationUsed tells whether the receipt has the token consuming the authorization of the payer,
+// which is how an aggregate3 with allowFailure tells which of its calls went through
+func AuthorizationUsed(receipt *types.Receipt, token, authorizer common.Address, nonce common.Hash) bool {
+	for _, l := range receipt.Logs {
+		if l.Address == token && len(l.Topics) == 3 && l.Topics[0] == authorizationUsedTopic &&
+			l.Topics[1] == common.BytesToHash(authorizer.Bytes()) && l.Topics[2] == nonce {
+			return true
+		}
+	}
+	return false
+}
+
+// Aggregate3Call runs the calls one after the other in a single transaction to Multicall3.
+// Without allowFailure one failing call reverts them all. The calls cannot carry value,
+// and their msg.sender is Multicall3, not the sender of the transaction.
+func Aggregate3Call(multicall common.Address, allowFailure bool, calls ...Call) (Call, error) {
+	c3 := make([]call3, len(calls))
+	for i, c := range calls {
+		if c.Value != nil && c.Value.Sign() != 0 {
+			return Call{}, fmt.Errorf("call %v carries value, aggregate3 does not pass it on", i)
+		}
+		c3[i] = call3{c.To, allowFailure, c.Data}
+	}
+	data, err := multicall3ABI.Pack("aggregate3", c3)
+	if err != nil {
+		return Call{}, fmt.Errorf("error packing the call: %w", err)
+	}
+	return Call{To: multicall, Data: data}, nil
+}
+
+// EstimateAggregate3 is the gas for the calls through Multicall3, margin included. It estimates them all-or-nothing:
+// with allowFailure a call short of gas only fails on its own, and the estimate could starve the later ones.
+func EstimateAggregate3(ctx context.Context, network string, from common.Address, calls ...Call) (uint64, error) {
+	multicall, ok := Multicall3Address(network)
+	if !ok {
+		return 0, fmt.Errorf("unknown network: %s", network)
+	}
+	client, err := GetClientByNetwork(network)
+	if err != nil {
+		return 0, err
+	}
+	call, err := Aggregate3Call(multicall, false, calls...)
+	if err != nil {
+		return 0, err
+	}
+	return estimateGas(ctx, client, network, ethereum.CallMsg{From: from, To: &call.To, Data: call.Data})
+}
+
+// TryAggregate3 runs the calls through Multicall3 with eth_call from the sender, letting each of them fail on its own,
+// and tells how every one of them went
+func TryAggregate3(ctx context.Context, network string, from common.Address, calls ...Call) ([]CallResult, error) {
+	multicall, ok := Multicall3Address(network)
+	if !ok {
+		return nil, fmt.Errorf("unknown network: %s", network)
+	}
+	client, err := GetClientByNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	call, err := Aggregate3Call(multicall, true, calls...)
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.CallContract(ctx, ethereum.CallMsg{From: from, To: &call.To, Data: call.Data}, nil)
+	if err != nil {
+		return nil, callError(err)
+	}
+	return decodeAggregate3(result, len(calls))
+}
+
+func decodeAggregate3(result []byte, n int) ([]CallResult, error) {
+	out, err := multicall3ABI.Unpack("aggregate3", result)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected aggregate3 answer: %w", err)
+	}
+	results := *abi.ConvertType(out[0], new([]CallResult)).(*[]CallResult)
+	if len(results) != n {
+		return nil, fmt.Errorf("aggregate3 answered %v results for %v calls", len(results), n)
+	}
+	return results, nil
+}
@@ -0,0 +1,104 @@
+package evmbinding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// multicallNode plays Multicall3: every aggregated call succeeds and echoes its data, except the ones starting with 0xdead
+type multicallNode struct{}
+
+func (multicallNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+		Params []struct {
+			To    common.Address `json:"to"`
+			Input hexutil.Bytes  `json:"input"`
+		} `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Method != "eth_call" || req.Params[0].To != Multicall3Canonical {
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"error":{"code":-32000,"message":"unexpected call"}}`, req.ID)
+		return
+	}
+	method := multicall3ABI.Methods["aggregate3"]
+	in, _ := method.Inputs.Unpack(req.Params[0].Input[4:])
+	calls := *abi.ConvertType(in[0], new([]call3)).(*[]call3)
+	results := make([]CallResult, len(calls))
+	for i, c := range calls {
+		results[i] = CallResult{!bytes.HasPrefix(c.CallData, common.FromHex("0xdead")) || !c.AllowFailure, c.CallData}
+	}
+	out, _ := method.Outputs.Pack(results)
+	fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":%q}`, req.ID, hexutil.Encode(out))
+}
+
+func TestAggregate3(t *testing.T) {
+	srv := httptest.NewServer(multicallNode{})
+	t.Cleanup(srv.Close)
+	withTestNetwork(t, srv.URL)
+	saved := pool
+	pool = NewClientPool()
+	t.Cleanup(func() { pool = saved })
+
+	calls := []Call{
+		{To: common.HexToAddress("0x01"), Data: common.FromHex("0x12345678")},
+		{To: common.HexToAddress("0x02"), Data: common.FromHex("0xdeadbeef")},
+		{To: common.HexToAddress("0x03"), Data: common.FromHex("0xcafe")},
+	}
+	results, err := TryAggregate3(context.Background(), "devnet", common.HexToAddress("0x77"), calls...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false, true} {
+		if results[i].Success != want || !bytes.Equal(results[i].ReturnData, calls[i].Data) {
+			t.Errorf("call %v: %v %x", i, results[i].Success, results[i].ReturnData)
+		}
+	}
+
+	if _, err := Aggregate3Call(Multicall3Canonical, false, Call{To: common.HexToAddress("0x01"), Value: big.NewInt(1)}); err == nil {
+		t.Error("aggregate3 took a call with value")
+	}
+	if _, err := decodeAggregate3(common.FromHex("0x1234"), 1); err == nil {
+		t.Error("garbage decoded")
+	}
+}
+
+func TestTransferWithAuthorizationCalls(t *testing.T) {
+	token, from := common.HexToAddress("0x01"), common.HexToAddress("0x857b06519E91e3A54538791bDbb0E22373e36b66")
+	nonce := common.HexToHash("0xa1")
+	split, err := TransferWithAuthorizationCall(token, from, common.HexToAddress("0x02"), big.NewInt(1), big.NewInt(0), big.NewInt(1), nonce, [32]byte{}, [32]byte{}, 27)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := TransferWithAuthorizationBytesCall(token, from, common.HexToAddress("0x02"), big.NewInt(1), big.NewInt(0), big.NewInt(1), nonce, []byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsTransferWithAuthorization(split) || !IsTransferWithAuthorization(packed) {
+		t.Error("transferWithAuthorization not recognised")
+	}
+	if IsTransferWithAuthorization(Call{To: token, Data: common.FromHex("0x23b872dd")}) || IsTransferWithAuthorization(Call{To: token}) {
+		t.Error("transferFrom taken for transferWithAuthorization")
+	}
+
+	used := &types.Log{Address: token, Topics: []common.Hash{authorizationUsedTopic, common.BytesToHash(from.Bytes()), nonce}}
+	receipt := &types.Receipt{Logs: []*types.Log{used}}
+	if !AuthorizationUsed(receipt, token, from, nonce) {
+		t.Error("AuthorizationUsed not found")
+	}
+	if AuthorizationUsed(receipt, token, from, common.HexToHash("0xa2")) || AuthorizationUsed(receipt, common.HexToAddress("0x03"), from, nonce) {
+		t.Error("AuthorizationUsed of another authorization taken")
+	}
+}
@@ -0,0 +1,351 @@
+package evmbinding
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/san-lab/sx402/all712"
+)
+
+const Base_sepolia = "base-sepolia"
+const Amoy = "amoy"
+const Sepolia = "sepolia"
+const Holesky = "holesky"
+const ZkSync_sepolia = "zksync-sepolia"
+const Arbitrum_sepolia = "arbitrum-sepolia"
+const OP_Sepolia = "op-sepolia"
+
+// Network is everything the facilitator knows about a chain
+type Network struct {
+	Name           string          `json:"name"`
+	ChainID        *big.Int        `json:"chainId"`
+	RPCURLs        []string        `json:"rpcUrls"`
+	Explorer       string          `json:"explorer"`
+	LzEid          uint32          `json:"lzEid,omitempty"` // LayerZero endpoint id, 0 if not connected
+	NativeCurrency Currency        `json:"nativeCurrency"`
+	Confirmations  uint64          `json:"confirmations"` // blocks on top of the inclusion block before a receipt counts
+	Fees           FeeConfig       `json:"fees"`
+	Permit2        *common.Address `json:"permit2,omitempty"`    // the Permit2 deployment, if not the canonical one
+	Multicall3     *common.Address `json:"multicall3,omitempty"` // the Multicall3 deployment, if not the canonical one
+}
+
+type Currency struct {
+	Name     string  `json:"name"`
+	Symbol   string  `json:"symbol"`
+	Decimals uint8   `json:"decimals"`
+	RefPrice float64 `json:"refPrice,omitempty"` // in the reference currency, used for the fee caps
+}
+
+// FeeConfig says how settlement transactions are priced on the network. Zero values mean the defaults.
+type FeeConfig struct {
+	Legacy            bool    `json:"legacy,omitempty"`            // no EIP-1559 on the chain, use gasPrice
+	TipPercentile     float64 `json:"tipPercentile,omitempty"`     // of the eth_feeHistory rewards, default 50
+	BaseFeeMultiplier float64 `json:"baseFeeMultiplier,omitempty"` // headroom over the next base fee, default 2
+	GasMargin         float64 `json:"gasMargin,omitempty"`         // on top of EstimateGas, default 1.2
+	MaxFeeFraction    float64 `json:"maxFeeFraction,omitempty"`    // max gas cost as a fraction of the payment value, default 0.5
+}
+
+var ether = Currency{"Ether", "ETH", 18, 0}
+
+// Compiled-in defaults, config/networks.json overrides them field by field and may add new networks
+var networks = map[string]*Network{
+	Base_sepolia: {
+		Name:           Base_sepolia,
+		ChainID:        big.NewInt(84532),
+		RPCURLs:        []string{"https://sepolia.base.org"},
+		Explorer:       "https://sepolia.basescan.org", // Basescan (Etherscan-style)
+		LzEid:          40245,
+		NativeCurrency: ether,
+	},
+	Sepolia: {
+		Name:           Sepolia,
+		ChainID:        big.NewInt(11155111),
+		RPCURLs:        []string{"https://ethereum-sepolia-rpc.publicnode.com"},
+		Explorer:       "https://sepolia.etherscan.io", // Official Sepolia Etherscan
+		LzEid:          40161,
+		NativeCurrency: ether,
+	},
+	Amoy: {
+		Name:           Amoy,
+		ChainID:        big.NewInt(80002),
+		RPCURLs:        []string{"https://rpc-amoy.polygon.technology/"},
+		Explorer:       "https://amoy.polygonscan.com", // Polygonscan for Amoy
+		LzEid:          40267,
+		NativeCurrency: Currency{"POL", "POL", 18, 0},
+	},
+	Holesky: {
+		Name:           Holesky,
+		ChainID:        big.NewInt(17000),
+		RPCURLs:        []string{"https://ethereum-holesky.publicnode.com"},
+		Explorer:       "https://holesky.etherscan.io", // Etherscan for Holesky
+		LzEid:          40217,
+		NativeCurrency: ether,
+	},
+	ZkSync_sepolia: {
+		Name:           ZkSync_sepolia,
+		ChainID:        big.NewInt(300),
+		RPCURLs:        []string{"https://sepolia.era.zksync.dev"},
+		Explorer:       "https://sepolia-era.zksync.network/",
+		LzEid:          40305,
+		NativeCurrency: ether,
+	},
+	Arbitrum_sepolia: {
+		Name:           Arbitrum_sepolia,
+		ChainID:        big.NewInt(421614),
+		RPCURLs:        []string{"https://sepolia-rollup.arbitrum.io/rpc"}, // "https://arbitrum-sepolia.gateway.tenderly.co",
+		Explorer:       "https://sepolia.arbiscan.io",
+		LzEid:          40231,
+		NativeCurrency: ether,
+	},
+	OP_Sepolia: {
+		Name:           OP_Sepolia,
+		ChainID:        big.NewInt(11155420),
+		RPCURLs:        []string{"https://optimism-sepolia.gateway.tenderly.co"},
+		Explorer:       "https://sepolia-optimism.etherscan.io/",
+		LzEid:          40232,
+		NativeCurrency: ether,
+	},
+}
+
+func init() {
+	log.Println(LoadNetworks("config/networks.json"))
+	all712.NetworkCAIP2, all712.NetworkName = CAIP2, ResolveNetwork
+}
+
+// LoadNetworks merges the network file into the registry.
+// Fields left empty in the file keep their compiled-in value.
+func LoadNetworks(relativePath string) error {
+	absPath, err := filepath.Abs(relativePath)
+	if err != nil {
+		return fmt.Errorf("could not resolve path: %w", err)
+	}
+
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return fmt.Errorf("could not read file %s: %w", absPath, err)
+	}
+
+	var overrides []*Network
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return fmt.Errorf("invalid JSON in %s: %w", absPath, err)
+	}
+
+	merged := map[string]*Network{}
+	for k, v := range networks {
+		n := *v
+		merged[k] = &n
+	}
+	for _, o := range overrides {
+		if len(o.Name) == 0 {
+			return fmt.Errorf("network without a name in %s", absPath)
+		}
+		n, ok := merged[o.Name]
+		if !ok {
+			n = &Network{Name: o.Name}
+			merged[o.Name] = n
+		}
+		n.merge(o)
+	}
+
+	for _, n := range merged {
+		if err := n.validate(); err != nil {
+			return fmt.Errorf("%s: %w", absPath, err)
+		}
+	}
+	if err := checkUnique(merged); err != nil {
+		return fmt.Errorf("%s: %w", absPath, err)
+	}
+	uncapped := []string{}
+	for name, n := range merged {
+		if n.NativeCurrency.RefPrice == 0 {
+			uncapped = append(uncapped, name)
+		}
+	}
+	if len(uncapped) > 0 {
+		sort.Strings(uncapped)
+		log.Printf("no refPrice for the native currency of %s, settlement fees are not capped there", strings.Join(uncapped, ", "))
+	}
+	networks = merged
+	return nil
+}
+
+func (n *Network) merge(o *Network) {
+	if o.ChainID != nil {
+		n.ChainID = o.ChainID
+	}
+	if len(o.RPCURLs) > 0 {
+		n.RPCURLs = o.RPCURLs
+	}
+	if len(o.Explorer) > 0 {
+		n.Explorer = o.Explorer
+	}
+	if o.LzEid != 0 {
+		n.LzEid = o.LzEid
+	}
+	if len(o.NativeCurrency.Symbol) > 0 {
+		n.NativeCurrency = o.NativeCurrency
+	}
+	if o.Confirmations != 0 {
+		n.Confirmations = o.Confirmations
+	}
+	if o.Permit2 != nil {
+		n.Permit2 = o.Permit2
+	}
+	if o.Multicall3 != nil {
+		n.Multicall3 = o.Multicall3
+	}
+	if o.Fees.Legacy {
+		n.Fees.Legacy = true
+	}
+	if o.Fees.TipPercentile != 0 {
+		n.Fees.TipPercentile = o.Fees.TipPercentile
+	}
+	if o.Fees.BaseFeeMultiplier != 0 {
+		n.Fees.BaseFeeMultiplier = o.Fees.BaseFeeMultiplier
+	}
+	if o.Fees.GasMargin != 0 {
+		n.Fees.GasMargin = o.Fees.GasMargin
+	}
+	if o.Fees.MaxFeeFraction != 0 {
+		n.Fees.MaxFeeFraction = o.Fees.MaxFeeFraction
+	}
+}
+
+func (n *Network) validate() error {
+	if n.ChainID == nil || n.ChainID.Sign() <= 0 {
+		return fmt.Errorf("network %s: missing chainId", n.Name)
+	}
+	if len(n.RPCURLs) == 0 {
+		return fmt.Errorf("network %s: no rpc urls", n.Name)
+	}
+	f := n.Fees
+	if f.TipPercentile < 0 || f.TipPercentile > 100 {
+		return fmt.Errorf("network %s: tipPercentile out of range", n.Name)
+	}
+	if f.BaseFeeMultiplier < 0 || (f.GasMargin != 0 && f.GasMargin < 1) || f.MaxFeeFraction < 0 {
+		return fmt.Errorf("network %s: invalid fee settings", n.Name)
+	}
+	return nil
+}
+
+func checkUnique(nets map[string]*Network) error {
+	chains := map[string]string{}
+	eids := map[uint32]string{}
+	for name, n := range nets {
+		if other, dup := chains[n.ChainID.String()]; dup {
+			return fmt.Errorf("chainId %v used by both %s and %s", n.ChainID, name, other)
+		}
+		chains[n.ChainID.String()] = name
+		if n.LzEid == 0 {
+			continue
+		}
+		if other, dup := eids[n.LzEid]; dup {
+			return fmt.Errorf("LayerZero eid %v used by both %s and %s", n.LzEid, name, other)
+		}
+		eids[n.LzEid] = name
+	}
+	return nil
+}
+
+func GetNetwork(name string) (*Network, bool) {
+	n, ok := networks[name]
+	return n, ok
+}
+
+func NetworkByChainID(chainID *big.Int) (*Network, bool) {
+	if chainID == nil {
+		return nil, false
+	}
+	for _, n := range networks {
+		if n.ChainID.Cmp(chainID) == 0 {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// NetworkByEid is the reverse lookup from a LayerZero endpoint id
+func NetworkByEid(eid uint32) (*Network, bool) {
+	if eid == 0 {
+		return nil, false
+	}
+	for _, n := range networks {
+		if n.LzEid == eid {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// Networks returns all known networks sorted by name
+func Networks() []*Network {
+	all := make([]*Network, 0, len(networks))
+	for _, n := range networks {
+		all = append(all, n)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+func ChainID(network string) (*big.Int, bool) {
+	n, ok := networks[network]
+	if !ok {
+		return nil, false
+	}
+	return n.ChainID, true
+}
+
+func ExplorerURL(network string) string {
+	n, ok := networks[network]
+	if !ok {
+		return ""
+	}
+	return n.Explorer
+}
+
+// LzEid returns the LayerZero endpoint id of the network as the decimal string used in ExtraInfo
+func LzEid(network string) (string, bool) {
+	n, ok := networks[network]
+	if !ok || n.LzEid == 0 {
+		return "", false
+	}
+	return strconv.FormatUint(uint64(n.LzEid), 10), true
+}
+
+// CAIP2 is the chain id of the network as x402 v2 spells it, e.g. "eip155:84532"
+func CAIP2(network string) (string, bool) {
+	n, ok := networks[network]
+	if !ok {
+		return "", false
+	}
+	return "eip155:" + n.ChainID.String(), true
+}
+
+// ResolveNetwork takes a network name or a CAIP-2 id and returns the network name.
+// Anything it does not recognize comes back as it is.
+func ResolveNetwork(id string) (string, bool) {
+	if _, ok := networks[id]; ok {
+		return id, true
+	}
+	ref, found := strings.CutPrefix(id, "eip155:")
+	if !found {
+		return id, false
+	}
+	chainID, ok := new(big.Int).SetString(ref, 10)
+	if !ok {
+		return id, false
+	}
+	n, ok := NetworkByChainID(chainID)
+	if !ok {
+		return id, false
+	}
+	return n.Name, true
+}
@@ -0,0 +1,129 @@
+package evmbinding
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/san-lab/sx402/all712"
+)
+
+func TestNetworkLookups(t *testing.T) {
+	n, ok := NetworkByEid(40245)
+	if !ok || n.Name != Base_sepolia {
+		t.Errorf("eid 40245 should resolve to %s, got %v", Base_sepolia, n)
+	}
+	n, ok = NetworkByChainID(big.NewInt(421614))
+	if !ok || n.Name != Arbitrum_sepolia {
+		t.Errorf("chain 421614 should resolve to %s, got %v", Arbitrum_sepolia, n)
+	}
+	if _, ok := NetworkByEid(0); ok {
+		t.Error("eid 0 must not resolve")
+	}
+	eid, ok := LzEid(Arbitrum_sepolia)
+	if !ok || eid != "40231" {
+		t.Errorf("unexpected eid for %s: %s", Arbitrum_sepolia, eid)
+	}
+}
+
+func TestLoadNetworks(t *testing.T) {
+	saved := networks
+	defer func() { networks = saved }()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "networks.json")
+	cfg := `[
+	{"name": "base-sepolia", "rpcUrls": ["http://localhost:8545", "http://localhost:8546"], "confirmations": 2},
+	{"name": "devnet", "chainId": 1337, "rpcUrls": ["http://localhost:9545"], "lzEid": 49999}
+]`
+	if err := os.WriteFile(path, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadNetworks(path); err != nil {
+		t.Fatal(err)
+	}
+
+	base, _ := GetNetwork(Base_sepolia)
+	if len(base.RPCURLs) != 2 || base.Confirmations != 2 {
+		t.Errorf("override not applied: %+v", base)
+	}
+	if base.ChainID.Int64() != 84532 || base.LzEid != 40245 {
+		t.Errorf("defaults lost in merge: %+v", base)
+	}
+	if saved[Base_sepolia].Confirmations != 0 {
+		t.Error("merge modified the previous registry")
+	}
+	if n, ok := NetworkByEid(49999); !ok || n.Name != "devnet" {
+		t.Error("new network not registered")
+	}
+
+	clash := `[{"name": "devnet2", "chainId": 84532, "rpcUrls": ["http://localhost:9545"]}]`
+	if err := os.WriteFile(path, []byte(clash), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadNetworks(path); err == nil {
+		t.Error("duplicate chainId accepted")
+	}
+}
+
+func TestCAIP2(t *testing.T) {
+	id, ok := CAIP2(Base_sepolia)
+	if !ok || id != "eip155:84532" {
+		t.Errorf("unexpected CAIP-2 id for %s: %s", Base_sepolia, id)
+	}
+	for _, in := range []string{"eip155:84532", Base_sepolia} {
+		if name, ok := ResolveNetwork(in); !ok || name != Base_sepolia {
+			t.Errorf("%s resolved to %s", in, name)
+		}
+	}
+	for _, in := range []string{"eip155:1234567", "solana:devnet", "nowhere"} {
+		if name, ok := ResolveNetwork(in); ok || name != in {
+			t.Errorf("%s should come back unresolved, got %s", in, name)
+		}
+	}
+}
+
+// the envelope codec of all712 goes through the network table of this package
+func TestEnvelopeVersions(t *testing.T) {
+	v2 := `{"x402Version":2,
+		"paymentPayload":{"x402Version":2,"resource":{"url":"http://shop/r","description":"thing"},
+			"accepted":{"scheme":"exact","network":"eip155:84532","amount":"1000","asset":"0xA","payTo":"0xB","maxTimeoutSeconds":60},
+			"payload":{"signature":"0x01"},"extensions":{"bazaar":{}}},
+		"paymentRequirements":{"scheme":"exact","network":"eip155:84532","amount":"1000","asset":"0xA","payTo":"0xB","maxTimeoutSeconds":60}}`
+	var env all712.Envelope
+	if err := json.Unmarshal([]byte(v2), &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.PaymentPayload.Network != Base_sepolia || env.PaymentRequirements.Network != Base_sepolia {
+		t.Errorf("CAIP-2 network not resolved: %s/%s", env.PaymentPayload.Network, env.PaymentRequirements.Network)
+	}
+	if env.PaymentRequirements.MaxAmountRequired != "1000" || env.PaymentRequirements.Resource != "http://shop/r" {
+		t.Errorf("v2 requirements not translated: %+v", env.PaymentRequirements)
+	}
+	if _, ok := env.Extensions["bazaar"]; !ok {
+		t.Error("extensions lost")
+	}
+
+	// and back the way it came
+	bts, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again all712.Envelope
+	if err := json.Unmarshal(bts, &again); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(bts, []byte(`"network":"eip155:84532"`)) || again.PaymentRequirements.Resource != "http://shop/r" {
+		t.Errorf("v2 envelope did not survive the round trip: %s", bts)
+	}
+
+	// v1 stays untouched
+	env.X402Version = 1
+	bts, _ = json.Marshal(env)
+	if !bytes.Contains(bts, []byte(`"network":"base-sepolia"`)) || !bytes.Contains(bts, []byte(`"maxAmountRequired":"1000"`)) {
+		t.Errorf("v1 envelope encoded wrong: %s", bts)
+	}
+}
@@ -5,52 +5,17 @@ import (
 	"fmt"
 	"math/big"
 	"strings"
-	"sync"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 )
 
-type nonceState struct {
-	mu    sync.Mutex
-	nonce uint64
-}
-
-var nonceMap sync.Map // map[common.Address]*nonceState
-
-func getNonce(ctx context.Context, client *ethclient.Client, address common.Address) (uint64, error) {
-	// Load or initialize nonceState for this address
-	val, _ := nonceMap.LoadOrStore(address, &nonceState{})
-	state := val.(*nonceState)
-
-	state.mu.Lock()
-	defer state.mu.Unlock()
-
-	// Fetch the latest pending nonce from the node
-	inblock, err := client.PendingNonceAt(ctx, address)
-	if err != nil {
-		return 0, err
-	}
-
-	// Sync in-memory nonce with on-chain nonce if on-chain is higher
-	if inblock > state.nonce {
-		state.nonce = inblock
-	}
-
-	nonceToUse := state.nonce
-	state.nonce++
-
-	return nonceToUse, nil
-}
-
 func PermitNonce(network, asset, owner string) (*big.Int, error) {
 	client, err := GetClientByNetwork(network)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to rpc: %w", err)
 	}
-	defer client.Close()
 
 	tokenAddress := common.HexToAddress(asset)
 	ownerAddress := common.HexToAddress(owner)
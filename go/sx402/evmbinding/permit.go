@@ -1,17 +1,13 @@
 package evmbinding
 
 import (
-	"context"
-	"crypto/ecdsa"
 	"encoding/hex"
+	"fmt"
 	"math/big"
 	"strings"
 
-	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/san-lab/sx402/all712"
 )
 
@@ -46,20 +42,41 @@ const permitABI = `[
   }
 ]`
 
-func EnactPermit(permit *all712.PermitMessage, facilKey *ecdsa.PrivateKey) (*common.Hash, error) {
-	client, err := GetlientByChainID(permit.Domain.ChainID)
+func EnactPermit(permit *all712.PermitMessage, facilitator Signer, maxCost *big.Int) (*common.Hash, error) {
+	network, ok := NetworkByChainID(permit.Domain.ChainID)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported ChainID: %v", permit.Domain.ChainID)
+	}
+	call, err := PermitCall(permit)
+	if err != nil {
+		return nil, err
+	}
 
-	parsedABI, err := abi.JSON(strings.NewReader(permitABI))
+	// Gas and fees are up to the tx manager, which takes care of nonce and re-pricing as well
+	h, err := Transactions().Send(TxRequest{Network: network.Name, Signer: facilitator, To: call.To, Data: call.Data, MaxCost: maxCost})
 	if err != nil {
 		return nil, err
 	}
+	return &h, nil
+
+}
+
+// PermitCall is the permit() call enacting the signed EIP-2612 message
+func PermitCall(permit *all712.PermitMessage) (Call, error) {
+	parsedABI, err := abi.JSON(strings.NewReader(permitABI))
+	if err != nil {
+		return Call{}, err
+	}
 
 	var r, s [32]byte
 	var v byte
 	// Convert r, s (hex strings to []byte)
 	sig, err := hex.DecodeString(strings.TrimPrefix(permit.Signature, "0x"))
-	if err != nil || len(r) != 32 {
-		return nil, err
+	if err != nil {
+		return Call{}, err
+	}
+	if len(sig) != 65 {
+		return Call{}, fmt.Errorf("invalid signature length: %v", len(sig))
 	}
 	copy(r[:], sig[:32])
 	copy(s[:], sig[32:64])
@@ -71,121 +88,42 @@ func EnactPermit(permit *all712.PermitMessage, facilKey *ecdsa.PrivateKey) (*com
 	input, err := parsedABI.Pack("permit", permit.Message.Owner, permit.Message.Spender,
 		permit.Message.Value, permit.Message.Deadline, v, r, s)
 	if err != nil {
-		return nil, err
-	}
-
-	// 3. Get nonce for your account
-	facilAddress := crypto.PubkeyToAddress(facilKey.PublicKey)
-
-	fromNonce, err := getNonce(context.Background(), client, facilAddress)
-	if err != nil {
-		return nil, err
-	}
-
-	// 4. Suggest gas price
-	gasPrice, err := client.SuggestGasPrice(context.Background())
-	if err != nil {
-		return nil, err
+		return Call{}, err
 	}
+	return Call{To: permit.Domain.VerifyingContract, Data: input}, nil
+}
 
-	// 5. Estimate gas limit
-	msg := ethereum.CallMsg{
-		From:     facilAddress,
-		To:       &permit.Domain.VerifyingContract,
-		GasPrice: gasPrice,
-		Value:    big.NewInt(0),
-		Data:     input,
+func TransferFrom(from, to, asset common.Address, amount, chainID *big.Int, facilitator Signer, maxCost *big.Int) (*common.Hash, error) {
+	network, ok := NetworkByChainID(chainID)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported ChainID: %v", chainID)
 	}
-	gasLimit, err := client.EstimateGas(context.Background(), msg)
+	call, err := TransferFromCall(from, to, asset, amount)
 	if err != nil {
 		return nil, err
 	}
 
-	// 6. Create the transaction
-	tx := types.NewTransaction(fromNonce, permit.Domain.VerifyingContract, big.NewInt(0), gasLimit+10000, gasPrice, input)
-
-	// 7. Sign the transaction
-	chainID, err := client.NetworkID(context.Background())
-	if err != nil {
-		return nil, err
-	}
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), facilKey)
-	if err != nil {
-		return nil, err
-	}
+	// No estimation here: the allowance only exists once the permit tx is mined,
+	// and estimating before that reverts. A transferFrom stays well under this.
+	gasLimit := uint64(100000)
 
-	err = client.SendTransaction(context.Background(), signedTx)
+	// Hand it over to the tx manager, it comes right after the permit nonce-wise
+	h, err := Transactions().Send(TxRequest{Network: network.Name, Signer: facilitator, To: call.To, Data: call.Data, GasLimit: gasLimit, MaxCost: maxCost})
 	if err != nil {
 		return nil, err
 	}
-
-	// Return transaction hash
-	h := signedTx.Hash()
 	return &h, nil
 
 }
 
-func TransferFrom(from, to, asset common.Address, amount, chainID *big.Int, facilKey *ecdsa.PrivateKey) (*common.Hash, error) {
-	client, err := GetlientByChainID(chainID)
-
+func TransferFromCall(from, to, asset common.Address, amount *big.Int) (Call, error) {
 	parsedABI, err := abi.JSON(strings.NewReader(permitABI))
 	if err != nil {
-		return nil, err
+		return Call{}, err
 	}
-
-	// 3. Get nonce for your account
-	facilAddress := crypto.PubkeyToAddress(facilKey.PublicKey)
-
 	input, err := parsedABI.Pack("transferFrom", from, to, amount)
 	if err != nil {
-		return nil, err
+		return Call{}, err
 	}
-
-	fromNonce, err := getNonce(context.Background(), client, facilAddress)
-	if err != nil {
-		return nil, err
-	}
-
-	// 4. Suggest gas price
-	gasPrice, err := client.SuggestGasPrice(context.Background())
-	if err != nil {
-		return nil, err
-	}
-
-	// 5. Estimate gas limit
-	/* Racy, racy
-	msg := ethereum.CallMsg{
-		From:     from,
-		To:       &asset,
-		GasPrice: gasPrice,
-		Value:    big.NewInt(0),
-		Data:     input,
-	}
-
-	gasLimit, err := client.EstimateGas(context.Background(), msg)
-	if err != nil {
-		return nil, err
-	}
-	*/
-	gasLimit := uint64(100000)
-
-	// 6. Create the transaction
-	tx := types.NewTransaction(fromNonce, asset, big.NewInt(0), gasLimit, gasPrice, input)
-
-	// 7. Sign the transaction
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), facilKey)
-	if err != nil {
-		return nil, err
-	}
-
-	// 8. Send transaction
-	err = client.SendTransaction(context.Background(), signedTx)
-	if err != nil {
-		return nil, err
-	}
-
-	// Return transaction hash
-	h := signedTx.Hash()
-	return &h, nil
-
+	return Call{To: asset, Data: input}, nil
 }
@@ -0,0 +1,116 @@
+package evmbinding
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/san-lab/sx402/all712"
+)
+
+// The canonical Uniswap Permit2 deployment, the same address on most chains; networks.json may say otherwise
+var Permit2Canonical = common.HexToAddress("0x000000000022D473030F116dDEE9F6B43aC78BA3")
+
+var permit2ABI, _ = abi.JSON(strings.NewReader(`[
+  {"type":"function","name":"nonceBitmap","stateMutability":"view",
+    "inputs":[{"name":"owner","type":"address"},{"name":"wordPos","type":"uint256"}],"outputs":[{"name":"","type":"uint256"}]},
+  {"type":"function","name":"permitWitnessTransferFrom","stateMutability":"nonpayable","outputs":[],
+    "inputs":[
+      {"name":"permit","type":"tuple","components":[
+        {"name":"permitted","type":"tuple","components":[{"name":"token","type":"address"},{"name":"amount","type":"uint256"}]},
+        {"name":"nonce","type":"uint256"},{"name":"deadline","type":"uint256"}]},
+      {"name":"transferDetails","type":"tuple","components":[{"name":"to","type":"address"},{"name":"requestedAmount","type":"uint256"}]},
+      {"name":"owner","type":"address"},
+      {"name":"witness","type":"bytes32"},
+      {"name":"witnessTypeString","type":"string"},
+      {"name":"signature","type":"bytes"}]}
+]`))
+
+var allowanceABI, _ = abi.JSON(strings.NewReader(`[{"type":"function","name":"allowance","stateMutability":"view",
+	"inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}]`))
+
+// Permit2Address is where Permit2 lives on the network
+func Permit2Address(network string) (common.Address, bool) {
+	n, ok := GetNetwork(network)
+	if !ok {
+		return common.Address{}, false
+	}
+	if n.Permit2 == nil {
+		return Permit2Canonical, true
+	}
+	return *n.Permit2, true
+}
+
+// CheckPermit2Nonce tells whether the owner has used the nonce, i.e. its bit in the nonce bitmap is set
+func CheckPermit2Nonce(client *ethclient.Client, permit2, owner common.Address, nonce *big.Int) (bool, error) {
+	wordPos := new(big.Int).Rsh(nonce, 8)
+	bitPos := uint(new(big.Int).And(nonce, big.NewInt(0xff)).Uint64())
+	data, err := permit2ABI.Pack("nonceBitmap", owner, wordPos)
+	if err != nil {
+		return false, fmt.Errorf("failed to pack data: %w", err)
+	}
+	result, err := client.CallContract(context.Background(), ethereum.CallMsg{To: &permit2, Data: data}, nil)
+	if err != nil {
+		return false, callError(err)
+	}
+	var bitmap *big.Int
+	if err := permit2ABI.UnpackIntoInterface(&bitmap, "nonceBitmap", result); err != nil {
+		return false, err
+	}
+	return bitmap.Bit(int(bitPos)) == 1, nil
+}
+
+// CheckAllowance is the ERC-20 allowance of the owner to the spender
+func CheckAllowance(client *ethclient.Client, token, owner, spender common.Address) (*big.Int, error) {
+	data, err := allowanceABI.Pack("allowance", owner, spender)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack data: %w", err)
+	}
+	result, err := client.CallContract(context.Background(), ethereum.CallMsg{To: &token, Data: data}, nil)
+	if err != nil {
+		return nil, callError(err)
+	}
+	var allowance *big.Int
+	err = allowanceABI.UnpackIntoInterface(&allowance, "allowance", result)
+	return allowance, err
+}
+
+// Permit2TransferCall is the permitWitnessTransferFrom of the signed message, paying amount to the witness' payTo.
+// The amount may be less than the permitted one. The signature is the one the payer's wallet takes,
+// unwrapped if it came as EIP-6492.
+func Permit2TransferCall(p2 *all712.Permit2Message, amount *big.Int, signature []byte) (Call, error) {
+	witness, err := all712.PaymentWitnessHash(p2.Message.Witness)
+	if err != nil {
+		return Call{}, err
+	}
+	if len(signature) == 65 && signature[64] < 27 {
+		// Permit2 hands v to ecrecover as it is
+		signature = append(append([]byte{}, signature[:64]...), signature[64]+27)
+	}
+
+	type tokenPermissions struct {
+		Token  common.Address
+		Amount *big.Int
+	}
+	permit := struct {
+		Permitted tokenPermissions
+		Nonce     *big.Int
+		Deadline  *big.Int
+	}{tokenPermissions{p2.Message.Token, p2.Message.Amount}, p2.Nonce, p2.Message.Deadline}
+	details := struct {
+		To              common.Address
+		RequestedAmount *big.Int
+	}{p2.Message.Witness.PayTo, amount}
+
+	data, err := permit2ABI.Pack("permitWitnessTransferFrom", permit, details, p2.Message.Owner,
+		witness, all712.Permit2WitnessTypeString, signature)
+	if err != nil {
+		return Call{}, fmt.Errorf("error packing the call: %w", err)
+	}
+	return Call{To: p2.Domain.VerifyingContract, Data: data}, nil
+}
@@ -0,0 +1,354 @@
+package evmbinding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/san-lab/sx402/all712"
+)
+
+// Endpoint is a single RPC url of a network, with its health and request counters
+type Endpoint struct {
+	URL string
+	url *url.URL
+
+	healthy   atomic.Bool
+	head      atomic.Uint64
+	requests  atomic.Uint64
+	failures  atomic.Uint64
+	latency   atomic.Int64 // accumulated, in nanoseconds
+	lastError atomic.Value // string
+}
+
+type EndpointStats struct {
+	URL          string  `json:"url"`
+	Healthy      bool    `json:"healthy"`
+	Head         uint64  `json:"head"`
+	Requests     uint64  `json:"requests"`
+	Failures     uint64  `json:"failures"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	LastError    string  `json:"lastError,omitempty"`
+}
+
+func (ep *Endpoint) record(took time.Duration, err error) {
+	ep.requests.Add(1)
+	ep.latency.Add(int64(took))
+	if err != nil {
+		ep.failures.Add(1)
+		ep.lastError.Store(err.Error())
+		ep.healthy.Store(false)
+	}
+}
+
+func (ep *Endpoint) Stats() EndpointStats {
+	st := EndpointStats{
+		URL:      ep.URL,
+		Healthy:  ep.healthy.Load(),
+		Head:     ep.head.Load(),
+		Requests: ep.requests.Load(),
+		Failures: ep.failures.Load(),
+	}
+	if st.Requests > 0 {
+		st.AvgLatencyMs = float64(ep.latency.Load()) / float64(st.Requests) / 1e6
+	}
+	if le, ok := ep.lastError.Load().(string); ok {
+		st.LastError = le
+	}
+	return st
+}
+
+// networkClient is the one long-lived ethclient of a network.
+// Its http transport picks the endpoint for every call, so callers never see the failover.
+type networkClient struct {
+	network   string
+	endpoints []*Endpoint
+	client    *ethclient.Client
+}
+
+// ordered returns the healthy endpoints first, in configuration order, then the rest as a last resort
+func (nc *networkClient) ordered() []*Endpoint {
+	eps := make([]*Endpoint, 0, len(nc.endpoints))
+	for _, ep := range nc.endpoints {
+		if ep.healthy.Load() {
+			eps = append(eps, ep)
+		}
+	}
+	for _, ep := range nc.endpoints {
+		if !ep.healthy.Load() {
+			eps = append(eps, ep)
+		}
+	}
+	return eps
+}
+
+// failoverTransport sends each request to the healthiest endpoint and, for reads, to the next one when it fails.
+// A transaction is sent once: the node may have propagated it before failing, so a resend is left to the TxManager.
+type failoverTransport struct {
+	nc   *networkClient
+	base http.RoundTripper
+}
+
+func (ft *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	retry := readOnly(body)
+	var lastErr error
+	for _, ep := range ft.nc.ordered() {
+		r := req.Clone(req.Context())
+		r.URL = ep.url
+		r.Host = ep.url.Host
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+
+		start := time.Now()
+		resp, err := ft.base.RoundTrip(r)
+		if err == nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			ep.record(time.Since(start), nil)
+			return resp, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%s: %s", ep.URL, resp.Status)
+			resp.Body.Close()
+		}
+		ep.record(time.Since(start), err)
+		lastErr = err
+		if req.Context().Err() != nil || !(retry || neverSent(err)) {
+			break
+		}
+		log.Printf("⚠️ RPC endpoint failed for %s, trying the next one: %v", ft.nc.network, err)
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no rpc endpoints for %s", ft.nc.network)
+	}
+	return nil, lastErr
+}
+
+// readOnly tells whether every call of the JSON-RPC request (or batch) only reads, so that sending it again is harmless
+func readOnly(body []byte) bool {
+	var calls []struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(body, &calls); err != nil {
+		calls = calls[:0]
+		var single struct {
+			Method string `json:"method"`
+		}
+		if err := json.Unmarshal(body, &single); err != nil {
+			return false
+		}
+		calls = append(calls, single)
+	}
+	for _, c := range calls {
+		if strings.HasPrefix(c.Method, "eth_send") {
+			return false
+		}
+	}
+	return true
+}
+
+// neverSent tells that the request did not reach the endpoint at all, it could not even connect
+func neverSent(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// ClientPool keeps one client per network and health-checks its endpoints in the background
+type ClientPool struct {
+	MaxLag         uint64 // blocks an endpoint may trail the best one before it is taken out of rotation
+	HealthInterval time.Duration
+	Transport      http.RoundTripper
+
+	mu      sync.Mutex
+	clients map[string]*networkClient
+	once    sync.Once
+}
+
+func NewClientPool() *ClientPool {
+	return &ClientPool{
+		MaxLag:         5,
+		HealthInterval: 30 * time.Second,
+		Transport:      http.DefaultTransport,
+		clients:        map[string]*networkClient{},
+	}
+}
+
+var pool = NewClientPool()
+
+func Pool() *ClientPool {
+	return pool
+}
+
+// Client returns the shared client of the network, creating it on first use.
+// The returned client must not be closed.
+func (p *ClientPool) Client(network string) (*ethclient.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if nc, ok := p.clients[network]; ok {
+		return nc.client, nil
+	}
+
+	n, ok := GetNetwork(network)
+	if !ok {
+		return nil, all712.Errorf(all712.CodeInvalidNetwork, "Unknown network: %s", network)
+	}
+	nc := &networkClient{network: network}
+	for _, raw := range n.RPCURLs {
+		u, err := url.Parse(raw)
+		if err != nil || !strings.HasPrefix(u.Scheme, "http") {
+			log.Printf("skipping rpc url %q of %s: only http(s) endpoints can be pooled", raw, network)
+			continue
+		}
+		ep := &Endpoint{URL: raw, url: u}
+		ep.healthy.Store(true)
+		nc.endpoints = append(nc.endpoints, ep)
+	}
+	if len(nc.endpoints) == 0 {
+		return nil, all712.Errorf(all712.CodeRPCUnavailable, "no usable rpc endpoints for %s", network)
+	}
+
+	httpClient := &http.Client{Transport: &failoverTransport{nc: nc, base: p.Transport}}
+	rpcClient, err := rpc.DialOptions(context.Background(), nc.endpoints[0].URL, rpc.WithHTTPClient(httpClient))
+	if err != nil {
+		return nil, all712.WithCode(all712.CodeRPCUnavailable, err)
+	}
+	nc.client = ethclient.NewClient(rpcClient)
+	p.clients[network] = nc
+
+	p.once.Do(func() { go p.healthLoop() })
+	return nc.client, nil
+}
+
+// Stats reports the endpoint metrics of every network that has been used so far
+func (p *ClientPool) Stats() map[string][]EndpointStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := map[string][]EndpointStats{}
+	for name, nc := range p.clients {
+		for _, ep := range nc.endpoints {
+			stats[name] = append(stats[name], ep.Stats())
+		}
+	}
+	return stats
+}
+
+func (p *ClientPool) healthLoop() {
+	ticker := time.NewTicker(p.HealthInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.CheckHealth()
+	}
+}
+
+// CheckHealth polls the head of every endpoint and takes failing or lagging ones out of rotation
+func (p *ClientPool) CheckHealth() {
+	p.mu.Lock()
+	ncs := make([]*networkClient, 0, len(p.clients))
+	for _, nc := range p.clients {
+		ncs = append(ncs, nc)
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, nc := range ncs {
+		wg.Add(1)
+		go func(nc *networkClient) {
+			defer wg.Done()
+			p.checkNetwork(nc)
+		}(nc)
+	}
+	wg.Wait()
+}
+
+func (p *ClientPool) checkNetwork(nc *networkClient) {
+	alive := make([]bool, len(nc.endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range nc.endpoints {
+		wg.Add(1)
+		go func(i int, ep *Endpoint) {
+			defer wg.Done()
+			head, err := p.blockNumber(ep)
+			if err != nil {
+				ep.lastError.Store(err.Error())
+				return
+			}
+			ep.head.Store(head)
+			alive[i] = true
+		}(i, ep)
+	}
+	wg.Wait()
+
+	var best uint64
+	for i, ep := range nc.endpoints {
+		if alive[i] && ep.head.Load() > best {
+			best = ep.head.Load()
+		}
+	}
+	for i, ep := range nc.endpoints {
+		healthy := alive[i] && best-ep.head.Load() <= p.MaxLag
+		if ep.healthy.Swap(healthy) != healthy {
+			log.Printf("RPC endpoint %s of %s healthy: %v (head %v, best %v)", ep.URL, nc.network, healthy, ep.head.Load(), best)
+		}
+	}
+}
+
+// blockNumber asks a single endpoint directly, bypassing the failover
+func (p *ClientPool) blockNumber(ep *Endpoint) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Transport: p.Transport}).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: %s", ep.URL, resp.Status)
+	}
+
+	var result struct {
+		Result *hexutil.Big `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	if result.Error != nil {
+		return 0, fmt.Errorf("%s: %s", ep.URL, result.Error.Message)
+	}
+	if result.Result == nil {
+		return 0, fmt.Errorf("%s: empty eth_blockNumber result", ep.URL)
+	}
+	return (*big.Int)(result.Result).Uint64(), nil
+}
@@ -0,0 +1,169 @@
+package evmbinding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeNode answers eth_blockNumber and eth_chainId, or fails with 503 when broken is set
+type fakeNode struct {
+	head   atomic.Uint64
+	broken atomic.Bool
+	calls  atomic.Uint64
+}
+
+func (f *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.calls.Add(1)
+	if f.broken.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	result := ""
+	switch req.Method {
+	case "eth_blockNumber":
+		result = fmt.Sprintf("0x%x", f.head.Load())
+	case "eth_chainId":
+		result = "0x539"
+	}
+	fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"%s"}`, req.ID, result)
+}
+
+func withTestNetwork(t *testing.T, urls ...string) {
+	saved := networks
+	networks = map[string]*Network{"devnet": {Name: "devnet", ChainID: big.NewInt(1337), RPCURLs: urls}}
+	t.Cleanup(func() { networks = saved })
+}
+
+func TestPoolFailover(t *testing.T) {
+	a, b := &fakeNode{}, &fakeNode{}
+	a.broken.Store(true)
+	b.head.Store(42)
+	sa, sb := httptest.NewServer(a), httptest.NewServer(b)
+	defer sa.Close()
+	defer sb.Close()
+	withTestNetwork(t, sa.URL, sb.URL)
+
+	p := NewClientPool()
+	client, err := p.Client("devnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := p.Client("devnet")
+	if again != client {
+		t.Error("pool dialed a second client")
+	}
+
+	head, err := client.BlockNumber(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != 42 {
+		t.Errorf("expected head 42 from the second endpoint, got %v", head)
+	}
+
+	stats := p.Stats()["devnet"]
+	if stats[0].Failures != 1 || stats[0].Healthy {
+		t.Errorf("failing endpoint not recorded: %+v", stats[0])
+	}
+	if stats[1].Requests != 1 || stats[1].Failures != 0 {
+		t.Errorf("unexpected stats of the healthy endpoint: %+v", stats[1])
+	}
+
+	// Once demoted, the broken endpoint is no longer tried first
+	client.BlockNumber(context.Background())
+	if a.calls.Load() != 1 {
+		t.Errorf("unhealthy endpoint called %v times", a.calls.Load())
+	}
+}
+
+func TestPoolLagging(t *testing.T) {
+	a, b := &fakeNode{}, &fakeNode{}
+	a.head.Store(100)
+	b.head.Store(200)
+	sa, sb := httptest.NewServer(a), httptest.NewServer(b)
+	defer sa.Close()
+	defer sb.Close()
+	withTestNetwork(t, sa.URL, sb.URL)
+
+	p := NewClientPool()
+	p.MaxLag = 10
+	client, err := p.Client("devnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.CheckHealth()
+
+	head, err := client.BlockNumber(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != 200 {
+		t.Errorf("lagging endpoint still preferred, got head %v", head)
+	}
+
+	// The lagging node catches up and comes back into rotation
+	a.head.Store(200)
+	p.CheckHealth()
+	if !p.Stats()["devnet"][0].Healthy {
+		t.Error("recovered endpoint not restored")
+	}
+}
+
+// A failing read goes on to the next endpoint, a transaction does not: the first node may have propagated it
+func TestPoolFailoverSends(t *testing.T) {
+	a, b := &fakeNode{}, &fakeNode{}
+	a.broken.Store(true)
+	sa, sb := httptest.NewServer(a), httptest.NewServer(b)
+	defer sa.Close()
+	defer sb.Close()
+	down := httptest.NewServer(&fakeNode{})
+	down.Close()
+	withTestNetwork(t, sa.URL, sb.URL)
+
+	p := NewClientPool()
+	client, err := p.Client("devnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hash string
+	if err := client.Client().CallContext(context.Background(), &hash, "eth_sendRawTransaction", "0x00"); err == nil {
+		t.Error("failed send reported as sent")
+	}
+	if a.calls.Load() != 1 || b.calls.Load() != 0 {
+		t.Errorf("send tried on %v and %v endpoints", a.calls.Load(), b.calls.Load())
+	}
+
+	// an endpoint that cannot even be reached never got the transaction
+	withTestNetwork(t, down.URL, sb.URL)
+	p = NewClientPool()
+	client, _ = p.Client("devnet")
+	if err := client.Client().CallContext(context.Background(), &hash, "eth_sendRawTransaction", "0x00"); err != nil {
+		t.Error("send not moved on from an unreachable endpoint:", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	cases := map[string]bool{
+		`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]}`:                                           true,
+		`[{"id":1,"method":"eth_blockNumber"},{"id":2,"method":"eth_getTransactionReceipt"}]`:                true,
+		`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`:                       false,
+		`[{"id":1,"method":"eth_blockNumber"},{"id":2,"method":"eth_sendRawTransaction","params":["0x00"]}]`: false,
+		`not json`: false,
+	}
+	for body, want := range cases {
+		if readOnly([]byte(body)) != want {
+			t.Errorf("%s: read only %v", body, !want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package evmbinding
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	kms "github.com/proveniencenft/kmsclitool/common"
+)
+
+// Signer is a facilitator account. The settlement code only ever sees this,
+// where the key lives (process memory, keystore, remote signer) is up to the implementation.
+type Signer interface {
+	Address() common.Address
+	SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+}
+
+// HashSigner is a Signer that also signs raw hashes. The keys held by the process are; the remote signers
+// only sign prefixed messages and typed data, a different signature, so they are not.
+type HashSigner interface {
+	Signer
+	SignHash(hash common.Hash) ([]byte, error) // 65 bytes, v in {0, 1}
+}
+
+// KeySigner holds the private key in memory
+type KeySigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+func NewKeySigner(key *ecdsa.PrivateKey) *KeySigner {
+	return &KeySigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+// LoadKeyfile decrypts a kmsclitool keyfile, the way the facilitator.json key always got loaded
+func LoadKeyfile(filename string, password []byte) (*KeySigner, error) {
+	kf, err := kms.ReadKeyfile(filename)
+	if err != nil || kf == nil {
+		return nil, fmt.Errorf("Error loading keyfile %s: %w", filename, err)
+	}
+	if err = kf.Decrypt(password); err != nil {
+		return nil, fmt.Errorf("Error decrypting keyfile %s: %w", filename, err)
+	}
+	key, err := crypto.ToECDSA(kf.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySigner(key), nil
+}
+
+func (ks *KeySigner) Address() common.Address {
+	return ks.address
+}
+
+func (ks *KeySigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), ks.key)
+}
+
+func (ks *KeySigner) SignHash(hash common.Hash) ([]byte, error) {
+	return crypto.Sign(hash[:], ks.key)
+}
+
+// KeystoreSigner is one account of a geth-style encrypted keystore directory
+type KeystoreSigner struct {
+	ks      *keystore.KeyStore
+	account accounts.Account
+}
+
+// OpenKeystore unlocks every account of the directory with the password, in the order the keystore lists them
+func OpenKeystore(dir string, password string) ([]*KeystoreSigner, error) {
+	ks := keystore.NewKeyStore(dir, keystore.StandardScryptN, keystore.StandardScryptP)
+	accs := ks.Accounts()
+	if len(accs) == 0 {
+		return nil, fmt.Errorf("no accounts in the keystore %s", dir)
+	}
+	signers := make([]*KeystoreSigner, len(accs))
+	for i, acc := range accs {
+		if err := ks.Unlock(acc, password); err != nil {
+			return nil, fmt.Errorf("error unlocking %s: %w", acc.Address, err)
+		}
+		signers[i] = &KeystoreSigner{ks: ks, account: acc}
+	}
+	return signers, nil
+}
+
+func (s *KeystoreSigner) Address() common.Address {
+	return s.account.Address
+}
+
+func (s *KeystoreSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return s.ks.SignTx(s.account, tx, chainID)
+}
+
+func (s *KeystoreSigner) SignHash(hash common.Hash) ([]byte, error) {
+	return s.ks.SignHash(s.account, hash[:])
+}
+
+// Remote signer dialects
+const (
+	Web3Signer = "web3signer" // eth_accounts / eth_signTransaction
+	Clef       = "clef"       // account_list / account_signTransaction
+)
+
+const remoteSignerTimeout = 10 * time.Second
+
+// RemoteSigner keeps the key out of the process: transactions get signed by a Web3Signer or Clef over JSON-RPC
+type RemoteSigner struct {
+	client  *rpc.Client
+	dialect string
+	address common.Address
+}
+
+// NewRemoteSigner connects to the signer and checks it knows the address.
+// An empty address is fine when the signer has exactly one account.
+func NewRemoteSigner(url, dialect, address string) (*RemoteSigner, error) {
+	if dialect == "" {
+		dialect = Web3Signer
+	}
+	if dialect != Web3Signer && dialect != Clef {
+		return nil, fmt.Errorf("unknown remote signer type: %s", dialect)
+	}
+	client, err := rpc.Dial(url)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the remote signer: %w", err)
+	}
+	rs := &RemoteSigner{client: client, dialect: dialect}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteSignerTimeout)
+	defer cancel()
+	method := "eth_accounts"
+	if dialect == Clef {
+		method = "account_list"
+	}
+	var known []common.Address
+	if err := client.CallContext(ctx, &known, method); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error listing the remote signer accounts: %w", err)
+	}
+	switch {
+	case address == "" && len(known) == 1:
+		rs.address = known[0]
+		return rs, nil
+	case address == "":
+		client.Close()
+		return nil, fmt.Errorf("the remote signer has %v accounts, pick one", len(known))
+	}
+	rs.address = common.HexToAddress(address)
+	for _, a := range known {
+		if a == rs.address {
+			return rs, nil
+		}
+	}
+	client.Close()
+	return nil, fmt.Errorf("the remote signer does not know %s", address)
+}
+
+func (rs *RemoteSigner) Address() common.Address {
+	return rs.address
+}
+
+// the transaction object both signers take
+type remoteTxArgs struct {
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to,omitempty"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas,omitempty"`
+	Value                *hexutil.Big    `json:"value"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Data                 hexutil.Bytes   `json:"data"`
+	ChainID              *hexutil.Big    `json:"chainId"`
+}
+
+func (rs *RemoteSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	args := remoteTxArgs{
+		From:    rs.address,
+		To:      tx.To(),
+		Gas:     hexutil.Uint64(tx.Gas()),
+		Value:   (*hexutil.Big)(tx.Value()),
+		Nonce:   hexutil.Uint64(tx.Nonce()),
+		Data:    tx.Data(),
+		ChainID: (*hexutil.Big)(chainID),
+	}
+	if tx.Type() == types.LegacyTxType {
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	} else {
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteSignerTimeout)
+	defer cancel()
+	method := "eth_signTransaction"
+	if rs.dialect == Clef {
+		method = "account_signTransaction"
+	}
+	var result json.RawMessage
+	if err := rs.client.CallContext(ctx, &result, method, args); err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+
+	// Web3Signer returns the raw tx, Clef wraps it in {raw, tx}
+	var raw hexutil.Bytes
+	if err := json.Unmarshal(result, &raw); err != nil {
+		var wrapped struct {
+			Raw hexutil.Bytes `json:"raw"`
+		}
+		if err := json.Unmarshal(result, &wrapped); err != nil {
+			return nil, fmt.Errorf("remote signer: unexpected answer %s", result)
+		}
+		raw = wrapped.Raw
+	}
+	signed := new(types.Transaction)
+	if err := signed.UnmarshalBinary(raw); err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+
+	// Trust, but verify: it has to be our transaction, signed by our account
+	from, err := types.Sender(types.LatestSignerForChainID(chainID), signed)
+	if err != nil || from != rs.address {
+		return nil, fmt.Errorf("remote signer: signed by %s, not %s (%v)", from, rs.address, err)
+	}
+	if signed.Nonce() != tx.Nonce() || signed.Gas() != tx.Gas() || !sameRecipient(signed.To(), tx.To()) ||
+		signed.Value().Cmp(tx.Value()) != 0 || !bytes.Equal(signed.Data(), tx.Data()) ||
+		signed.GasFeeCap().Cmp(tx.GasFeeCap()) != 0 || signed.GasTipCap().Cmp(tx.GasTipCap()) != 0 {
+		return nil, fmt.Errorf("remote signer: the signed transaction differs from the request")
+	}
+	return signed, nil
+}
+
+func sameRecipient(a, b *common.Address) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
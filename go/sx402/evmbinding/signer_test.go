@@ -0,0 +1,156 @@
+package evmbinding
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var testChainID = big.NewInt(84532)
+
+func testTx() *types.Transaction {
+	return newTx(testChainID, 7, common.HexToAddress("0xdEaD"), big.NewInt(1), 50000,
+		txFees{TipCap: big.NewInt(1e6), FeeCap: big.NewInt(2e9)}, []byte{1, 2, 3})
+}
+
+// checkSigner signs a tx and a hash and recovers the signer's address from both
+func checkSigner(t *testing.T, s HashSigner) {
+	t.Helper()
+	signed, err := s.SignTx(testTx(), testChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(testChainID), signed)
+	if err != nil || from != s.Address() {
+		t.Errorf("tx signed by %s, expected %s (%v)", from, s.Address(), err)
+	}
+
+	hash := crypto.Keccak256Hash([]byte("x402"))
+	sig, err := s.SignHash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := crypto.SigToPub(hash[:], sig)
+	if err != nil || crypto.PubkeyToAddress(*pub) != s.Address() {
+		t.Errorf("hash not signed by %s (%v)", s.Address(), err)
+	}
+}
+
+func TestKeySigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	checkSigner(t, NewKeySigner(key))
+}
+
+func TestKeystoreSigner(t *testing.T) {
+	dir := t.TempDir()
+	ks := keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP)
+	for i := 0; i < 2; i++ {
+		if _, err := ks.NewAccount("secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := OpenKeystore(dir, "wrong"); err == nil {
+		t.Error("keystore opened with a wrong password")
+	}
+	signers, err := OpenKeystore(dir, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 {
+		t.Fatalf("%v signers, expected 2", len(signers))
+	}
+	for _, s := range signers {
+		checkSigner(t, s)
+	}
+}
+
+// signerStub speaks enough Web3Signer/Clef to sign with a local key
+type signerStub struct {
+	key     *ecdsa.PrivateKey
+	dialect string
+	tamper  bool // sign something else than asked
+}
+
+func (s *signerStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	reply := func(result any) {
+		out, _ := json.Marshal(result)
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":%s}`, req.ID, out)
+	}
+	list, sign := "eth_accounts", "eth_signTransaction"
+	if s.dialect == Clef {
+		list, sign = "account_list", "account_signTransaction"
+	}
+	switch req.Method {
+	case list:
+		reply([]common.Address{crypto.PubkeyToAddress(s.key.PublicKey)})
+	case sign:
+		var args remoteTxArgs
+		json.Unmarshal(req.Params[0], &args)
+		if s.tamper {
+			args.Nonce++
+		}
+		tx := newTx(args.ChainID.ToInt(), uint64(args.Nonce), *args.To, args.Value.ToInt(), uint64(args.Gas),
+			txFees{TipCap: args.MaxPriorityFeePerGas.ToInt(), FeeCap: args.MaxFeePerGas.ToInt()}, args.Data)
+		signed, _ := types.SignTx(tx, types.LatestSignerForChainID(args.ChainID.ToInt()), s.key)
+		raw, _ := signed.MarshalBinary()
+		if s.dialect == Clef {
+			reply(map[string]any{"raw": hexutil.Bytes(raw), "tx": signed})
+		} else {
+			reply(hexutil.Bytes(raw))
+		}
+	default:
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"error":{"code":-32601,"message":"method not found"}}`, req.ID)
+	}
+}
+
+func TestRemoteSigner(t *testing.T) {
+	for _, dialect := range []string{Web3Signer, Clef} {
+		key, _ := crypto.GenerateKey()
+		stub := &signerStub{key: key, dialect: dialect}
+		srv := httptest.NewServer(stub)
+		defer srv.Close()
+
+		if _, err := NewRemoteSigner(srv.URL, dialect, "0x0000000000000000000000000000000000000001"); err == nil {
+			t.Errorf("%s: accepted an address the signer does not know", dialect)
+		}
+		rs, err := NewRemoteSigner(srv.URL, dialect, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rs.Address() != crypto.PubkeyToAddress(key.PublicKey) {
+			t.Errorf("%s: wrong address %s", dialect, rs.Address())
+		}
+		signed, err := rs.SignTx(testTx(), testChainID)
+		if err != nil {
+			t.Fatalf("%s: %v", dialect, err)
+		}
+		if signed.Nonce() != 7 || signed.GasFeeCap().Cmp(big.NewInt(2e9)) != 0 {
+			t.Errorf("%s: signed the wrong tx", dialect)
+		}
+		if _, ok := Signer(rs).(HashSigner); ok {
+			t.Errorf("%s: remote signer claims to sign raw hashes", dialect)
+		}
+
+		stub.tamper = true
+		if _, err := rs.SignTx(testTx(), testChainID); err == nil {
+			t.Errorf("%s: accepted a tx different from the request", dialect)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package evmbinding
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/san-lab/sx402/all712"
+)
+
+// Call is one contract call of a settlement, as it will be sent by the facilitator
+type Call struct {
+	To    common.Address
+	Value *big.Int
+	Data  []byte
+}
+
+// RevertError is a simulated call that reverted
+type RevertError struct {
+	Call   int // index of the reverting call
+	Reason string
+	Data   []byte
+}
+
+func (re *RevertError) Error() string {
+	return "execution reverted: " + re.Reason
+}
+
+// ErrNoSimulateV1 is a node without eth_simulateV1, which cannot check calls that depend on each other
+var ErrNoSimulateV1 = errors.New("eth_simulateV1 not supported")
+
+// Simulate runs the calls from the given address on top of the latest block. A single call goes
+// through eth_call. Several go through eth_simulateV1, so the later calls see the effects of the earlier
+// ones (transferFrom after permit). On nodes without eth_simulateV1 that cannot be checked, and the answer is
+// ErrNoSimulateV1 (rpc_unavailable) rather than a pass on the first call alone.
+// A revert comes back as *RevertError with the decoded reason.
+func Simulate(ctx context.Context, network string, from common.Address, calls ...Call) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	client, err := GetClientByNetwork(network)
+	if err != nil {
+		return err
+	}
+	if len(calls) > 1 {
+		err = simulateV1(ctx, client.Client(), from, calls)
+		if errors.Is(err, ErrNoSimulateV1) {
+			log.Printf("%s has no eth_simulateV1, the %v calls cannot be checked", network, len(calls))
+			return all712.WithCode(all712.CodeRPCUnavailable, fmt.Errorf("%s: %w", network, err))
+		}
+		return err
+	}
+
+	_, err = client.CallContract(ctx, ethereum.CallMsg{From: from, To: &calls[0].To, Value: calls[0].Value, Data: calls[0].Data}, nil)
+	if err == nil {
+		return nil
+	}
+	if data, ok := revertData(err); ok {
+		return &RevertError{Call: 0, Reason: DecodeRevert(data), Data: data}
+	}
+	if strings.Contains(err.Error(), "execution reverted") {
+		return &RevertError{Call: 0, Reason: strings.TrimPrefix(strings.TrimPrefix(err.Error(), "execution reverted"), ": ")}
+	}
+	return fmt.Errorf("simulation failed: %w", err)
+}
+
+type simCall struct {
+	From  common.Address `json:"from"`
+	To    common.Address `json:"to"`
+	Value *hexutil.Big   `json:"value,omitempty"`
+	Input hexutil.Bytes  `json:"input"`
+}
+
+type simResult struct {
+	Calls []simCallResult `json:"calls"`
+}
+
+type simCallResult struct {
+	Status     hexutil.Uint64 `json:"status"`
+	ReturnData hexutil.Bytes  `json:"returnData"`
+	GasUsed    hexutil.Uint64 `json:"gasUsed"`
+	Error      *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func simulateV1(ctx context.Context, client *rpc.Client, from common.Address, calls []Call) error {
+	results, err := simulateCalls(ctx, client, from, calls)
+	if err != nil {
+		return err
+	}
+	for i, c := range results {
+		if c.Status == 1 {
+			continue
+		}
+		reason := DecodeRevert(c.ReturnData)
+		if len(c.ReturnData) == 0 && c.Error != nil {
+			reason = c.Error.Message
+		}
+		return &RevertError{Call: i, Reason: reason, Data: c.ReturnData}
+	}
+	return nil
+}
+
+// simulateCalls runs the calls one after the other in a single eth_simulateV1 block and returns what each did
+func simulateCalls(ctx context.Context, client *rpc.Client, from common.Address, calls []Call) ([]simCallResult, error) {
+	sc := make([]simCall, len(calls))
+	for i, c := range calls {
+		sc[i] = simCall{From: from, To: c.To, Input: c.Data}
+		if c.Value != nil {
+			sc[i].Value = (*hexutil.Big)(c.Value)
+		}
+	}
+	opts := map[string]any{
+		"blockStateCalls": []map[string]any{{"calls": sc}},
+	}
+	var result []simResult
+	err := client.CallContext(ctx, &result, "eth_simulateV1", opts, "latest")
+	if err != nil {
+		var rpcErr rpc.Error
+		if (errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32601) || strings.Contains(err.Error(), "method not found") ||
+			strings.Contains(err.Error(), "not supported") || strings.Contains(err.Error(), "does not exist") {
+			return nil, ErrNoSimulateV1
+		}
+		return nil, fmt.Errorf("simulation failed: %w", err)
+	}
+	if len(result) == 0 || len(result[0].Calls) != len(calls) {
+		return nil, fmt.Errorf("simulation failed: incomplete result")
+	}
+	return result[0].Calls, nil
+}
+
+// SimulateGas is the gas limit of each of the calls when they run in order, with the margin of the network.
+// For calls that only go through after the earlier ones (a settlement after the deployment of the payer's wallet),
+// which eth_estimateGas cannot price alone.
+func SimulateGas(ctx context.Context, network string, from common.Address, calls ...Call) ([]uint64, error) {
+	client, err := GetClientByNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	results, err := simulateCalls(ctx, client.Client(), from, calls)
+	if err != nil {
+		return nil, callError(err)
+	}
+	gas := make([]uint64, len(results))
+	for i, c := range results {
+		if c.Status != 1 {
+			return nil, &RevertError{Call: i, Reason: DecodeRevert(c.ReturnData), Data: c.ReturnData}
+		}
+		gas[i] = uint64(float64(c.GasUsed) * feeConfig(network).GasMargin)
+	}
+	return gas, nil
+}
+
+// revertData digs the revert payload out of an eth_call error
+func revertData(err error) ([]byte, bool) {
+	var de rpc.DataError
+	if !errors.As(err, &de) {
+		return nil, false
+	}
+	s, ok := de.ErrorData().(string)
+	if !ok {
+		return nil, false
+	}
+	data, err := hexutil.Decode(s)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+var errorABIs struct {
+	sync.RWMutex
+	list []*abi.ABI
+}
+
+// RegisterErrors makes the custom errors of the contract readable in revert reasons
+func RegisterErrors(contract *abi.ABI) {
+	errorABIs.Lock()
+	defer errorABIs.Unlock()
+	errorABIs.list = append(errorABIs.list, contract)
+}
+
+// DecodeRevert turns revert data into something a human can read:
+// Error(string), Panic(uint256) and the custom errors of the registered contracts.
+func DecodeRevert(data []byte) string {
+	if len(data) == 0 {
+		return "reverted without a reason"
+	}
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		return reason
+	}
+	if len(data) >= 4 {
+		errorABIs.RLock()
+		defer errorABIs.RUnlock()
+		for _, contract := range errorABIs.list {
+			for name, e := range contract.Errors {
+				if !bytes.Equal(e.ID[:4], data[:4]) {
+					continue
+				}
+				args, err := e.Inputs.Unpack(data[4:])
+				if err != nil {
+					return name
+				}
+				parts := make([]string, len(args))
+				for i, a := range args {
+					parts[i] = fmt.Sprint(a)
+				}
+				return fmt.Sprintf("%s(%s)", name, strings.Join(parts, ", "))
+			}
+		}
+	}
+	return "unknown error " + hexutil.Encode(data[:min(len(data), 4)])
+}
@@ -0,0 +1,124 @@
+package evmbinding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/san-lab/sx402/all712"
+)
+
+const errorsABI = `[{"type":"error","name":"ERC20InsufficientBalance","inputs":[
+	{"name":"sender","type":"address"},{"name":"balance","type":"uint256"},{"name":"needed","type":"uint256"}]}]`
+
+func revertString(reason string) []byte {
+	strType, _ := abi.NewType("string", "", nil)
+	packed, _ := abi.Arguments{{Type: strType}}.Pack(reason)
+	return append(common.FromHex("0x08c379a0"), packed...)
+}
+
+func TestDecodeRevert(t *testing.T) {
+	contract, err := abi.JSON(strings.NewReader(errorsABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterErrors(&contract)
+
+	insufficient := contract.Errors["ERC20InsufficientBalance"]
+	custom, _ := insufficient.Inputs.Pack(common.HexToAddress("0x01"), big.NewInt(5), big.NewInt(10))
+	custom = append(insufficient.ID.Bytes()[:4:4], custom...)
+
+	uintType, _ := abi.NewType("uint256", "", nil)
+	panicArgs, _ := abi.Arguments{{Type: uintType}}.Pack(big.NewInt(0x11))
+
+	cases := []struct {
+		data []byte
+		want string
+	}{
+		{nil, "reverted without a reason"},
+		{revertString("FiatTokenV2: invalid signature"), "FiatTokenV2: invalid signature"},
+		{append(common.FromHex("0x4e487b71"), panicArgs...), "arithmetic underflow or overflow"},
+		{custom, "ERC20InsufficientBalance(0x0000000000000000000000000000000000000001, 5, 10)"},
+		{common.FromHex("0xdeadbeef00"), "unknown error 0xdeadbeef"},
+	}
+	for _, c := range cases {
+		if got := DecodeRevert(c.data); !strings.Contains(got, c.want) {
+			t.Errorf("DecodeRevert(%x) = %q, want %q", c.data, got, c.want)
+		}
+	}
+}
+
+// simNode answers eth_call with a revert and eth_simulateV1 with the second call failing,
+// unless noSimulate is set
+type simNode struct {
+	noSimulate bool
+	methods    []string
+}
+
+func (s *simNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	s.methods = append(s.methods, req.Method)
+	switch {
+	case req.Method == "eth_chainId":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x539"}`, req.ID)
+	case req.Method == "eth_blockNumber":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x1"}`, req.ID)
+	case req.Method == "eth_call":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"error":{"code":3,"message":"execution reverted","data":"%s"}}`,
+			req.ID, hexutil.Encode(revertString("first")))
+	case req.Method == "eth_simulateV1" && !s.noSimulate:
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":[{"calls":[{"status":"0x1","returnData":"0x"},{"status":"0x0","returnData":"%s"}]}]}`,
+			req.ID, hexutil.Encode(revertString("second")))
+	default:
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"error":{"code":-32601,"message":"the method %s does not exist/is not available"}}`, req.ID, req.Method)
+	}
+}
+
+func withSimNode(t *testing.T, node *simNode) {
+	srv := httptest.NewServer(node)
+	t.Cleanup(srv.Close)
+	withTestNetwork(t, srv.URL)
+	saved := pool
+	pool = NewClientPool()
+	t.Cleanup(func() { pool = saved })
+}
+
+func TestSimulate(t *testing.T) {
+	node := &simNode{}
+	withSimNode(t, node)
+	call := Call{To: common.HexToAddress("0x02"), Data: []byte{1, 2, 3, 4}}
+
+	var revert *RevertError
+	err := Simulate(context.Background(), "devnet", common.HexToAddress("0x01"), call)
+	if !errors.As(err, &revert) || revert.Call != 0 || revert.Reason != "first" {
+		t.Fatalf("single call: %v", err)
+	}
+
+	err = Simulate(context.Background(), "devnet", common.HexToAddress("0x01"), call, call)
+	if !errors.As(err, &revert) || revert.Call != 1 || revert.Reason != "second" {
+		t.Fatalf("bundle: %v", err)
+	}
+
+	// Without eth_simulateV1 the bundle cannot be checked, which is no pass
+	node.noSimulate = true
+	err = Simulate(context.Background(), "devnet", common.HexToAddress("0x01"), call, call)
+	if !errors.Is(err, ErrNoSimulateV1) || all712.CodeOf(err) != all712.CodeRPCUnavailable {
+		t.Fatalf("bundle without eth_simulateV1: %v", err)
+	}
+	if errors.As(err, &revert) {
+		t.Error("unchecked bundle reported as a revert")
+	}
+}
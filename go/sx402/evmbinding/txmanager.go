@@ -0,0 +1,451 @@
+package evmbinding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type TxEventKind string
+
+const (
+	TxReplaced TxEventKind = "replaced" // re-priced, Hash is the new version
+	TxMined    TxEventKind = "mined"    // one of the versions made it into a block
+	TxDropped  TxEventKind = "dropped"  // the nonce was used by something else, none of the versions will be mined
+)
+
+// TxEvent reports what happened to a transaction handed to the TxManager.
+// Original is the hash returned by Send, whatever the number of replacements.
+type TxEvent struct {
+	Kind     TxEventKind
+	Network  string
+	Original common.Hash
+	Hash     common.Hash
+	Receipt  *types.Receipt
+	Reason   string
+}
+
+// The part of the ethclient the manager needs
+type txBackend interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+	EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// TxRequest is a transaction for the TxManager to sign, price and send
+type TxRequest struct {
+	Network  string
+	Signer   Signer
+	To       common.Address
+	Value    *big.Int
+	Data     []byte
+	GasLimit uint64         // 0 - estimate
+	MaxCost  *big.Int       // in wei, the most the gas may cost including re-pricing; nil - no cap
+	Done     chan<- TxEvent // if set, gets the TxMined or TxDropped of this tx, should have room for it
+}
+
+type trackedTx struct {
+	nonce   uint64
+	to      common.Address
+	value   *big.Int
+	data    []byte
+	gas     uint64
+	fees    txFees
+	maxCost *big.Int
+	hashes  []common.Hash // every broadcast version, oldest first
+	signed  *types.Transaction
+	sentAt  time.Time
+	filler  bool // plugs a nonce gap, nobody waits for it
+	behind  int  // checks that found the nonce used but no receipt of ours
+	done    chan<- TxEvent
+}
+
+// txAccount is one facilitator account on one chain
+type txAccount struct {
+	mu       sync.Mutex // the nonces and the in-flight set, never held across a check's RPCs
+	checking sync.Mutex // one check of the account at a time
+	network  string
+	signer   Signer
+	address  common.Address
+	chainID  *big.Int
+	next     uint64
+	synced   bool
+	inflight map[uint64]*trackedTx
+}
+
+// TxManager owns the nonces of the facilitator accounts and watches every transaction it sends
+// until it is mined: re-pricing the ones that linger, plugging nonce gaps and reporting to the Listener.
+type TxManager struct {
+	ReplaceAfter    time.Duration // a tx not mined by then gets re-priced
+	BumpPercent     int64         // nodes want at least 10% to accept a replacement
+	MaxReplacements int           // afterwards the last version just gets re-broadcast
+	PollInterval    time.Duration
+	Listener        func(TxEvent)
+
+	backend  func(network string) (txBackend, error)
+	mu       sync.Mutex
+	accounts map[string]*txAccount // network/address
+	once     sync.Once
+}
+
+func NewTxManager() *TxManager {
+	return &TxManager{
+		ReplaceAfter:    2 * time.Minute,
+		BumpPercent:     20,
+		MaxReplacements: 5,
+		PollInterval:    5 * time.Second,
+		backend: func(network string) (txBackend, error) {
+			return GetClientByNetwork(network)
+		},
+		accounts: map[string]*txAccount{},
+	}
+}
+
+var txManager = NewTxManager()
+
+func Transactions() *TxManager {
+	return txManager
+}
+
+const sendTimeout = 30 * time.Second
+
+// Send prices, signs and broadcasts the transaction with the next free nonce of the signer's account
+func (tm *TxManager) Send(req TxRequest) (common.Hash, error) {
+	client, err := tm.backend(req.Network)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	acc, err := tm.account(req.Network, req.Signer, client)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if req.Value == nil {
+		req.Value = big.NewInt(0)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	fees, err := suggestFees(ctx, client, req.Network)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	gas := req.GasLimit
+	if gas == 0 {
+		gas, err = estimateGas(ctx, client, req.Network, ethereum.CallMsg{From: acc.address, To: &req.To, Value: req.Value, Data: req.Data})
+		if err != nil {
+			return common.Hash{}, err
+		}
+	}
+	if req.MaxCost != nil {
+		cost := new(big.Int).Mul(new(big.Int).SetUint64(gas), fees.maxPerGas())
+		if cost.Cmp(req.MaxCost) > 0 {
+			return common.Hash{}, fmt.Errorf("%w: %v wei for %v gas at %s, cap %v", ErrFeeTooHigh, cost, gas, fees, req.MaxCost)
+		}
+	}
+
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if err := acc.sync(ctx, client); err != nil {
+			return common.Hash{}, err
+		}
+		tx := &trackedTx{nonce: acc.next, to: req.To, value: req.Value, data: req.Data, gas: gas, fees: fees, maxCost: req.MaxCost, done: req.Done}
+		err = acc.broadcast(ctx, client, tx)
+		if err == nil {
+			acc.next++
+			acc.inflight[tx.nonce] = tx
+			tm.once.Do(func() { go tm.loop() })
+			return tx.hashes[0], nil
+		}
+		// someone else used the nonce, start over from the node's view once
+		if attempt == 0 && strings.Contains(err.Error(), "nonce too low") {
+			acc.synced = false
+			continue
+		}
+		return common.Hash{}, fmt.Errorf("could not send tx: %w", err)
+	}
+}
+
+func (tm *TxManager) account(network string, signer Signer, client txBackend) (*txAccount, error) {
+	address := signer.Address()
+	id := network + "/" + address.Hex()
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if acc, ok := tm.accounts[id]; ok {
+		return acc, nil
+	}
+	chainID, ok := ChainID(network)
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		var err error
+		chainID, err = client.ChainID(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error recovering ChainID: %w", err)
+		}
+	}
+	acc := &txAccount{network: network, signer: signer, address: address, chainID: chainID, inflight: map[uint64]*trackedTx{}}
+	tm.accounts[id] = acc
+	return acc, nil
+}
+
+// sync takes the next nonce from the node when nothing of ours is in flight,
+// so a dropped transaction does not leave a hole forever
+func (acc *txAccount) sync(ctx context.Context, client txBackend) error {
+	if acc.synced && len(acc.inflight) > 0 {
+		return nil
+	}
+	pending, err := client.PendingNonceAt(ctx, acc.address)
+	if err != nil {
+		return fmt.Errorf("error getting the account nonce: %w", err)
+	}
+	if len(acc.inflight) == 0 || pending > acc.next {
+		acc.next = pending
+	}
+	acc.synced = true
+	return nil
+}
+
+func (acc *txAccount) broadcast(ctx context.Context, client txBackend, tx *trackedTx) error {
+	signed, err := acc.signer.SignTx(newTx(acc.chainID, tx.nonce, tx.to, tx.value, tx.gas, tx.fees, tx.data), acc.chainID)
+	if err != nil {
+		return fmt.Errorf("error signing transaction: %w", err)
+	}
+	if err := client.SendTransaction(ctx, signed); err != nil {
+		if !unanswered(err) {
+			return err
+		}
+		// the node may have taken it before failing; if not, the replacement after ReplaceAfter sends it again
+		log.Printf("tx manager: no answer to the broadcast of %s, keeping it in flight: %v", signed.Hash(), err)
+	}
+	tx.signed = signed
+	tx.hashes = append(tx.hashes, signed.Hash())
+	tx.sentAt = time.Now()
+	return nil
+}
+
+func (tm *TxManager) loop() {
+	ticker := time.NewTicker(tm.PollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		tm.Check()
+	}
+}
+
+// Check goes once over everything in flight
+func (tm *TxManager) Check() {
+	tm.mu.Lock()
+	accounts := make([]*txAccount, 0, len(tm.accounts))
+	for _, acc := range tm.accounts {
+		accounts = append(accounts, acc)
+	}
+	tm.mu.Unlock()
+
+	for _, acc := range accounts {
+		for _, ev := range tm.checkAccount(acc) {
+			if tm.Listener != nil {
+				tm.Listener(ev)
+			}
+		}
+	}
+}
+
+// finish reports the last event of the tx, to its Done channel too
+func (tx *trackedTx) finish(ev TxEvent, events *[]TxEvent) {
+	*events = append(*events, ev)
+	if tx.done != nil {
+		select {
+		case tx.done <- ev:
+		default:
+		}
+	}
+}
+
+// checkAccount works on a snapshot of the in-flight set and talks to the node without acc.mu,
+// so that Send does not wait behind a slow node. The trackedTx themselves are only touched by checks.
+func (tm *TxManager) checkAccount(acc *txAccount) (events []TxEvent) {
+	acc.checking.Lock()
+	defer acc.checking.Unlock()
+	acc.mu.Lock()
+	inflight := make(map[uint64]*trackedTx, len(acc.inflight))
+	for n, tx := range acc.inflight {
+		inflight[n] = tx
+	}
+	next := acc.next
+	acc.mu.Unlock()
+	if len(inflight) == 0 {
+		return
+	}
+	client, err := tm.backend(acc.network)
+	if err != nil {
+		log.Println("tx manager:", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	latest, err := client.NonceAt(ctx, acc.address, nil)
+	if err != nil {
+		log.Printf("tx manager: no nonce for %s on %s: %v", acc.address, acc.network, err)
+		return
+	}
+
+	nonces := make([]uint64, 0, len(inflight))
+	for n := range inflight {
+		nonces = append(nonces, n)
+	}
+	sort.Slice(nonces, func(i, j int) bool { return nonces[i] < nonces[j] })
+
+	done := []uint64{}
+	for _, n := range nonces {
+		tx := inflight[n]
+		if receipt, hash := findReceipt(ctx, client, tx.hashes); receipt != nil {
+			done = append(done, n)
+			if !tx.filler {
+				tx.finish(TxEvent{Kind: TxMined, Network: acc.network, Original: tx.hashes[0], Hash: hash, Receipt: receipt}, &events)
+			}
+			continue
+		}
+
+		if n < latest {
+			// Receipts can lag behind the nonce, give it one more round
+			tx.behind++
+			if tx.behind > 1 {
+				done = append(done, n)
+				log.Printf("⚠️ Nonce %v of %s on %s was used by another transaction", n, acc.address, acc.network)
+				if !tx.filler {
+					tx.finish(TxEvent{Kind: TxDropped, Network: acc.network, Original: tx.hashes[0], Hash: tx.hashes[len(tx.hashes)-1],
+						Reason: "nonce used by another transaction"}, &events)
+				}
+			}
+			continue
+		}
+
+		if time.Since(tx.sentAt) < tm.ReplaceAfter {
+			continue
+		}
+		if len(tx.hashes) > tm.MaxReplacements {
+			// Out of patience for re-pricing, just make sure the node still has it
+			if err := client.SendTransaction(ctx, tx.signed); err != nil && !isKnownTx(err) {
+				log.Printf("tx manager: re-broadcast of %s failed: %v", tx.signed.Hash(), err)
+			}
+			tx.sentAt = time.Now()
+			continue
+		}
+		if err := tm.reprice(ctx, client, acc, tx); err != nil {
+			log.Printf("tx manager: could not replace %s: %v", tx.hashes[len(tx.hashes)-1], err)
+			continue
+		}
+		log.Printf("⛽ Replaced %s with %s at %s", tx.hashes[len(tx.hashes)-2], tx.hashes[len(tx.hashes)-1], tx.fees)
+		if !tx.filler {
+			events = append(events, TxEvent{Kind: TxReplaced, Network: acc.network, Original: tx.hashes[0], Hash: tx.hashes[len(tx.hashes)-1]})
+		}
+	}
+
+	// Everything below our next nonce has to be in flight or mined, otherwise the later ones wait forever
+	fillers := []*trackedTx{}
+	for n := latest; n < next; n++ {
+		if _, ok := inflight[n]; ok {
+			continue
+		}
+		log.Printf("⚠️ Nonce gap at %v for %s on %s, filling it", n, acc.address, acc.network)
+		filler := &trackedTx{nonce: n, to: acc.address, value: big.NewInt(0), gas: 21000, filler: true}
+		filler.fees, err = suggestFees(ctx, client, acc.network)
+		if err == nil {
+			filler.fees = filler.fees.bump(tm.BumpPercent)
+			err = acc.broadcast(ctx, client, filler)
+		}
+		if err != nil {
+			log.Printf("tx manager: could not fill nonce %v: %v", n, err)
+			continue
+		}
+		fillers = append(fillers, filler)
+	}
+
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	for _, n := range done {
+		delete(acc.inflight, n)
+	}
+	for _, filler := range fillers {
+		acc.inflight[filler.nonce] = filler
+	}
+	if len(acc.inflight) == 0 {
+		acc.synced = false
+	}
+	return
+}
+
+// reprice sends the same transaction with higher fees, or the current suggestion if that is higher still
+func (tm *TxManager) reprice(ctx context.Context, client txBackend, acc *txAccount, tx *trackedTx) error {
+	fees := tx.fees.bump(tm.BumpPercent)
+	if suggested, err := suggestFees(ctx, client, acc.network); err == nil && suggested.legacy() == fees.legacy() {
+		fees = fees.atLeast(suggested)
+	}
+	if tx.maxCost != nil {
+		cost := new(big.Int).Mul(new(big.Int).SetUint64(tx.gas), fees.maxPerGas())
+		if cost.Cmp(tx.maxCost) > 0 {
+			return fmt.Errorf("%w: %v wei", ErrFeeTooHigh, cost)
+		}
+	}
+	old := tx.fees
+	tx.fees = fees
+	if err := acc.broadcast(ctx, client, tx); err != nil {
+		tx.fees = old
+		return err
+	}
+	return nil
+}
+
+func findReceipt(ctx context.Context, client txBackend, hashes []common.Hash) (*types.Receipt, common.Hash) {
+	for _, h := range hashes {
+		if receipt, err := client.TransactionReceipt(ctx, h); err == nil && receipt != nil {
+			return receipt, h
+		}
+	}
+	return nil, common.Hash{}
+}
+
+// unanswered tells that a broadcast failed on its way without a verdict of the node, so the tx may be out anyway
+func unanswered(err error) bool {
+	var answered rpc.Error
+	var status rpc.HTTPError
+	return !errors.As(err, &answered) && !errors.As(err, &status) && !neverSent(err)
+}
+
+func isKnownTx(err error) bool {
+	return strings.Contains(err.Error(), "already known") || strings.Contains(err.Error(), "known transaction")
+}
+
+// InFlight is the number of transactions of the account still waiting to be mined
+func (tm *TxManager) InFlight(network string, address common.Address) int {
+	tm.mu.Lock()
+	acc, ok := tm.accounts[network+"/"+address.Hex()]
+	tm.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	return len(acc.inflight)
+}
@@ -0,0 +1,390 @@
+package evmbinding
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// nodeError is a rejection by the node, what ethclient makes of a JSON-RPC error
+type nodeError string
+
+func (e nodeError) Error() string  { return string(e) }
+func (e nodeError) ErrorCode() int { return -32000 }
+
+// fakeChain is the node of the tx manager tests: signed txs wait in a pool, one per sender and nonce,
+// until commit mines them in nonce order
+type fakeChain struct {
+	mu       sync.Mutex
+	mined    map[common.Address]uint64
+	pool     map[common.Address]map[uint64]*types.Transaction
+	txs      map[common.Hash]*types.Transaction
+	receipts map[common.Hash]*types.Receipt
+	head     int64
+	sendErr  error         // the next SendTransaction fails with it, without the tx
+	lost     error         // the next SendTransaction takes the tx but fails with it
+	slow     chan struct{} // NonceAt waits for it when set
+	entered  chan struct{}
+}
+
+var fakeChainID = big.NewInt(1337)
+
+func newFakeChain() *fakeChain {
+	return &fakeChain{
+		mined:    map[common.Address]uint64{},
+		pool:     map[common.Address]map[uint64]*types.Transaction{},
+		txs:      map[common.Hash]*types.Transaction{},
+		receipts: map[common.Hash]*types.Receipt{},
+	}
+}
+
+func (f *fakeChain) ChainID(ctx context.Context) (*big.Int, error) { return fakeChainID, nil }
+
+func (f *fakeChain) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := f.mined[account]
+	for f.pool[account][n] != nil {
+		n++
+	}
+	return n, nil
+}
+
+func (f *fakeChain) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	if f.slow != nil {
+		f.entered <- struct{}{}
+		<-f.slow
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.mined[account], nil
+}
+
+func (f *fakeChain) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(params.GWei), nil
+}
+
+func (f *fakeChain) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(params.GWei), nil
+}
+
+func (f *fakeChain) FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error) {
+	return &ethereum.FeeHistory{BaseFee: []*big.Int{big.NewInt(params.GWei), big.NewInt(params.GWei)},
+		Reward: [][]*big.Int{{big.NewInt(params.GWei)}}}, nil
+}
+
+func (f *fakeChain) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	return 21000, nil
+}
+
+func (f *fakeChain) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.sendErr; err != nil {
+		f.sendErr = nil
+		return err
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(fakeChainID), tx)
+	if err != nil {
+		return nodeError("invalid sender")
+	}
+	if _, ok := f.txs[tx.Hash()]; ok {
+		return nodeError("already known")
+	}
+	if tx.Nonce() < f.mined[from] {
+		return nodeError("nonce too low")
+	}
+	if f.pool[from] == nil {
+		f.pool[from] = map[uint64]*types.Transaction{}
+	}
+	if old := f.pool[from][tx.Nonce()]; old != nil && tx.GasTipCap().Cmp(old.GasTipCap()) <= 0 {
+		return nodeError("replacement transaction underpriced")
+	}
+	f.pool[from][tx.Nonce()] = tx
+	f.txs[tx.Hash()] = tx
+	if err := f.lost; err != nil {
+		f.lost = nil
+		return err
+	}
+	return nil
+}
+
+func (f *fakeChain) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r, ok := f.receipts[txHash]; ok {
+		return r, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+// commit mines a block with whatever is minable in the pool
+func (f *fakeChain) commit() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.head++
+	for from, txs := range f.pool {
+		for tx := txs[f.mined[from]]; tx != nil; tx = txs[f.mined[from]] {
+			f.receipts[tx.Hash()] = &types.Receipt{Status: types.ReceiptStatusSuccessful, TxHash: tx.Hash(), BlockNumber: big.NewInt(f.head)}
+			delete(txs, tx.Nonce())
+			f.mined[from]++
+		}
+	}
+}
+
+func fakeManager(t *testing.T) (*TxManager, *fakeChain, *ecdsa.PrivateKey, *[]TxEvent) {
+	key, _ := crypto.GenerateKey()
+	chain := newFakeChain()
+
+	tm := NewTxManager()
+	tm.ReplaceAfter = 0
+	tm.backend = func(string) (txBackend, error) { return chain, nil }
+	events := new([]TxEvent)
+	tm.Listener = func(ev TxEvent) { *events = append(*events, ev) }
+	return tm, chain, key, events
+}
+
+func transfer(key *ecdsa.PrivateKey, to common.Address) TxRequest {
+	return TxRequest{Network: "simulated", Signer: NewKeySigner(key), To: to, Value: big.NewInt(1), GasLimit: 21000}
+}
+
+func TestTxManagerReplace(t *testing.T) {
+	tm, chain, key, events := fakeManager(t)
+	to := common.HexToAddress("0x01")
+
+	done := make(chan TxEvent, 1)
+	req := transfer(key, to)
+	req.Done = done
+	h, err := tm.Send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.Check() // not mined within ReplaceAfter -> re-priced
+	if len(*events) != 1 || (*events)[0].Kind != TxReplaced || (*events)[0].Original != h {
+		t.Fatalf("expected a replacement, got %+v", *events)
+	}
+	replacement := (*events)[0].Hash
+
+	chain.commit()
+	tm.Check()
+	if len(*events) != 2 || (*events)[1].Kind != TxMined || (*events)[1].Hash != replacement || (*events)[1].Original != h {
+		t.Fatalf("expected the replacement to be mined, got %+v", *events)
+	}
+	select {
+	case ev := <-done:
+		if ev.Kind != TxMined || ev.Hash != replacement || ev.Receipt == nil {
+			t.Errorf("expected Done to get the mined replacement, got %+v", ev)
+		}
+	default:
+		t.Error("Done not told about the mined tx")
+	}
+	if tm.InFlight("simulated", crypto.PubkeyToAddress(key.PublicKey)) != 0 {
+		t.Error("mined tx still in flight")
+	}
+}
+
+func TestTxManagerDropped(t *testing.T) {
+	tm, chain, key, events := fakeManager(t)
+	tm.ReplaceAfter = 1 << 40
+	to := common.HexToAddress("0x01")
+
+	h, err := tm.Send(transfer(key, to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// somebody else replaces our nonce 0
+	other, _ := types.SignTx(types.NewTransaction(0, to, big.NewInt(2), 21000, big.NewInt(4*params.GWei), nil),
+		types.LatestSignerForChainID(fakeChainID), key)
+	if err := chain.SendTransaction(context.Background(), other); err != nil {
+		t.Fatal(err)
+	}
+	chain.commit()
+
+	tm.Check()
+	tm.Check()
+	if len(*events) != 1 || (*events)[0].Kind != TxDropped || (*events)[0].Original != h {
+		t.Fatalf("expected the tx to be dropped, got %+v", *events)
+	}
+
+	// and the next one picks the right nonce again
+	h, err = tm.Send(transfer(key, to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.commit()
+	tm.Check()
+	if len(*events) != 2 || (*events)[1].Kind != TxMined || (*events)[1].Hash != h {
+		t.Fatalf("expected the second tx to be mined, got %+v", *events)
+	}
+}
+
+func TestTxManagerGap(t *testing.T) {
+	tm, chain, key, events := fakeManager(t)
+	tm.ReplaceAfter = 1 << 40
+	to := common.HexToAddress("0x01")
+
+	if _, err := tm.Send(transfer(key, to)); err != nil {
+		t.Fatal(err)
+	}
+	// pretend nonce 1 was used by a tx that vanished
+	acc := tm.accounts["simulated/"+crypto.PubkeyToAddress(key.PublicKey).Hex()]
+	acc.next++
+	if _, err := tm.Send(transfer(key, to)); err != nil {
+		t.Fatal(err)
+	}
+
+	tm.Check() // fills nonce 1
+	chain.commit()
+	tm.Check()
+	mined := 0
+	for _, ev := range *events {
+		if ev.Kind == TxMined {
+			mined++
+		}
+	}
+	if mined != 2 {
+		t.Fatalf("expected both txs mined behind the filler, got %+v", *events)
+	}
+}
+
+func TestTxManagerFees(t *testing.T) {
+	tm, chain, key, _ := fakeManager(t)
+	to := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+
+	req := transfer(key, to)
+	req.GasLimit = 0
+	h, err := tm.Send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := chain.txs[h]
+	if tx == nil {
+		t.Fatal("tx not sent")
+	}
+	if tx.Type() != types.DynamicFeeTxType {
+		t.Error("expected a dynamic fee tx, got type", tx.Type())
+	}
+	if tx.Gas() != 25200 {
+		t.Error("expected the estimate plus 20%, got", tx.Gas())
+	}
+
+	req.MaxCost = big.NewInt(1)
+	if _, err := tm.Send(req); !errors.Is(err, ErrFeeTooHigh) {
+		t.Error("expected the fee cap to kick in, got", err)
+	}
+
+	// a chain configured as legacy
+	saved := networks
+	networks = map[string]*Network{"simulated": {Name: "simulated", ChainID: big.NewInt(1337), RPCURLs: []string{"none"}, Fees: FeeConfig{Legacy: true}}}
+	t.Cleanup(func() { networks = saved })
+	req.MaxCost = nil
+	h, err = tm.Send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx = chain.txs[h]
+	if tx.Type() != types.LegacyTxType {
+		t.Error("expected a legacy tx, got type", tx.Type())
+	}
+}
+
+// A broadcast that fails on its way may still have reached the node: it stays in flight, the replacement resends it
+func TestTxManagerUnanswered(t *testing.T) {
+	tm, chain, key, events := fakeManager(t)
+	to := common.HexToAddress("0x01")
+
+	chain.lost = errors.New("connection reset by peer")
+	h, err := tm.Send(transfer(key, to))
+	if err != nil {
+		t.Fatal("unanswered broadcast reported as failed:", err)
+	}
+	chain.commit()
+	tm.Check()
+	if len(*events) != 1 || (*events)[0].Kind != TxMined || (*events)[0].Hash != h {
+		t.Fatalf("expected the tx the node took to be mined, got %+v", *events)
+	}
+
+	chain.sendErr = errors.New("connection reset by peer")
+	h, err = tm.Send(transfer(key, to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.Check() // resent at a higher price
+	chain.commit()
+	tm.Check()
+	if last := (*events)[len(*events)-1]; last.Kind != TxMined || last.Original != h {
+		t.Fatalf("expected the lost tx to be resent and mined, got %+v", *events)
+	}
+
+	chain.sendErr = nodeError("insufficient funds for gas * price + value")
+	if _, err := tm.Send(transfer(key, to)); err == nil {
+		t.Error("tx rejected by the node reported as sent")
+	}
+}
+
+// A check waiting for a slow node does not hold up Send
+func TestTxManagerSlowCheck(t *testing.T) {
+	tm, chain, key, _ := fakeManager(t)
+	tm.ReplaceAfter = 1 << 40
+	to := common.HexToAddress("0x01")
+	if _, err := tm.Send(transfer(key, to)); err != nil {
+		t.Fatal(err)
+	}
+
+	chain.slow, chain.entered = make(chan struct{}), make(chan struct{})
+	checked := make(chan struct{})
+	go func() {
+		tm.Check()
+		close(checked)
+	}()
+	<-chain.entered
+	sent := make(chan error)
+	go func() {
+		_, err := tm.Send(transfer(key, to))
+		sent <- err
+	}()
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Send waited for the check")
+	}
+	close(chain.slow)
+	<-checked
+	if n := tm.InFlight("simulated", crypto.PubkeyToAddress(key.PublicKey)); n != 2 {
+		t.Errorf("%v txs in flight after the check, expected 2", n)
+	}
+}
+
+func TestGasBudget(t *testing.T) {
+	saved := networks
+	networks = map[string]*Network{"devnet": {Name: "devnet", ChainID: big.NewInt(1337), RPCURLs: []string{"none"},
+		NativeCurrency: Currency{"Ether", "ETH", 18, 2000}, Fees: FeeConfig{MaxFeeFraction: 0.5}}}
+	t.Cleanup(func() { networks = saved })
+
+	// half of a 4 USD payment at 2000 USD/ETH is 0.001 ETH
+	if b := GasBudget("devnet", 4); b == nil || b.Cmp(big.NewInt(1e15)) != 0 {
+		t.Error("wrong budget:", b)
+	}
+	// unset, the fraction defaults to a half too
+	networks["devnet"].Fees.MaxFeeFraction = 0
+	if b := GasBudget("devnet", 4); b == nil || b.Cmp(big.NewInt(1e15)) != 0 {
+		t.Error("wrong default budget:", b)
+	}
+	networks["devnet"].NativeCurrency.RefPrice = 0
+	if b := GasBudget("devnet", 4); b != nil {
+		t.Error("expected no cap without a reference price, got", b)
+	}
+}
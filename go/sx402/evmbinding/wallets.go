@@ -0,0 +1,189 @@
+package evmbinding
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/san-lab/sx402/all712"
+)
+
+var ErrNoWallet = all712.Errorf(all712.CodeFacilitatorUnavailable, "no facilitator wallet available")
+
+// Wallet is a facilitator account and the networks it settles on; no networks - all of them
+type Wallet struct {
+	Signer   Signer
+	Networks []string
+}
+
+func (w *Wallet) serves(network string) bool {
+	if len(w.Networks) == 0 {
+		return true
+	}
+	for _, n := range w.Networks {
+		if n == network {
+			return true
+		}
+	}
+	return false
+}
+
+type cachedBalance struct {
+	balance *big.Int
+	at      time.Time
+}
+
+// WalletPool spreads the settlements of a network over several facilitator accounts,
+// so they do not queue behind one nonce sequence and one native balance.
+type WalletPool struct {
+	BalanceTTL time.Duration // how long a native balance lookup is trusted
+
+	mu       sync.Mutex
+	wallets  []*Wallet
+	balances map[string]cachedBalance // network/address
+	next     map[string]int           // round-robin start per network
+	balance  func(network string, address common.Address) (*big.Int, error)
+}
+
+func NewWalletPool() *WalletPool {
+	return &WalletPool{
+		BalanceTTL: 30 * time.Second,
+		balances:   map[string]cachedBalance{},
+		next:       map[string]int{},
+		balance: func(network string, address common.Address) (*big.Int, error) {
+			client, err := GetClientByNetwork(network)
+			if err != nil {
+				return nil, err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+			return client.BalanceAt(ctx, address, nil)
+		},
+	}
+}
+
+var walletPool = NewWalletPool()
+
+func Wallets() *WalletPool {
+	return walletPool
+}
+
+// Add puts the account in the pool for the given networks (none - all networks).
+// Adding an address again replaces its assignment.
+func (wp *WalletPool) Add(signer Signer, networks ...string) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	for _, w := range wp.wallets {
+		if w.Signer.Address() == signer.Address() {
+			w.Signer, w.Networks = signer, networks
+			return
+		}
+	}
+	wp.wallets = append(wp.wallets, &Wallet{Signer: signer, Networks: networks})
+}
+
+// All lists the wallets of the pool in the order they were added
+func (wp *WalletPool) All() []Wallet {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	all := make([]Wallet, len(wp.wallets))
+	for i, w := range wp.wallets {
+		all[i] = *w
+	}
+	return all
+}
+
+// For lists the accounts settling on the network
+func (wp *WalletPool) For(network string) []Signer {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	signers := []Signer{}
+	for _, w := range wp.wallets {
+		if w.serves(network) {
+			signers = append(signers, w.Signer)
+		}
+	}
+	return signers
+}
+
+// Find returns the account with the address if it settles on the network
+func (wp *WalletPool) Find(network string, address common.Address) (Signer, bool) {
+	for _, s := range wp.For(network) {
+		if s.Address() == address {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Balance is the native balance of the account, cached for BalanceTTL
+func (wp *WalletPool) Balance(network string, address common.Address) (*big.Int, error) {
+	id := network + "/" + address.Hex()
+	wp.mu.Lock()
+	cached, ok := wp.balances[id]
+	wp.mu.Unlock()
+	if ok && time.Since(cached.at) < wp.BalanceTTL {
+		return cached.balance, nil
+	}
+	balance, err := wp.balance(network, address)
+	if err != nil {
+		return nil, err
+	}
+	wp.mu.Lock()
+	wp.balances[id] = cachedBalance{balance: balance, at: time.Now()}
+	wp.mu.Unlock()
+	return balance, nil
+}
+
+// Pick chooses the account for the next settlement on the network: among the ones accept lets through
+// (nil - all) and that hold at least need wei (nil - anything above zero), the one with the fewest
+// transactions in flight, then the richest. Ties go round-robin. Unknown balances do not disqualify.
+func (wp *WalletPool) Pick(network string, need *big.Int, accept func(Signer) bool) (Signer, error) {
+	candidates := []Signer{}
+	for _, s := range wp.For(network) {
+		if accept == nil || accept(s) {
+			candidates = append(candidates, s)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w on %s", ErrNoWallet, network)
+	}
+
+	wp.mu.Lock()
+	start := wp.next[network]
+	wp.next[network] = start + 1
+	wp.mu.Unlock()
+
+	var best Signer
+	var bestDepth int
+	var bestBalance *big.Int
+	for i := range candidates {
+		s := candidates[(start+i)%len(candidates)]
+		balance, err := wp.Balance(network, s.Address())
+		if err != nil {
+			log.Printf("⚠️ Unable to check the balance of %s on %s: %v", s.Address(), network, err)
+		} else if (need == nil && balance.Sign() == 0) || (need != nil && balance.Cmp(need) < 0) {
+			continue
+		}
+		depth := Transactions().InFlight(network, s.Address())
+		if best == nil || depth < bestDepth || (depth == bestDepth && richer(balance, bestBalance)) {
+			best, bestDepth, bestBalance = s, depth, balance
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%w on %s: none of %v can cover %v wei", ErrNoWallet, network, len(candidates), need)
+	}
+	return best, nil
+}
+
+// nil is an unknown balance, it loses against any known one
+func richer(a, b *big.Int) bool {
+	if a == nil {
+		return false
+	}
+	return b == nil || a.Cmp(b) > 0
+}
@@ -0,0 +1,98 @@
+package evmbinding
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func testWallet() Signer {
+	key, _ := crypto.GenerateKey()
+	return NewKeySigner(key)
+}
+
+// testPool has fixed balances instead of asking a node
+func testPool(balances map[common.Address]int64) *WalletPool {
+	wp := NewWalletPool()
+	wp.balance = func(network string, address common.Address) (*big.Int, error) {
+		b, ok := balances[address]
+		if !ok {
+			return nil, errors.New("unknown")
+		}
+		return big.NewInt(b), nil
+	}
+	return wp
+}
+
+func TestWalletPoolAssignment(t *testing.T) {
+	a, b := testWallet(), testWallet()
+	wp := testPool(nil)
+	wp.Add(a)
+	wp.Add(b, "base-sepolia")
+	if n := len(wp.For("base-sepolia")); n != 2 {
+		t.Errorf("%v wallets on base-sepolia, expected 2", n)
+	}
+	if w := wp.For("arbitrum-sepolia"); len(w) != 1 || w[0] != a {
+		t.Errorf("wrong wallets on arbitrum-sepolia: %v", w)
+	}
+	if _, ok := wp.Find("arbitrum-sepolia", b.Address()); ok {
+		t.Error("found a wallet on a network it is not assigned to")
+	}
+	wp.Add(a, "sepolia")
+	if len(wp.All()) != 2 || len(wp.For("arbitrum-sepolia")) != 0 {
+		t.Error("adding a wallet again did not replace its networks")
+	}
+}
+
+func TestWalletPoolPick(t *testing.T) {
+	saved := txManager
+	txManager = NewTxManager()
+	t.Cleanup(func() { txManager = saved })
+
+	poor, rich, busy := testWallet(), testWallet(), testWallet()
+	wp := testPool(map[common.Address]int64{poor.Address(): 0, rich.Address(): 1000, busy.Address(): 5000})
+	wp.Add(poor)
+	wp.Add(rich)
+	wp.Add(busy)
+	txManager.accounts["devnet/"+busy.Address().Hex()] = &txAccount{inflight: map[uint64]*trackedTx{0: {}}}
+
+	// fewest in flight first, the empty wallet never
+	for i := 0; i < 3; i++ {
+		if w, err := wp.Pick("devnet", nil, nil); err != nil || w != rich {
+			t.Fatalf("picked %v (%v), expected the idle funded wallet", w, err)
+		}
+	}
+	// unless it cannot pay
+	if w, _ := wp.Pick("devnet", big.NewInt(2000), nil); w != busy {
+		t.Error("did not fall back to the busy wallet that can pay")
+	}
+	if _, err := wp.Pick("devnet", big.NewInt(10000), nil); !errors.Is(err, ErrNoWallet) {
+		t.Errorf("expected ErrNoWallet, got %v", err)
+	}
+	// accept narrows the choice
+	onlyPoor := func(s Signer) bool { return s == poor }
+	if _, err := wp.Pick("devnet", nil, onlyPoor); !errors.Is(err, ErrNoWallet) {
+		t.Errorf("picked an unfunded wallet: %v", err)
+	}
+}
+
+func TestWalletPoolRoundRobin(t *testing.T) {
+	a, b := testWallet(), testWallet()
+	wp := testPool(map[common.Address]int64{a.Address(): 100, b.Address(): 100})
+	wp.Add(a)
+	wp.Add(b)
+	seen := map[Signer]int{}
+	for i := 0; i < 4; i++ {
+		w, err := wp.Pick("devnet", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[w]++
+	}
+	if seen[a] != 2 || seen[b] != 2 {
+		t.Errorf("equal wallets not used in turn: %v/%v", seen[a], seen[b])
+	}
+}
@@ -0,0 +1,326 @@
+package facilitator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/state"
+)
+
+// With BatchWindow set, exact payments are not settled one transaction each: the authorizations of the same
+// (network, asset) wait up to BatchWindow, or until there are BatchSize of them, and go together through Multicall3.
+// Multicall3 is then the msg.sender of every call, so only transferWithAuthorization, which checks the payer's
+// signature and not its caller, may ever be batched.
+var BatchWindow time.Duration
+var BatchSize = 50
+
+// BatchMineTimeout is how long a /settle that does not take "Prefer: respond-async" waits for its batch to be mined
+var BatchMineTimeout = 30 * time.Second
+
+// batchItem is one settlement waiting for its batch
+type batchItem struct {
+	envelope *all712.Envelope
+	call     evmbinding.Call
+	rec      *state.SettlementRecord // of a deferred settlement, the batch records its outcome
+	batched  bool                    // went out with others, the tx succeeding does not mean this one did
+	done     chan batchOutcome
+}
+
+type batchOutcome struct {
+	tx     common.Hash
+	err    error
+	mining bool // the tx is out but its receipt did not come in time, the ledger gets the outcome
+}
+
+type batchKey struct {
+	network string
+	asset   common.Address
+}
+
+type batchQueue struct {
+	items []*batchItem
+	timer *time.Timer
+}
+
+type batcher struct {
+	mu     sync.Mutex
+	queues map[batchKey]*batchQueue
+	settle func(network string, items []*batchItem) // sends a batch, and finishes every item of it
+}
+
+var batches = &batcher{queues: map[batchKey]*batchQueue{}, settle: settleBatch}
+
+func init() {
+	state.BatchOutcome = batchedSettlementUsed
+}
+
+// add queues the item; the first one of a batch starts its window
+func (b *batcher) add(item *batchItem) {
+	key := batchKey{item.envelope.PaymentPayload.Network, common.HexToAddress(item.envelope.PaymentRequirements.Asset)}
+	b.mu.Lock()
+	q, ok := b.queues[key]
+	if !ok {
+		q = new(batchQueue)
+		b.queues[key] = q
+		q.timer = time.AfterFunc(BatchWindow, func() { b.flush(key, q) })
+	}
+	q.items = append(q.items, item)
+	full := len(q.items) >= BatchSize
+	b.mu.Unlock()
+	if full {
+		b.flush(key, q)
+	}
+}
+
+// flush sends the queue, unless the window and the size limit both went for it and the other one won
+func (b *batcher) flush(key batchKey, q *batchQueue) {
+	b.mu.Lock()
+	if b.queues[key] != q {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.queues, key)
+	b.mu.Unlock()
+	q.timer.Stop()
+	log.Printf("settling a batch of %v on %s", len(q.items), key.network)
+	go b.settle(key.network, q.items)
+}
+
+// settleBatch tries the calls through Multicall3 first, so that a failing authorization is answered on its own.
+// The rest goes in one aggregate3 transaction where each call may still fail alone, say when its nonce is used
+// before the batch is mined: the receipt tracker then finds no AuthorizationUsed of it and marks only it reverted.
+// A caller waiting for its settlement is only answered once the receipt shows the token consumed its authorization.
+func settleBatch(network string, items []*batchItem) {
+	wallet, err := evmbinding.Wallets().Pick(network, batchBudget(items), nil)
+	if err != nil {
+		finishBatch(items, common.Hash{}, err)
+		return
+	}
+
+	calls := make([]evmbinding.Call, len(items))
+	for i, item := range items {
+		calls[i] = item.call
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), simulationTimeout)
+	defer cancel()
+	results, err := evmbinding.TryAggregate3(ctx, network, wallet.Address(), calls...)
+	if err != nil {
+		finishBatch(items, common.Hash{}, all712.WithCode(all712.CodeRPCUnavailable, fmt.Errorf("unable to simulate the batch: %w", err)))
+		return
+	}
+	good := []*batchItem{}
+	for i, item := range items {
+		if !results[i].Success {
+			reason := evmbinding.DecodeRevert(results[i].ReturnData)
+			log.Printf("settlement on %s would revert: %s", network, reason)
+			item.finish(common.Hash{}, all712.Errorf(all712.CodeSettlementReverts, "settlement would revert: %s", reason))
+			continue
+		}
+		good = append(good, item)
+	}
+
+	switch len(good) {
+	case 0:
+		return
+	case 1:
+		h, err := sendCall(good[0].envelope, wallet, good[0].call)
+		if err != nil {
+			err = fmt.Errorf("error sending: %w", err)
+		}
+		finishBatch(good, h, err)
+		return
+	}
+	calls = calls[:0]
+	for _, item := range good {
+		calls = append(calls, item.call)
+	}
+	multicall, _ := evmbinding.Multicall3Address(network)
+	aggregate, err := evmbinding.Aggregate3Call(multicall, true, calls...)
+	if err != nil {
+		finishBatch(good, common.Hash{}, err)
+		return
+	}
+	gas, err := evmbinding.EstimateAggregate3(ctx, network, wallet.Address(), calls...)
+	if err != nil {
+		finishBatch(good, common.Hash{}, err)
+		return
+	}
+	for _, item := range good {
+		item.batched = true
+	}
+	mined := make(chan evmbinding.TxEvent, 1)
+	h, err := evmbinding.Transactions().Send(evmbinding.TxRequest{
+		Network:  network,
+		Signer:   wallet,
+		To:       aggregate.To,
+		Data:     aggregate.Data,
+		GasLimit: gas,
+		MaxCost:  batchBudget(good),
+		Done:     mined,
+	})
+	if err != nil {
+		finishBatch(good, h, fmt.Errorf("error sending the batch: %w", err))
+		return
+	}
+	// The deferred ones are the receipt tracker's from here, the waiting ones hear about their own call
+	waiting := []*batchItem{}
+	for _, item := range good {
+		if item.rec != nil {
+			item.finish(h, nil)
+			continue
+		}
+		waiting = append(waiting, item)
+	}
+	if len(waiting) == 0 {
+		return
+	}
+	select {
+	case ev := <-mined:
+		finishMined(waiting, ev)
+	case <-time.After(BatchMineTimeout):
+		for _, item := range waiting {
+			item.done <- batchOutcome{tx: h, mining: true,
+				err: all712.Errorf(all712.CodeRPCUnavailable, "batch %s not mined within %v", h, BatchMineTimeout)}
+		}
+	}
+}
+
+// finishMined answers the settlements waiting for a batch by what the token did with each of them
+func finishMined(items []*batchItem, ev evmbinding.TxEvent) {
+	for _, item := range items {
+		var err error
+		switch {
+		case ev.Kind != evmbinding.TxMined:
+			err = all712.Errorf(all712.CodeSettlementReverts, "batch %s %s: %s", ev.Original, ev.Kind, ev.Reason)
+		case ev.Receipt.Status != ethtypes.ReceiptStatusSuccessful:
+			err = all712.Errorf(all712.CodeSettlementReverts, "batch %s reverted", ev.Hash)
+		default:
+			err = authorizationUsed(item.envelope, ev.Receipt)
+		}
+		item.finish(ev.Hash, all712.WithCode(all712.CodeSettlementReverts, err))
+	}
+}
+
+// batchBudget is what the settlements would have been allowed to cost one by one; nil if one of them has no cap
+func batchBudget(items []*batchItem) *big.Int {
+	budget := new(big.Int)
+	for _, item := range items {
+		b := gasBudget(item.envelope)
+		if b == nil {
+			return nil
+		}
+		budget.Add(budget, b)
+	}
+	return budget
+}
+
+func finishBatch(items []*batchItem, tx common.Hash, err error) {
+	for _, item := range items {
+		item.finish(tx, err)
+	}
+}
+
+// finish hands the outcome to the waiting /settle, and to the ledger if no one waits
+func (item *batchItem) finish(tx common.Hash, err error) {
+	if item.rec != nil {
+		if err != nil {
+			log.Println("deferred settlement failed:", err)
+			item.rec.Status = state.StatusFailed
+			item.rec.Error = err.Error()
+		} else {
+			item.rec.Status = state.StatusPending
+			item.rec.TxHash = tx.Hex()
+			item.rec.Batched = item.batched
+		}
+		state.RecordSettlement(item.rec)
+	}
+	item.done <- batchOutcome{tx: tx, err: err}
+}
+
+// deferred tells whether the caller takes an acknowledgement now and the outcome later, from the ledger
+func deferred(c *gin.Context) bool {
+	return c.Request != nil && strings.Contains(strings.ToLower(c.GetHeader("Prefer")), "respond-async")
+}
+
+// settleBatched puts the exact payment into its batch. The caller waits for the batch to be mined,
+// or with "Prefer: respond-async" gets 202 and the id of the settlement to look up at /settlements/<id>.
+func settleBatched(c *gin.Context, envelope *all712.Envelope, response *types.SettleResponse, call evmbinding.Call) {
+	if !evmbinding.IsTransferWithAuthorization(call) {
+		failSettle(c, response, all712.Errorf(all712.CodeUnexpected, "only transferWithAuthorization can be batched"))
+		return
+	}
+	item := &batchItem{envelope: envelope, call: call, done: make(chan batchOutcome, 1)}
+	if r, ok := c.Get("settlement"); ok && deferred(c) {
+		rec := r.(*state.SettlementRecord)
+		rec.Payer = *response.Payer
+		rec.Status = state.StatusQueued
+		state.RecordSettlement(rec)
+		item.rec = rec
+		batches.add(item)
+		respondQueued(c, response, rec.ID)
+		return
+	}
+
+	batches.add(item)
+	outcome := <-item.done
+	if r, ok := c.Get("settlement"); ok {
+		rec := r.(*state.SettlementRecord)
+		rec.Batched = item.batched
+		if outcome.mining {
+			// Still ours to find out, the receipt tracker records how it ends
+			rec.TxHash = outcome.tx.Hex()
+			rec.Status = state.StatusPending
+			outcome.err = fmt.Errorf("%w, look for settlement %s", outcome.err, rec.ID)
+		}
+	}
+	if outcome.err != nil {
+		failSettle(c, response, outcome.err)
+		return
+	}
+	response.Success = true
+	response.Transaction = outcome.tx.Hex()
+	respondSettle(c, http.StatusOK, response)
+}
+
+// batchedSettlementUsed is the state.BatchOutcome: the batch tx succeeded, but did the token consume this authorization
+func batchedSettlementUsed(rec *state.SettlementRecord, receipt *ethtypes.Receipt) error {
+	envelope := new(all712.Envelope)
+	if err := json.Unmarshal(rec.Envelope, envelope); err != nil || envelope.PaymentPayload == nil || envelope.PaymentRequirements == nil {
+		return fmt.Errorf("no envelope to look for the settlement in the batch")
+	}
+	return authorizationUsed(envelope, receipt)
+}
+
+// authorizationUsed looks for the AuthorizationUsed of the envelope's authorization among the logs of the batch
+func authorizationUsed(envelope *all712.Envelope, receipt *ethtypes.Receipt) error {
+	id := new(authorizationID)
+	if err := json.Unmarshal(envelope.PaymentPayload.Payload, id); err != nil || id.Authorization == nil {
+		return fmt.Errorf("no authorization to look for in the batch")
+	}
+	if !evmbinding.AuthorizationUsed(receipt, common.HexToAddress(envelope.PaymentRequirements.Asset),
+		common.HexToAddress(id.Authorization.From), common.HexToHash(id.Authorization.Nonce)) {
+		return fmt.Errorf("the authorization failed within its batch")
+	}
+	return nil
+}
+
+// respondQueued acknowledges a deferred settlement: accepted, no transaction yet
+func respondQueued(c *gin.Context, response *types.SettleResponse, id string) {
+	response.Success = true
+	response.Network = all712.WireNetwork(requestVersion(c), response.Network)
+	c.Header("X-Settlement-ID", id)
+	c.JSON(http.StatusAccepted, settleResult{SettleResponse: *response, SettlementID: id})
+}
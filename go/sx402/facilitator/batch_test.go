@@ -0,0 +1,170 @@
+package facilitator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/schemes"
+	"github.com/san-lab/sx402/state"
+)
+
+const testUSDC = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+
+func TestBatches(t *testing.T) {
+	savedSettle, savedWindow, savedSize := batches.settle, BatchWindow, BatchSize
+	t.Cleanup(func() { batches.settle, BatchWindow, BatchSize = savedSettle, savedWindow, savedSize })
+	BatchWindow, BatchSize = 200*time.Millisecond, 3
+	batchTx := common.HexToHash("0xba7c4")
+	sent := make(chan []*batchItem, 10)
+	batches.settle = func(network string, items []*batchItem) {
+		sent <- items
+		finishBatch(items, batchTx, nil)
+	}
+
+	// a batch per asset; three of them fill one before its window is over
+	item := func(asset string) *batchItem {
+		envelope := exactEnvelope("10000", testPayTo, 0, time.Now().Unix()+60)
+		envelope.PaymentRequirements.Asset = asset
+		return &batchItem{envelope: envelope, done: make(chan batchOutcome, 1)}
+	}
+	start := time.Now()
+	items := []*batchItem{item(testUSDC), item(testUSDC), item("0x89D5"), item(testUSDC)}
+	for _, it := range items {
+		batches.add(it)
+	}
+	if full := <-sent; len(full) != 3 || time.Since(start) >= BatchWindow {
+		t.Errorf("full batch of %v sent after %v", len(full), time.Since(start))
+	}
+	if rest := <-sent; len(rest) != 1 || rest[0] != items[2] || time.Since(start) < BatchWindow {
+		t.Errorf("batch of %v sent after %v", len(rest), time.Since(start))
+	}
+	for i, it := range items {
+		if outcome := <-it.done; outcome.err != nil || outcome.tx != batchTx {
+			t.Errorf("item %v: %v %v", i, outcome.tx, outcome.err)
+		}
+	}
+
+	// /settle waits for the batch, or takes an acknowledgement and finds the outcome in the ledger
+	path := filepath.Join(t.TempDir(), "schemes.json")
+	os.WriteFile(path, []byte(`[{"scheme":"exact","type":"exac","network":"`+walletNet(t)+`",
+		"asset":"`+testUSDC+`","extra":{"name":"USDC","version":"2"}}]`), 0644)
+	if err := schemes.LoadSchemes(path); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := crypto.GenerateKey()
+	evmbinding.Wallets().Add(evmbinding.NewKeySigner(key))
+	testNode.eoa.Store(true)
+	validBefore := time.Now().Unix() + 60
+	settle := func(nonce string, async bool) (*httptest.ResponseRecorder, *settleResult) {
+		envelope := exactEnvelope("10000", testPayTo, 0, validBefore)
+		envelope.PaymentPayload.Scheme, envelope.PaymentPayload.Network = "exact", walletNet(t)
+		envelope.PaymentRequirements.Asset = testUSDC
+		envelope.PaymentPayload.Payload = bytes.Replace(envelope.PaymentPayload.Payload, []byte(`"0x01"`), []byte(`"`+nonce+`"`), 1)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/facilitator/settle", nil)
+		if async {
+			c.Request.Header.Set("Prefer", "respond-async")
+		}
+		c.Set("envelope", *envelope)
+		SettleHandler(c)
+		res := new(settleResult)
+		json.Unmarshal(w.Body.Bytes(), res)
+		return w, res
+	}
+
+	w, res := settle("0xa1", true)
+	if w.Code != http.StatusAccepted || !res.Success || len(res.Transaction) > 0 || len(res.SettlementID) == 0 {
+		t.Fatalf("deferred settlement answered %v %s", w.Code, w.Body)
+	}
+	if rec, err := state.Ledger().Get(res.SettlementID); err != nil || rec.Status != state.StatusQueued {
+		t.Errorf("deferred settlement not queued: %v %v", rec, err)
+	}
+	// a retry while it waits is the same settlement
+	if w, again := settle("0xa1", true); w.Code != http.StatusAccepted || again.SettlementID != res.SettlementID {
+		t.Errorf("retry answered %v %s", w.Code, w.Body)
+	}
+
+	w, sync := settle("0xa2", false)
+	if w.Code != http.StatusOK || !sync.Success || sync.Transaction != batchTx.Hex() {
+		t.Errorf("batched settlement answered %v %s", w.Code, w.Body)
+	}
+	if batch := <-sent; len(batch) != 2 {
+		t.Errorf("both settlements not in one batch: %v", len(batch))
+	}
+	if rec, err := state.Ledger().Get(res.SettlementID); err != nil || rec.Status != state.StatusPending || rec.TxHash != batchTx.Hex() {
+		t.Errorf("outcome of the deferred settlement not recorded: %v %v", rec, err)
+	}
+	// the outcome is public, the signed authorization is not
+	w = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: res.SettlementID}}
+	getSettlement(c)
+	if rec := new(state.SettlementRecord); json.Unmarshal(w.Body.Bytes(), rec) != nil || rec.TxHash != batchTx.Hex() || rec.Envelope != nil {
+		t.Errorf("/settlements/<id> answered %s", w.Body)
+	}
+}
+
+// A batch tx that went through does not make all of its settlements good: one whose authorization the token
+// did not consume failed on its own, and only it is reverted
+func TestBatchOutcome(t *testing.T) {
+	envelope := func(nonce string) *all712.Envelope {
+		envelope := exactEnvelope("10000", testPayTo, 0, time.Now().Unix()+60)
+		envelope.PaymentRequirements.Asset = testUSDC
+		envelope.PaymentPayload.Payload = bytes.Replace(envelope.PaymentPayload.Payload, []byte(`"0x01"`), []byte(`"`+nonce+`"`), 1)
+		return envelope
+	}
+	record := func(nonce string) *state.SettlementRecord {
+		raw, _ := json.Marshal(envelope(nonce))
+		return &state.SettlementRecord{Batched: true, Envelope: raw}
+	}
+	settled, failed := record("0xb1"), record("0xb2")
+	receipt := &ethtypes.Receipt{Status: ethtypes.ReceiptStatusSuccessful, Logs: []*ethtypes.Log{{
+		Address: common.HexToAddress(testUSDC),
+		Topics: []common.Hash{
+			crypto.Keccak256Hash([]byte("AuthorizationUsed(address,bytes32)")),
+			common.HexToHash("0x857b06519E91e3A54538791bDbb0E22373e36b66"),
+			common.HexToHash("0xb1"),
+		},
+	}}}
+	if state.BatchOutcome == nil {
+		t.Fatal("the receipt tracker cannot tell the outcome of batched settlements")
+	}
+	if err := state.BatchOutcome(settled, receipt); err != nil {
+		t.Error("settled authorization not found in the batch:", err)
+	}
+	if err := state.BatchOutcome(failed, receipt); err == nil {
+		t.Error("failed authorization taken as settled with the rest of its batch")
+	}
+
+	// the callers waiting for the batch are answered by the same receipt
+	batchTx := common.HexToHash("0xba7c4")
+	waiting := []*batchItem{
+		{envelope: envelope("0xb1"), done: make(chan batchOutcome, 1)},
+		{envelope: envelope("0xb2"), done: make(chan batchOutcome, 1)},
+	}
+	finishMined(waiting, evmbinding.TxEvent{Kind: evmbinding.TxMined, Hash: batchTx, Receipt: receipt})
+	if outcome := <-waiting[0].done; outcome.err != nil || outcome.tx != batchTx {
+		t.Errorf("settled authorization answered %v %v", outcome.tx, outcome.err)
+	}
+	if outcome := <-waiting[1].done; !errors.Is(outcome.err, &all712.Error{Code: all712.CodeSettlementReverts}) {
+		t.Errorf("failed authorization answered %v", outcome.err)
+	}
+	finishMined(waiting[:1], evmbinding.TxEvent{Kind: evmbinding.TxDropped, Original: batchTx, Reason: "nonce used by another transaction"})
+	if outcome := <-waiting[0].done; !errors.Is(outcome.err, &all712.Error{Code: all712.CodeSettlementReverts}) {
+		t.Errorf("dropped batch answered %v", outcome.err)
+	}
+}
@@ -0,0 +1,94 @@
+package facilitator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/signing"
+)
+
+// counterfactual splits the EIP-6492 signature of a payer whose wallet is not deployed yet into the deployment
+// to make before the settlement and the signature the wallet takes. Other signatures come back as they are.
+func counterfactual(network string, payer common.Address, signature []byte) (*evmbinding.Call, []byte, error) {
+	cf, ok := signing.ParseERC6492(signature)
+	if !ok {
+		return nil, signature, nil
+	}
+	deployed, err := evmbinding.IsContract(network, payer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to look for the wallet of %s: %w", payer.Hex(), err)
+	}
+	if deployed {
+		return nil, cf.Signature, nil
+	}
+	deploy := cf.DeployCall()
+	return &deploy, cf.Signature, nil
+}
+
+// crossChainCounterfactual unwraps the signature of the cross-chain message for the token, and gives the deployment that goes first
+func crossChainCounterfactual(network string, ccmsg *all712.CrossChainTransferMessage) (*evmbinding.Call, error) {
+	deploy, sig, err := counterfactual(network, ccmsg.Authorization.From, common.FromHex(ccmsg.Signature))
+	if err != nil {
+		return nil, err
+	}
+	ccmsg.Signature = hexutil.Encode(sig)
+	return deploy, nil
+}
+
+// withDeploy puts the wallet deployment, if any, in front of the settlement calls
+func withDeploy(deploy *evmbinding.Call, calls ...evmbinding.Call) []evmbinding.Call {
+	if deploy == nil {
+		return calls
+	}
+	return append([]evmbinding.Call{*deploy}, calls...)
+}
+
+// sendSettlement sends the settlement call, after deploying the payer's wallet if there is one to deploy.
+// The settlement only goes through once the wallet exists, so its gas comes from simulating the two together.
+func sendSettlement(envelope *all712.Envelope, wallet evmbinding.Signer, deploy *evmbinding.Call, call evmbinding.Call) (common.Hash, error) {
+	if deploy == nil {
+		return sendCall(envelope, wallet, call)
+	}
+	network := envelope.PaymentPayload.Network
+	ctx, cancel := context.WithTimeout(context.Background(), simulationTimeout)
+	defer cancel()
+	gas, err := evmbinding.SimulateGas(ctx, network, wallet.Address(), *deploy, call)
+	var revert *evmbinding.RevertError
+	if errors.As(err, &revert) {
+		return common.Hash{}, all712.Errorf(all712.CodeSettlementReverts, "settlement after the wallet deployment would revert: %s", revert.Reason)
+	}
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("unable to price the settlement after the wallet deployment: %w", err)
+	}
+
+	// the deployment and the settlement are two txs sharing the budget of the settlement
+	budget := shareOf(gasBudget(envelope), 2)
+	deployTx, err := evmbinding.Transactions().Send(evmbinding.TxRequest{
+		Network:  network,
+		Signer:   wallet,
+		To:       deploy.To,
+		Value:    deploy.Value,
+		Data:     deploy.Data,
+		GasLimit: gas[0],
+		MaxCost:  budget,
+	})
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("error deploying the payer's wallet: %w", err)
+	}
+	log.Printf("deploying the wallet of the payer in %s on %s", deployTx.Hex(), network)
+	return evmbinding.Transactions().Send(evmbinding.TxRequest{
+		Network:  network,
+		Signer:   wallet,
+		To:       call.To,
+		Value:    call.Value,
+		Data:     call.Data,
+		GasLimit: gas[1],
+		MaxCost:  budget,
+	})
+}
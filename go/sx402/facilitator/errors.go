@@ -0,0 +1,87 @@
+package facilitator
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/signing"
+)
+
+// The answers to refused payments: invalidReason/errorReason hold the all712 code,
+// the message next to it the details for humans
+type verifyResult struct {
+	types.VerifyResponse
+	InvalidMessage  string                  `json:"invalidMessage,omitempty"`
+	SignatureMethod signing.SignatureMethod `json:"signatureMethod,omitempty"` // ecdsa, erc1271 or erc6492, once the signature checked out
+}
+
+type settleResult struct {
+	types.SettleResponse
+	ErrorMessage string `json:"errorMessage,omitempty"`
+	SettlementID string `json:"settlementId,omitempty"` // of a deferred settlement, to look up its outcome
+}
+
+// httpStatus of a refusal: a payment that does not qualify is a regular answer,
+// a request that cannot be read is the caller's fault, and the facilitator's own troubles are worth a retry
+func httpStatus(code all712.ErrorCode) int {
+	switch code {
+	case all712.CodeInvalidPayload, all712.CodeInvalidVersion:
+		return http.StatusBadRequest
+	case all712.CodeRPCUnavailable, all712.CodeFacilitatorUnavailable:
+		return http.StatusServiceUnavailable
+	case all712.CodeUnexpected:
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
+
+// respondVerify answers /verify with the outcome of the checks
+func respondVerify(c *gin.Context, payer *string, err error) {
+	if err != nil {
+		rejectVerify(c, payer, err)
+		return
+	}
+	c.JSON(http.StatusOK, verifyResult{VerifyResponse: types.VerifyResponse{IsValid: true, Payer: payer}, SignatureMethod: signatureMethod(c)})
+}
+
+// signatureMethod is how the verify handler validated the payer's signature, if it got that far
+func signatureMethod(c *gin.Context) signing.SignatureMethod {
+	if m, ok := c.Get("signatureMethod"); ok {
+		return m.(signing.SignatureMethod)
+	}
+	return ""
+}
+
+// rejectVerify answers /verify with an invalid payment
+func rejectVerify(c *gin.Context, payer *string, err error) {
+	code := all712.CodeOf(err)
+	reason := string(code)
+	log.Println("payment not valid:", err)
+	result := verifyResult{types.VerifyResponse{InvalidReason: &reason, Payer: payer}, all712.Details(err), signatureMethod(c)}
+	c.AbortWithStatusJSON(httpStatus(code), result)
+}
+
+// failSettle records the failed settlement in the ledger and answers /settle with it
+func failSettle(c *gin.Context, response *types.SettleResponse, err error) {
+	code := all712.CodeOf(err)
+	reason := string(code)
+	log.Println("settlement failed:", err)
+	response.Success = false
+	response.ErrorReason = &reason
+	recordSettlement(c, response, err.Error())
+	response.Network = all712.WireNetwork(requestVersion(c), response.Network)
+	c.AbortWithStatusJSON(httpStatus(code), settleResult{SettleResponse: *response, ErrorMessage: all712.Details(err)})
+}
+
+// rejectRequest is for the middleware, which refuses in the language of the endpoint
+func rejectRequest(c *gin.Context, network string, err error) {
+	if c.Request != nil && strings.HasSuffix(c.Request.URL.Path, "/settle") {
+		failSettle(c, &types.SettleResponse{Network: network}, err)
+		return
+	}
+	rejectVerify(c, nil, err)
+}
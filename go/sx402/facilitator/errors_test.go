@@ -0,0 +1,119 @@
+package facilitator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+)
+
+const testPayTo = "0xCEF702Bd69926B13ab7150624daA7aFEE0300786"
+
+func exactEnvelope(value, to string, validAfter, validBefore int64) *all712.Envelope {
+	payload := fmt.Sprintf(`{"signature":"0x00","authorization":{"from":"0x857b06519E91e3A54538791bDbb0E22373e36b66",
+		"to":%q,"value":%q,"validAfter":"%d","validBefore":"%d","nonce":"0x01"}}`, to, value, validAfter, validBefore)
+	envelope := testEnvelope(payload)
+	extra := json.RawMessage(`{"name":"USDC","version":"2"}`)
+	envelope.PaymentRequirements.MaxAmountRequired = "10000"
+	envelope.PaymentRequirements.PayTo = testPayTo
+	envelope.PaymentRequirements.Extra = &extra
+	return envelope
+}
+
+func TestExactCodes(t *testing.T) {
+	now := time.Now().Unix()
+	cases := []struct {
+		envelope *all712.Envelope
+		code     all712.ErrorCode
+	}{
+		{testEnvelope(`{"signature":1}`), all712.CodeInvalidPayload},
+		{exactEnvelope("10", testPayTo, 0, now+60), all712.CodeAmountMismatch},
+		{exactEnvelope("10000", testPayTo, now+60, now+120), all712.CodeNotYetValid},
+		{exactEnvelope("10000", testPayTo, 0, now-1), all712.CodeExpired},
+		{exactEnvelope("10000", "0x857b06519E91e3A54538791bDbb0E22373e36b66", 0, now+60), all712.CodeRecipientMismatch},
+		{exactEnvelope("10000", testPayTo, 0, now+60), all712.CodeInvalidSignature},
+	}
+	// a payer without code, whose signature does not recover
+	testNode.eoa.Store(true)
+	for i, tc := range cases {
+		tc.envelope.PaymentPayload.Network = walletNet(t)
+		_, err := ParseAndVerifyExact(tc.envelope)
+		if all712.CodeOf(err) != tc.code || !errors.Is(err, &all712.Error{Code: tc.code}) {
+			t.Errorf("case %v: expected %s, got %v", i, tc.code, err)
+		}
+	}
+
+	// a code survives wrapping, and is not overwritten
+	err := fmt.Errorf("error sending: %w", all712.WithCode(all712.CodeRPCUnavailable, evmbinding.ErrFeeTooHigh))
+	if all712.CodeOf(err) != all712.CodeFeeTooHigh || !errors.Is(err, evmbinding.ErrFeeTooHigh) {
+		t.Errorf("wrong code of %v", err)
+	}
+}
+
+func TestRejections(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	evmbinding.Wallets().Add(evmbinding.NewKeySigner(key))
+
+	router := gin.New()
+	group := router.Group("/facilitator", ParseEnvelope, SetupClient)
+	group.POST("/verify", verifyHandler)
+	group.POST("/settle", SettleHandler)
+
+	envelope := exactEnvelope("10000", testPayTo, 0, time.Now().Unix()+60)
+	envelope.PaymentPayload.Scheme = "nope"
+	unknownScheme, _ := json.Marshal(envelope)
+	envelope.PaymentPayload.Network = "nowhere"
+	unknownNetwork, _ := json.Marshal(envelope)
+
+	cases := []struct {
+		path, body string
+		status     int
+		reason     all712.ErrorCode
+	}{
+		{"/verify", `{"x402Version":`, http.StatusBadRequest, all712.CodeInvalidPayload},
+		{"/settle", `{"x402Version":0}`, http.StatusBadRequest, all712.CodeInvalidVersion},
+		{"/verify", string(unknownScheme), http.StatusOK, all712.CodeUnsupportedScheme},
+		{"/settle", string(unknownScheme), http.StatusOK, all712.CodeUnsupportedScheme},
+		{"/verify", string(unknownNetwork), http.StatusOK, all712.CodeInvalidNetwork},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/facilitator"+tc.path, strings.NewReader(tc.body)))
+		if w.Code != tc.status {
+			t.Errorf("%s %s: status %v, expected %v", tc.path, tc.body, w.Code, tc.status)
+		}
+		if tc.path == "/verify" {
+			res := new(verifyResult)
+			json.Unmarshal(w.Body.Bytes(), res)
+			if res.IsValid || res.InvalidReason == nil || *res.InvalidReason != string(tc.reason) || len(res.InvalidMessage) == 0 {
+				t.Errorf("%s %s: answered %s", tc.path, tc.body, w.Body)
+			}
+		} else {
+			res := new(settleResult)
+			json.Unmarshal(w.Body.Bytes(), res)
+			if res.Success || res.ErrorReason == nil || *res.ErrorReason != string(tc.reason) || len(res.ErrorMessage) == 0 {
+				t.Errorf("%s %s: answered %s", tc.path, tc.body, w.Body)
+			}
+		}
+	}
+
+	for code, status := range map[all712.ErrorCode]int{
+		all712.CodeInsufficientFunds:      http.StatusOK,
+		all712.CodeRPCUnavailable:         http.StatusServiceUnavailable,
+		all712.CodeFacilitatorUnavailable: http.StatusServiceUnavailable,
+		all712.CodeUnexpected:             http.StatusInternalServerError,
+	} {
+		if httpStatus(code) != status {
+			t.Errorf("%s answered with %v, expected %v", code, httpStatus(code), status)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package facilitator
+
+import (
+	"math/big"
+
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/schemes"
+)
+
+// gasBudget is the most the settlement tx may cost in gas, per the fee cap of the network.
+// nil when the network has no cap or the scheme's asset has no reference price.
+func gasBudget(envelope *all712.Envelope) *big.Int {
+	network := envelope.PaymentPayload.Network
+	scheme, err := schemes.GetScheme(envelope.PaymentPayload.Scheme, network)
+	if err != nil {
+		return nil
+	}
+	amount, ok := new(big.Int).SetString(envelope.PaymentRequirements.MaxAmountRequired, 10)
+	if !ok {
+		return nil
+	}
+	value, ok := scheme.Value(amount)
+	if !ok {
+		return nil
+	}
+	return evmbinding.GasBudget(network, value)
+}
+
+// shareOf is the part of the budget each of the n txs of one settlement may spend, so that together they stay within it
+func shareOf(budget *big.Int, n int64) *big.Int {
+	if budget == nil {
+		return nil
+	}
+	return new(big.Int).Div(budget, big.NewInt(n))
+}
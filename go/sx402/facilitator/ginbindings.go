@@ -2,6 +2,7 @@ package facilitator
 
 import (
 	"bytes"
+	"context"
 	"html/template"
 	"io"
 	"log"
@@ -10,18 +11,44 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
 	"github.com/san-lab/sx402/mockstore/store"
 	"github.com/san-lab/sx402/schemes"
+	"github.com/san-lab/sx402/state"
 )
 
 var Template = template.New("")
 
+// Scheme configuration; SchemesReload == 0 disables watching the file
+var SchemesFile = "config/schemes.json"
+var SchemesReload time.Duration
+
+// How long a settlement tx may wait for a block before it gets re-priced
+var ReplaceAfter = 2 * time.Minute
+
 func Start(withStore bool, facilitatorPassword []byte) {
 	err := InitKeys(facilitatorPassword)
 	if err != nil {
 		log.Fatal("error initializig keys:", err)
 		return
 	}
+	err = schemes.LoadSchemes(SchemesFile)
+	if err != nil {
+		log.Fatal("error loading schemes:", err)
+		return
+	}
+	checkPinnedSpenders()
+	if SchemesReload > 0 {
+		schemes.WatchSchemes(context.Background(), SchemesFile, SchemesReload)
+	}
+	err = openLedger()
+	if err != nil {
+		log.Fatal("error opening the settlement ledger:", err)
+		return
+	}
+	evmbinding.Transactions().ReplaceAfter = ReplaceAfter
+	evmbinding.Transactions().Listener = state.GetReceiptCollector().OnTxEvent
 	router := gin.Default()
 	template.Must(Template.ParseGlob("templates/*html"))
 	router.SetHTMLTemplate(Template)
@@ -29,8 +56,8 @@ func Start(withStore bool, facilitatorPassword []byte) {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "X-Payment", "PAYMENT-SIGNATURE"},
+		ExposeHeaders:    []string{"Content-Length", "X-PAYMENT-RESPONSE", "PAYMENT-REQUIRED", "PAYMENT-RESPONSE"},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -41,6 +68,10 @@ func Start(withStore bool, facilitatorPassword []byte) {
 	router.GET("facilitator/receipt", prettyReceiptPage)
 	router.GET("facilitator/permitnonce", permitNonceHandler)
 	router.GET("facilitator/markup", getMarkup)
+	router.GET("facilitator/rpcstatus", getRPCStatus)
+	router.GET("facilitator/wallets", getWallets)
+	router.GET("facilitator/settlements", listSettlements)
+	router.GET("facilitator/settlements/:id", getSettlement)
 	withEnvelope := router.Group("/facilitator", RequestLogger(), ParseEnvelope, SetupClient)
 	withEnvelope.POST("/verify", verifyHandler)
 	withEnvelope.POST("/settle", SettleHandler)
@@ -83,12 +114,50 @@ func RequestLogger() gin.HandlerFunc {
 	}
 }
 
+// getSupported lists the scheme/network pairs; ?x402Version=2 lists them with CAIP-2 networks
 func getSupported(c *gin.Context) {
-	supported := []schemes.SchemeKey{}
-	for scheme := range schemes.SchemeMap {
-		supported = append(supported, scheme)
+	if c.Query("x402Version") == "2" {
+		type kind struct {
+			X402Version int    `json:"x402Version"`
+			Scheme      string `json:"scheme"`
+			Network     string `json:"network"`
+		}
+		kinds := []kind{}
+		for _, k := range schemes.Supported() {
+			kinds = append(kinds, kind{2, k.Name, all712.WireNetwork(2, k.Network)})
+		}
+		c.JSON(http.StatusOK, gin.H{"kinds": kinds, "handlers": SchemeHandlers()})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"kinds": supported,
+		"kinds":    schemes.Supported(),
+		"handlers": SchemeHandlers(),
 	})
 }
+
+// Per-endpoint health and request metrics of the rpc client pool
+func getRPCStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, evmbinding.Pool().Stats())
+}
+
+// getWallets shows the facilitator wallets with their in-flight transactions and cached balances per network
+func getWallets(c *gin.Context) {
+	type walletStatus struct {
+		Network  string `json:"network"`
+		Address  string `json:"address"`
+		InFlight int    `json:"inFlight"`
+		Balance  string `json:"balance,omitempty"`
+	}
+	status := []walletStatus{}
+	for _, n := range evmbinding.Networks() {
+		network := n.Name
+		for _, w := range evmbinding.Wallets().For(network) {
+			ws := walletStatus{Network: network, Address: w.Address().Hex(), InFlight: evmbinding.Transactions().InFlight(network, w.Address())}
+			if balance, err := evmbinding.Wallets().Balance(network, w.Address()); err == nil {
+				ws.Balance = balance.String()
+			}
+			status = append(status, ws)
+		}
+	}
+	c.JSON(http.StatusOK, status)
+}
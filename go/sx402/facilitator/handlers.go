@@ -0,0 +1,115 @@
+package facilitator
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/schemes"
+)
+
+// SchemeHandler is a payment method. /verify and /settle look the scheme of the envelope up
+// in the scheme registry and hand the request to the handler of its type.
+// Verify answers with a types.VerifyResponse and Settle with a types.SettleResponse, both through c.
+// The rpc client of the network is in the context under "client" and the ledger record of the
+// settlement under "settlement".
+type SchemeHandler interface {
+	Verify(c *gin.Context, envelope *all712.Envelope)
+	Settle(c *gin.Context, envelope *all712.Envelope)
+	Describe() SchemeDescription
+}
+
+// SchemeDescription is what /supported tells about a scheme type
+type SchemeDescription struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Settlement  string `json:"settlement"` // what the facilitator sends on chain
+}
+
+var handlers = struct {
+	sync.RWMutex
+	byType map[string]SchemeHandler
+}{byType: map[string]SchemeHandler{}}
+
+// RegisterSchemeHandler plugs a payment method in; entries of the type in the scheme file become valid.
+// Meant for init(), a second handler for the same type panics.
+func RegisterSchemeHandler(schemeType string, handler SchemeHandler) {
+	handlers.Lock()
+	defer handlers.Unlock()
+	if _, dup := handlers.byType[schemeType]; dup {
+		panic("scheme handler registered twice for " + schemeType)
+	}
+	handlers.byType[schemeType] = handler
+	schemes.RegisterType(schemeType)
+}
+
+// handlerFor finds the handler of the scheme the envelope pays with
+func handlerFor(envelope *all712.Envelope) (SchemeHandler, error) {
+	scheme, err := schemes.GetScheme(envelope.PaymentPayload.Scheme, envelope.PaymentPayload.Network)
+	if err != nil {
+		return nil, all712.Errorf(all712.CodeUnsupportedScheme, "unsupported scheme/network pair: %s/%s", envelope.PaymentPayload.Scheme, envelope.PaymentPayload.Network)
+	}
+	handlers.RLock()
+	defer handlers.RUnlock()
+	handler, ok := handlers.byType[scheme.Type]
+	if !ok {
+		return nil, all712.Errorf(all712.CodeUnsupportedScheme, "unsupported scheme: %s", envelope.PaymentPayload.Scheme)
+	}
+	return handler, nil
+}
+
+// SchemeHandlers describes every registered payment method, sorted by type
+func SchemeHandlers() []SchemeDescription {
+	handlers.RLock()
+	defer handlers.RUnlock()
+	descs := make([]SchemeDescription, 0, len(handlers.byType))
+	for _, h := range handlers.byType {
+		descs = append(descs, h.Describe())
+	}
+	sort.Slice(descs, func(i, j int) bool { return descs[i].Type < descs[j].Type })
+	return descs
+}
+
+// builtinHandler adapts the verify/settle functions of this package
+type builtinHandler struct {
+	verify, settle func(c *gin.Context, envelope *all712.Envelope)
+	desc           SchemeDescription
+}
+
+func (h builtinHandler) Verify(c *gin.Context, envelope *all712.Envelope) { h.verify(c, envelope) }
+func (h builtinHandler) Settle(c *gin.Context, envelope *all712.Envelope) { h.settle(c, envelope) }
+func (h builtinHandler) Describe() SchemeDescription                      { return h.desc }
+
+func init() {
+	RegisterSchemeHandler(schemes.ExactType, builtinHandler{VerifyExactEnvelope, SettleExactScheme, SchemeDescription{
+		Type:        schemes.ExactType,
+		Description: "EIP-3009 transferWithAuthorization of the exact amount to payTo",
+		Settlement:  "transferWithAuthorization",
+	}})
+	RegisterSchemeHandler(schemes.PermitType, builtinHandler{VerifyPermitEnvelope, SettlePermitScheme, SchemeDescription{
+		Type:        schemes.PermitType,
+		Description: "EIP-2612 permit to the facilitator, which then pulls the amount to payTo",
+		Settlement:  "permit + transferFrom",
+	}})
+	RegisterSchemeHandler(schemes.Permit2Type, builtinHandler{VerifyPermit2Envelope, SettlePermit2Scheme, SchemeDescription{
+		Type:        schemes.Permit2Type,
+		Description: "Uniswap Permit2 transfer of any approved ERC-20, with a witness binding payTo and the resource",
+		Settlement:  "permitWitnessTransferFrom",
+	}})
+	RegisterSchemeHandler(schemes.UptoType, builtinHandler{VerifyPermit2Envelope, SettleUptoScheme, SchemeDescription{
+		Type:        schemes.UptoType,
+		Description: "Permit2 transfer of up to maxAmountRequired, settled for the consumedAmount the resource server reports",
+		Settlement:  "permitWitnessTransferFrom",
+	}})
+	RegisterSchemeHandler(schemes.Payer0Legacy, builtinHandler{VerifyPayer0Envelope, SettlePayerZero, SchemeDescription{
+		Type:        schemes.Payer0Legacy,
+		Description: "EIP-3009 authorization bridged to payTo on the destination chain over LayerZero",
+		Settlement:  "sendWithAuthorization",
+	}})
+	RegisterSchemeHandler(schemes.Payer0Type, builtinHandler{VerifyCrossChainScheme, SettleCrossChainScheme, SchemeDescription{
+		Type:        schemes.Payer0Type,
+		Description: "cross-chain authorization with a guaranteed minimal amount on the destination chain, over LayerZero",
+		Settlement:  "sendWithCCAuthorization",
+	}})
+}
@@ -0,0 +1,76 @@
+package facilitator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/schemes"
+)
+
+type recordingHandler struct {
+	verified, settled int
+}
+
+func (h *recordingHandler) Verify(c *gin.Context, envelope *all712.Envelope) {
+	h.verified++
+	c.JSON(http.StatusOK, gin.H{"isValid": true})
+}
+
+func (h *recordingHandler) Settle(c *gin.Context, envelope *all712.Envelope) {
+	h.settled++
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (h *recordingHandler) Describe() SchemeDescription {
+	return SchemeDescription{Type: "recording", Description: "test handler"}
+}
+
+func TestSchemeHandlerDispatch(t *testing.T) {
+	h := &recordingHandler{}
+	RegisterSchemeHandler("recording", h)
+
+	path := filepath.Join(t.TempDir(), "schemes.json")
+	err := os.WriteFile(path, []byte(`[
+		{"scheme":"rec","type":"recording","network":"base-sepolia","asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e","extra":{"name":"R","version":"1"}},
+		{"scheme":"PZ_toOP","type":"payer0","network":"arbitrum-sepolia","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"name":"P","version":"1"},"dstEid":"40232"}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := schemes.LoadSchemes(path); err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := crypto.GenerateKey()
+	evmbinding.Wallets().Add(evmbinding.NewKeySigner(key))
+
+	envelope := testEnvelope(`{}`)
+	envelope.PaymentPayload.Scheme = "rec"
+	for _, endpoint := range []gin.HandlerFunc{verifyHandler, SettleHandler} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Set("envelope", *envelope)
+		endpoint(c)
+	}
+	if h.verified != 1 || h.settled != 1 {
+		t.Errorf("verified %v, settled %v times, expected once each", h.verified, h.settled)
+	}
+
+	// Settle used to route by name and missed the schemes not spelled out there
+	envelope.PaymentPayload.Scheme = schemes.Scheme_Payer0Plus_toOP
+	envelope.PaymentPayload.Network = "arbitrum-sepolia"
+	handler, err := handlerFor(envelope)
+	if err != nil || handler.Describe().Type != schemes.Payer0Type {
+		t.Errorf("PZ_toOP not handled as payer0: %v", err)
+	}
+
+	envelope.PaymentPayload.Scheme = "nope"
+	if _, err := handlerFor(envelope); err == nil {
+		t.Error("found a handler for an unknown scheme")
+	}
+}
@@ -0,0 +1,177 @@
+package facilitator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/schemes"
+	"github.com/san-lab/sx402/state"
+)
+
+// The bits of the payload that identify an authorization. 3009-style payloads (exact, payer0, cross-chain)
+// carry authorization.from/nonce, permits and Permit2 transfers carry message.owner and the permit nonce.
+type authorizationID struct {
+	Authorization *struct {
+		From  string `json:"from"`
+		Nonce string `json:"nonce"`
+	} `json:"authorization"`
+	Message *struct {
+		Owner string `json:"owner"`
+	} `json:"message"`
+	Nonce *big.Int `json:"nonce"`
+}
+
+// The nonce spaces of the authorizations: the token's EIP-3009 nonces, its EIP-2612 permit counter, and Permit2's
+const (
+	kind3009    = "3009"
+	kindPermit  = "permit"
+	kindPermit2 = "permit2"
+)
+
+// settlementKey identifies the authorization being settled by (network, kind, asset, payer, nonce).
+// The kind keeps apart nonces that look alike, permit 1 is no 3009 nonce 0x01.
+func settlementKey(envelope *all712.Envelope) (string, error) {
+	if envelope.PaymentPayload == nil || envelope.PaymentRequirements == nil {
+		return "", fmt.Errorf("incomplete envelope")
+	}
+	id := new(authorizationID)
+	if err := json.Unmarshal(envelope.PaymentPayload.Payload, id); err != nil {
+		return "", err
+	}
+	var kind, payer string
+	var nonce common.Hash
+	switch {
+	case id.Authorization != nil && len(id.Authorization.Nonce) > 0:
+		kind = kind3009
+		payer = id.Authorization.From
+		nonce = common.HexToHash(id.Authorization.Nonce)
+	case id.Message != nil && id.Nonce != nil:
+		kind = kindPermit
+		if scheme, err := schemes.GetScheme(envelope.PaymentPayload.Scheme, envelope.PaymentPayload.Network); err == nil && scheme.UsesPermit2() {
+			kind = kindPermit2
+		}
+		payer = id.Message.Owner
+		nonce = common.BigToHash(id.Nonce)
+	default:
+		return "", fmt.Errorf("no authorization nonce in the payload")
+	}
+	if !common.IsHexAddress(payer) {
+		return "", fmt.Errorf("invalid payer address: %s", payer)
+	}
+	return strings.ToLower(fmt.Sprintf("%s/%s/%s/%s/%s",
+		envelope.PaymentPayload.Network,
+		kind,
+		common.HexToAddress(envelope.PaymentRequirements.Asset).Hex(),
+		common.HexToAddress(payer).Hex(),
+		nonce.Hex())), nil
+}
+
+// Serializes settlements of the same authorization
+var settleLocks = keyedMutex{locks: map[string]*keyedLock{}}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+func (km *keyedMutex) Lock(key string) {
+	km.mu.Lock()
+	l, ok := km.locks[key]
+	if !ok {
+		l = new(keyedLock)
+		km.locks[key] = l
+	}
+	l.refs++
+	km.mu.Unlock()
+	l.Lock()
+}
+
+func (km *keyedMutex) Unlock(key string) {
+	km.mu.Lock()
+	l := km.locks[key]
+	l.refs--
+	if l.refs == 0 {
+		delete(km.locks, key)
+	}
+	km.mu.Unlock()
+	l.Unlock()
+}
+
+// replaySettlement answers a repeated /settle with the outcome of the original one,
+// as long as that one is on its way or made it. Failed and reverted attempts may be retried.
+// The nonce is public once on-chain, so only the very same payment for the very same requirements
+// gets the original answer; anything else with its nonce is refused.
+func replaySettlement(c *gin.Context, key string, envelope *all712.Envelope) bool {
+	rec, err := state.Ledger().GetByKey(key)
+	if err != nil {
+		return false
+	}
+	if rec.Status == state.StatusQueued || rec.Status == state.StatusPending || rec.Status == state.StatusConfirmed {
+		if err := sameSettlement(rec, envelope); err != nil {
+			failSettle(c, &types.SettleResponse{Network: envelope.PaymentPayload.Network},
+				all712.Errorf(all712.CodeNonceUsed, "the authorization nonce was settled by settlement %s: %w", rec.ID, err))
+			return true
+		}
+	}
+	payer := rec.Payer
+	switch {
+	case rec.Status == state.StatusQueued, rec.Status == state.StatusPending && rec.Batched:
+		// a batch tx on its way says nothing of one settlement in it yet, the ledger will
+		c.Header("X-Settlement-Replay", rec.ID)
+		respondQueued(c, &types.SettleResponse{Network: rec.Network, Payer: &payer}, rec.ID)
+		return true
+	case rec.Status == state.StatusPending, rec.Status == state.StatusConfirmed:
+	default:
+		return false
+	}
+	response := types.SettleResponse{
+		Success:     true,
+		Transaction: rec.TxHash,
+		Network:     all712.WireNetwork(requestVersion(c), rec.Network),
+		Payer:       &payer,
+	}
+	c.Header("X-Settlement-Replay", rec.ID)
+	c.JSON(http.StatusOK, response)
+	return true
+}
+
+// sameSettlement tells whether the envelope is a retry of the recorded settlement: the same payload,
+// signature included, for the same requirements
+func sameSettlement(rec *state.SettlementRecord, envelope *all712.Envelope) error {
+	stored := new(all712.Envelope)
+	if err := json.Unmarshal(rec.Envelope, stored); err != nil || stored.PaymentPayload == nil || stored.PaymentRequirements == nil {
+		return fmt.Errorf("no envelope to compare with")
+	}
+	was, is := stored.PaymentRequirements, envelope.PaymentRequirements
+	switch {
+	case stored.PaymentPayload.Scheme != envelope.PaymentPayload.Scheme:
+		return fmt.Errorf("for scheme %s", stored.PaymentPayload.Scheme)
+	case !strings.EqualFold(was.PayTo, is.PayTo):
+		return fmt.Errorf("paying %s", was.PayTo)
+	case !strings.EqualFold(was.Asset, is.Asset):
+		return fmt.Errorf("in %s", was.Asset)
+	case was.MaxAmountRequired != is.MaxAmountRequired || stored.ConsumedAmount != envelope.ConsumedAmount:
+		return fmt.Errorf("for another amount")
+	case was.Resource != is.Resource:
+		return fmt.Errorf("for %s", was.Resource)
+	}
+	var a, b bytes.Buffer
+	if json.Compact(&a, stored.PaymentPayload.Payload) != nil || json.Compact(&b, envelope.PaymentPayload.Payload) != nil || !bytes.Equal(a.Bytes(), b.Bytes()) {
+		return fmt.Errorf("with another payload")
+	}
+	return nil
+}
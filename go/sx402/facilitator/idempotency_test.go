@@ -0,0 +1,152 @@
+package facilitator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/schemes"
+	"github.com/san-lab/sx402/state"
+)
+
+func testEnvelope(payload string) *all712.Envelope {
+	return &all712.Envelope{
+		X402Version:         1,
+		PaymentPayload:      &all712.PaymentPayload{Network: "base-sepolia", Payload: json.RawMessage(payload)},
+		PaymentRequirements: &types.PaymentRequirements{Asset: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"},
+	}
+}
+
+func TestSettlementKey(t *testing.T) {
+	exact := testEnvelope(`{"signature":"0x","authorization":{"from":"0x857b06519E91e3A54538791bDbb0E22373e36b66","nonce":"0x01"}}`)
+	// same authorization, different casing
+	exact2 := testEnvelope(`{"signature":"0x","authorization":{"from":"0x857b06519e91e3a54538791bdbb0e22373e36b66","nonce":"0x0000000000000000000000000000000000000000000000000000000000000001"}}`)
+	permit := testEnvelope(`{"message":{"owner":"0x857b06519E91e3A54538791bDbb0E22373e36b66"},"nonce":1}`)
+
+	k1, err := settlementKey(exact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, _ := settlementKey(exact2)
+	if k1 != k2 {
+		t.Errorf("keys differ: %s / %s", k1, k2)
+	}
+	// the same nonce of another kind of authorization is another authorization
+	k3, err := settlementKey(permit)
+	if err != nil || k3 == k1 {
+		t.Errorf("permit key %s (%v) same as the 3009 one", k3, err)
+	}
+	path := filepath.Join(t.TempDir(), "schemes.json")
+	os.WriteFile(path, []byte(`[{"scheme":"permit2_EURS","type":"permit2","network":"base-sepolia",
+		"asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e","extra":{"name":"EURS","facilitator":"0xfAc178B1C359D41e9162A1A6385380de96809048"}}]`), 0644)
+	if err := schemes.LoadSchemes(path); err != nil {
+		t.Fatal(err)
+	}
+	permit.PaymentPayload.Scheme = "permit2_EURS"
+	k4, err := settlementKey(permit)
+	if err != nil || k4 == k3 || k4 == k1 {
+		t.Errorf("permit2 key %s (%v) same as another kind's", k4, err)
+	}
+	if _, err := settlementKey(testEnvelope(`{"signature":"0x"}`)); err == nil {
+		t.Error("expected an error for a payload without nonce")
+	}
+}
+
+func TestKeyedMutex(t *testing.T) {
+	km := keyedMutex{locks: map[string]*keyedLock{}}
+	var inside, maxInside int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			km.Lock("k")
+			mu.Lock()
+			inside++
+			maxInside = max(maxInside, inside)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			inside--
+			mu.Unlock()
+			km.Unlock("k")
+		}()
+	}
+	wg.Wait()
+	if maxInside != 1 {
+		t.Error("critical section entered concurrently:", maxInside)
+	}
+	if len(km.locks) != 0 {
+		t.Error("locks leaked:", len(km.locks))
+	}
+}
+
+func TestReplaySettlement(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	state.SetSettlementStore(state.NewMemoryStore())
+
+	original := exactEnvelope("10000", testPayTo, 0, time.Now().Unix()+60)
+	original.PaymentRequirements.Resource = "https://example.com/cheap"
+	replay := func(envelope *all712.Envelope) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		if !replaySettlement(c, "k", envelope) {
+			return nil
+		}
+		return w
+	}
+
+	rec := newSettlement(original)
+	rec.Key = "k"
+	state.Ledger().Save(rec)
+	if replay(original) != nil {
+		t.Error("failed settlements must be retryable")
+	}
+
+	rec.Status = state.StatusPending
+	rec.TxHash = "0x00000000000000000000000000000000000000000000000000000000000000aa"
+	rec.Payer = "0x857b06519E91e3A54538791bDbb0E22373e36b66"
+	state.Ledger().Save(rec)
+	w := replay(original)
+	if w == nil || w.Code != http.StatusOK {
+		t.Fatal("pending settlement not replayed")
+	}
+	response := new(types.SettleResponse)
+	json.Unmarshal(w.Body.Bytes(), response)
+	if !response.Success || response.Transaction != rec.TxHash || *response.Payer != rec.Payer {
+		t.Error("wrong replayed response:", w.Body.String())
+	}
+
+	// the nonce of a settled authorization is public, reusing it for something else gets nothing
+	for name, edit := range map[string]func(e *all712.Envelope){
+		"resource": func(e *all712.Envelope) { e.PaymentRequirements.Resource = "https://example.com/pricey" },
+		"payTo":    func(e *all712.Envelope) { e.PaymentRequirements.PayTo = "0x857b06519E91e3A54538791bDbb0E22373e36b66" },
+		"amount":   func(e *all712.Envelope) { e.PaymentRequirements.MaxAmountRequired = "20000" },
+		"signature": func(e *all712.Envelope) {
+			e.PaymentPayload.Payload = []byte(strings.Replace(string(e.PaymentPayload.Payload), `"0x00"`, `"0x01"`, 1))
+		},
+	} {
+		other := exactEnvelope("10000", testPayTo, 0, time.Now().Unix()+60)
+		other.PaymentPayload.Payload = original.PaymentPayload.Payload
+		other.PaymentRequirements.Resource = original.PaymentRequirements.Resource
+		edit(other)
+		w := replay(other)
+		res := new(settleResult)
+		if w != nil {
+			json.Unmarshal(w.Body.Bytes(), res)
+		}
+		if w == nil || res.Success || res.ErrorReason == nil || *res.ErrorReason != string(all712.CodeNonceUsed) {
+			t.Errorf("other %s replayed: %v", name, w)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package facilitator
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/state"
+)
+
+// Where the settlement ledger is kept; empty keeps it in memory only
+var LedgerFile = "settlements.db"
+
+func openLedger() error {
+	if len(LedgerFile) == 0 {
+		return nil
+	}
+	store, err := state.OpenBoltStore(LedgerFile)
+	if err != nil {
+		return err
+	}
+	state.SetSettlementStore(store)
+	return nil
+}
+
+// newSettlement starts the ledger record of a /settle call
+func newSettlement(envelope *all712.Envelope) *state.SettlementRecord {
+	now := time.Now()
+	rec := &state.SettlementRecord{
+		ID:          state.NewSettlementID(now),
+		SubmittedAt: now,
+		Status:      state.StatusFailed,
+	}
+	rec.Envelope, _ = json.Marshal(envelope)
+	if envelope.PaymentPayload != nil {
+		rec.Network = envelope.PaymentPayload.Network
+		rec.Scheme = envelope.PaymentPayload.Scheme
+	}
+	if envelope.PaymentRequirements != nil {
+		rec.Asset = envelope.PaymentRequirements.Asset
+		rec.PayTo = envelope.PaymentRequirements.PayTo
+		rec.Amount = envelope.PaymentRequirements.MaxAmountRequired
+	}
+	if len(envelope.ConsumedAmount) > 0 {
+		rec.Amount = envelope.ConsumedAmount
+	}
+	return rec
+}
+
+// respondSettle records the outcome of the settlement in the ledger and sends it back
+func respondSettle(c *gin.Context, status int, response *types.SettleResponse) {
+	reason := ""
+	if response.ErrorReason != nil {
+		reason = *response.ErrorReason
+	}
+	recordSettlement(c, response, reason)
+	response.Network = all712.WireNetwork(requestVersion(c), response.Network)
+	c.JSON(status, response)
+}
+
+// recordSettlement updates the ledger record of the /settle call, if there is one
+func recordSettlement(c *gin.Context, response *types.SettleResponse, reason string) {
+	r, ok := c.Get("settlement")
+	if !ok {
+		return
+	}
+	rec := r.(*state.SettlementRecord)
+	if response.Payer != nil {
+		rec.Payer = *response.Payer
+	}
+	rec.Error = reason
+	if response.Success && len(response.Transaction) > 0 {
+		rec.TxHash = response.Transaction
+		rec.Status = state.StatusPending
+	}
+	state.RecordSettlement(rec)
+}
+
+// requestVersion is the x402 version the caller speaks, answers go back in the same one
+func requestVersion(c *gin.Context) int {
+	if e, ok := c.Get("envelope"); ok {
+		return e.(all712.Envelope).X402Version
+	}
+	return 1
+}
+
+// Audit view on the ledger: /settlements?since=<unix seconds>&limit=<n>
+func listSettlements(c *gin.Context) {
+	var since time.Time
+	if s := c.Query("since"); len(s) > 0 {
+		secs, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be a unix timestamp"})
+			return
+		}
+		since = time.Unix(secs, 0)
+	}
+	limit := 100
+	if l := c.Query("limit"); len(l) > 0 {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		limit = n
+	}
+
+	recs, err := state.Ledger().List(since, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, rec := range recs {
+		unsigned(rec)
+	}
+	c.JSON(http.StatusOK, gin.H{"settlements": recs})
+}
+
+// One settlement of the ledger: /settlements/<id>, where deferred settlements get their outcome
+func getSettlement(c *gin.Context) {
+	rec, err := state.Ledger().Get(c.Param("id"))
+	if errors.Is(err, state.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "settlement not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, unsigned(rec))
+}
+
+// unsigned leaves the envelope out of a record going to the (unauthenticated) ledger views,
+// its signed authorization is nobody else's business
+func unsigned(rec *state.SettlementRecord) *state.SettlementRecord {
+	rec.Envelope = nil
+	return rec
+}
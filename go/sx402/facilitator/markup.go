@@ -2,6 +2,7 @@ package facilitator
 
 import (
 	"fmt"
+	"log"
 	"math/big"
 	"net/http"
 
@@ -28,14 +29,24 @@ func getMarkup(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "scheme/network pair not found"})
 		return
 	}
-	markup := new(big.Int)
-
-	if query.DstEid == nil {
-		markup, err = evmbinding.GetMarkup(query.Network, scheme.Asset, keyfile.Address)
-
-	} else {
-		markup, err = evmbinding.GetDetailedMarkup(query.Network, scheme.Asset, *query.DstEid, keyfile.Address)
-
+	// Any wallet of the network may settle, so quote the highest of their markups
+	var markup *big.Int
+	for _, w := range evmbinding.Wallets().For(query.Network) {
+		var m *big.Int
+		if query.DstEid == nil {
+			m, err = evmbinding.GetMarkup(query.Network, scheme.Asset, w.Address().Hex())
+		} else {
+			m, err = evmbinding.GetDetailedMarkup(query.Network, scheme.Asset, *query.DstEid, w.Address().Hex())
+		}
+		if err != nil {
+			break
+		}
+		if markup == nil || m.Cmp(markup) > 0 {
+			markup = m
+		}
+	}
+	if markup == nil && err == nil {
+		err = fmt.Errorf("%w on %s", evmbinding.ErrNoWallet, query.Network)
 	}
 
 	if err != nil {
@@ -52,3 +63,28 @@ func getMarkup(c *gin.Context) {
 		"markup":  markup.String(),
 	})
 }
+
+// walletMarkup is what the token keeps when the wallet sends the settlement: the OFT3009CC markups
+// are keyed by msg.sender. dstEid 0 - the local markup. Lookup errors count as no markup.
+func walletMarkup(network, asset string, dstEid uint32, wallet evmbinding.Signer) *big.Int {
+	var markup *big.Int
+	var err error
+	if dstEid == 0 {
+		markup, err = evmbinding.GetMarkup(network, asset, wallet.Address().Hex())
+	} else {
+		markup, err = evmbinding.GetDetailedMarkup(network, asset, dstEid, wallet.Address().Hex())
+	}
+	if err != nil || markup == nil {
+		log.Println(err)
+		return new(big.Int)
+	}
+	return markup
+}
+
+// markupLeaves accepts the wallets whose markup leaves at least minimum out of amount
+func markupLeaves(network, asset string, dstEid uint32, amount, minimum *big.Int) func(evmbinding.Signer) bool {
+	return func(wallet evmbinding.Signer) bool {
+		markup := walletMarkup(network, asset, dstEid, wallet)
+		return new(big.Int).Sub(amount, markup).Cmp(minimum) >= 0
+	}
+}
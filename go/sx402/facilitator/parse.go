@@ -4,25 +4,34 @@ import (
 	"fmt"
 	"log"
 	"math/big"
-	"net/http"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gin-gonic/gin"
 	"github.com/san-lab/sx402/all712"
 	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/signing"
 )
 
 func ParseEnvelope(c *gin.Context) {
 	log.Println("in middleware")
 	var payload all712.Envelope
 	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		rejectRequest(c, "", all712.Errorf(all712.CodeInvalidPayload, "invalid JSON: %w", err))
 		return
 	}
 
 	if payload.X402Version == 0 {
-		reason := "Empty envelope/nil Version"
-		c.JSON(http.StatusBadRequest, gin.H{"error": reason})
+		rejectRequest(c, "", all712.Errorf(all712.CodeInvalidVersion, "empty envelope/nil version"))
+		return
+	}
+	if payload.X402Version > 2 {
+		rejectRequest(c, "", all712.Errorf(all712.CodeInvalidVersion, "unsupported x402 version: %v", payload.X402Version))
+		return
+	}
+	if payload.PaymentPayload == nil || payload.PaymentRequirements == nil {
+		rejectRequest(c, "", all712.Errorf(all712.CodeInvalidPayload, "missing paymentPayload or paymentRequirements"))
+		return
 	}
 	c.Set("envelope", payload)
 	c.Next()
@@ -30,28 +39,35 @@ func ParseEnvelope(c *gin.Context) {
 func SetupClient(c *gin.Context) {
 	enlp, exists := c.Get("envelope")
 	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Envelope not found"})
+		rejectRequest(c, "", all712.Errorf(all712.CodeUnexpected, "envelope not found"))
 		return
 	}
 	payload := enlp.(all712.Envelope)
 
 	network := payload.PaymentPayload.Network
 	if len(network) == 0 {
-		reason := "No network specified"
-		c.JSON(http.StatusBadRequest, gin.H{"error": reason})
+		rejectRequest(c, network, all712.Errorf(all712.CodeInvalidNetwork, "no network specified"))
 		return
 	}
 
 	client, err := evmbinding.GetClientByNetwork(network)
 	if err != nil {
-		reason := fmt.Sprintf("could not connect to rpc: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": reason})
+		rejectRequest(c, network, all712.WithCode(all712.CodeRPCUnavailable, fmt.Errorf("could not connect to rpc: %w", err)))
 		return
 	}
 	c.Set("client", client)
 	c.Next()
 }
 
+// contextClient is the rpc client SetupClient put in the context
+func contextClient(c *gin.Context) (*ethclient.Client, error) {
+	clnt, exists := c.Get("client")
+	if !exists {
+		return nil, all712.Errorf(all712.CodeUnexpected, "no client from middleware")
+	}
+	return clnt.(*ethclient.Client), nil
+}
+
 type ParsedData struct {
 	Amount      *big.Int
 	Markup      *big.Int
@@ -63,4 +79,7 @@ type ParsedData struct {
 	ValidBefore *big.Int
 	signature   []byte
 	nonce       [32]byte
+	deploy      *evmbinding.Call // the EIP-6492 deployment of the payer's wallet, to go before the settlement
+
+	SignatureMethod signing.SignatureMethod
 }
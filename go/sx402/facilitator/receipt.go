@@ -24,7 +24,7 @@ func HandlerReceiptStatus(c *gin.Context) {
 
 	hash := common.HexToHash(tx)
 
-	pr, ok := state.GetPendingReceipt(hash, network) //rt.receipts.Load(key)
+	rec, ok := state.GetSettlement(hash, network)
 	if !ok {
 		c.JSON(http.StatusOK, gin.H{
 			"status": "not_found",
@@ -32,27 +32,35 @@ func HandlerReceiptStatus(c *gin.Context) {
 		return
 	}
 
-	await := time.Since(pr.SubmittedAt).Seconds()
-
-	if pr.Receipt == nil {
+	switch rec.Status {
+	case state.StatusPending:
 		c.JSON(http.StatusOK, gin.H{
 			"status":     "pending",
-			"await_time": await,
+			"await_time": time.Since(rec.SubmittedAt).Seconds(),
+		})
+	case state.StatusConfirmed, state.StatusReverted:
+		c.JSON(http.StatusOK, gin.H{
+			"status":      "found",
+			"reverted":    rec.Status == state.StatusReverted,
+			"settle_time": fmt.Sprintf("%v sec", rec.TimeToSettle.Seconds()),
+			"receipt":     rec.Receipt, // Gin uses JSON tags from the receipt struct
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"status": rec.Status,
+			"error":  rec.Error,
 		})
-		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"status":      "found",
-		"settle_time": fmt.Sprintf("%v sec", pr.TimeToSettle.Seconds()),
-		"receipt":     pr.Receipt, // Gin uses JSON tags from the receipt struct
-	})
 }
 
 type RecDisplayData struct {
 	Network    string
 	Tx         string
 	Submitted  string
+	Scheme     string
+	Payer      string
+	PayTo      string
+	Amount     string
 	Error      string
 	Status     string
 	SettleTime string
@@ -75,16 +83,23 @@ func prettyReceiptPage(c *gin.Context) {
 	} else {
 		hash := common.HexToHash(tx)
 
-		pr, ok := state.GetPendingReceipt(hash, network) //rt.receipts.Load(key)
+		rec, ok := state.GetSettlement(hash, network)
 		if !ok {
 			recdata.Status = "not_found"
 
 		} else {
-			rec, _ := json.MarshalIndent(pr.Receipt, " ", " ")
-			recdata.Submitted = fmt.Sprintf("%s", pr.SubmittedAt.Unix())
-			recdata.Status = "found"
-			recdata.SettleTime = fmt.Sprintf("%v sec", pr.TimeToSettle.Seconds())
-			recdata.Receipt = string(rec)
+			recdata.Submitted = rec.SubmittedAt.Format(time.RFC3339)
+			recdata.Scheme = rec.Scheme
+			recdata.Payer = rec.Payer
+			recdata.PayTo = rec.PayTo
+			recdata.Amount = rec.Amount
+			recdata.Error = rec.Error
+			recdata.Status = string(rec.Status)
+			if rec.Receipt != nil {
+				receipt, _ := json.MarshalIndent(rec.Receipt, " ", " ")
+				recdata.SettleTime = fmt.Sprintf("%v sec", rec.TimeToSettle.Seconds())
+				recdata.Receipt = string(receipt)
+			}
 		}
 
 	}
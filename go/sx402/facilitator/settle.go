@@ -1,8 +1,6 @@
 package facilitator
 
 import (
-	"context"
-	"crypto/ecdsa"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -13,103 +11,74 @@ import (
 	"log"
 
 	"github.com/coinbase/x402/go/pkg/types"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gin-gonic/gin"
-	kms "github.com/proveniencenft/kmsclitool/common"
 	"github.com/san-lab/sx402/all712"
 	"github.com/san-lab/sx402/evmbinding"
-	"github.com/san-lab/sx402/oft"
 	"github.com/san-lab/sx402/schemes"
-	"github.com/san-lab/sx402/state"
 )
 
-var keyfile_name = "facilitator.json"
-
-func InitKeys(password []byte) error {
-	kf, err := kms.ReadKeyfile(keyfile_name)
-	if err != nil || kf == nil {
-
-		return fmt.Errorf("Error loading keyfile %s: %w", keyfile_name, err)
-	}
-	err = kf.Decrypt(password)
-	if err != nil {
-		log.Fatal("Error decrypting keyfile", keyfile_name, err)
-	}
-	fpk, err = crypto.ToECDSA(kf.Plaintext)
-	if err != nil {
-		return err
-	}
-	fmt.Println(crypto.PubkeyToAddress(fpk.PublicKey))
-	keyfile = kf
-	return nil
-}
-
-var keyfile *kms.Keyfile
-var fpk *ecdsa.PrivateKey
-
 func SettleHandler(c *gin.Context) {
-	if fpk == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Facilitator not properly initialized"})
-		c.Abort()
+	if len(evmbinding.Wallets().All()) == 0 {
+		failSettle(c, &types.SettleResponse{}, all712.Errorf(all712.CodeFacilitatorUnavailable, "facilitator not properly initialized"))
 		return
 	}
 
 	enlp, exists := c.Get("envelope")
 	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Envelope not found"})
+		failSettle(c, &types.SettleResponse{}, all712.Errorf(all712.CodeUnexpected, "envelope not found"))
 		return
 	}
 	envelope := enlp.(all712.Envelope)
 
-	switch envelope.PaymentPayload.Scheme {
-	case schemes.Scheme_Exact_EURC, schemes.Scheme_Exact_USDC, schemes.Scheme_Exact_EURS, schemes.Scheme_Exact_Draft:
-		SettleExactScheme(c, &envelope)
-	case schemes.Scheme_Permit_USDC:
-		SettlePermitScheme(c, &envelope)
-	case schemes.Scheme_Payer0_toArbitrum, schemes.Scheme_Payer0_toBase, schemes.Scheme_Payer0M_toBase:
-		SettlePayerZero(c, &envelope)
-	case schemes.Scheme_Payer0Plus_toBase, schemes.Scheme_Payer0Plus_toArbitrum:
-		SettleCrossChainScheme(c, &envelope)
-	default:
-		response := types.SettleResponse{}
-		response.Network = envelope.PaymentPayload.Network
-		response.Payer = &envelope.PaymentRequirements.PayTo
-		response.Success = false
-		reason := "Unsupported Scheme: " + envelope.PaymentPayload.Scheme
-		response.ErrorReason = &reason
-		c.JSON(http.StatusOK, response)
-		c.Abort()
+	// Settle every authorization at most once; retries get the original answer
+	key, err := settlementKey(&envelope)
+	if err != nil {
+		log.Println("no idempotency key for the settlement:", err)
+	} else {
+		settleLocks.Lock(key)
+		defer settleLocks.Unlock(key)
+		if replaySettlement(c, key, &envelope) {
+			log.Println("replayed settlement of", key)
+			return
+		}
+	}
+	settlement := newSettlement(&envelope)
+	settlement.Key = key
+	c.Set("settlement", settlement)
+
+	handler, err := handlerFor(&envelope)
+	if err != nil {
+		failSettle(c, &types.SettleResponse{Network: envelope.PaymentPayload.Network}, err)
 		return
 	}
+	// only metered schemes settle for less than the requirements say
+	if len(envelope.ConsumedAmount) > 0 {
+		if scheme, _ := schemes.GetScheme(envelope.PaymentPayload.Scheme, envelope.PaymentPayload.Network); scheme == nil || scheme.Type != schemes.UptoType {
+			failSettle(c, &types.SettleResponse{Network: envelope.PaymentPayload.Network},
+				all712.Errorf(all712.CodeInvalidPayload, "consumedAmount is for upto schemes only, not %s", envelope.PaymentPayload.Scheme))
+			return
+		}
+	}
+	handler.Settle(c, &envelope)
 }
 
 func SettleExactScheme(c *gin.Context, envelope *all712.Envelope) {
 
-	response := types.SettleResponse{}
+	network := envelope.PaymentPayload.Network
+	response := types.SettleResponse{Network: network}
 
 	exactPayload := new(types.ExactEvmPayload)
 	err := json.Unmarshal(envelope.PaymentPayload.Payload, exactPayload)
 	if err != nil {
-		response.Success = false
-		reason := fmt.Sprintf("when setting: rror unmarshalling the exact payload (%s)", err)
-		response.ErrorReason = &reason
-		c.JSON(http.StatusBadRequest, response)
+		failSettle(c, &response, all712.Errorf(all712.CodeInvalidPayload, "error unmarshalling the exact payload: %w", err))
 		return
 	}
-
-	clnt, exists := c.Get("client")
-	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No Client from middleware"})
+	if exactPayload.Authorization == nil {
+		failSettle(c, &response, all712.Errorf(all712.CodeInvalidPayload, "no authorization in the exact payload"))
 		return
 	}
 
-	client := clnt.(*ethclient.Client)
-
-	response.Network = envelope.PaymentPayload.Network
-
 	var from, to, tokenAddress common.Address
 	var value, validAfter, validBefore *big.Int
 
@@ -123,25 +92,21 @@ func SettleExactScheme(c *gin.Context, envelope *all712.Envelope) {
 	// Convert value
 	value, ok := new(big.Int).SetString(exactPayload.Authorization.Value, 10)
 	if !ok {
-		reason := "error Invalid value format"
-		response.ErrorReason = &reason
-		c.JSON(http.StatusBadRequest, response)
+		failSettle(c, &response, all712.Errorf(all712.CodeInvalidPayload, "invalid value format"))
 		return
 	}
 
 	// Convert validAfter / validBefore
 	validAfter, ok = new(big.Int).SetString(exactPayload.Authorization.ValidAfter, 10)
 	if !ok {
-		reason := "error Invalid ValidAfter format"
-		response.ErrorReason = &reason
-		c.JSON(http.StatusBadRequest, response)
+		failSettle(c, &response, all712.Errorf(all712.CodeInvalidPayload, "invalid ValidAfter format"))
+		return
 	}
 
 	validBefore, ok = new(big.Int).SetString(exactPayload.Authorization.ValidBefore, 10)
 	if !ok {
-		reason := "error Invalid ValidBefore format"
-		response.ErrorReason = &reason
-		c.JSON(http.StatusBadRequest, response)
+		failSettle(c, &response, all712.Errorf(all712.CodeInvalidPayload, "invalid ValidBefore format"))
+		return
 	}
 
 	// Convert nonce (hex string to [32]byte)
@@ -159,176 +124,229 @@ func SettleExactScheme(c *gin.Context, envelope *all712.Envelope) {
 
 	// Convert r, s (hex strings to []byte)
 	sig, err := hex.DecodeString(strings.TrimPrefix(exactPayload.Signature, "0x"))
-	if err != nil || len(r) != 32 {
-		reason := "error: Invalid signature format"
-		response.ErrorReason = &reason
-		c.JSON(http.StatusBadRequest, response)
+	if err != nil || len(sig) == 0 {
+		failSettle(c, &response, all712.Errorf(all712.CodeInvalidSignature, "invalid signature format"))
 		return
 	}
-	copy(r[:], sig[:32])
-	copy(s[:], sig[32:64])
-	v = sig[64]
-	if v < 27 {
-		v += 27
-	}
 
-	h, err := evmbinding.TransferWithAuthorization(client, fpk, tokenAddress, from, to, value, validAfter, validBefore, nonce, r, s, v)
+	payer := from.Hex()
+	response.Payer = &payer
 
+	// an EIP-6492 signature of a wallet yet to be deployed: deploy it, then pass on the signature it takes
+	deploy, sig, err := counterfactual(network, from, sig)
 	if err != nil {
-		log.Println("error executing settlement", err)
-		reason := fmt.Sprintf("Error parsing ABI: %s", err.Error())
-		response.ErrorReason = &reason
-		c.JSON(http.StatusOK, response)
-		//c.Abort()
+		failSettle(c, &response, err)
 		return
 	}
 
-	state.GetReceiptCollector().Submit(*h, envelope.PaymentPayload.Network)
+	var call evmbinding.Call
+	if len(sig) == 65 {
+		copy(r[:], sig[:32])
+		copy(s[:], sig[32:64])
+		v = sig[64]
+		if v < 27 {
+			v += 27
+		}
+		call, err = evmbinding.TransferWithAuthorizationCall(tokenAddress, from, to, value, validAfter, validBefore, nonce, r, s, v)
+	} else {
+		// a wallet contract signature (ERC-1271), the token checks it with isValidSignature
+		call, err = evmbinding.TransferWithAuthorizationBytesCall(tokenAddress, from, to, value, validAfter, validBefore, nonce, sig)
+	}
+	if err != nil {
+		failSettle(c, &response, err)
+		return
+	}
+	// a wallet yet to be deployed goes on its own, the others may wait for a batch
+	if BatchWindow > 0 && deploy == nil {
+		settleBatched(c, envelope, &response, call)
+		return
+	}
+	wallet, err := evmbinding.Wallets().Pick(network, gasBudget(envelope), nil)
+	if err != nil {
+		failSettle(c, &response, err)
+		return
+	}
+	if SimulateSettle {
+		if err := simulateSettlement(network, wallet, withDeploy(deploy, call)...); err != nil {
+			failSettle(c, &response, err)
+			return
+		}
+	}
+
+	h, err := sendSettlement(envelope, wallet, deploy, call)
+	if err != nil {
+		failSettle(c, &response, fmt.Errorf("error sending: %w", err))
+		return
+	}
 
 	response.Success = true
 	response.Transaction = h.Hex()
-	payer := from.Hex()
-	response.Payer = &payer
-	c.JSON(http.StatusOK, response)
+	respondSettle(c, http.StatusOK, &response)
 
 }
 
 func SettlePermitScheme(c *gin.Context, envelope *all712.Envelope) {
 	//reuse the exact one for now
-	response := types.SettleResponse{}
+	network := envelope.PaymentPayload.Network
+	response := types.SettleResponse{Network: network}
 	permit := new(all712.PermitMessage)
 	err := json.Unmarshal(envelope.PaymentPayload.Payload, permit)
 	if err != nil {
-		response.Success = false
-		reason := fmt.Sprintf("when setting: rror unmarshalling the permit (%s)", err)
-		response.ErrorReason = &reason
-		c.JSON(http.StatusBadRequest, response)
+		failSettle(c, &response, all712.Errorf(all712.CodeInvalidPayload, "error unmarshalling the permit: %w", err))
+		return
+	}
+
+	owner := permit.Message.Owner.Hex()
+	response.Payer = &owner
+
+	// Only the spender of the permit can pull the funds
+	wallet, ok := evmbinding.Wallets().Find(network, permit.Message.Spender)
+	if !ok {
+		failSettle(c, &response, all712.Errorf(all712.CodeRecipientMismatch, "the permit spender %s is not a facilitator wallet on %s", permit.Message.Spender, network))
 		return
 	}
 
-	_, err = evmbinding.EnactPermit(permit, fpk)
+	if SimulateSettle {
+		calls, err := permitCalls(envelope, permit)
+		if err != nil {
+			failSettle(c, &response, all712.WithCode(all712.CodeInvalidPayload, err))
+			return
+		}
+		if err := simulateSettlement(network, wallet, calls...); err != nil {
+			failSettle(c, &response, err)
+			return
+		}
+	}
+
+	// the permit and the transferFrom are two txs sharing the budget of the settlement
+	budget := shareOf(gasBudget(envelope), 2)
+	_, err = evmbinding.EnactPermit(permit, wallet, budget)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error enacting permit": err})
+		failSettle(c, &response, fmt.Errorf("error enacting permit: %w", err))
 		return
 	}
 
 	h, err := evmbinding.TransferFrom(permit.Message.Owner, common.HexToAddress(envelope.PaymentRequirements.PayTo),
-		permit.Domain.VerifyingContract, permit.Message.Value, permit.Domain.ChainID, fpk)
+		permit.Domain.VerifyingContract, permit.Message.Value, permit.Domain.ChainID, wallet, budget)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error in transferFrom()": err})
+		failSettle(c, &response, fmt.Errorf("error in transferFrom(): %w", err))
 		return
 	}
-	state.GetReceiptCollector().Submit(*h, envelope.PaymentPayload.Network)
-	spender := permit.Message.Spender.Hex()
 	response.Success = true
 	response.Transaction = h.Hex()
-	response.Network = envelope.PaymentRequirements.Network
-	response.Payer = &spender
-	c.JSON(http.StatusOK, response)
+	respondSettle(c, http.StatusOK, &response)
 
 }
 
-func SettlePayerZero(c *gin.Context, envelope *all712.Envelope) {
-
-	response := types.SettleResponse{}
+func SettlePermit2Scheme(c *gin.Context, envelope *all712.Envelope) {
+	settlePermit2(c, envelope, false)
+}
 
-	clnt, exists := c.Get("client")
-	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No Client from middleware"})
+// settlePermit2 transfers the permitted amount, or for metered (upto) payments what the resource server reports consumed
+func settlePermit2(c *gin.Context, envelope *all712.Envelope, metered bool) {
+	network := envelope.PaymentPayload.Network
+	response := types.SettleResponse{Network: network}
+	p2 := new(all712.Permit2Message)
+	err := json.Unmarshal(envelope.PaymentPayload.Payload, p2)
+	if err != nil {
+		failSettle(c, &response, all712.Errorf(all712.CodeInvalidPayload, "error unmarshalling the Permit2 transfer: %w", err))
 		return
 	}
+	owner := p2.Message.Owner.Hex()
+	response.Payer = &owner
 
-	pd, err := FormallyVerifyPayer0Envelope(envelope)
-	if err != nil {
-		reason := fmt.Sprintf("error Invalid format: %v", err)
-		response.ErrorReason = &reason
-		c.JSON(http.StatusBadRequest, response)
+	// Permit2 only takes the transfer from the spender, and it pays payTo as the witness says
+	wallet, ok := evmbinding.Wallets().Find(network, p2.Message.Spender)
+	if !ok {
+		failSettle(c, &response, all712.Errorf(all712.CodeRecipientMismatch, "the Permit2 spender %s is not a facilitator wallet on %s", p2.Message.Spender, network))
 		return
 	}
-
-	client := clnt.(*ethclient.Client)
-
-	markup, insignificant_err := evmbinding.GetMarkup(envelope.PaymentPayload.Network, envelope.PaymentRequirements.Asset, keyfile.Address)
-	if insignificant_err != nil {
-		log.Println(insignificant_err)
+	if p2.Message.Witness.PayTo != common.HexToAddress(envelope.PaymentRequirements.PayTo) {
+		failSettle(c, &response, all712.Errorf(all712.CodeRecipientMismatch, "witness payTo %s is not %s", p2.Message.Witness.PayTo, envelope.PaymentRequirements.PayTo))
+		return
+	}
+	if p2.Message.Amount == nil || p2.Message.Deadline == nil || p2.Nonce == nil {
+		failSettle(c, &response, all712.Errorf(all712.CodeInvalidPayload, "nil amount, deadline or nonce in the Permit2 message"))
+		return
 	}
 
-	response.Network = envelope.PaymentPayload.Network
-	payto, err := hex.DecodeString(envelope.PaymentRequirements.PayTo[2:])
+	amount := p2.Message.Amount
+	if metered {
+		if amount, err = consumedAmount(envelope, p2.Message.Amount); err != nil {
+			failSettle(c, &response, err)
+			return
+		}
+	}
+	deploy, call, err := permit2Call(network, p2, amount)
 	if err != nil {
-		log.Fatalf("Error decoding payTo. This cannot happen: %v", err)
-		c.JSON(http.StatusInternalServerError, "internal kms error")
+		failSettle(c, &response, err)
 		return
 	}
-	sendParam := new(oft.SendParam)
-	sendParam.AmountLD = pd.Amount
-	sendParam.MinAmountLD = big.NewInt(0).Sub(pd.Amount, markup)
-	sendParam.ExtraOptions = []byte{0, 3}
-	sendParam.To = [32]byte(common.LeftPadBytes(payto, 32))
-	sendParam.DstEid = pd.DstEid
-	sendParam.ComposeMsg = []byte{}
-	sendParam.OftCmd = []byte{}
+	if SimulateSettle {
+		if err := simulateSettlement(network, wallet, withDeploy(deploy, call)...); err != nil {
+			failSettle(c, &response, err)
+			return
+		}
+	}
 
-	p0token, err := oft.NewOft(pd.Asset, client)
+	h, err := sendSettlement(envelope, wallet, deploy, call)
 	if err != nil {
-		reason := fmt.Sprintf("error binding token contract: %v", err)
-		response.ErrorReason = &reason
-		c.JSON(http.StatusBadRequest, response)
+		failSettle(c, &response, fmt.Errorf("error sending: %w", err))
 		return
 	}
+	response.Success = true
+	response.Transaction = h.Hex()
+	respondSettle(c, http.StatusOK, &response)
+}
 
-	// Create a new CallOpts (read-only call)
-	callOpts := &bind.CallOpts{
-		Context: context.Background(),
-	}
+func SettlePayerZero(c *gin.Context, envelope *all712.Envelope) {
 
-	// Set the _payInLzToken parameter (true or false as required).
-	payInLzToken := false
+	response := types.SettleResponse{Network: envelope.PaymentPayload.Network}
 
-	// Call quoteSend on the contract.
-	messagingFee, err := p0token.QuoteSend(callOpts, *sendParam, payInLzToken)
+	client, err := contextClient(c)
 	if err != nil {
-		reason := fmt.Sprintf("error quoting send price: %v", err)
-		response.ErrorReason = &reason
-		c.JSON(http.StatusOK, response) //is it OK?
+		failSettle(c, &response, err)
 		return
 	}
 
-	auth, err := bind.NewKeyedTransactorWithChainID(fpk, pd.chainID)
+	pd, err := FormallyVerifyPayer0Envelope(envelope)
 	if err != nil {
-		log.Fatalf("Failed to create TransactOpts: %v", err)
-		c.JSON(http.StatusInternalServerError, "internal kms error")
+		failSettle(c, &response, err)
 		return
 	}
 
-	gasPrice, err := client.SuggestGasPrice(context.Background())
+	payer := pd.Payer.Hex()
+	response.Payer = &payer
+
+	// Only wallets whose markup the authorized amount covers may send it
+	network := envelope.PaymentPayload.Network
+	wallet, err := evmbinding.Wallets().Pick(network, gasBudget(envelope),
+		markupLeaves(network, envelope.PaymentRequirements.Asset, 0, pd.Amount, new(big.Int)))
 	if err != nil {
-		reason := fmt.Sprintf("error getting price suggestion: %v", err)
-		response.ErrorReason = &reason
-		c.JSON(http.StatusInternalServerError, response)
+		failSettle(c, &response, err)
 		return
 	}
 
-	// Optionally customize gas
-	auth.GasLimit = uint64(1000000)                           // set if needed
-	auth.GasPrice = gasPrice.Add(gasPrice, big.NewInt(30000)) //
-	auth.Value = messagingFee.NativeFee                       //.Mul(messagingFee.NativeFee, big.NewInt(2))
-
-	txh, err := p0token.SendWithAuthorization(auth, *sendParam, messagingFee, pd.Payer,
-		pd.ValidAfter, pd.ValidBefore, pd.nonce, pd.signature, common.HexToAddress(keyfile.Address))
+	call, err := payer0Call(client, envelope, pd, wallet)
 	if err != nil {
-		reason := fmt.Sprintf("error sending: %v", err)
-		response.ErrorReason = &reason
-		c.JSON(http.StatusOK, response) //is it OK?
+		failSettle(c, &response, err)
 		return
+	}
+	if SimulateSettle {
+		if err := simulateSettlement(network, wallet, withDeploy(pd.deploy, call)...); err != nil {
+			failSettle(c, &response, err)
+			return
+		}
+	}
 
+	txh, err := sendSettlement(envelope, wallet, pd.deploy, call)
+	if err != nil {
+		failSettle(c, &response, fmt.Errorf("error sending: %w", err))
+		return
 	}
-	fmt.Printf("transaction hash: %s", txh.Hash().Hex())
-	state.GetReceiptCollector().Submit(txh.Hash(), envelope.PaymentPayload.Network)
 
 	response.Success = true
-	response.Transaction = txh.Hash().Hex()
-	response.Payer = &envelope.PaymentRequirements.PayTo
-	c.JSON(http.StatusOK, response)
+	response.Transaction = txh.Hex()
+	respondSettle(c, http.StatusOK, &response)
 
 }
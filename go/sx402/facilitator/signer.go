@@ -0,0 +1,121 @@
+package facilitator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/schemes"
+)
+
+var keyfile_name = "facilitator.json"
+
+// Instead of the keyfile the facilitator account may come from a keystore directory or a remote signer
+var (
+	KeystoreDir      string
+	RemoteSignerURL  string
+	RemoteSignerType = evmbinding.Web3Signer
+	SignerAddress    string // which account of the keystore/remote signer; empty - the first/only one
+)
+
+// WalletsFile lists several facilitator wallets and their networks; it replaces the single account above.
+// Every entry takes one source: keyfile, keystore (with an optional address) or remote (with type and address).
+//
+//	[ {"keyfile": "facilitator.json"},
+//	  {"keystore": "keys", "address": "0x...", "networks": ["base-sepolia"]},
+//	  {"remote": "http://localhost:9000", "type": "web3signer", "address": "0x...", "networks": ["arbitrum-sepolia"]} ]
+var WalletsFile string
+
+type walletConfig struct {
+	Keyfile  string   `json:"keyfile,omitempty"`
+	Keystore string   `json:"keystore,omitempty"`
+	Remote   string   `json:"remote,omitempty"`
+	Type     string   `json:"type,omitempty"`
+	Address  string   `json:"address,omitempty"`
+	Networks []string `json:"networks,omitempty"` // empty - all networks
+}
+
+func InitKeys(password []byte) error {
+	configs := []walletConfig{{Keyfile: keyfile_name, Keystore: KeystoreDir, Remote: RemoteSignerURL, Type: RemoteSignerType, Address: SignerAddress}}
+	if len(WalletsFile) > 0 {
+		data, err := os.ReadFile(WalletsFile)
+		if err != nil {
+			return err
+		}
+		configs = nil
+		if err = json.Unmarshal(data, &configs); err != nil {
+			return fmt.Errorf("error parsing %s: %w", WalletsFile, err)
+		}
+		if len(configs) == 0 {
+			return fmt.Errorf("no wallets in %s", WalletsFile)
+		}
+	}
+	for _, cfg := range configs {
+		signer, err := openWallet(cfg, password)
+		if err != nil {
+			return err
+		}
+		evmbinding.Wallets().Add(signer, cfg.Networks...)
+		log.Println("facilitator wallet", signer.Address(), "networks:", cfg.Networks)
+	}
+	return nil
+}
+
+func openWallet(cfg walletConfig, password []byte) (evmbinding.Signer, error) {
+	switch {
+	case len(cfg.Remote) > 0:
+		return evmbinding.NewRemoteSigner(cfg.Remote, cfg.Type, cfg.Address)
+	case len(cfg.Keystore) > 0:
+		return keystoreSigner(cfg.Keystore, string(password), cfg.Address)
+	case len(cfg.Keyfile) > 0:
+		return evmbinding.LoadKeyfile(cfg.Keyfile, password)
+	}
+	return nil, fmt.Errorf("wallet without a keyfile, keystore or remote signer")
+}
+
+func keystoreSigner(dir, password, address string) (evmbinding.Signer, error) {
+	signers, err := evmbinding.OpenKeystore(dir, password)
+	if err != nil {
+		return nil, err
+	}
+	if len(address) == 0 {
+		return signers[0], nil
+	}
+	for _, s := range signers {
+		if strings.EqualFold(s.Address().Hex(), address) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("no account %s in the keystore %s", address, dir)
+}
+
+// firstWallet is an account of the network accept lets through (nil - any), for the checks
+// in /verify; which one really settles is decided by the pool at /settle
+func firstWallet(network string, accept func(evmbinding.Signer) bool) (evmbinding.Signer, error) {
+	for _, w := range evmbinding.Wallets().For(network) {
+		if accept == nil || accept(w) {
+			return w, nil
+		}
+	}
+	return nil, fmt.Errorf("%w on %s", evmbinding.ErrNoWallet, network)
+}
+
+// checkPinnedSpenders warns about permit-style schemes whose extra.facilitator is not a wallet of the pool.
+// The payer signs that one address as the spender, so these schemes do not spread over the pool:
+// they settle from that wallet only, and stop settling when it is drained or removed.
+func checkPinnedSpenders() {
+	for _, s := range schemes.All() {
+		if s.Extra == nil || len((*s.Extra)["facilitator"]) == 0 {
+			continue
+		}
+		spender := common.HexToAddress((*s.Extra)["facilitator"])
+		if _, ok := evmbinding.Wallets().Find(s.Network, spender); !ok {
+			log.Printf("⚠️ scheme %s on %s is pinned to spender %s, which is not a facilitator wallet there; it cannot settle",
+				s.SchemeName, s.Network, spender.Hex())
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package facilitator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/oft"
+	"github.com/san-lab/sx402/oftcc"
+)
+
+// /verify always simulates the settlement; with SimulateSettle /settle does it too, right before broadcasting
+var SimulateSettle bool
+
+const simulationTimeout = 10 * time.Second
+
+func init() {
+	// The custom errors of our OFTs (and the ERC20 ones they inherit) in revert reasons
+	for _, md := range []*bind.MetaData{oft.OftMetaData, oftcc.OftccMetaData} {
+		if parsed, err := md.GetAbi(); err == nil {
+			evmbinding.RegisterErrors(parsed)
+		}
+	}
+}
+
+// simulateSettlement runs the settlement calls from the facilitator wallet.
+// It returns nil if they go through and why not otherwise.
+func simulateSettlement(network string, wallet evmbinding.Signer, calls ...evmbinding.Call) error {
+	ctx, cancel := context.WithTimeout(context.Background(), simulationTimeout)
+	defer cancel()
+	err := evmbinding.Simulate(ctx, network, wallet.Address(), calls...)
+	if err == nil {
+		return nil
+	}
+	var revert *evmbinding.RevertError
+	if errors.As(err, &revert) {
+		log.Printf("settlement on %s would revert: %s", network, revert.Reason)
+		return all712.Errorf(all712.CodeSettlementReverts, "settlement would revert: %s", revert.Reason)
+	}
+	return all712.WithCode(all712.CodeRPCUnavailable, fmt.Errorf("unable to simulate the settlement: %w", err))
+}
+
+func splitSignature(sig []byte) (r, s [32]byte, v byte, err error) {
+	if len(sig) != 65 {
+		err = fmt.Errorf("invalid signature length: %v", len(sig))
+		return
+	}
+	copy(r[:], sig[:32])
+	copy(s[:], sig[32:64])
+	v = sig[64]
+	if v < 27 {
+		v += 27
+	}
+	return
+}
+
+// exactCall is the transferWithAuthorization of a verified exact payload.
+// Signatures of wallet contracts that are not 65 bytes go with the bytes overload.
+func exactCall(envelope *all712.Envelope, pd ParsedData) (evmbinding.Call, error) {
+	if len(pd.signature) != 65 {
+		return evmbinding.TransferWithAuthorizationBytesCall(pd.Asset, pd.Payer, common.HexToAddress(envelope.PaymentRequirements.PayTo),
+			pd.Amount, pd.ValidAfter, pd.ValidBefore, pd.nonce, pd.signature)
+	}
+	r, s, v, err := splitSignature(pd.signature)
+	if err != nil {
+		return evmbinding.Call{}, err
+	}
+	return evmbinding.TransferWithAuthorizationCall(pd.Asset, pd.Payer, common.HexToAddress(envelope.PaymentRequirements.PayTo),
+		pd.Amount, pd.ValidAfter, pd.ValidBefore, pd.nonce, r, s, v)
+}
+
+// permitCalls are the permit and the transferFrom to payTo that follows it
+func permitCalls(envelope *all712.Envelope, permit *all712.PermitMessage) ([]evmbinding.Call, error) {
+	enact, err := evmbinding.PermitCall(permit)
+	if err != nil {
+		return nil, err
+	}
+	transfer, err := evmbinding.TransferFromCall(permit.Message.Owner, common.HexToAddress(envelope.PaymentRequirements.PayTo),
+		permit.Domain.VerifyingContract, permit.Message.Value)
+	if err != nil {
+		return nil, err
+	}
+	return []evmbinding.Call{enact, transfer}, nil
+}
+
+// payer0Call is the sendWithAuthorization of a payer0 (legacy) payload sent by the wallet,
+// with the LayerZero fee quoted now. Leftover native fee goes back to the wallet.
+func payer0Call(client *ethclient.Client, envelope *all712.Envelope, pd ParsedData, wallet evmbinding.Signer) (evmbinding.Call, error) {
+	markup := walletMarkup(envelope.PaymentPayload.Network, envelope.PaymentRequirements.Asset, 0, wallet)
+
+	payto := common.HexToAddress(envelope.PaymentRequirements.PayTo)
+	sendParam := new(oft.SendParam)
+	sendParam.AmountLD = pd.Amount
+	sendParam.MinAmountLD = big.NewInt(0).Sub(pd.Amount, markup)
+	sendParam.ExtraOptions = []byte{0, 3}
+	sendParam.To = [32]byte(common.LeftPadBytes(payto.Bytes(), 32))
+	sendParam.DstEid = pd.DstEid
+	sendParam.ComposeMsg = []byte{}
+	sendParam.OftCmd = []byte{}
+
+	p0token, err := oft.NewOft(pd.Asset, client)
+	if err != nil {
+		return evmbinding.Call{}, fmt.Errorf("error binding token contract: %w", err)
+	}
+
+	// Set the _payInLzToken parameter (true or false as required).
+	payInLzToken := false
+
+	messagingFee, err := p0token.QuoteSend(&bind.CallOpts{Context: context.Background()}, *sendParam, payInLzToken)
+	if err != nil {
+		return evmbinding.Call{}, fmt.Errorf("error quoting send price: %w", err)
+	}
+
+	oftABI, err := oft.OftMetaData.GetAbi()
+	if err != nil {
+		return evmbinding.Call{}, err
+	}
+	data, err := oftABI.Pack("sendWithAuthorization", *sendParam, messagingFee, pd.Payer,
+		pd.ValidAfter, pd.ValidBefore, pd.nonce, pd.signature, wallet.Address())
+	if err != nil {
+		return evmbinding.Call{}, fmt.Errorf("error packing the call: %w", err)
+	}
+	// The native fee pays for the LayerZero message
+	return evmbinding.Call{To: pd.Asset, Value: messagingFee.NativeFee, Data: data}, nil
+}
+
+// crossChainCall is the sendWithCCAuthorization of a PZ payload sent by the wallet, with the LayerZero fee quoted now
+func crossChainCall(client *ethclient.Client, ccmsg *all712.CrossChainTransferMessage, wallet evmbinding.Signer) (evmbinding.Call, error) {
+	payto := ccmsg.Authorization.To
+	sendParam := new(oftcc.SendParam)
+	sendParam.AmountLD = ccmsg.Authorization.Amount
+	sendParam.MinAmountLD = ccmsg.Authorization.MinimalAmount
+	sendParam.ExtraOptions = []byte{0, 3}
+	sendParam.To = [32]byte(common.LeftPadBytes(payto.Bytes(), 32))
+	sendParam.DstEid = uint32(ccmsg.Authorization.DestinationChain.Uint64())
+	sendParam.ComposeMsg = []byte{}
+	sendParam.OftCmd = []byte{}
+
+	p0token, err := oftcc.NewOftcc(ccmsg.Domain.VerifyingContract, client)
+	if err != nil {
+		return evmbinding.Call{}, fmt.Errorf("error binding token contract: %w", err)
+	}
+
+	// Set the _payInLzToken parameter (true or false as required).
+	payInLzToken := false
+
+	messagingFee, err := p0token.QuoteSend(&bind.CallOpts{Context: context.Background()}, *sendParam, payInLzToken)
+	if err != nil {
+		return evmbinding.Call{}, fmt.Errorf("error quoting send price: %w", err)
+	}
+
+	sig := common.Hex2Bytes(strings.TrimPrefix(ccmsg.Signature, "0x"))
+
+	ccABI, err := oftcc.OftccMetaData.GetAbi()
+	if err != nil {
+		return evmbinding.Call{}, err
+	}
+	data, err := ccABI.Pack("sendWithCCAuthorization",
+		*sendParam,
+		messagingFee,
+		ccmsg.Authorization.From,
+		ccmsg.Authorization.ValidAfter,
+		ccmsg.Authorization.ValidBefore,
+		common.HexToHash(ccmsg.Authorization.Nonce),
+		sig,
+		wallet.Address())
+	if err != nil {
+		return evmbinding.Call{}, fmt.Errorf("error packing the call: %w", err)
+	}
+	// The native fee pays for the LayerZero message
+	return evmbinding.Call{To: ccmsg.Domain.VerifyingContract, Value: messagingFee.NativeFee, Data: data}, nil
+}
+
+// sendCall hands a settlement call over to the tx manager, gas gets estimated there
+func sendCall(envelope *all712.Envelope, wallet evmbinding.Signer, call evmbinding.Call) (common.Hash, error) {
+	return evmbinding.Transactions().Send(evmbinding.TxRequest{
+		Network: envelope.PaymentPayload.Network,
+		Signer:  wallet,
+		To:      call.To,
+		Value:   call.Value,
+		Data:    call.Data,
+		MaxCost: gasBudget(envelope),
+	})
+}
@@ -0,0 +1,34 @@
+package facilitator
+
+import (
+	"math/big"
+
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+)
+
+// The upto scheme is metered: the payer signs a Permit2 transfer of MaxAmountRequired, which /verify checks like
+// any Permit2 payment, and /settle moves only the consumedAmount the resource server puts in the envelope.
+
+// SettleUptoScheme transfers what was consumed, never more than the payer authorized
+func SettleUptoScheme(c *gin.Context, envelope *all712.Envelope) {
+	settlePermit2(c, envelope, true)
+}
+
+// consumedAmount is what the resource server settles for, out of the ceiling
+func consumedAmount(envelope *all712.Envelope, ceiling *big.Int) (*big.Int, error) {
+	if len(envelope.ConsumedAmount) == 0 {
+		return nil, all712.Errorf(all712.CodeInvalidPayload, "upto settlement without consumedAmount")
+	}
+	consumed, ok := new(big.Int).SetString(envelope.ConsumedAmount, 10)
+	if !ok {
+		return nil, all712.Errorf(all712.CodeInvalidPayload, "wrong consumedAmount: %s", envelope.ConsumedAmount)
+	}
+	if consumed.Sign() <= 0 {
+		return nil, all712.Errorf(all712.CodeInvalidPayload, "nothing consumed, nothing to settle: %v", consumed)
+	}
+	if consumed.Cmp(ceiling) > 0 {
+		return nil, all712.Errorf(all712.CodeAmountMismatch, "consumed %v is over the authorized %v", consumed, ceiling)
+	}
+	return consumed, nil
+}
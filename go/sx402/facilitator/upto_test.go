@@ -0,0 +1,73 @@
+package facilitator
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/schemes"
+)
+
+func TestUpto(t *testing.T) {
+	ceiling := big.NewInt(10000)
+	for consumed, code := range map[string]all712.ErrorCode{
+		"":      all712.CodeInvalidPayload,
+		"ten":   all712.CodeInvalidPayload,
+		"0":     all712.CodeInvalidPayload,
+		"10001": all712.CodeAmountMismatch,
+	} {
+		if _, err := consumedAmount(&all712.Envelope{ConsumedAmount: consumed}, ceiling); all712.CodeOf(err) != code {
+			t.Errorf("consumed %q: expected %s, got %v", consumed, code, err)
+		}
+	}
+	consumed, err := consumedAmount(&all712.Envelope{ConsumedAmount: "2500"}, ceiling)
+	if err != nil || consumed.Int64() != 2500 {
+		t.Fatalf("consumed 2500 of 10000: %v %v", consumed, err)
+	}
+
+	// Permit2 gets the consumed amount as the requestedAmount of the transfer details, right after the permit
+	key, _ := crypto.GenerateKey()
+	p2 := &all712.Permit2Message{
+		Domain: all712.Domain{Name: "Permit2", ChainID: big.NewInt(31337), VerifyingContract: evmbinding.Permit2Canonical},
+		Message: all712.Permit2Transfer{Owner: crypto.PubkeyToAddress(key.PublicKey), Amount: ceiling,
+			Deadline: big.NewInt(time.Now().Unix() + 60), Witness: all712.PaymentWitness{PayTo: common.HexToAddress(testPayTo)}},
+		Nonce: big.NewInt(1),
+	}
+	call, err := evmbinding.Permit2TransferCall(p2, consumed, make([]byte, 65))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requested := new(big.Int).SetBytes(call.Data[4+5*32 : 4+6*32]); requested.Cmp(consumed) != 0 {
+		t.Errorf("requested %v instead of %v", requested, consumed)
+	}
+
+	// the other schemes settle for the full amount, a consumedAmount there is a mistake of the caller
+	path := filepath.Join(t.TempDir(), "schemes.json")
+	os.WriteFile(path, []byte(`[{"scheme":"exact","type":"exac","network":"`+walletNet(t)+`",
+		"asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e","extra":{"name":"USDC","version":"2"}}]`), 0644)
+	if err := schemes.LoadSchemes(path); err != nil {
+		t.Fatal(err)
+	}
+	evmbinding.Wallets().Add(evmbinding.NewKeySigner(key))
+	envelope := exactEnvelope("10000", testPayTo, 0, time.Now().Unix()+60)
+	envelope.PaymentPayload.Scheme, envelope.PaymentPayload.Network = "exact", walletNet(t)
+	envelope.ConsumedAmount = "2500"
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("envelope", *envelope)
+	SettleHandler(c)
+	res := new(settleResult)
+	json.Unmarshal(w.Body.Bytes(), res)
+	if res.Success || res.ErrorReason == nil || *res.ErrorReason != string(all712.CodeInvalidPayload) {
+		t.Errorf("exact settled for the consumed amount: %s", w.Body)
+	}
+}
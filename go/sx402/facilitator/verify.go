@@ -4,9 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"math/big"
-	"net/http"
 	"strings"
 	"time"
 
@@ -18,7 +16,6 @@ import (
 	"github.com/san-lab/sx402/all712"
 	"github.com/san-lab/sx402/evmbinding"
 	"github.com/san-lab/sx402/oft"
-	"github.com/san-lab/sx402/schemes"
 	"github.com/san-lab/sx402/signing"
 )
 
@@ -29,70 +26,52 @@ var TortugaOperator = common.HexToAddress("0xe1b783Bead4D2FDA861eA16e9D8Fa670AaD
 func verifyHandler(c *gin.Context) {
 	enlp, exists := c.Get("envelope")
 	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Envelope not found"})
-		c.Abort()
+		rejectVerify(c, nil, all712.Errorf(all712.CodeUnexpected, "envelope not found"))
 		return
 	}
 	envelope := enlp.(all712.Envelope)
 
-	scheme, err := schemes.GetScheme(envelope.PaymentPayload.Scheme, envelope.PaymentPayload.Network)
+	handler, err := handlerFor(&envelope)
 	if err != nil {
-		response := types.VerifyResponse{}
-		reason := "Unsupported Scheme/Network pair: " + envelope.PaymentPayload.Scheme
-		response.InvalidReason = &reason
-		response.Payer = &envelope.PaymentRequirements.PayTo
-		c.JSON(http.StatusOK, response)
-		c.Abort()
-		return
-	}
-
-	switch scheme.Type {
-	case schemes.ExactType:
-		VerifyExactEnvelope(c, &envelope)
-	case schemes.PermitType:
-		VerifyPermitEnvelope(c, &envelope)
-	case schemes.Payer0Legacy:
-		VerifyPayer0Envelope(c, &envelope)
-	case schemes.Payer0Type:
-		VerifyCrossChainScheme(c, &envelope)
-	default:
-		response := types.VerifyResponse{}
-		reason := "Unsupported Scheme: " + envelope.PaymentPayload.Scheme
-		response.InvalidReason = &reason
-		response.Payer = &envelope.PaymentRequirements.PayTo
-		c.JSON(http.StatusOK, response)
-		c.Abort()
+		rejectVerify(c, &envelope.PaymentRequirements.PayTo, err)
 		return
 	}
+	handler.Verify(c, &envelope)
 }
 
 func VerifyExactEnvelope(c *gin.Context, envelope *all712.Envelope) {
-	clnt, exists := c.Get("client")
-	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Client not found"})
-		c.Abort()
+	client, err := contextClient(c)
+	if err != nil {
+		rejectVerify(c, nil, err)
 		return
 	}
-	client := clnt.(*ethclient.Client)
-
-	response := types.VerifyResponse{}
-	response.InvalidReason = new(string)
 
 	parsedD, err := ParseAndVerifyExact(envelope)
-
 	if err != nil {
-		*response.InvalidReason = err.Error()
-		c.JSON(http.StatusOK, response)
+		rejectVerify(c, nil, err)
 		return
 	}
+	c.Set("signatureMethod", parsedD.SignatureMethod)
 
 	p := parsedD.Payer.Hex()
-	response.Payer = &p
+	respondVerify(c, &p, verifyExactOnChain(client, envelope, parsedD))
+}
+
+func verifyExactOnChain(client *ethclient.Client, envelope *all712.Envelope, pd ParsedData) error {
 	// Checks on-chain
-	reason := ""
-	response.IsValid, reason = Verify3009OnChainConstraints(client, parsedD)
-	response.InvalidReason = &reason
-	c.JSON(http.StatusOK, response)
+	if err := Verify3009OnChainConstraints(client, pd); err != nil {
+		return err
+	}
+	// Dry-run the settlement, catches what the checks above do not (blacklists, paused tokens...)
+	call, err := exactCall(envelope, pd)
+	if err != nil {
+		return all712.WithCode(all712.CodeInvalidSignature, err)
+	}
+	wallet, err := firstWallet(envelope.PaymentPayload.Network, nil)
+	if err != nil {
+		return err
+	}
+	return simulateSettlement(envelope.PaymentPayload.Network, wallet, withDeploy(pd.deploy, call)...)
 }
 
 func ParseAndVerifyExact(envelope *all712.Envelope) (pd ParsedData, err error) {
@@ -101,154 +80,142 @@ func ParseAndVerifyExact(envelope *all712.Envelope) (pd ParsedData, err error) {
 	exactPayload := new(types.ExactEvmPayload)
 	err = json.Unmarshal(envelope.PaymentPayload.Payload, exactPayload)
 	if err != nil {
-		err = fmt.Errorf("error unmarshalling exact payload: %w", err)
+		err = all712.Errorf(all712.CodeInvalidPayload, "error unmarshalling exact payload: %w", err)
+		return
+	}
+	if exactPayload.Authorization == nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "no authorization in the exact payload")
 		return
 	}
 
 	pd.Amount, ok = new(big.Int).SetString(exactPayload.Authorization.Value, 10)
 	if !ok {
-		err = fmt.Errorf("wrong value: %s", exactPayload.Authorization.Value)
+		err = all712.Errorf(all712.CodeInvalidPayload, "wrong value: %s", exactPayload.Authorization.Value)
 		return
 	}
 
 	required, ok := new(big.Int).SetString(envelope.PaymentRequirements.MaxAmountRequired, 10)
 	if !ok {
-		err = fmt.Errorf("wrong MaxAmountRequired value: %s", envelope.PaymentRequirements.MaxAmountRequired)
+		err = all712.Errorf(all712.CodeInvalidPayload, "wrong MaxAmountRequired value: %s", envelope.PaymentRequirements.MaxAmountRequired)
 		return
 	}
 	if pd.Amount.Cmp(required) != 0 {
-		err = fmt.Errorf("authorized amount dirrefent from required: %v, %v", pd.Amount, required)
+		err = all712.Errorf(all712.CodeAmountMismatch, "authorized amount different from required: %v, %v", pd.Amount, required)
 		return
 	}
 
 	pd.ValidAfter, ok = new(big.Int).SetString(exactPayload.Authorization.ValidAfter, 10)
 	if !ok {
-		err = fmt.Errorf("wrong VelidAfter parameter: %s", exactPayload.Authorization.ValidAfter)
+		err = all712.Errorf(all712.CodeInvalidPayload, "wrong ValidAfter parameter: %s", exactPayload.Authorization.ValidAfter)
 		return
 	}
 
 	if time.Now().Unix() < pd.ValidAfter.Int64() {
-		err = fmt.Errorf("authorization not valid yet: %v/%v", pd.ValidAfter.Int64(), time.Now().Unix())
+		err = all712.Errorf(all712.CodeNotYetValid, "authorization not valid yet: %v/%v", pd.ValidAfter.Int64(), time.Now().Unix())
 		return
 	}
 
 	pd.ValidBefore, ok = new(big.Int).SetString(exactPayload.Authorization.ValidBefore, 10)
 	if !ok {
-		err = fmt.Errorf("wrong VelidBefore parameter: %s", exactPayload.Authorization.ValidBefore)
+		err = all712.Errorf(all712.CodeInvalidPayload, "wrong ValidBefore parameter: %s", exactPayload.Authorization.ValidBefore)
 		return
 	}
 	if time.Now().Unix() > pd.ValidBefore.Int64() {
-		err = fmt.Errorf("authorization expired: %v/%v", pd.ValidBefore.Int64(), time.Now().Unix())
+		err = all712.Errorf(all712.CodeExpired, "authorization expired: %v/%v", pd.ValidBefore.Int64(), time.Now().Unix())
 		return
 	}
 
 	pd.Asset = common.HexToAddress(envelope.PaymentRequirements.Asset)
-	pd.chainID, ok = evmbinding.ChainIDs[envelope.PaymentPayload.Network]
+	pd.chainID, ok = evmbinding.ChainID(envelope.PaymentPayload.Network)
 	if !ok {
-		err = fmt.Errorf("unsupported network: %s", envelope.PaymentPayload.Network)
+		err = all712.Errorf(all712.CodeInvalidNetwork, "unsupported network: %s", envelope.PaymentPayload.Network)
 		return
 
 	}
 
 	if !strings.EqualFold(envelope.PaymentRequirements.PayTo, exactPayload.Authorization.To) {
-		err = fmt.Errorf("destination account mismatch: %s/%s", envelope.PaymentRequirements.PayTo, exactPayload.Authorization.To)
+		err = all712.Errorf(all712.CodeRecipientMismatch, "destination account mismatch: %s/%s", envelope.PaymentRequirements.PayTo, exactPayload.Authorization.To)
 		return
 	}
 
 	einfo := ExtraInfo{}
+	if envelope.PaymentRequirements.Extra == nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "missing ExtraInfo")
+		return
+	}
 	err = json.Unmarshal(*envelope.PaymentRequirements.Extra, &einfo)
 	if err != nil {
-		err = fmt.Errorf("error parsing ExtraInfo: %w", err)
+		err = all712.Errorf(all712.CodeInvalidPayload, "error parsing ExtraInfo: %w", err)
 		return
 	}
 
-	pd.Payer, pd.nonce, pd.signature, err = signing.VerifyTransferWithAuthorizationSignature(
+	pd.Payer, pd.nonce, pd.signature, pd.SignatureMethod, err = signing.VerifyTransferWithAuthorization(
 		exactPayload.Signature,
 		*exactPayload.Authorization,
 		einfo["name"], einfo["version"],
 		pd.chainID, common.HexToAddress(envelope.PaymentRequirements.Asset))
 
 	if err != nil {
+		err = all712.WithCode(all712.CodeInvalidSignature, err)
 		return
 	}
-
+	pd.deploy, pd.signature, err = counterfactual(envelope.PaymentPayload.Network, pd.Payer, pd.signature)
 	return
 }
 
 var zeroPeer [32]byte
 
 func VerifyPayer0Envelope(c *gin.Context, envelope *all712.Envelope) {
-	clnt, exists := c.Get("client")
-	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Client not found"})
-		c.Abort()
+	client, err := contextClient(c)
+	if err != nil {
+		rejectVerify(c, nil, err)
 		return
 	}
-	client := clnt.(*ethclient.Client)
-
-	response := types.VerifyResponse{}
-	response.InvalidReason = new(string)
 
 	pd, err := FormallyVerifyPayer0Envelope(envelope)
 	if err != nil {
-		*response.InvalidReason = err.Error()
-		c.JSON(http.StatusOK, response)
+		rejectVerify(c, nil, err)
 		return
 	}
+	c.Set("signatureMethod", pd.SignatureMethod)
 
-	markup, insignificant_err := evmbinding.GetMarkup(envelope.PaymentPayload.Network, envelope.PaymentRequirements.Asset, keyfile.Address)
-	if insignificant_err != nil {
-		log.Println(insignificant_err)
-	}
+	p := pd.Payer.Hex()
+	respondVerify(c, &p, verifyPayer0OnChain(client, envelope, pd))
+}
 
-	if pd.Amount.Cmp(markup) == -1 {
-		err = fmt.Errorf("Slippage margin error: %v/%v", pd.Amount, markup)
-		*response.InvalidReason = err.Error()
-		c.JSON(http.StatusOK, response)
-		return
+func verifyPayer0OnChain(client *ethclient.Client, envelope *all712.Envelope, pd ParsedData) error {
+	// Some wallet of the network must have a markup the amount covers
+	wallet, err := firstWallet(envelope.PaymentPayload.Network,
+		markupLeaves(envelope.PaymentPayload.Network, envelope.PaymentRequirements.Asset, 0, pd.Amount, new(big.Int)))
+	if err != nil {
+		return all712.Errorf(all712.CodeMarkupNotCovered, "slippage margin error: %v does not cover the markup of any facilitator wallet", pd.Amount)
 	}
 
-	p := pd.Payer.Hex()
-	response.Payer = &p
 	// Checks on-chain
-	response.IsValid, *response.InvalidReason = Verify3009OnChainConstraints(client, pd)
-
-	if !response.IsValid {
-		c.JSON(http.StatusOK, response)
-		c.Abort()
-		return
+	if err := Verify3009OnChainConstraints(client, pd); err != nil {
+		return err
 	}
 
-	//TODO: Check if Peer exists
 	contract, err := oft.NewOft(pd.Asset, client)
 	if err != nil {
-		*response.InvalidReason = fmt.Sprintf("failed to instantiate the contract: %v", err)
-		response.IsValid = false
-		c.JSON(http.StatusOK, response)
-		c.Abort()
-		return
+		return fmt.Errorf("failed to instantiate the contract: %w", err)
 	}
 	callOpts := &bind.CallOpts{
 		Context: context.Background(),
 	}
 	peerAtDst, err := contract.Peers(callOpts, pd.DstEid)
 	if err != nil {
-		*response.InvalidReason = fmt.Sprintf("error checking peers: %v", err)
-		response.IsValid = false
-		c.JSON(http.StatusOK, response)
-		c.Abort()
-		return
+		return fmt.Errorf("error checking peers: %w", err)
 	}
 	if peerAtDst == zeroPeer {
-		*response.InvalidReason = fmt.Sprintf("No peer at dest chain: %v ", pd.DstEid)
-		response.IsValid = false
-		c.JSON(http.StatusOK, response)
-		c.Abort()
-		return
+		return all712.Errorf(all712.CodeUnsupportedScheme, "no peer at dest chain: %v", pd.DstEid)
 	}
 
-	response.IsValid = true
-	c.JSON(http.StatusOK, response)
+	call, err := payer0Call(client, envelope, pd, wallet)
+	if err != nil {
+		return err
+	}
+	return simulateSettlement(envelope.PaymentPayload.Network, wallet, withDeploy(pd.deploy, call)...)
 }
 
 func FormallyVerifyPayer0Envelope(envelope *all712.Envelope) (pd ParsedData, err error) {
@@ -261,12 +228,12 @@ func FormallyVerifyPayer0Envelope(envelope *all712.Envelope) (pd ParsedData, err
 	payer0Payload := new(all712.Payer03009Payload)
 	err = json.Unmarshal(envelope.PaymentPayload.Payload, payer0Payload)
 	if err != nil {
-		err = fmt.Errorf("error unmarshalling Payer03009Payload: %w", err)
+		err = all712.Errorf(all712.CodeInvalidPayload, "error unmarshalling Payer03009Payload: %w", err)
 		return
 	}
 
 	if payer0Payload.DestEid == 0 {
-		err = fmt.Errorf("Missing destination chain id")
+		err = all712.Errorf(all712.CodeInvalidPayload, "missing destination chain id")
 		return
 	}
 	pd.DstEid = payer0Payload.DestEid
@@ -274,29 +241,23 @@ func FormallyVerifyPayer0Envelope(envelope *all712.Envelope) (pd ParsedData, err
 	return
 }
 
-func Verify3009OnChainConstraints(client *ethclient.Client, pd ParsedData) (ok bool, reason string) {
-
-	// Checks on-chain
+// Verify3009OnChainConstraints checks that the nonce is fresh and the payer can afford the amount
+func Verify3009OnChainConstraints(client *ethclient.Client, pd ParsedData) error {
 	known, err := evmbinding.CheckAuthorizationState(client, pd.Asset, pd.Payer, pd.nonce)
-	if known {
-		reason = "Nonce already used"
-		return
-	}
 	if err != nil {
-		reason = fmt.Sprintf("Unable to check the auth state: %v", err)
-		return
+		return fmt.Errorf("unable to check the auth state: %w", err)
+	}
+	if known {
+		return all712.Errorf(all712.CodeNonceUsed, "nonce already used")
 	}
 
 	balance, err := evmbinding.CheckTokenBalance(client, pd.Asset, pd.Payer)
 	if err != nil {
-		reason = fmt.Sprintf("Unable to check the balance: %v", err)
-		return
+		return fmt.Errorf("unable to check the balance: %w", err)
 	}
 
 	if pd.Amount.Cmp(balance) == 1 {
-		reason = fmt.Sprintf("Insufficient balance: %v", balance)
-		return
+		return all712.Errorf(all712.CodeInsufficientFunds, "insufficient balance: %v", balance)
 	}
-	ok = true
-	return
+	return nil
 }
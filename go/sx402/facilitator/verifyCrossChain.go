@@ -2,64 +2,46 @@ package facilitator
 
 import (
 	"encoding/json"
-	"log"
-	"math/big"
-	"net/http"
 
-	"github.com/coinbase/x402/go/pkg/types"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gin-gonic/gin"
 	"github.com/san-lab/sx402/all712"
-	"github.com/san-lab/sx402/evmbinding"
 	"github.com/san-lab/sx402/signing"
 )
 
 func VerifyCrossChainScheme(c *gin.Context, envelope *all712.Envelope) {
-
-	clnt, exists := c.Get("client")
-	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Client not found"})
-		c.Abort()
+	client, err := contextClient(c)
+	if err != nil {
+		rejectVerify(c, nil, err)
 		return
 	}
-	client := clnt.(*ethclient.Client)
-
-	response := types.VerifyResponse{}
-	response.InvalidReason = new(string)
 
 	// TODO: Use ExtraInfo for additional validation?
 	ccmsg, _, err := parseCrossChainMessage(envelope)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"Parsing error": err.Error()})
-		c.Abort()
+		rejectVerify(c, nil, err)
 		return
 	}
 
-	rec, err := signing.VerifyCrossChainAuthSignature(ccmsg)
+	rec, method, err := signing.VerifyCrossChainAuthorization(ccmsg)
 	if err != nil {
-		reason := "Error verifying signature: " + err.Error()
-		response.IsValid = false
-		response.InvalidReason = &reason
-		c.JSON(http.StatusOK, response)
-		c.Abort()
+		rejectVerify(c, nil, all712.WithCode(all712.CodeInvalidSignature, err))
 		return
 	}
+	c.Set("signatureMethod", method)
 
-	markup, insignificant_err := evmbinding.GetDetailedMarkup(envelope.PaymentPayload.Network,
-		envelope.PaymentRequirements.Asset,
-		uint32(ccmsg.Authorization.DestinationChain.Uint64()),
-		keyfile.Address)
-	if insignificant_err != nil {
-		log.Println(insignificant_err)
-	}
+	p := rec.Hex()
+	respondVerify(c, &p, verifyCrossChainOnChain(client, envelope, ccmsg, rec))
+}
 
-	//The authorzed amount must cover the slippage and the minAmount
-	if new(big.Int).Sub(ccmsg.Authorization.Amount, markup).Cmp(ccmsg.Authorization.MinimalAmount) < 0 {
-		reason := "minAmount not guaranteed"
-		response.IsValid = false
-		response.InvalidReason = &reason
-		c.JSON(http.StatusOK, response)
+func verifyCrossChainOnChain(client *ethclient.Client, envelope *all712.Envelope, ccmsg *all712.CrossChainTransferMessage, payer common.Address) error {
+	//The authorzed amount must cover the slippage and the minAmount, for some wallet of the network
+	wallet, err := firstWallet(envelope.PaymentPayload.Network, markupLeaves(envelope.PaymentPayload.Network,
+		envelope.PaymentRequirements.Asset, uint32(ccmsg.Authorization.DestinationChain.Uint64()),
+		ccmsg.Authorization.Amount, ccmsg.Authorization.MinimalAmount))
+	if err != nil {
+		return all712.Errorf(all712.CodeMarkupNotCovered, "minAmount not guaranteed")
 	}
 
 	//Reuse the EIP3009 verification for now
@@ -70,24 +52,46 @@ func VerifyCrossChainScheme(c *gin.Context, envelope *all712.Envelope) {
 	pd.Asset = ccmsg.Domain.VerifyingContract
 	pd.ValidAfter = ccmsg.Authorization.ValidAfter
 	pd.ValidBefore = ccmsg.Authorization.ValidBefore
-	pd.Payer = rec
-	valid, reason := Verify3009OnChainConstraints(client, pd)
+	pd.Payer = payer
+	if err := Verify3009OnChainConstraints(client, pd); err != nil {
+		return err
+	}
 
-	response.IsValid = valid
-	response.InvalidReason = &reason
-	c.JSON(http.StatusOK, response)
+	deploy, err := crossChainCounterfactual(envelope.PaymentPayload.Network, ccmsg)
+	if err != nil {
+		return err
+	}
+	call, err := crossChainCall(client, ccmsg, wallet)
+	if err != nil {
+		return err
+	}
+	return simulateSettlement(envelope.PaymentPayload.Network, wallet, withDeploy(deploy, call)...)
 }
 
 func parseCrossChainMessage(envelope *all712.Envelope) (ccmsg *all712.CrossChainTransferMessage, extraInfo *ExtraInfo, err error) {
 	ccmsg = new(all712.CrossChainTransferMessage)
 	err = json.Unmarshal(envelope.PaymentPayload.Payload, ccmsg)
 	if err != nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "parsing error: %w", err)
+		return
+	}
+	auth := ccmsg.Authorization
+	if ccmsg.Domain == nil || ccmsg.Domain.ChainID == nil || auth == nil || auth.Amount == nil || auth.MinimalAmount == nil ||
+		auth.DestinationChain == nil || auth.ValidAfter == nil || auth.ValidBefore == nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "incomplete cross-chain authorization")
 		return
 	}
 
 	//This may needa more systematic design
 	extraInfo = new(ExtraInfo)
+	if envelope.PaymentRequirements.Extra == nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "missing ExtraInfo")
+		return
+	}
 	err = json.Unmarshal(*envelope.PaymentRequirements.Extra, extraInfo)
+	if err != nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "error parsing ExtraInfo: %w", err)
+	}
 	return
 
 }
@@ -5,10 +5,9 @@ import (
 	"fmt"
 	"math/big"
 	"net/http"
-	"strings"
 	"time"
 
-	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gin-gonic/gin"
 	"github.com/san-lab/sx402/all712"
@@ -17,45 +16,43 @@ import (
 )
 
 func VerifyPermitEnvelope(c *gin.Context, envelope *all712.Envelope) {
-	clnt, exists := c.Get("client")
-	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Client not found"})
-		c.Abort()
+	client, err := contextClient(c)
+	if err != nil {
+		rejectVerify(c, nil, err)
 		return
 	}
-	client := clnt.(*ethclient.Client)
-
-	response := types.VerifyResponse{}
 
 	permit, err := FormallyVerifyPermitEnvelope(envelope)
 	if err != nil {
-		reason := err.Error()
-		response.InvalidReason = &reason
-		c.JSON(http.StatusBadRequest, response)
+		rejectVerify(c, nil, err)
 		return
 	}
 
 	p := permit.Message.Owner.Hex()
-	response.Payer = &p
+	respondVerify(c, &p, verifyPermitOnChain(client, envelope, permit))
+}
+
+func verifyPermitOnChain(client *ethclient.Client, envelope *all712.Envelope, permit *all712.PermitMessage) error {
 	// Checks on-chain
 
 	//check if nonce(s) is correct
 
 	balance, err := evmbinding.CheckTokenBalance(client, permit.Domain.VerifyingContract, permit.Message.Owner)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"Unable to check the balance": err})
-		return
+		return fmt.Errorf("unable to check the balance: %w", err)
 	}
 
 	if permit.Message.Value.Cmp(balance) == 1 {
-		reason := fmt.Sprintf("Insufficient balance: %v", balance)
-		response.InvalidReason = &reason
-		c.JSON(http.StatusOK, response)
-		return
+		return all712.Errorf(all712.CodeInsufficientFunds, "insufficient balance: %v", balance)
 	}
 
-	response.IsValid = true
-	c.JSON(http.StatusOK, response)
+	// permit + transferFrom in one simulation, the second depends on the first
+	calls, err := permitCalls(envelope, permit)
+	if err != nil {
+		return all712.WithCode(all712.CodeInvalidPayload, err)
+	}
+	wallet, _ := evmbinding.Wallets().Find(envelope.PaymentPayload.Network, permit.Message.Spender)
+	return simulateSettlement(envelope.PaymentPayload.Network, wallet, calls...)
 }
 
 func FormallyVerifyPermitEnvelope(envelope *all712.Envelope) (permit *all712.PermitMessage, err error) {
@@ -63,58 +60,72 @@ func FormallyVerifyPermitEnvelope(envelope *all712.Envelope) (permit *all712.Per
 	permit = new(all712.PermitMessage)
 	err = json.Unmarshal(envelope.PaymentPayload.Payload, permit)
 	if err != nil {
-		err = fmt.Errorf("error unmarshalling permit payload: %w", err)
+		err = all712.Errorf(all712.CodeInvalidPayload, "error unmarshalling permit payload: %w", err)
 		return
 	}
 
 	//This may needa more systematic design
 	extraInfo := new(ExtraInfo)
+	if envelope.PaymentRequirements.Extra == nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "missing extra permit info")
+		return
+	}
 	err = json.Unmarshal(*envelope.PaymentRequirements.Extra, extraInfo)
 	if err != nil {
-		err = fmt.Errorf("error unmarshalling extra permit info")
+		err = all712.Errorf(all712.CodeInvalidPayload, "error unmarshalling extra permit info")
 		return
 	}
 	eFacilitator, ok := (*extraInfo)["facilitator"]
 
-	if !ok || !strings.EqualFold(eFacilitator, keyfile.Address) {
-		err = fmt.Errorf("missing or wrong faclitator: %s", eFacilitator)
+	// The facilitator named in the requirements is the wallet that pulls the funds, so it has to be the spender
+	if !ok || !common.IsHexAddress(eFacilitator) {
+		err = all712.Errorf(all712.CodeInvalidPayload, "missing or wrong faclitator: %s", eFacilitator)
+		return
+	}
+	if _, ok = evmbinding.Wallets().Find(envelope.PaymentPayload.Network, common.HexToAddress(eFacilitator)); !ok {
+		err = all712.Errorf(all712.CodeRecipientMismatch, "missing or wrong faclitator: %s", eFacilitator)
+		return
+	}
+	if common.HexToAddress(eFacilitator) != permit.Message.Spender {
+		err = all712.Errorf(all712.CodeRecipientMismatch, "permit spender %s is not the facilitator %s", permit.Message.Spender, eFacilitator)
 		return
 	}
 
 	amount := permit.Message.Value
-	if amount == nil {
-		err = fmt.Errorf("nil value in permit message")
+	if amount == nil || permit.Message.Deadline == nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "nil value or deadline in permit message")
 		return
 	}
 
 	required, ok := new(big.Int).SetString(envelope.PaymentRequirements.MaxAmountRequired, 10)
 	if !ok {
-		err = fmt.Errorf("wrong MaxAmountRequired value: %s", envelope.PaymentRequirements.MaxAmountRequired)
+		err = all712.Errorf(all712.CodeInvalidPayload, "wrong MaxAmountRequired value: %s", envelope.PaymentRequirements.MaxAmountRequired)
 		return
 	}
 	if amount.Cmp(required) != 0 {
-		err = fmt.Errorf("authorized amount dirrefent from required: %v, %v", amount, required)
+		err = all712.Errorf(all712.CodeAmountMismatch, "authorized amount different from required: %v, %v", amount, required)
 		return
 	}
 
 	if time.Now().Unix() > permit.Message.Deadline.Int64() {
-		err = fmt.Errorf("authorization expired: %v/%v", permit.Message.Deadline.Uint64(), time.Now().Unix())
+		err = all712.Errorf(all712.CodeExpired, "authorization expired: %v/%v", permit.Message.Deadline.Uint64(), time.Now().Unix())
 		return
 	}
 
-	chainID, ok := evmbinding.ChainIDs[envelope.PaymentPayload.Network]
+	chainID, ok := evmbinding.ChainID(envelope.PaymentPayload.Network)
 	if !ok {
-		err = fmt.Errorf("unsupported network: %s", envelope.PaymentPayload.Network)
+		err = all712.Errorf(all712.CodeInvalidNetwork, "unsupported network: %s", envelope.PaymentPayload.Network)
 		return
 
 	}
 
-	if chainID.Cmp(permit.Domain.ChainID) != 0 {
-		err = fmt.Errorf("ChainID mismatch: %v/%v", chainID, permit.Domain.ChainID)
+	if permit.Domain.ChainID == nil || chainID.Cmp(permit.Domain.ChainID) != 0 {
+		err = all712.Errorf(all712.CodeInvalidNetwork, "ChainID mismatch: %v/%v", chainID, permit.Domain.ChainID)
 		return
 	}
 
 	_, err = signing.VerifyPermitSignature(permit)
+	err = all712.WithCode(all712.CodeInvalidSignature, err)
 	return
 
 }
@@ -0,0 +1,170 @@
+package facilitator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/signing"
+)
+
+func VerifyPermit2Envelope(c *gin.Context, envelope *all712.Envelope) {
+	client, err := contextClient(c)
+	if err != nil {
+		rejectVerify(c, nil, err)
+		return
+	}
+
+	p2, method, err := FormallyVerifyPermit2Envelope(envelope)
+	if err != nil {
+		rejectVerify(c, nil, err)
+		return
+	}
+	c.Set("signatureMethod", method)
+
+	p := p2.Message.Owner.Hex()
+	respondVerify(c, &p, verifyPermit2OnChain(client, envelope, p2))
+}
+
+func verifyPermit2OnChain(client *ethclient.Client, envelope *all712.Envelope, p2 *all712.Permit2Message) error {
+	owner, amount := p2.Message.Owner, p2.Message.Amount
+	used, err := evmbinding.CheckPermit2Nonce(client, p2.Domain.VerifyingContract, owner, p2.Nonce)
+	if err != nil {
+		return fmt.Errorf("unable to check the Permit2 nonce: %w", err)
+	}
+	if used {
+		return all712.Errorf(all712.CodeNonceUsed, "Permit2 nonce already used")
+	}
+
+	balance, err := evmbinding.CheckTokenBalance(client, p2.Message.Token, owner)
+	if err != nil {
+		return fmt.Errorf("unable to check the balance: %w", err)
+	}
+	if amount.Cmp(balance) == 1 {
+		return all712.Errorf(all712.CodeInsufficientFunds, "insufficient balance: %v", balance)
+	}
+
+	allowance, err := evmbinding.CheckAllowance(client, p2.Message.Token, owner, p2.Domain.VerifyingContract)
+	if err != nil {
+		return fmt.Errorf("unable to check the Permit2 allowance: %w", err)
+	}
+	if amount.Cmp(allowance) == 1 {
+		return all712.Errorf(all712.CodeNoAllowance, "Permit2 allowance of %v does not cover the amount", allowance)
+	}
+
+	deploy, call, err := permit2Call(envelope.PaymentPayload.Network, p2, p2.Message.Amount)
+	if err != nil {
+		return err
+	}
+	wallet, _ := evmbinding.Wallets().Find(envelope.PaymentPayload.Network, p2.Message.Spender)
+	return simulateSettlement(envelope.PaymentPayload.Network, wallet, withDeploy(deploy, call)...)
+}
+
+// permit2Call is the permitWitnessTransferFrom of amount out of the message, and the deployment of the owner's wallet if it has to go first
+func permit2Call(network string, p2 *all712.Permit2Message, amount *big.Int) (*evmbinding.Call, evmbinding.Call, error) {
+	sig, err := hexutil.Decode(p2.Signature)
+	if err != nil {
+		return nil, evmbinding.Call{}, all712.Errorf(all712.CodeInvalidSignature, "error decoding the Permit2 signature: %w", err)
+	}
+	deploy, sig, err := counterfactual(network, p2.Message.Owner, sig)
+	if err != nil {
+		return nil, evmbinding.Call{}, err
+	}
+	call, err := evmbinding.Permit2TransferCall(p2, amount, sig)
+	if err != nil {
+		return nil, evmbinding.Call{}, all712.WithCode(all712.CodeInvalidPayload, err)
+	}
+	return deploy, call, nil
+}
+
+func FormallyVerifyPermit2Envelope(envelope *all712.Envelope) (p2 *all712.Permit2Message, method signing.SignatureMethod, err error) {
+	p2 = new(all712.Permit2Message)
+	err = json.Unmarshal(envelope.PaymentPayload.Payload, p2)
+	if err != nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "error unmarshalling Permit2 payload: %w", err)
+		return
+	}
+	if p2.Message.Amount == nil || p2.Message.Deadline == nil || p2.Nonce == nil || p2.Domain.ChainID == nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "nil amount, deadline, nonce or chainId in the Permit2 message")
+		return
+	}
+
+	// Like with permits, the facilitator in the requirements is the spender, the only one Permit2 lets pull the funds
+	network := envelope.PaymentPayload.Network
+	extraInfo := new(ExtraInfo)
+	if envelope.PaymentRequirements.Extra == nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "missing extra permit info")
+		return
+	}
+	if err = json.Unmarshal(*envelope.PaymentRequirements.Extra, extraInfo); err != nil {
+		err = all712.Errorf(all712.CodeInvalidPayload, "error unmarshalling extra permit info")
+		return
+	}
+	eFacilitator := (*extraInfo)["facilitator"]
+	if !common.IsHexAddress(eFacilitator) {
+		err = all712.Errorf(all712.CodeInvalidPayload, "missing or wrong faclitator: %s", eFacilitator)
+		return
+	}
+	if _, ok := evmbinding.Wallets().Find(network, common.HexToAddress(eFacilitator)); !ok {
+		err = all712.Errorf(all712.CodeRecipientMismatch, "missing or wrong faclitator: %s", eFacilitator)
+		return
+	}
+	if common.HexToAddress(eFacilitator) != p2.Message.Spender {
+		err = all712.Errorf(all712.CodeRecipientMismatch, "Permit2 spender %s is not the facilitator %s", p2.Message.Spender, eFacilitator)
+		return
+	}
+
+	chainID, ok := evmbinding.ChainID(network)
+	if !ok {
+		err = all712.Errorf(all712.CodeInvalidNetwork, "unsupported network: %s", network)
+		return
+	}
+	if chainID.Cmp(p2.Domain.ChainID) != 0 {
+		err = all712.Errorf(all712.CodeInvalidNetwork, "ChainID mismatch: %v/%v", chainID, p2.Domain.ChainID)
+		return
+	}
+	if permit2, _ := evmbinding.Permit2Address(network); p2.Domain.VerifyingContract != permit2 {
+		err = all712.Errorf(all712.CodeInvalidPayload, "%s is not Permit2 on %s", p2.Domain.VerifyingContract, network)
+		return
+	}
+	if p2.Message.Token != common.HexToAddress(envelope.PaymentRequirements.Asset) {
+		err = all712.Errorf(all712.CodeInvalidPayload, "Permit2 token %s is not the asset %s", p2.Message.Token, envelope.PaymentRequirements.Asset)
+		return
+	}
+
+	required, ok := new(big.Int).SetString(envelope.PaymentRequirements.MaxAmountRequired, 10)
+	if !ok {
+		err = all712.Errorf(all712.CodeInvalidPayload, "wrong MaxAmountRequired value: %s", envelope.PaymentRequirements.MaxAmountRequired)
+		return
+	}
+	if p2.Message.Amount.Cmp(required) != 0 {
+		err = all712.Errorf(all712.CodeAmountMismatch, "authorized amount different from required: %v, %v", p2.Message.Amount, required)
+		return
+	}
+
+	// The witness is what ties the signature to this payment
+	if p2.Message.Witness.PayTo != common.HexToAddress(envelope.PaymentRequirements.PayTo) {
+		err = all712.Errorf(all712.CodeRecipientMismatch, "witness payTo %s is not %s", p2.Message.Witness.PayTo, envelope.PaymentRequirements.PayTo)
+		return
+	}
+	if p2.Message.Witness.Resource != envelope.PaymentRequirements.Resource {
+		err = all712.Errorf(all712.CodeInvalidPayload, "witness resource %q is not %q", p2.Message.Witness.Resource, envelope.PaymentRequirements.Resource)
+		return
+	}
+
+	if p2.Message.Deadline.Cmp(big.NewInt(time.Now().Unix())) < 0 {
+		err = all712.Errorf(all712.CodeExpired, "authorization expired: %v/%v", p2.Message.Deadline, time.Now().Unix())
+		return
+	}
+
+	_, method, err = signing.VerifyPermit2Signature(p2)
+	err = all712.WithCode(all712.CodeInvalidSignature, err)
+	return
+}
@@ -0,0 +1,200 @@
+package facilitator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/signing"
+)
+
+// walletNode has a wallet contract accepting every signature at every address, or only EOAs when eoa is set.
+// Simulated blocks succeed, and their last call answers like isValidSignature.
+type walletNode struct {
+	eoa atomic.Bool
+}
+
+const magicValue = "0x1626ba7e00000000000000000000000000000000000000000000000000000000"
+
+func (n *walletNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	result := `"0x"`
+	switch req.Method {
+	case "eth_chainId":
+		result = `"0x7a69"`
+	case "eth_getCode":
+		if !n.eoa.Load() {
+			result = `"0x6080"`
+		}
+	case "eth_call":
+		result = fmt.Sprintf("%q", magicValue)
+	case "eth_simulateV1":
+		result = fmt.Sprintf(`[{"calls":[{"status":"0x1","returnData":"0x","gasUsed":"0x30d40"},
+			{"status":"0x1","returnData":%q,"gasUsed":"0x186a0"}]}]`, magicValue)
+	}
+	fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":%s}`, req.ID, result)
+}
+
+var (
+	testNode     = &walletNode{}
+	walletNetSet sync.Once
+)
+
+// walletNet is the network of testNode; the rpc client pool keeps one client per network, so the node lives as long as the tests
+func walletNet(t *testing.T) string {
+	walletNetSet.Do(func() {
+		srv := httptest.NewServer(testNode)
+		path := filepath.Join(t.TempDir(), "networks.json")
+		os.WriteFile(path, []byte(fmt.Sprintf(`[{"name":"walletnet","chainId":31337,"rpcUrls":[%q]}]`, srv.URL)), 0644)
+		if err := evmbinding.LoadNetworks(path); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return "walletnet"
+}
+
+func TestContractPayer(t *testing.T) {
+	envelope := exactEnvelope("10000", testPayTo, 0, time.Now().Unix()+60)
+	envelope.PaymentPayload.Network = walletNet(t)
+
+	testNode.eoa.Store(false)
+	pd, err := ParseAndVerifyExact(envelope)
+	if err != nil || pd.SignatureMethod != signing.MethodERC1271 {
+		t.Fatalf("contract signature not accepted: %v %v", pd.SignatureMethod, err)
+	}
+	// the one-byte signature cannot go as v, r, s
+	call, err := exactCall(envelope, pd)
+	if err != nil || !bytes.HasPrefix(call.Data, common.FromHex("0xcf092995")) {
+		t.Errorf("not the bytes overload of transferWithAuthorization: %x %v", call.Data[:4], err)
+	}
+
+	testNode.eoa.Store(true)
+	if _, err := ParseAndVerifyExact(envelope); all712.CodeOf(err) != all712.CodeInvalidSignature {
+		t.Errorf("EOA payer with a bad signature: %v", err)
+	}
+}
+
+func TestCounterfactualPayer(t *testing.T) {
+	factory := common.HexToAddress("0xfac")
+	inner := bytes.Repeat([]byte{0xaa}, 70)
+	wrapped, err := signing.WrapERC6492(&signing.Counterfactual{Factory: factory, FactoryData: []byte{1, 2, 3}, Signature: inner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := exactEnvelope("10000", testPayTo, 0, time.Now().Unix()+60)
+	envelope.PaymentPayload.Network = walletNet(t)
+	envelope.PaymentPayload.Payload = bytes.Replace(envelope.PaymentPayload.Payload, []byte(`"0x00"`), []byte(fmt.Sprintf("%q", hexutil.Encode(wrapped))), 1)
+
+	// not deployed yet: the deployment goes first, the wallet takes the inner signature
+	testNode.eoa.Store(true)
+	pd, err := ParseAndVerifyExact(envelope)
+	if err != nil || pd.SignatureMethod != signing.MethodERC6492 {
+		t.Fatalf("counterfactual signature not accepted: %v %v", pd.SignatureMethod, err)
+	}
+	if pd.deploy == nil || pd.deploy.To != factory || !bytes.Equal(pd.signature, inner) {
+		t.Errorf("wrong deployment %v or signature %x", pd.deploy, pd.signature)
+	}
+	calls := withDeploy(pd.deploy, evmbinding.Call{To: pd.Asset})
+	gas, err := evmbinding.SimulateGas(context.Background(), envelope.PaymentPayload.Network, common.Address{}, calls...)
+	if err != nil || len(gas) != 2 || gas[1] < 100000 {
+		t.Errorf("settlement after the deployment priced at %v: %v", gas, err)
+	}
+
+	// deployed since: a plain ERC-1271 check, nothing to deploy
+	testNode.eoa.Store(false)
+	pd, err = ParseAndVerifyExact(envelope)
+	if err != nil || pd.SignatureMethod != signing.MethodERC1271 || pd.deploy != nil || !bytes.Equal(pd.signature, inner) {
+		t.Errorf("deployed wallet: %v %v %v", pd.SignatureMethod, pd.deploy, err)
+	}
+}
+
+func TestPermit2(t *testing.T) {
+	network := walletNet(t)
+	payerKey, _ := crypto.GenerateKey()
+	spenderKey, _ := crypto.GenerateKey()
+	spender := evmbinding.NewKeySigner(spenderKey)
+	evmbinding.Wallets().Add(spender)
+	permit2, _ := evmbinding.Permit2Address(network)
+
+	sign := func(edit func(p2 *all712.Permit2Message)) *all712.Envelope {
+		p2 := &all712.Permit2Message{
+			Domain: all712.Domain{Name: "Permit2", ChainID: big.NewInt(31337), VerifyingContract: permit2},
+			Message: all712.Permit2Transfer{
+				Owner:    crypto.PubkeyToAddress(payerKey.PublicKey),
+				Token:    common.HexToAddress("0x036CbD53842c5426634e7929541eC2318f3dCF7e"),
+				Amount:   big.NewInt(10000),
+				Spender:  spender.Address(),
+				Deadline: big.NewInt(time.Now().Unix() + 60),
+				Witness:  all712.PaymentWitness{PayTo: common.HexToAddress(testPayTo), Resource: "https://shop/item"},
+			},
+			Nonce: big.NewInt(1234),
+		}
+		edit(p2)
+		if _, err := signing.SignPermit2Transfer(p2, payerKey); err != nil {
+			t.Fatal(err)
+		}
+		payload, _ := json.Marshal(p2)
+		envelope := testEnvelope(string(payload))
+		extra := json.RawMessage(`{"name":"USDC","facilitator":"` + spender.Address().Hex() + `"}`)
+		envelope.PaymentPayload.Network = network
+		envelope.PaymentRequirements.MaxAmountRequired = "10000"
+		envelope.PaymentRequirements.PayTo = testPayTo
+		envelope.PaymentRequirements.Resource = "https://shop/item"
+		envelope.PaymentRequirements.Extra = &extra
+		return envelope
+	}
+
+	envelope := sign(func(*all712.Permit2Message) {})
+	p2, method, err := FormallyVerifyPermit2Envelope(envelope)
+	if err != nil || method != signing.MethodECDSA {
+		t.Fatalf("Permit2 transfer not accepted: %v %v", method, err)
+	}
+	deploy, call, err := permit2Call(network, p2, p2.Message.Amount)
+	selector := crypto.Keccak256([]byte("permitWitnessTransferFrom(((address,uint256),uint256,uint256),(address,uint256),address,bytes32,string,bytes)"))[:4]
+	if err != nil || deploy != nil || call.To != permit2 || !bytes.HasPrefix(call.Data, selector) {
+		t.Errorf("wrong settlement call: %v %x", err, call.Data[:4])
+	}
+
+	cases := []struct {
+		edit func(p2 *all712.Permit2Message)
+		code all712.ErrorCode
+	}{
+		{func(p2 *all712.Permit2Message) { p2.Message.Amount = big.NewInt(10) }, all712.CodeAmountMismatch},
+		{func(p2 *all712.Permit2Message) { p2.Message.Witness.PayTo = p2.Message.Owner }, all712.CodeRecipientMismatch},
+		{func(p2 *all712.Permit2Message) { p2.Message.Witness.Resource = "https://shop/other" }, all712.CodeInvalidPayload},
+		{func(p2 *all712.Permit2Message) { p2.Message.Spender = p2.Message.Owner }, all712.CodeRecipientMismatch},
+		{func(p2 *all712.Permit2Message) { p2.Domain.VerifyingContract = p2.Message.Token }, all712.CodeInvalidPayload},
+		{func(p2 *all712.Permit2Message) { p2.Message.Deadline = big.NewInt(time.Now().Unix() - 1) }, all712.CodeExpired},
+	}
+	for i, tc := range cases {
+		if _, _, err := FormallyVerifyPermit2Envelope(sign(tc.edit)); all712.CodeOf(err) != tc.code {
+			t.Errorf("case %v: expected %s, got %v", i, tc.code, err)
+		}
+	}
+
+	// signed by someone else for an owner without code
+	testNode.eoa.Store(true)
+	envelope = sign(func(p2 *all712.Permit2Message) { p2.Message.Owner = common.HexToAddress(testPayTo) })
+	if _, _, err := FormallyVerifyPermit2Envelope(envelope); all712.CodeOf(err) != all712.CodeInvalidSignature {
+		t.Errorf("foreign signature: %v", err)
+	}
+}
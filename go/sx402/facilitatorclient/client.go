@@ -0,0 +1,278 @@
+// Package facilitatorclient talks to an x402 facilitator: /verify, /settle and the helper endpoints
+// of this facilitator (supported, markup, permitnonce, receiptraw).
+// Requests are retried with backoff on network errors, 429 and 5xx, and fail over across
+// the facilitator URLs. Retrying /settle is safe, the facilitator settles an authorization once.
+package facilitatorclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/san-lab/sx402/all712"
+)
+
+// StatusError is a non-2xx answer of the facilitator
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("facilitator %s: %d %s: %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// Temporary is true for the answers worth retrying
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+var ErrNoURL = errors.New("no facilitator URL")
+
+type Client struct {
+	HTTP    *http.Client
+	Header  http.Header   // sent with every request, e.g. Authorization
+	Retries int           // extra rounds over all the URLs
+	Backoff time.Duration // before the first retry round, doubles each round
+
+	mu        sync.Mutex
+	urls      []string
+	preferred int // the URL that answered last
+}
+
+// New makes a client for the facilitator(s), given as the base URL like "http://localhost:3010/facilitator".
+// Several URLs are tried in turn, starting from the one that answered last.
+func New(urls ...string) *Client {
+	c := &Client{
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+		Header:  http.Header{},
+		Retries: 2,
+		Backoff: 200 * time.Millisecond,
+	}
+	for _, u := range urls {
+		if u = strings.TrimRight(strings.TrimSpace(u), "/"); len(u) > 0 {
+			c.urls = append(c.urls, u)
+		}
+	}
+	return c
+}
+
+// WithBearer sets an Authorization: Bearer header
+func (c *Client) WithBearer(token string) *Client {
+	c.Header.Set("Authorization", "Bearer "+token)
+	return c
+}
+
+// URL is the facilitator currently in use
+func (c *Client) URL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.urls) == 0 {
+		return ""
+	}
+	return c.urls[c.preferred]
+}
+
+// Verify asks the facilitator whether the payment is good; a rejected payment is not an error,
+// it comes back with IsValid false.
+func (c *Client) Verify(ctx context.Context, envelope *all712.Envelope) (*types.VerifyResponse, error) {
+	response := new(types.VerifyResponse)
+	err := c.post(ctx, "/verify", envelope, response)
+	return response, err
+}
+
+// Settle has the facilitator execute the payment. When the facilitator refuses, the error
+// comes together with its SettleResponse if it sent one, ErrorReason tells why.
+func (c *Client) Settle(ctx context.Context, envelope *all712.Envelope) (*types.SettleResponse, error) {
+	response := new(types.SettleResponse)
+	err := c.post(ctx, "/settle", envelope, response)
+	return response, err
+}
+
+// Kind is a scheme/network pair the facilitator settles
+type Kind struct {
+	Scheme  string `json:"Name"`
+	Network string `json:"Network"`
+}
+
+// Handler is a payment method of the facilitator
+type Handler struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Settlement  string `json:"settlement"`
+}
+
+type SupportedResponse struct {
+	Kinds    []Kind    `json:"kinds"`
+	Handlers []Handler `json:"handlers"`
+}
+
+func (c *Client) Supported(ctx context.Context) (*SupportedResponse, error) {
+	response := new(SupportedResponse)
+	err := c.get(ctx, "/supported", nil, response)
+	return response, err
+}
+
+// Markup is what the facilitator keeps of a payment with the scheme; dstEid is empty for same-chain payments
+func (c *Client) Markup(ctx context.Context, scheme, network, dstEid string) (*big.Int, error) {
+	q := url.Values{"scheme": {scheme}, "network": {network}}
+	if len(dstEid) > 0 {
+		q.Set("dstEid", dstEid)
+	}
+	var response struct {
+		Markup string `json:"markup"`
+	}
+	if err := c.get(ctx, "/markup", q, &response); err != nil {
+		return nil, err
+	}
+	return parseInt("markup", response.Markup)
+}
+
+// PermitNonce is the next EIP-2612 nonce of the owner on the asset
+func (c *Client) PermitNonce(ctx context.Context, network, asset, owner string) (*big.Int, error) {
+	q := url.Values{"network": {network}, "asset": {asset}, "owner": {owner}}
+	var response struct {
+		Nonce string `json:"nonce"`
+	}
+	if err := c.get(ctx, "/permitnonce", q, &response); err != nil {
+		return nil, err
+	}
+	return parseInt("nonce", response.Nonce)
+}
+
+// Receipt is where a settlement tx stands: "not_found", "pending", "found" (mined, maybe reverted)
+// or the ledger status of a settlement that went wrong.
+type Receipt struct {
+	Status     string          `json:"status"`
+	AwaitTime  float64         `json:"await_time,omitempty"`
+	Reverted   bool            `json:"reverted,omitempty"`
+	SettleTime string          `json:"settle_time,omitempty"`
+	Receipt    json.RawMessage `json:"receipt,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+func (c *Client) Receipt(ctx context.Context, network, tx string) (*Receipt, error) {
+	response := new(Receipt)
+	err := c.get(ctx, "/receiptraw", url.Values{"network": {network}, "tx": {tx}}, response)
+	return response, err
+}
+
+func parseInt(name, s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("facilitator sent a bad %s: %q", name, s)
+	}
+	return n, nil
+}
+
+func (c *Client) post(ctx context.Context, path string, body any, out any) error {
+	bts, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+	return c.do(ctx, http.MethodPost, path, nil, bts, out)
+}
+
+func (c *Client) get(ctx context.Context, path string, q url.Values, out any) error {
+	return c.do(ctx, http.MethodGet, path, q, nil, out)
+}
+
+// do goes over the URLs, starting from the preferred one, for 1+Retries rounds
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, body []byte, out any) error {
+	c.mu.Lock()
+	urls, start := c.urls, c.preferred
+	c.mu.Unlock()
+	if len(urls) == 0 {
+		return ErrNoURL
+	}
+
+	var lastErr error
+	backoff := c.Backoff
+	for round := 0; round <= c.Retries; round++ {
+		if round > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w (last error: %v)", ctx.Err(), lastErr)
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		for i := range urls {
+			idx := (start + i) % len(urls)
+			err := c.once(ctx, method, urls[idx]+path, q, body, out)
+			if err == nil {
+				c.mu.Lock()
+				c.preferred = idx
+				c.mu.Unlock()
+				return nil
+			}
+			if !retryable(ctx, err) {
+				return err
+			}
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Temporary()
+	}
+	return true // could not reach it
+}
+
+func (c *Client) once(ctx context.Context, method, u string, q url.Values, body []byte, out any) error {
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("facilitator %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	bts, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("facilitator %s: %w", u, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		// the settle and verify refusals carry a response worth keeping
+		json.Unmarshal(bts, out)
+		return &StatusError{URL: u, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(bts))}
+	}
+	if err := json.Unmarshal(bts, out); err != nil {
+		return fmt.Errorf("error parsing the response of %s: %w", u, err)
+	}
+	return nil
+}
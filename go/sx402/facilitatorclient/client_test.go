@@ -0,0 +1,109 @@
+package facilitatorclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/san-lab/sx402/all712"
+)
+
+func testEnvelope() *all712.Envelope {
+	return &all712.Envelope{X402Version: 1, PaymentPayload: &all712.PaymentPayload{X402Version: 1, Scheme: "exact", Network: "base-sepolia"}}
+}
+
+func TestFailover(t *testing.T) {
+	var downHits, upHits atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downHits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upHits.Add(1)
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/facilitator/markup" || r.URL.Query().Get("dstEid") != "40245" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"markup":"250"}`))
+	}))
+	defer up.Close()
+
+	c := New(down.URL+"/facilitator", up.URL+"/facilitator/").WithBearer("s3cret")
+	c.Backoff = time.Millisecond
+	m, err := c.Markup(context.Background(), "PZ_toBase", "arbitrum-sepolia", "40245")
+	if err != nil || m.Int64() != 250 {
+		t.Fatalf("markup %v, %v", m, err)
+	}
+	if c.URL() != up.URL+"/facilitator" {
+		t.Errorf("did not stick to the facilitator that answered: %s", c.URL())
+	}
+	// the next call goes straight to the one that works
+	c.Markup(context.Background(), "PZ_toBase", "arbitrum-sepolia", "40245")
+	if downHits.Load() != 1 || upHits.Load() != 2 {
+		t.Errorf("down hit %v, up hit %v times", downHits.Load(), upHits.Load())
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"isValid":true}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.Backoff = time.Millisecond
+	res, err := c.Verify(context.Background(), testEnvelope())
+	if err != nil || !res.IsValid || hits.Load() != 3 {
+		t.Fatalf("valid %v, err %v after %v tries", res.IsValid, err, hits.Load())
+	}
+
+	hits.Store(0)
+	c.Retries = 1
+	if _, err := c.Verify(context.Background(), testEnvelope()); err == nil || hits.Load() != 2 {
+		t.Errorf("expected to give up after 2 tries, got %v after %v", err, hits.Load())
+	}
+
+	// a deadline stops the retries
+	hits.Store(-100)
+	c.Retries, c.Backoff = 10, time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Verify(ctx, testEnvelope()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline, got %v", err)
+	}
+}
+
+func TestSettleRefused(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"success":false,"errorReason":"insufficient_funds","network":"base-sepolia"}`))
+	}))
+	defer srv.Close()
+
+	res, err := New(srv.URL).Settle(context.Background(), testEnvelope())
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a 400 StatusError, got %v", err)
+	}
+	if res.ErrorReason == nil || *res.ErrorReason != "insufficient_funds" {
+		t.Errorf("the settle response got lost: %+v", res)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("a refusal was retried %v times", hits.Load()-1)
+	}
+}
@@ -3,19 +3,52 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"strings"
 
 	"github.com/san-lab/sx402/facilitator"
+	"github.com/san-lab/sx402/mockstore/store"
+	"github.com/san-lab/sx402/paywall"
 	"golang.org/x/term"
 )
 
 func main() {
 	withDemoStore := flag.Bool("demoStore", false, "starts the demo store under /store")
 	password := flag.String("password", "", "keyfile password")
+	flag.StringVar(&facilitator.SchemesFile, "schemes", facilitator.SchemesFile, "scheme configuration file")
+	flag.DurationVar(&facilitator.SchemesReload, "reloadSchemes", 0, "how often to check the scheme file for changes (0 - never)")
+	flag.StringVar(&facilitator.LedgerFile, "ledger", facilitator.LedgerFile, "settlement ledger file (empty - keep in memory)")
+	flag.DurationVar(&facilitator.ReplaceAfter, "replaceAfter", facilitator.ReplaceAfter, "re-price settlement transactions not mined within this time")
+	flag.DurationVar(&facilitator.BatchWindow, "batchWindow", 0, "collect exact settlements this long and send them in one Multicall3 transaction (0 - no batching)")
+	flag.IntVar(&facilitator.BatchSize, "batchSize", facilitator.BatchSize, "send a batch as soon as it has this many settlements")
+	flag.BoolVar(&facilitator.SimulateSettle, "simulateSettle", false, "dry-run every settlement with eth_call right before broadcasting it")
+	flag.StringVar(&facilitator.KeystoreDir, "keystore", "", "take the facilitator account from this keystore directory instead of the keyfile")
+	flag.StringVar(&facilitator.RemoteSignerURL, "remoteSigner", "", "sign with a remote signer at this URL instead of a local key")
+	flag.StringVar(&facilitator.RemoteSignerType, "remoteSignerType", facilitator.RemoteSignerType, "remote signer protocol: web3signer or clef")
+	flag.StringVar(&facilitator.SignerAddress, "signerAddress", "", "facilitator account in the keystore/remote signer (default - the first one)")
+	flag.StringVar(&facilitator.WalletsFile, "wallets", "", "JSON list of facilitator wallets and their networks (replaces the single account flags)")
+	storeFacilitators := flag.String("storeFacilitator", strings.Join(store.FacilitatorURLs, ","), "comma separated facilitator URLs the demo store pays through")
+	flag.StringVar(&store.FacilitatorToken, "storeFacilitatorToken", "", "bearer token the demo store sends to its facilitator")
+	proxyManifest := flag.String("proxy", "", "route/price manifest; runs only a paywalled reverse proxy to its upstream, no facilitator")
+	proxyFacilitators := flag.String("proxyFacilitator", "", "comma separated facilitator URLs the proxy pays through (default - the ones of the manifest)")
 	flag.Parse()
+	store.FacilitatorURLs = strings.Split(*storeFacilitators, ",")
+
+	if len(*proxyManifest) > 0 {
+		manifest, err := paywall.LoadManifest(*proxyManifest)
+		if err != nil {
+			log.Fatal("error loading the proxy manifest:", err)
+		}
+		if len(*proxyFacilitators) > 0 {
+			manifest.Facilitators = strings.Split(*proxyFacilitators, ",")
+		}
+		log.Fatal(paywall.ServeProxy(manifest))
+	}
+
 	var passwordBytes []byte
 	var err error
-	if len(*password) == 0 {
+	if len(*password) == 0 && len(facilitator.RemoteSignerURL) == 0 {
 		fmt.Print("Enter facilitator's keyfile password: ")
 		passwordBytes, err = term.ReadPassword(int(os.Stdin.Fd()))
 		fmt.Println() // move to next line after input
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/san-lab/sx402/mockstore/store"
+	"github.com/san-lab/sx402/schemes"
 )
 
 func main() {
+	if err := schemes.LoadSchemes("../config/schemes.json"); err != nil {
+		log.Fatal(err)
+	}
+
 	r := gin.Default()
 
 	// Load HTML templates
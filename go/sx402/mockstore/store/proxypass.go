@@ -1,12 +1,11 @@
 package store
 
 import (
-	"fmt"
-	"io"
+	"errors"
 	"net/http"
-	"net/url"
 
 	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/facilitatorclient"
 )
 
 func permitNonceProxyHandler(c *gin.Context) {
@@ -19,40 +18,22 @@ func permitNonceProxyHandler(c *gin.Context) {
 		return
 	}
 
-	// Build facilitator URL with query params
-	facilitatorURL, err := url.Parse(fmt.Sprintf("%s/permitnonce", facilitatorURI))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid facilitator base URL"})
-		return
-	}
-
-	q := facilitatorURL.Query()
-	q.Set("network", network)
-	q.Set("asset", asset)
-	q.Set("owner", owner)
-	facilitatorURL.RawQuery = q.Encode()
-
-	// Forward the GET request to the facilitator
-	resp, err := http.Get(facilitatorURL.String())
+	nonce, err := Facilitator().PermitNonce(c.Request.Context(), network, asset, owner)
 	if err != nil {
+		// pass the facilitator's own refusal on, anything else is a gateway problem
+		var se *facilitatorclient.StatusError
+		if errors.As(err, &se) && !se.Temporary() {
+			c.Data(se.StatusCode, "application/json", []byte(se.Body))
+			return
+		}
 		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to contact facilitator"})
 		return
 	}
-	defer resp.Body.Close()
 
-	// Copy status code from facilitator response
-	c.Status(resp.StatusCode)
-
-	// Copy headers from facilitator response (optional, or filter as needed)
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Writer.Header().Add(key, value)
-		}
-	}
-
-	// Copy body from facilitator response
-	if _, err := io.Copy(c.Writer, resp.Body); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read facilitator response"})
-		return
-	}
+	c.JSON(http.StatusOK, gin.H{
+		"network": network,
+		"asset":   asset,
+		"owner":   owner,
+		"nonce":   nonce.String(),
+	})
 }
@@ -59,10 +59,12 @@ func ResourceHandler(c *gin.Context) {
 	}
 
 	time.Sleep(time.Second)
-	_, status := state.GetPendingReceipt(common.HexToHash(txHash), c.GetString("network"))
+	rec, ok := state.GetSettlement(common.HexToHash(txHash), c.GetString("network"))
 
-	if status {
+	if ok && rec.Status == state.StatusConfirmed {
 		data.Status = "Settled"
+	} else if ok && rec.Status == state.StatusPending {
+		data.Status = "Pending"
 	}
 
 	c.Status(http.StatusOK)
@@ -4,13 +4,33 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/facilitatorclient"
 )
 
 var StorePrefix = "mockstore"
 
+// The facilitators the store sends its payments to, tried in order; the token goes as a bearer token
+var FacilitatorURLs = []string{"http://localhost:3010/facilitator"}
+var FacilitatorToken string
+
+var facilitator *facilitatorclient.Client
+var facilitatorOnce sync.Once
+
+// Facilitator is the client of the configured facilitators
+func Facilitator() *facilitatorclient.Client {
+	facilitatorOnce.Do(func() {
+		facilitator = facilitatorclient.New(FacilitatorURLs...)
+		if len(FacilitatorToken) > 0 {
+			facilitator.WithBearer(FacilitatorToken)
+		}
+	})
+	return facilitator
+}
+
 func Start(router *gin.Engine, tmpl *template.Template) {
 	log.Println("starting the demo store")
 
@@ -21,8 +41,8 @@ func Start(router *gin.Engine, tmpl *template.Template) {
 
 	chainids := map[string]uint64{}
 
-	for k, v := range evmbinding.ChainIDs {
-		chainids[k] = v.Uint64()
+	for _, n := range evmbinding.Networks() {
+		chainids[n.Name] = n.ChainID.Uint64()
 	}
 	store.GET("/", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "index.html", gin.H{"ChainIDs": chainids})
@@ -0,0 +1,154 @@
+package paywall
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/schemes"
+)
+
+// PaymentKey is where the Gin adapter leaves the *Payment in the gin context
+const PaymentKey = "x402.payment"
+
+// UsageKey is where the Gin adapter leaves the Usage of a metered (upto) payment in the gin context
+const UsageKey = "x402.usage"
+
+// Usage is how the handler of a metered (upto) payment tells what the request consumed, in units of the asset.
+// The adapters settle for that once the handler is done. A handler that does not report is misconfigured:
+// nothing is charged and the request fails with 500, rather than charging the whole authorization.
+type Usage func(consumed *big.Int)
+
+type paymentKey struct{}
+type usageKey struct{}
+
+// FromContext is the payment of a request that went through the paywall
+func FromContext(ctx context.Context) (*Payment, bool) {
+	p, ok := ctx.Value(paymentKey{}).(*Payment)
+	return p, ok
+}
+
+// UsageFromContext is the usage report of a request with a metered payment
+func UsageFromContext(ctx context.Context) (Usage, bool) {
+	u, ok := ctx.Value(usageKey{}).(Usage)
+	return u, ok
+}
+
+func withPayment(r *http.Request, p *Payment) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), paymentKey{}, p))
+}
+
+func withUsage(r *http.Request, u Usage) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), usageKey{}, u))
+}
+
+// Handler puts the route in front of a net/http handler. Metered payments are settled after the handler,
+// for the usage it reports, and its response is held back until then.
+func (p *Paywall) Handler(route *Route, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payment := p.Authorize(w, r, route)
+		if payment == nil {
+			return
+		}
+		r = withPayment(r, payment)
+		if metered(payment) {
+			held := newHeldResponse()
+			usage, report := usageReport()
+			next.ServeHTTP(held, withUsage(r, report))
+			p.settleHeld(w, r, payment, held, usage())
+			return
+		}
+		if !p.Settle(w, r, payment) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Gin is the route as gin middleware; the payment is in the context under PaymentKey and in the request context.
+// Metered payments also get their Usage there, and are settled after the handlers.
+func (p *Paywall) Gin(route *Route) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payment := p.Authorize(c.Writer, c.Request, route)
+		if payment == nil {
+			c.Abort()
+			return
+		}
+		c.Set(PaymentKey, payment)
+		c.Request = withPayment(c.Request, payment)
+		if metered(payment) {
+			held := newHeldResponse()
+			usage, report := usageReport()
+			c.Set(UsageKey, report)
+			c.Request = withUsage(c.Request, report)
+			w := c.Writer
+			c.Writer = &heldGinWriter{ResponseWriter: w, held: held}
+			c.Next()
+			c.Writer = w
+			p.settleHeld(w, c.Request, payment, held, usage())
+			return
+		}
+		if !p.Settle(c.Writer, c.Request, payment) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// metered payments are the upto ones, they pay for what the request consumed
+func metered(payment *Payment) bool {
+	scheme, err := schemes.GetScheme(payment.Envelope.PaymentPayload.Scheme, payment.Envelope.PaymentPayload.Network)
+	return err == nil && scheme.Type == schemes.UptoType
+}
+
+// usageReport is the Usage handed to the handler, and what it reported
+func usageReport() (func() *big.Int, Usage) {
+	var consumed *big.Int
+	return func() *big.Int { return consumed }, func(amount *big.Int) { consumed = amount }
+}
+
+// settleHeld settles a metered payment for the usage and lets the held response through.
+// A failed request is not charged, nor one that consumed nothing; one without a usage report is not delivered either.
+func (p *Paywall) settleHeld(w http.ResponseWriter, r *http.Request, payment *Payment, held *heldResponse, consumed *big.Int) {
+	if held.status >= 400 || (consumed != nil && consumed.Sign() == 0) {
+		held.writeTo(w)
+		return
+	}
+	if consumed == nil {
+		log.Printf("metered route %s reported no usage, not settling", r.URL.Path)
+		http.Error(w, "the resource did not report its usage", http.StatusInternalServerError)
+		return
+	}
+	if !p.SettleUsage(w, r, payment, consumed) {
+		return
+	}
+	held.writeTo(w)
+}
+
+// heldGinWriter keeps what the gin handlers write in a heldResponse
+type heldGinWriter struct {
+	gin.ResponseWriter
+	held *heldResponse
+}
+
+func (h *heldGinWriter) Header() http.Header               { return h.held.Header() }
+func (h *heldGinWriter) WriteHeader(status int)            { h.held.WriteHeader(status) }
+func (h *heldGinWriter) WriteHeaderNow()                   {}
+func (h *heldGinWriter) Write(b []byte) (int, error)       { return h.held.Write(b) }
+func (h *heldGinWriter) WriteString(s string) (int, error) { return h.held.Write([]byte(s)) }
+func (h *heldGinWriter) Written() bool                     { return h.held.status != 0 }
+func (h *heldGinWriter) Size() int {
+	if h.held.status == 0 {
+		return -1 // what gin says before anything is written
+	}
+	return h.held.body.Len()
+}
+func (h *heldGinWriter) Status() int {
+	if h.held.status == 0 {
+		return http.StatusOK
+	}
+	return h.held.status
+}
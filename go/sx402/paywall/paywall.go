@@ -0,0 +1,275 @@
+// Package paywall puts x402 payments in front of HTTP handlers. A Route says what a resource costs,
+// which schemes pay for it and who gets the money; the paywall answers unpaid requests with a 402,
+// verifies and settles the payment through the facilitator and lets paid requests through with
+// X-PAYMENT-RESPONSE (PAYMENT-RESPONSE for x402 v2 clients) set.
+package paywall
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/facilitatorclient"
+	"github.com/san-lab/sx402/schemes"
+)
+
+// v1 headers
+const X_PAYMENT_HEADER = "X-Payment"
+const X_PAYMENT_RESPONSE_HEADER = "X-PAYMENT-RESPONSE"
+
+// v2 headers
+const PAYMENT_SIGNATURE_HEADER = "PAYMENT-SIGNATURE"
+const PAYMENT_REQUIRED_HEADER = "PAYMENT-REQUIRED"
+const PAYMENT_RESPONSE_HEADER = "PAYMENT-RESPONSE"
+
+// USAGE_HEADER is how an upstream behind the proxy reports what a metered (upto) request consumed, in units of the asset
+const USAGE_HEADER = "X-PAYMENT-USAGE"
+
+// Price of a resource. Fiat is in the reference currency of the schemes (see schemes.Scheme.RefPrice)
+// and is converted for the schemes that know their refPrice. Units is in the smallest units of the asset
+// and is charged as it is by the schemes that don't. Set one or both.
+type Price struct {
+	Fiat  float64  `json:"fiat,omitempty"`
+	Units *big.Int `json:"units,omitempty"`
+}
+
+func Fiat(amount float64) Price { return Price{Fiat: amount} }
+func Units(amount int64) Price  { return Price{Units: big.NewInt(amount)} }
+
+// Amount is the price in units of the scheme's asset
+func (p Price) Amount(scheme *schemes.Scheme) (*big.Int, error) {
+	if p.Fiat > 0 && scheme.RefPrice > 0 {
+		unit := math.Pow10(int(scheme.Decimals))
+		amount, _ := new(big.Float).SetFloat64(math.Ceil(p.Fiat / scheme.RefPrice * unit)).Int(nil)
+		return amount, nil
+	}
+	if p.Units != nil {
+		return new(big.Int).Set(p.Units), nil
+	}
+	return nil, fmt.Errorf("no price for %s on %s: a fiat price needs the refPrice of the scheme", scheme.SchemeName, scheme.Network)
+}
+
+// Route is a paid resource
+type Route struct {
+	Price       Price
+	Accepts     []schemes.SchemeKey
+	PayTo       string
+	Resource    string // the URL in the requirements; default - the URL of the request
+	Description string
+	MimeType    string
+}
+
+// Payment is how a request got paid; Settlement is nil until it is settled
+type Payment struct {
+	Envelope   *all712.Envelope
+	Settlement *types.SettleResponse
+
+	accepts []*types.PaymentRequirements // for the 402 if settling fails
+}
+
+type Paywall struct {
+	Facilitator *facilitatorclient.Client
+}
+
+func New(facilitator *facilitatorclient.Client) *Paywall {
+	return &Paywall{Facilitator: facilitator}
+}
+
+// Requirements are the payment options of the route. Payer0 schemes are priced with the
+// facilitator's markup on top, so the payee still gets the full price.
+func (p *Paywall) Requirements(ctx context.Context, route *Route, resource string) []*types.PaymentRequirements {
+	accepts := []*types.PaymentRequirements{}
+	for _, key := range route.Accepts {
+		scheme, err := schemes.GetScheme(key.Name, key.Network)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		amount, err := route.Price.Amount(scheme)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if scheme.Type == schemes.Payer0Type {
+			markup, err := p.Facilitator.Markup(ctx, scheme.SchemeName, scheme.Network, scheme.DstEid)
+			if err != nil {
+				log.Println("no markup for", scheme.SchemeName, scheme.Network, err)
+			} else {
+				amount.Add(amount, markup)
+			}
+		}
+		req := scheme.Requirement(resource, amount.String(), route.PayTo)
+		req.Description = route.Description
+		req.MimeType = route.MimeType
+		accepts = append(accepts, req)
+	}
+	return accepts
+}
+
+// Check runs the x402 exchange for the request. A paid request gets its Payment back
+// with the payment response header set on w; otherwise the 402 is written to w and Check returns nil.
+func (p *Paywall) Check(w http.ResponseWriter, r *http.Request, route *Route) *Payment {
+	payment := p.Authorize(w, r, route)
+	if payment == nil || !p.Settle(w, r, payment) {
+		return nil
+	}
+	return payment
+}
+
+// Authorize is the first half of Check: the payment is verified, not settled yet.
+// Settle it once the resource was delivered.
+func (p *Paywall) Authorize(w http.ResponseWriter, r *http.Request, route *Route) *Payment {
+	resource := route.Resource
+	if len(resource) == 0 {
+		resource = requestURL(r)
+	}
+	accepts := p.Requirements(r.Context(), route, resource)
+
+	// v2 clients send PAYMENT-SIGNATURE, v1 clients X-Payment
+	header := r.Header.Get(PAYMENT_SIGNATURE_HEADER)
+	if header == "" {
+		header = r.Header.Get(X_PAYMENT_HEADER)
+	}
+	if header == "" {
+		PaymentRequired(w, accepts, "X-PAYMENT header is required")
+		return nil
+	}
+
+	ppld, v2, err := ParsePaymentHeader(header)
+	if err != nil {
+		PaymentRequired(w, accepts, "Bad X-Payment header: "+err.Error())
+		return nil
+	}
+	env := &all712.Envelope{X402Version: 1, PaymentPayload: ppld}
+	for _, req := range accepts {
+		if req.Network == ppld.Network && req.Scheme == ppld.Scheme {
+			env.PaymentRequirements = req
+			break
+		}
+	}
+	if env.PaymentRequirements == nil {
+		PaymentRequired(w, accepts, fmt.Sprintf("%s on %s is not accepted here", ppld.Scheme, ppld.Network))
+		return nil
+	}
+	// talk to the facilitator in the version of the client
+	if v2 != nil {
+		env.X402Version = v2.X402Version
+		env.Extensions = v2.Extensions
+	}
+
+	verified, err := p.Facilitator.Verify(r.Context(), env)
+	if err != nil {
+		PaymentRequired(w, accepts, "could not verify the payment: "+err.Error())
+		return nil
+	}
+	if !verified.IsValid {
+		reason := "invalid payment"
+		if verified.InvalidReason != nil {
+			reason = *verified.InvalidReason
+		}
+		PaymentRequired(w, accepts, reason)
+		return nil
+	}
+	return &Payment{Envelope: env, accepts: accepts}
+}
+
+// Settle has the facilitator execute an authorized payment and sets the payment response header on w.
+// When that fails the 402 is written to w.
+func (p *Paywall) Settle(w http.ResponseWriter, r *http.Request, payment *Payment) bool {
+	env, accepts := payment.Envelope, payment.accepts
+	settled, err := p.Facilitator.Settle(r.Context(), env)
+	if err == nil && !settled.Success {
+		err = fmt.Errorf("settlement failed")
+	}
+	if err != nil {
+		reason := err.Error()
+		if settled != nil && settled.ErrorReason != nil {
+			reason = *settled.ErrorReason
+		}
+		PaymentRequired(w, accepts, reason)
+		return false
+	}
+
+	name, value := paymentResponseHeader(env.X402Version, settled)
+	w.Header().Set(name, value)
+	payment.Settlement = settled
+	return true
+}
+
+// SettleUsage settles a metered (upto) payment for what the request consumed, at most the authorized amount.
+// The adapters call it after the handler with the Usage it reported; handlers that Authorize
+// themselves call it once they know the usage, instead of Settle.
+func (p *Paywall) SettleUsage(w http.ResponseWriter, r *http.Request, payment *Payment, consumed *big.Int) bool {
+	payment.Envelope.ConsumedAmount = consumed.String()
+	return p.Settle(w, r, payment)
+}
+
+// PaymentRequired writes a 402: the v1 body, and the v2 one in the PAYMENT-REQUIRED header
+func PaymentRequired(w http.ResponseWriter, accepts []*types.PaymentRequirements, reason string) {
+	w.Header().Set(PAYMENT_REQUIRED_HEADER, paymentRequiredHeader(accepts, reason))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPaymentRequired)
+	json.NewEncoder(w).Encode(map[string]any{
+		"x402Version": 1,
+		"error":       reason,
+		"accepts":     accepts,
+	})
+}
+
+// ParsePaymentHeader reads X-Payment or PAYMENT-SIGNATURE, base64 or plain JSON;
+// v2 is returned as sent next to its v1 form
+func ParsePaymentHeader(header string) (*all712.PaymentPayload, *all712.PaymentPayloadV2, error) {
+	headerbts := []byte(header)
+	if !strings.Contains(header, "{") { //Not pure json, let us try base64
+		var err error
+		headerbts, err = base64.StdEncoding.DecodeString(header)
+		if err != nil {
+			return nil, nil, fmt.Errorf("neither JSON nor base64")
+		}
+	}
+	return all712.ParsePaymentPayload(headerbts)
+}
+
+// paymentRequiredHeader is the v2 402 body, base64 encoded
+func paymentRequiredHeader(accepts []*types.PaymentRequirements, reason string) string {
+	pr := all712.PaymentRequired{X402Version: 2, Error: reason, Accepts: []*all712.PaymentRequirementsV2{}}
+	for _, req := range accepts {
+		v2, resource := all712.ToV2(req)
+		pr.Accepts = append(pr.Accepts, v2)
+		pr.Resource = resource
+	}
+	bts, _ := json.Marshal(pr)
+	return base64.StdEncoding.EncodeToString(bts)
+}
+
+// paymentResponseHeader is the settle response as a client of the version expects it
+func paymentResponseHeader(version int, response *types.SettleResponse) (string, string) {
+	wire := *response
+	network, _ := all712.NetworkName(response.Network)
+	wire.Network = all712.WireNetwork(version, network)
+	bts, _ := json.Marshal(wire)
+	header := X_PAYMENT_RESPONSE_HEADER
+	if version >= 2 {
+		header = PAYMENT_RESPONSE_HEADER
+	}
+	return header, base64.StdEncoding.EncodeToString(bts)
+}
+
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); len(proto) > 0 {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
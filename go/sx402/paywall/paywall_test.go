@@ -0,0 +1,290 @@
+package paywall
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/gin-gonic/gin"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/facilitatorclient"
+	"github.com/san-lab/sx402/schemes"
+)
+
+func loadTestSchemes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schemes.json")
+	err := os.WriteFile(path, []byte(`[
+		{"scheme":"exact","type":"exac","network":"base-sepolia","asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e","extra":{"name":"USDC","version":"2"},"decimals":6,"refPrice":1},
+		{"scheme":"exact_EURS","type":"exac","network":"arbitrum-sepolia","asset":"0x8069a68DdaAFE2227f1AF283D23fD6FC2C59b6EC","extra":{"name":"EURS","version":"1"}},
+		{"scheme":"upto_USDC","type":"upto","network":"base-sepolia","asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e","extra":{"name":"USDC","facilitator":"0xfAc178B1C359D41e9162A1A6385380de96809048"}},
+		{"scheme":"PZ_toBase","type":"payer0","network":"arbitrum-sepolia","asset":"0xd7A4537267741d00F9654856b81F0AEe409B7aD9","extra":{"name":"EURSM","version":"1"},"dstEid":"40245"}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := schemes.LoadSchemes(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// settled counts the /settle calls of the fake facilitator, consumed keeps the consumedAmount of the last one
+var settled atomic.Int32
+var consumed atomic.Value
+
+// fakeFacilitator accepts everything but the payloads marked bad
+func fakeFacilitator(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/markup":
+			w.Write([]byte(`{"markup":"7"}`))
+		case "/verify":
+			var env all712.Envelope
+			json.NewDecoder(r.Body).Decode(&env)
+			if string(env.PaymentPayload.Payload) == `"bad"` {
+				reason := "invalid_signature"
+				json.NewEncoder(w).Encode(types.VerifyResponse{InvalidReason: &reason})
+				return
+			}
+			json.NewEncoder(w).Encode(types.VerifyResponse{IsValid: true})
+		case "/settle":
+			settled.Add(1)
+			var env all712.Envelope
+			json.NewDecoder(r.Body).Decode(&env)
+			consumed.Store(env.ConsumedAmount)
+			network := all712.WireNetwork(env.X402Version, env.PaymentPayload.Network)
+			json.NewEncoder(w).Encode(types.SettleResponse{Success: true, Transaction: "0xabc", Network: network})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+var testRoute = &Route{
+	Price: Price{Fiat: 0.5, Units: big.NewInt(1000)},
+	Accepts: []schemes.SchemeKey{
+		{Name: "exact", Network: "base-sepolia"},
+		{Name: "exact_EURS", Network: "arbitrum-sepolia"},
+		{Name: "PZ_toBase", Network: "arbitrum-sepolia"},
+		{Name: "nope", Network: "base-sepolia"},
+	},
+	PayTo: "0xCEF702Bd69926B13ab7150624daA7aFEE0300786",
+}
+
+func TestPriceAmount(t *testing.T) {
+	usdc := &schemes.Scheme{Decimals: 6, RefPrice: 1}
+	eurc := &schemes.Scheme{Decimals: 6, RefPrice: 1.08}
+	for _, tc := range []struct {
+		price  Price
+		scheme *schemes.Scheme
+		want   string
+	}{
+		{Fiat(0.5), usdc, "500000"},
+		{Fiat(1), eurc, "925926"}, // rounded up
+		{Price{Fiat: 1, Units: big.NewInt(42)}, &schemes.Scheme{}, "42"},
+		{Units(42), usdc, "42"},
+	} {
+		amount, err := tc.price.Amount(tc.scheme)
+		if err != nil || amount.String() != tc.want {
+			t.Errorf("%+v: got %v (%v), expected %s", tc.price, amount, err, tc.want)
+		}
+	}
+	if _, err := Fiat(1).Amount(&schemes.Scheme{}); err == nil {
+		t.Error("fiat price without a refPrice")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	loadTestSchemes(t)
+	pw := New(facilitatorclient.New(fakeFacilitator(t).URL))
+	var paid *Payment
+	srv := httptest.NewServer(pw.Handler(testRoute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paid, _ = FromContext(r.Context())
+		w.Write([]byte("content"))
+	})))
+	defer srv.Close()
+
+	// unpaid
+	resp, err := http.Get(srv.URL + "/thing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Accepts []*types.PaymentRequirements `json:"accepts"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPaymentRequired || len(resp.Header.Get(PAYMENT_REQUIRED_HEADER)) == 0 {
+		t.Fatalf("expected a 402 with PAYMENT-REQUIRED, got %v", resp.Status)
+	}
+	amounts := map[string]string{}
+	for _, req := range body.Accepts {
+		amounts[req.Scheme] = req.MaxAmountRequired
+		if req.Resource != srv.URL+"/thing" || req.PayTo != testRoute.PayTo {
+			t.Errorf("wrong requirement %+v", req)
+		}
+	}
+	if amounts["exact"] != "500000" || amounts["exact_EURS"] != "1000" || amounts["PZ_toBase"] != "1007" || len(amounts) != 3 {
+		t.Errorf("unexpected amounts %v", amounts)
+	}
+
+	// paid, v1
+	pay := func(header, payload string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/thing", nil)
+		req.Header.Set(header, base64.StdEncoding.EncodeToString([]byte(payload)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	resp = pay(X_PAYMENT_HEADER, `{"x402Version":1,"scheme":"exact","network":"base-sepolia","payload":{}}`)
+	if resp.StatusCode != http.StatusOK || paid == nil || paid.Settlement.Transaction != "0xabc" {
+		t.Fatalf("v1 payment not let through: %v, %+v", resp.Status, paid)
+	}
+	if sr := decodeSettle(t, resp.Header.Get(X_PAYMENT_RESPONSE_HEADER)); sr.Network != "base-sepolia" {
+		t.Errorf("unexpected X-PAYMENT-RESPONSE %+v", sr)
+	}
+
+	// paid, v2
+	paid = nil
+	resp = pay(PAYMENT_SIGNATURE_HEADER, `{"x402Version":2,"accepted":{"scheme":"exact","network":"eip155:84532","amount":"500000"},"payload":{}}`)
+	if resp.StatusCode != http.StatusOK || paid == nil || paid.Envelope.X402Version != 2 {
+		t.Fatalf("v2 payment not let through: %v", resp.Status)
+	}
+	if sr := decodeSettle(t, resp.Header.Get(PAYMENT_RESPONSE_HEADER)); sr.Network != "eip155:84532" {
+		t.Errorf("unexpected PAYMENT-RESPONSE %+v", sr)
+	}
+}
+
+func decodeSettle(t *testing.T, header string) *types.SettleResponse {
+	bts, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settled := new(types.SettleResponse)
+	if err := json.Unmarshal(bts, settled); err != nil {
+		t.Fatal(err)
+	}
+	return settled
+}
+
+func TestGin(t *testing.T) {
+	loadTestSchemes(t)
+	pw := New(facilitatorclient.New(fakeFacilitator(t).URL))
+	router := gin.New()
+	router.GET("/thing", pw.Gin(testRoute), func(c *gin.Context) {
+		if _, ok := c.Get(PaymentKey); !ok {
+			t.Error("no payment in the context")
+		}
+		c.String(http.StatusOK, "content")
+	})
+
+	for payload, status := range map[string]int{
+		`{"x402Version":1,"scheme":"exact","network":"base-sepolia","payload":{}}`:    http.StatusOK,
+		`{"x402Version":1,"scheme":"exact","network":"base-sepolia","payload":"bad"}`: http.StatusPaymentRequired,
+		`{"x402Version":1,"scheme":"exact","network":"amoy","payload":{}}`:            http.StatusPaymentRequired,
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/thing", nil)
+		req.Header.Set(X_PAYMENT_HEADER, payload)
+		router.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("%s: got %v, expected %v", payload, w.Code, status)
+		}
+	}
+}
+
+func TestSettleUsage(t *testing.T) {
+	pw := New(facilitatorclient.New(fakeFacilitator(t).URL))
+	req := &types.PaymentRequirements{Scheme: "upto_USDC", Network: "base-sepolia", MaxAmountRequired: "10000"}
+	for _, version := range []int{1, 2} {
+		payment := &Payment{Envelope: &all712.Envelope{X402Version: version,
+			PaymentPayload:      &all712.PaymentPayload{X402Version: version, Scheme: "upto_USDC", Network: "base-sepolia", Payload: []byte(`{}`)},
+			PaymentRequirements: req}}
+		w := httptest.NewRecorder()
+		if !pw.SettleUsage(w, httptest.NewRequest(http.MethodGet, "/llm", nil), payment, big.NewInt(2500)) {
+			t.Fatalf("v%v: not settled: %s", version, w.Body)
+		}
+		if consumed.Load() != "2500" {
+			t.Errorf("v%v: the facilitator got consumedAmount %v", version, consumed.Load())
+		}
+	}
+}
+
+func TestMetered(t *testing.T) {
+	loadTestSchemes(t)
+	pw := New(facilitatorclient.New(fakeFacilitator(t).URL))
+	route := &Route{Price: Units(10000), Accepts: []schemes.SchemeKey{{Name: "upto_USDC", Network: "base-sepolia"}}, PayTo: testRoute.PayTo}
+	payload := `{"x402Version":1,"scheme":"upto_USDC","network":"base-sepolia","payload":{}}`
+
+	// the handler reports the usage, fails on /broken and forgets to report on /silent;
+	// neither is charged, and the unreported response is not delivered
+	serve := func(w http.ResponseWriter, r *http.Request, report Usage) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path == "/silent" {
+			w.Write([]byte("tokens"))
+			return
+		}
+		report(big.NewInt(2500))
+		w.Write([]byte("tokens"))
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", pw.Handler(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, ok := UsageFromContext(r.Context())
+		if !ok {
+			t.Fatal("no usage report in the request context")
+		}
+		serve(w, r, report)
+	})))
+	router := gin.New()
+	router.Any("/*path", pw.Gin(route), func(c *gin.Context) {
+		report, ok := c.Get(UsageKey)
+		if !ok {
+			t.Fatal("no usage report in the gin context")
+		}
+		serve(c.Writer, c.Request, report.(Usage))
+	})
+
+	for name, h := range map[string]http.Handler{"net/http": mux, "gin": router} {
+		settled.Store(0)
+		consumed.Store("")
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/llm", nil)
+		req.Header.Set(X_PAYMENT_HEADER, payload)
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != "tokens" || len(w.Header().Get(X_PAYMENT_RESPONSE_HEADER)) == 0 {
+			t.Errorf("%s: answered %v %q", name, w.Code, w.Body)
+		}
+		if settled.Load() != 1 || consumed.Load() != "2500" {
+			t.Errorf("%s: settled %v times for %v", name, settled.Load(), consumed.Load())
+		}
+
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, "/broken", nil)
+		req.Header.Set(X_PAYMENT_HEADER, payload)
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusInternalServerError || settled.Load() != 1 {
+			t.Errorf("%s: failed request answered %v, settled %v times", name, w.Code, settled.Load())
+		}
+
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, "/silent", nil)
+		req.Header.Set(X_PAYMENT_HEADER, payload)
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusInternalServerError || w.Body.String() == "tokens" || settled.Load() != 1 {
+			t.Errorf("%s: unreported usage answered %v %q, settled %v times", name, w.Code, w.Body, settled.Load())
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package paywall
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/san-lab/sx402/facilitatorclient"
+	"github.com/san-lab/sx402/schemes"
+)
+
+// Manifest describes a paywalled upstream: which paths cost what. The patterns are http.ServeMux
+// patterns ("GET /weather", "/reports/"); paths no route matches get a 404. Routes without
+// accepts/payTo take the ones of the manifest.
+type Manifest struct {
+	Listen           string          `json:"listen"`
+	Upstream         string          `json:"upstream"`
+	Facilitators     []string        `json:"facilitators"`
+	FacilitatorToken string          `json:"facilitatorToken,omitempty"`
+	PayTo            string          `json:"payTo"`
+	Accepts          []SchemeRef     `json:"accepts"`
+	Routes           []ManifestRoute `json:"routes"`
+}
+
+type SchemeRef struct {
+	Scheme  string `json:"scheme"`
+	Network string `json:"network"`
+}
+
+type ManifestRoute struct {
+	Pattern     string      `json:"pattern"`
+	Price       Price       `json:"price"`
+	Accepts     []SchemeRef `json:"accepts,omitempty"`
+	PayTo       string      `json:"payTo,omitempty"`
+	Description string      `json:"description,omitempty"`
+	MimeType    string      `json:"mimeType,omitempty"`
+}
+
+func LoadManifest(filename string) (*Manifest, error) {
+	bts, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(bts, m); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", filename, err)
+	}
+	if len(m.Listen) == 0 {
+		m.Listen = ":3020"
+	}
+	if len(m.Facilitators) == 0 {
+		m.Facilitators = []string{"http://localhost:3010/facilitator"}
+	}
+	return m, nil
+}
+
+// ServeProxy listens on the manifest's address and proxies the paid requests upstream
+func ServeProxy(manifest *Manifest) error {
+	client := facilitatorclient.New(manifest.Facilitators...)
+	if len(manifest.FacilitatorToken) > 0 {
+		client.WithBearer(manifest.FacilitatorToken)
+	}
+	handler, err := New(client).Proxy(manifest)
+	if err != nil {
+		return err
+	}
+	log.Printf("x402 proxy on %s for %s", manifest.Listen, manifest.Upstream)
+	return http.ListenAndServe(manifest.Listen, handler)
+}
+
+// Proxy is the handler for the manifest's routes. A paid request goes upstream with the payment headers
+// stripped, and the payment is settled only if the upstream answers below 400.
+// The upstream response is held back until then, so it does not stream.
+func (p *Paywall) Proxy(manifest *Manifest) (http.Handler, error) {
+	upstream, err := url.Parse(manifest.Upstream)
+	if err != nil || len(upstream.Host) == 0 {
+		return nil, fmt.Errorf("bad upstream URL: %q", manifest.Upstream)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+
+	mux := http.NewServeMux()
+	for _, mr := range manifest.Routes {
+		route := &Route{
+			Price:       mr.Price,
+			PayTo:       mr.PayTo,
+			Description: mr.Description,
+			MimeType:    mr.MimeType,
+		}
+		if len(route.PayTo) == 0 {
+			route.PayTo = manifest.PayTo
+		}
+		refs := mr.Accepts
+		if len(refs) == 0 {
+			refs = manifest.Accepts
+		}
+		for _, ref := range refs {
+			route.Accepts = append(route.Accepts, schemes.SchemeKey{Name: ref.Scheme, Network: ref.Network})
+		}
+		if len(route.PayTo) == 0 || len(route.Accepts) == 0 {
+			return nil, fmt.Errorf("route %q needs payTo and accepts", mr.Pattern)
+		}
+		if err := register(mux, mr.Pattern, p.proxyRoute(route, proxy)); err != nil {
+			return nil, err
+		}
+	}
+	return mux, nil
+}
+
+// register turns the ServeMux panic on a bad or duplicate pattern into an error
+func register(mux *http.ServeMux, pattern string, h http.Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("route %q: %v", pattern, r)
+		}
+	}()
+	mux.Handle(pattern, h)
+	return nil
+}
+
+func (p *Paywall) proxyRoute(route *Route, proxy http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payment := p.Authorize(w, r, route)
+		if payment == nil {
+			return
+		}
+		r.Header.Del(X_PAYMENT_HEADER)
+		r.Header.Del(PAYMENT_SIGNATURE_HEADER)
+
+		upstream := newHeldResponse()
+		proxy.ServeHTTP(upstream, r)
+		if metered(payment) {
+			p.settleHeld(w, r, payment, upstream, upstreamUsage(upstream))
+			return
+		}
+		if upstream.status >= 400 {
+			// not delivered, not paid
+			upstream.writeTo(w)
+			return
+		}
+		if !p.Settle(w, r, payment) {
+			return
+		}
+		upstream.writeTo(w)
+	})
+}
+
+// heldResponse keeps the upstream response until the payment is settled
+// upstreamUsage is what the upstream says a metered request consumed, in its USAGE_HEADER; nil if it does not say.
+// The header is for the proxy only, it does not go back to the client.
+func upstreamUsage(upstream *heldResponse) *big.Int {
+	header := upstream.Header().Get(USAGE_HEADER)
+	upstream.Header().Del(USAGE_HEADER)
+	if len(header) == 0 {
+		return nil
+	}
+	consumed, ok := new(big.Int).SetString(strings.TrimSpace(header), 10)
+	if !ok || consumed.Sign() < 0 {
+		log.Printf("bad %s from the upstream: %q", USAGE_HEADER, header)
+		return nil
+	}
+	return consumed
+}
+
+type heldResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newHeldResponse() *heldResponse {
+	return &heldResponse{header: http.Header{}}
+}
+
+func (h *heldResponse) Header() http.Header { return h.header }
+
+func (h *heldResponse) WriteHeader(status int) {
+	if h.status == 0 {
+		h.status = status
+	}
+}
+
+func (h *heldResponse) Write(b []byte) (int, error) {
+	h.WriteHeader(http.StatusOK)
+	return h.body.Write(b)
+}
+
+func (h *heldResponse) writeTo(w http.ResponseWriter) {
+	h.WriteHeader(http.StatusOK)
+	for k, v := range h.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(h.status)
+	w.Write(h.body.Bytes())
+}
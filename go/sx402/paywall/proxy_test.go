@@ -0,0 +1,104 @@
+package paywall
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/san-lab/sx402/facilitatorclient"
+)
+
+func TestProxy(t *testing.T) {
+	loadTestSchemes(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Header.Get(X_PAYMENT_HEADER)) > 0 {
+			t.Error("payment header forwarded upstream")
+		}
+		if r.URL.Path == "/reports/broken" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path == "/llm" {
+			w.Header().Set(USAGE_HEADER, "2500")
+		}
+		w.Write([]byte("upstream " + r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	manifest := &Manifest{
+		Upstream: upstream.URL,
+		PayTo:    "0xCEF702Bd69926B13ab7150624daA7aFEE0300786",
+		Accepts:  []SchemeRef{{"exact", "base-sepolia"}},
+		Routes: []ManifestRoute{
+			{Pattern: "GET /weather", Price: Fiat(0.001)},
+			{Pattern: "/reports/", Price: Fiat(0.01)},
+			{Pattern: "/llm", Price: Units(10000), Accepts: []SchemeRef{{"upto_USDC", "base-sepolia"}}},
+			{Pattern: "/silent", Price: Units(10000), Accepts: []SchemeRef{{"upto_USDC", "base-sepolia"}}},
+		},
+	}
+	handler, err := New(facilitatorclient.New(fakeFacilitator(t).URL)).Proxy(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	get := func(path string, paid bool) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+path, nil)
+		if paid {
+			scheme := "exact"
+			if path == "/llm" || path == "/silent" {
+				scheme = "upto_USDC"
+			}
+			req.Header.Set(X_PAYMENT_HEADER, `{"x402Version":1,"scheme":"`+scheme+`","network":"base-sepolia","payload":{}}`)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	settled.Store(0)
+	if resp, _ := get("/weather", false); resp.StatusCode != http.StatusPaymentRequired {
+		t.Errorf("unpaid request got %v", resp.Status)
+	}
+	if resp, _ := get("/free", true); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("a path without a route got %v", resp.Status)
+	}
+	resp, body := get("/weather", true)
+	if resp.StatusCode != http.StatusOK || body != "upstream /weather" || len(resp.Header.Get(X_PAYMENT_RESPONSE_HEADER)) == 0 {
+		t.Errorf("paid request got %v %q", resp.Status, body)
+	}
+	if settled.Load() != 1 {
+		t.Errorf("settled %v times, expected once", settled.Load())
+	}
+	// the upstream failed, so nobody pays
+	if resp, _ := get("/reports/broken", true); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("upstream error came back as %v", resp.Status)
+	}
+	if settled.Load() != 1 {
+		t.Error("settled a request the upstream failed")
+	}
+
+	// metered routes are settled for the usage the upstream reports, and nothing without a report
+	consumed.Store("")
+	resp, body = get("/llm", true)
+	if resp.StatusCode != http.StatusOK || body != "upstream /llm" || len(resp.Header.Get(USAGE_HEADER)) > 0 {
+		t.Errorf("metered request got %v %q %v", resp.Status, body, resp.Header)
+	}
+	if settled.Load() != 2 || consumed.Load() != "2500" {
+		t.Errorf("metered request settled %v times for %v", settled.Load(), consumed.Load())
+	}
+	if resp, _ := get("/silent", true); resp.StatusCode != http.StatusInternalServerError || settled.Load() != 2 {
+		t.Errorf("unreported usage got %v, settled %v times", resp.Status, settled.Load())
+	}
+
+	manifest.Routes = append(manifest.Routes, ManifestRoute{Pattern: "GET /weather", Price: Fiat(1)})
+	if _, err := New(nil).Proxy(manifest); err == nil {
+		t.Error("duplicate route accepted")
+	}
+}
@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SpendLedger keeps the signed spends
+type SpendLedger interface {
+	Record(s *Spend) error
+	Since(t time.Time) ([]*Spend, error) // oldest first
+	Close() error
+}
+
+// MemoryLedger forgets everything on restart
+type MemoryLedger struct {
+	mu     sync.Mutex
+	spends []*Spend
+}
+
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{}
+}
+
+func (ml *MemoryLedger) Record(s *Spend) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.spends = append(ml.spends, s)
+	sort.SliceStable(ml.spends, func(i, j int) bool { return ml.spends[i].Time.Before(ml.spends[j].Time) })
+	return nil
+}
+
+func (ml *MemoryLedger) Since(t time.Time) ([]*Spend, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	i := sort.Search(len(ml.spends), func(i int) bool { return !ml.spends[i].Time.Before(t) })
+	return append([]*Spend{}, ml.spends[i:]...), nil
+}
+
+func (ml *MemoryLedger) Close() error { return nil }
+
+var spendsBucket = []byte("spends") // unix nanos + sequence -> json spend
+
+// BoltLedger keeps the spends in an embedded BoltDB file
+type BoltLedger struct {
+	db *bolt.DB
+}
+
+func OpenBoltLedger(path string) (*BoltLedger, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open the spend ledger %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(spendsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltLedger{db: db}, nil
+}
+
+func (bl *BoltLedger) Record(s *Spend) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return bl.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(spendsBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := binary.BigEndian.AppendUint64(timeKey(s.Time), seq)
+		return b.Put(key, data)
+	})
+}
+
+func (bl *BoltLedger) Since(t time.Time) (spends []*Spend, err error) {
+	spends = []*Spend{}
+	err = bl.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(spendsBucket).Cursor()
+		for k, v := c.Seek(timeKey(t)); k != nil; k, v = c.Next() {
+			s := new(Spend)
+			if err := json.Unmarshal(v, s); err != nil {
+				return err
+			}
+			spends = append(spends, s)
+		}
+		return nil
+	})
+	return
+}
+
+func (bl *BoltLedger) Close() error {
+	return bl.db.Close()
+}
+
+// timeKey sorts the way time does, for the times after 1970
+func timeKey(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
@@ -0,0 +1,214 @@
+// Package policy keeps paying agents within their budgets. An Engine checks every payment
+// against the Policy before it gets signed and books what was signed in a spend ledger,
+// which the rolling daily and monthly budgets are counted from.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrDenied is behind every policy violation
+var ErrDenied = errors.New("payment denied by policy")
+
+// Violation says which rule a payment broke
+type Violation struct {
+	Rule   string
+	Reason string
+}
+
+func (v *Violation) Error() string { return fmt.Sprintf("%v: %s: %s", ErrDenied, v.Rule, v.Reason) }
+func (v *Violation) Unwrap() error { return ErrDenied }
+
+func deny(rule, format string, args ...any) error {
+	return &Violation{Rule: rule, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Caps are in the smallest units of the asset; nil - no cap
+type Caps struct {
+	PerRequest   *big.Int `json:"perRequest,omitempty"`
+	Daily        *big.Int `json:"daily,omitempty"`        // rolling 24 hours
+	Monthly      *big.Int `json:"monthly,omitempty"`      // rolling 30 days
+	ConfirmAbove *big.Int `json:"confirmAbove,omitempty"` // larger payments need a confirmation
+}
+
+type Policy struct {
+	// Caps per asset address. Assets not listed are not paid with.
+	Assets map[string]Caps `json:"assets"`
+	// Caps per host, counted per asset
+	Hosts map[string]Caps `json:"hosts,omitempty"`
+
+	// Empty allow lists allow everything that is not denied
+	AllowPayTo   []string `json:"allowPayTo,omitempty"`
+	DenyPayTo    []string `json:"denyPayTo,omitempty"`
+	AllowSchemes []string `json:"allowSchemes,omitempty"`
+	DenySchemes  []string `json:"denySchemes,omitempty"`
+}
+
+func LoadPolicy(filename string) (*Policy, error) {
+	bts, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	p := new(Policy)
+	if err := json.Unmarshal(bts, p); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", filename, err)
+	}
+	return p, nil
+}
+
+// Spend is one signed payment authorization
+type Spend struct {
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host"`
+	Network string    `json:"network"`
+	Asset   string    `json:"asset"`
+	PayTo   string    `json:"payTo"`
+	Scheme  string    `json:"scheme"`
+	Amount  *big.Int  `json:"amount"`
+}
+
+const day = 24 * time.Hour
+const month = 30 * day
+
+type Engine struct {
+	Policy *Policy
+	Ledger SpendLedger
+	// Confirm is asked about the payments above ConfirmAbove; without it they are denied
+	Confirm func(s *Spend) bool
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+func NewEngine(policy *Policy, ledger SpendLedger) *Engine {
+	if ledger == nil {
+		ledger = NewMemoryLedger()
+	}
+	return &Engine{Policy: policy, Ledger: ledger, now: time.Now}
+}
+
+// Guard runs sign only if the spend is within the policy and books it when sign succeeds.
+// Spends are checked one at a time, so concurrent payments cannot overrun a budget together.
+func (e *Engine) Guard(s *Spend, sign func() error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s.Time = e.now()
+	if err := e.check(s); err != nil {
+		return err
+	}
+	if err := sign(); err != nil {
+		return err
+	}
+	return e.Ledger.Record(s)
+}
+
+// Check tells whether the spend would pass, without booking it
+func (e *Engine) Check(s *Spend) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s.Time = e.now()
+	return e.check(s)
+}
+
+func (e *Engine) check(s *Spend) error {
+	p := e.Policy
+	if s.Amount == nil || s.Amount.Sign() < 0 {
+		return deny("amount", "no amount")
+	}
+	if !listed(p.AllowPayTo, s.PayTo, true) || listed(p.DenyPayTo, s.PayTo, false) {
+		return deny("payTo", "%s is not an allowed payee", s.PayTo)
+	}
+	if !listed(p.AllowSchemes, s.Scheme, true) || listed(p.DenySchemes, s.Scheme, false) {
+		return deny("scheme", "%s is not an allowed scheme", s.Scheme)
+	}
+
+	assetCaps, ok := lookupAsset(p.Assets, s.Asset)
+	if !ok {
+		return deny("asset", "%s is not an allowed asset", s.Asset)
+	}
+	history, err := e.Ledger.Since(s.Time.Add(-month))
+	if err != nil {
+		return fmt.Errorf("could not read the spend ledger: %w", err)
+	}
+	sameAsset := func(h *Spend) bool { return sameAddress(h.Asset, s.Asset) }
+	if err := e.within("asset "+s.Asset, assetCaps, s, history, sameAsset); err != nil {
+		return err
+	}
+	if hostCaps, ok := p.Hosts[s.Host]; ok {
+		sameHost := func(h *Spend) bool { return h.Host == s.Host && sameAsset(h) }
+		if err := e.within("host "+s.Host, hostCaps, s, history, sameHost); err != nil {
+			return err
+		}
+	}
+	confirm := assetCaps.ConfirmAbove
+	if hostCaps, ok := p.Hosts[s.Host]; ok && hostCaps.ConfirmAbove != nil && (confirm == nil || hostCaps.ConfirmAbove.Cmp(confirm) < 0) {
+		confirm = hostCaps.ConfirmAbove
+	}
+	if confirm != nil && s.Amount.Cmp(confirm) > 0 && (e.Confirm == nil || !e.Confirm(s)) {
+		return deny("confirm", "%v above %v was not confirmed", s.Amount, confirm)
+	}
+	return nil
+}
+
+// within checks the caps against the spends of the history that count
+func (e *Engine) within(rule string, caps Caps, s *Spend, history []*Spend, counts func(*Spend) bool) error {
+	if caps.PerRequest != nil && s.Amount.Cmp(caps.PerRequest) > 0 {
+		return deny(rule, "%v is over the %v per request", s.Amount, caps.PerRequest)
+	}
+	daily, monthly := new(big.Int).Set(s.Amount), new(big.Int).Set(s.Amount)
+	for _, h := range history {
+		if !counts(h) {
+			continue
+		}
+		monthly.Add(monthly, h.Amount)
+		if h.Time.After(s.Time.Add(-day)) {
+			daily.Add(daily, h.Amount)
+		}
+	}
+	if caps.Daily != nil && daily.Cmp(caps.Daily) > 0 {
+		return deny(rule, "daily budget of %v exceeded", caps.Daily)
+	}
+	if caps.Monthly != nil && monthly.Cmp(caps.Monthly) > 0 {
+		return deny(rule, "monthly budget of %v exceeded", caps.Monthly)
+	}
+	return nil
+}
+
+// listed: is the value on the list; an empty list answers ifEmpty
+func listed(list []string, value string, ifEmpty bool) bool {
+	if len(list) == 0 {
+		return ifEmpty
+	}
+	for _, l := range list {
+		if sameAddress(l, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func lookupAsset(assets map[string]Caps, asset string) (Caps, bool) {
+	for a, caps := range assets {
+		if sameAddress(a, asset) {
+			return caps, true
+		}
+	}
+	return Caps{}, false
+}
+
+// sameAddress compares addresses whatever their case, and anything else as it is
+func sameAddress(a, b string) bool {
+	if common.IsHexAddress(a) && common.IsHexAddress(b) {
+		return common.HexToAddress(a) == common.HexToAddress(b)
+	}
+	return strings.EqualFold(a, b)
+}
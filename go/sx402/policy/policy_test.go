@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"errors"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const usdc = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+const eurc = "0x08210F9170F89Ab7658F0B5E3fF39b0E03C594D4"
+const shop = "0xCEF702Bd69926B13ab7150624daA7aFEE0300786"
+
+func testPolicy() *Policy {
+	return &Policy{
+		Assets: map[string]Caps{
+			usdc: {PerRequest: big.NewInt(100), Daily: big.NewInt(250), Monthly: big.NewInt(1000), ConfirmAbove: big.NewInt(80)},
+			eurc: {},
+		},
+		Hosts:       map[string]Caps{"cheap.example": {Daily: big.NewInt(50)}},
+		DenyPayTo:   []string{"0x000000000000000000000000000000000000dEaD"},
+		DenySchemes: []string{"permit_USDC"},
+	}
+}
+
+func spend(host, asset string, amount int64) *Spend {
+	return &Spend{Host: host, Network: "base-sepolia", Asset: asset, PayTo: shop, Scheme: "exact", Amount: big.NewInt(amount)}
+}
+
+// engine with a clock the test moves
+func testEngine(ledger SpendLedger) (*Engine, *time.Time) {
+	e := NewEngine(testPolicy(), ledger)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+	return e, &now
+}
+
+func rule(err error) string {
+	var v *Violation
+	if errors.As(err, &v) {
+		return v.Rule
+	}
+	return ""
+}
+
+func TestRules(t *testing.T) {
+	e, _ := testEngine(nil)
+	noSign := func() error { return nil }
+
+	for name, tc := range map[string]struct {
+		spend *Spend
+		rule  string
+	}{
+		"fine":          {spend("a.example", usdc, 50), ""},
+		"per request":   {spend("a.example", usdc, 101), "asset " + usdc},
+		"unknown asset": {spend("a.example", "0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582", 1), "asset"},
+		"uncapped":      {spend("a.example", eurc, 1000000), ""},
+		"denied payee":  {&Spend{Asset: eurc, PayTo: "0x000000000000000000000000000000000000dead", Scheme: "exact", Amount: big.NewInt(1)}, "payTo"},
+		"denied scheme": {&Spend{Asset: usdc, PayTo: shop, Scheme: "permit_USDC", Amount: big.NewInt(1)}, "scheme"},
+		"needs confirm": {spend("a.example", usdc, 90), "confirm"},
+	} {
+		if got := rule(e.Check(tc.spend)); got != tc.rule {
+			t.Errorf("%s: broke %q, expected %q", name, got, tc.rule)
+		}
+	}
+
+	e.Confirm = func(s *Spend) bool { return s.Amount.Int64() < 95 }
+	if err := e.Guard(spend("a.example", usdc, 90), noSign); err != nil {
+		t.Errorf("confirmed payment denied: %v", err)
+	}
+	if !errors.Is(e.Guard(spend("a.example", usdc, 99), noSign), ErrDenied) {
+		t.Error("unconfirmed payment let through")
+	}
+
+	// a failed signature is not a spend
+	failed := errors.New("no key")
+	if err := e.Guard(spend("a.example", usdc, 10), func() error { return failed }); err != failed {
+		t.Errorf("expected the signing error, got %v", err)
+	}
+	if spends, _ := e.Ledger.Since(time.Time{}); len(spends) != 1 {
+		t.Errorf("%v spends booked, expected 1", len(spends))
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	e, now := testEngine(nil)
+	noSign := func() error { return nil }
+
+	for i := 0; i < 3; i++ {
+		if err := e.Guard(spend("a.example", usdc, 80), noSign); err != nil {
+			t.Fatalf("payment %v: %v", i, err)
+		}
+	}
+	if rule(e.Check(spend("a.example", usdc, 20))) != "asset "+usdc {
+		t.Error("daily budget not enforced")
+	}
+	// the day rolls over, the month does not
+	for d := 0; d < 3; d++ {
+		*now = now.Add(25 * time.Hour)
+		for i := 0; i < 3; i++ {
+			if err := e.Guard(spend("b.example", usdc, 80), noSign); err != nil {
+				t.Fatalf("day %v payment %v: %v", d, i, err)
+			}
+		}
+	}
+	*now = now.Add(25 * time.Hour)
+	if err := e.Check(spend("b.example", usdc, 80)); err == nil || !strings.Contains(err.Error(), "monthly") {
+		t.Errorf("monthly budget not enforced: %v", err)
+	}
+	*now = now.Add(month)
+	if err := e.Check(spend("b.example", usdc, 80)); err != nil {
+		t.Errorf("budget still spent a month later: %v", err)
+	}
+
+	// host caps count only that host
+	if err := e.Guard(spend("cheap.example", eurc, 40), noSign); err != nil {
+		t.Fatal(err)
+	}
+	if rule(e.Check(spend("cheap.example", eurc, 20))) != "host cheap.example" {
+		t.Error("host budget not enforced")
+	}
+	if err := e.Check(spend("other.example", eurc, 20)); err != nil {
+		t.Errorf("host budget applied to another host: %v", err)
+	}
+}
+
+func TestBoltLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spends.db")
+	ledger, err := OpenBoltLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, now := testEngine(ledger)
+	for i := 0; i < 3; i++ {
+		*now = now.Add(time.Hour)
+		if err := e.Guard(spend("a.example", usdc, 80), func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ledger.Close()
+
+	// the budget survives a restart
+	ledger, err = OpenBoltLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+	e2 := NewEngine(testPolicy(), ledger)
+	e2.now = e.now
+	if rule(e2.Check(spend("a.example", usdc, 20))) != "asset "+usdc {
+		t.Error("daily budget forgotten after reopening the ledger")
+	}
+	spends, err := ledger.Since(now.Add(-90 * time.Minute))
+	if err != nil || len(spends) != 2 || spends[0].Amount.Int64() != 80 {
+		t.Errorf("unexpected spends since: %v %v", spends, err)
+	}
+}
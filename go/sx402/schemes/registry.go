@@ -0,0 +1,228 @@
+package schemes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/san-lab/sx402/evmbinding"
+)
+
+// schemeRegistry holds the schemes loaded from the config file.
+// A reload swaps the whole map, so lookups done by in-flight requests keep
+// working on the copy of the Scheme they already got.
+type schemeRegistry struct {
+	mu      sync.RWMutex
+	schemes map[SchemeKey]Scheme
+}
+
+var registry = &schemeRegistry{schemes: map[SchemeKey]Scheme{}}
+
+func (r *schemeRegistry) get(key SchemeKey) (Scheme, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.schemes[key]
+	return s, ok
+}
+
+func (r *schemeRegistry) swap(schemes map[SchemeKey]Scheme) {
+	r.mu.Lock()
+	r.schemes = schemes
+	r.mu.Unlock()
+}
+
+// Supported lists the scheme/network pairs currently loaded, sorted for stable output
+func Supported() []SchemeKey {
+	registry.mu.RLock()
+	keys := make([]SchemeKey, 0, len(registry.schemes))
+	for k := range registry.schemes {
+		keys = append(keys, k)
+	}
+	registry.mu.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Name != keys[j].Name {
+			return keys[i].Name < keys[j].Name
+		}
+		return keys[i].Network < keys[j].Network
+	})
+	return keys
+}
+
+// All returns a snapshot of the loaded schemes, in the order of Supported()
+func All() []Scheme {
+	all := []Scheme{}
+	for _, k := range Supported() {
+		if s, ok := registry.get(k); ok {
+			all = append(all, s)
+		}
+	}
+	return all
+}
+
+// LoadSchemes reads, validates and activates the scheme file.
+// On any error the previously loaded schemes stay in place.
+func LoadSchemes(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read scheme file %s: %w", path, err)
+	}
+	schemes, err := ParseSchemes(data)
+	if err != nil {
+		return fmt.Errorf("invalid scheme file %s: %w", path, err)
+	}
+	registry.swap(schemes)
+	log.Printf("Loaded %v schemes from %s", len(schemes), path)
+	return nil
+}
+
+// ParseSchemes decodes a JSON list of schemes and validates every entry
+func ParseSchemes(data []byte) (map[SchemeKey]Scheme, error) {
+	var list []Scheme
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	schemes := map[SchemeKey]Scheme{}
+	for i, s := range list {
+		if err := s.normalize(); err != nil {
+			return nil, fmt.Errorf("scheme #%v (%s on %s): %w", i, s.SchemeName, s.Network, err)
+		}
+		key := SchemeKey{s.SchemeName, s.Network}
+		if _, dup := schemes[key]; dup {
+			return nil, fmt.Errorf("scheme #%v: duplicate %s on %s", i, s.SchemeName, s.Network)
+		}
+		schemes[key] = s
+	}
+	return schemes, nil
+}
+
+var schemeTypes = struct {
+	sync.RWMutex
+	known map[string]bool
+}{known: map[string]bool{ExactType: true, PermitType: true, Permit2Type: true, UptoType: true, Payer0Legacy: true, Payer0Type: true}}
+
+// RegisterType makes entries of a new scheme type loadable; whoever handles the type registers it
+func RegisterType(schemeType string) {
+	schemeTypes.Lock()
+	defer schemeTypes.Unlock()
+	schemeTypes.known[schemeType] = true
+}
+
+func knownType(schemeType string) bool {
+	schemeTypes.RLock()
+	defer schemeTypes.RUnlock()
+	return schemeTypes.known[schemeType]
+}
+
+// normalize validates the entry and folds dstEid into the ExtraInfo, which is where the clients look for it
+func (s *Scheme) normalize() error {
+	if len(s.SchemeName) == 0 {
+		return fmt.Errorf("missing scheme name")
+	}
+	if !knownType(s.Type) {
+		return fmt.Errorf("unknown scheme type: %q", s.Type)
+	}
+	if _, ok := evmbinding.GetNetwork(s.Network); !ok {
+		return fmt.Errorf("unknown network: %q", s.Network)
+	}
+	if !common.IsHexAddress(s.Asset) {
+		return fmt.Errorf("invalid asset address: %q", s.Asset)
+	}
+	if s.Extra == nil {
+		return fmt.Errorf("missing extra info")
+	}
+	for _, field := range []string{"name", "version"} {
+		// Permit2 signs over its own domain, the token's version does not matter
+		if len((*s.Extra)[field]) == 0 && !(s.UsesPermit2() && field == "version") {
+			return fmt.Errorf("missing extra.%s", field)
+		}
+	}
+
+	if s.RefPrice < 0 {
+		return fmt.Errorf("negative refPrice")
+	}
+
+	// the spender the payer signs; these schemes are pinned to that one facilitator wallet, not the pool
+	if (s.Type == PermitType || s.UsesPermit2()) && !common.IsHexAddress((*s.Extra)["facilitator"]) {
+		return fmt.Errorf("permit schemes need a valid extra.facilitator")
+	}
+
+	if len(s.DstEid) == 0 {
+		s.DstEid = (*s.Extra)["dstEid"]
+	}
+	switch s.Type {
+	case Payer0Legacy, Payer0Type:
+		eid, err := strconv.ParseUint(s.DstEid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("cross-chain schemes need a numeric dstEid: %q", s.DstEid)
+		}
+		dst, ok := evmbinding.NetworkByEid(uint32(eid))
+		if !ok {
+			return fmt.Errorf("dstEid %v does not belong to any known network", eid)
+		}
+		if dst.Name == s.Network {
+			return fmt.Errorf("dstEid %v points back at the source network", eid)
+		}
+		s.Extra = s.Extra.SetDstEid(s.DstEid)
+	default:
+		if len(s.DstEid) > 0 {
+			return fmt.Errorf("dstEid is only valid for cross-chain schemes")
+		}
+	}
+	return nil
+}
+
+// UsesPermit2 is true for the schemes whose payments are Permit2 transfers by extra.facilitator
+func (s *Scheme) UsesPermit2() bool {
+	return s.Type == Permit2Type || s.Type == UptoType
+}
+
+// WatchSchemes polls the file every interval and reloads it whenever its modification time changes, until ctx is done.
+// A broken file is reported and ignored, the running set of schemes is kept.
+// The file as it is now counts as loaded; the returned channel is closed once the watcher stopped.
+func WatchSchemes(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	var lastMod time.Time
+	if fi, err := os.Stat(path); err == nil {
+		lastMod = fi.ModTime()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				log.Println("scheme watcher:", err)
+				continue
+			}
+			if !fi.ModTime().After(lastMod) {
+				continue
+			}
+			lastMod = fi.ModTime()
+			err = LoadSchemes(path)
+			if err != nil {
+				log.Println("scheme reload rejected:", err)
+			}
+			if reloaded != nil {
+				reloaded(err)
+			}
+		}
+	}()
+	return stopped
+}
+
+// reloaded, when set, hears the outcome of every reload of the watcher
+var reloaded func(err error)
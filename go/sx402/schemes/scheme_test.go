@@ -1 +1,127 @@
 package schemes
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShippedSchemeFile(t *testing.T) {
+	err := LoadSchemes("../config/schemes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := GetScheme(Scheme_Payer0Plus_toBase, "arbitrum-sepolia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*s.Extra)["dstEid"] != "40245" {
+		t.Errorf("dstEid not folded into extra: %v", *s.Extra)
+	}
+	if len(Supported()) != len(All()) {
+		t.Error("Supported and All disagree")
+	}
+}
+
+func TestSchemeValidation(t *testing.T) {
+	bad := map[string]string{
+		"type":      `[{"scheme":"x","type":"bogus","network":"amoy","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"name":"A","version":"1"}}]`,
+		"network":   `[{"scheme":"x","type":"exac","network":"mars","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"name":"A","version":"1"}}]`,
+		"asset":     `[{"scheme":"x","type":"exac","network":"amoy","asset":"0x41","extra":{"name":"A","version":"1"}}]`,
+		"version":   `[{"scheme":"x","type":"exac","network":"amoy","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"name":"A"}}]`,
+		"dstEid":    `[{"scheme":"x","type":"payer0","network":"amoy","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"name":"A","version":"1"}}]`,
+		"permit":    `[{"scheme":"x","type":"permit","network":"amoy","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"name":"A","version":"1"}}]`,
+		"permit2":   `[{"scheme":"x","type":"permit2","network":"amoy","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"name":"A"}}]`,
+		"upto":      `[{"scheme":"x","type":"upto","network":"amoy","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"version":"1","facilitator":"0xfAc178B1C359D41e9162A1A6385380de96809048"}}]`,
+		"duplicate": `[{"scheme":"x","type":"exac","network":"amoy","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"name":"A","version":"1"}},{"scheme":"x","type":"exac","network":"amoy","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"name":"B","version":"1"}}]`,
+	}
+	for name, data := range bad {
+		if _, err := ParseSchemes([]byte(data)); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestSchemeReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schemes.json")
+	first := `[{"scheme":"exact","type":"exac","network":"amoy","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"name":"USDC","version":"2"}}]`
+	second := `[{"scheme":"exact_X","type":"exac","network":"amoy","asset":"0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582","extra":{"name":"X","version":"1"}}]`
+
+	if err := os.WriteFile(path, []byte(first), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadSchemes(path); err != nil {
+		t.Fatal(err)
+	}
+	held, err := GetScheme("exact", "amoy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outcomes := make(chan error, 10)
+	reloaded = func(err error) { outcomes <- err }
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := WatchSchemes(ctx, path, 10*time.Millisecond)
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+		reloaded = nil
+	})
+	// the new file gets its mtime, a second apart, before it is moved in place: the watcher sees each change
+	// once and in full, even with a coarse filesystem clock
+	rewrite := func(data string, at time.Time) error {
+		next := path + ".next"
+		if err := os.WriteFile(next, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(next, at, at); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(next, path); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-outcomes:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("the watcher did not reload the file")
+			return nil
+		}
+	}
+
+	if err := rewrite("not json", time.Now().Add(time.Second)); err == nil {
+		t.Error("a broken file was loaded")
+	}
+	if _, err := GetScheme("exact", "amoy"); err != nil {
+		t.Error("a broken file must not replace the loaded schemes")
+	}
+
+	if err := rewrite(second, time.Now().Add(2*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetScheme("exact_X", "amoy"); err != nil {
+		t.Error("reload did not pick up the new scheme")
+	}
+	if _, err := GetScheme("exact", "amoy"); err == nil {
+		t.Error("removed scheme still served")
+	}
+	if held.Asset != "0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582" {
+		t.Error("scheme held by a caller changed under it")
+	}
+}
+
+func TestSchemeValue(t *testing.T) {
+	s := Scheme{Decimals: 6, RefPrice: 1.08}
+	v, ok := s.Value(big.NewInt(2500000))
+	if !ok || math.Abs(v-2.7) > 1e-9 {
+		t.Error("wrong value:", v, ok)
+	}
+	s.RefPrice = 0
+	if _, ok := s.Value(big.NewInt(1)); ok {
+		t.Error("no value without a reference price")
+	}
+}
@@ -0,0 +1,72 @@
+package signing
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/san-lab/sx402/evmbinding"
+)
+
+// SignatureMethod is how the signature of a payer got validated
+type SignatureMethod string
+
+const (
+	MethodECDSA   SignatureMethod = "ecdsa"   // recovered to the payer
+	MethodERC1271 SignatureMethod = "erc1271" // the payer is a wallet contract and its isValidSignature said yes
+)
+
+// VerifyPayerSignature checks that the signature over digest is the payer's, the way the OFT3009CC tokens do:
+// ecrecover first, and for payers with code the ERC-1271 isValidSignature of their contract.
+// EIP-6492 wrapped signatures of wallets yet to be deployed are checked against their deployment.
+// The chainID picks the network of the contract call.
+func VerifyPayerSignature(payer common.Address, digest, signature []byte, chainID *big.Int) (SignatureMethod, error) {
+	if cf, ok := ParseERC6492(signature); ok {
+		return verifyCounterfactual(payer, digest, cf, chainID)
+	}
+	recovered, recoverErr := recoverSigner(digest, signature)
+	if recoverErr == nil && recovered == payer {
+		return MethodECDSA, nil
+	}
+	if recoverErr == nil {
+		recoverErr = fmt.Errorf("recovered address differ: %s expected %s", recovered.Hex(), payer.Hex())
+	}
+
+	network, ok := evmbinding.NetworkByChainID(chainID)
+	if !ok {
+		return "", recoverErr
+	}
+	contract, err := evmbinding.IsContract(network.Name, payer)
+	if err != nil {
+		return "", fmt.Errorf("unable to look for the code of %s: %w", payer.Hex(), err)
+	}
+	if !contract {
+		return "", recoverErr
+	}
+	valid, err := evmbinding.IsValidERC1271Signature(network.Name, payer, [32]byte(digest), signature)
+	if err != nil {
+		return "", fmt.Errorf("unable to check the ERC-1271 signature of %s: %w", payer.Hex(), err)
+	}
+	if !valid {
+		return "", fmt.Errorf("wallet contract %s rejects the signature", payer.Hex())
+	}
+	return MethodERC1271, nil
+}
+
+// recoverSigner takes v as 0/1 or 27/28
+func recoverSigner(digest, signature []byte) (common.Address, error) {
+	if len(signature) != 65 {
+		return common.Address{}, fmt.Errorf("invalid signature length: %v", len(signature))
+	}
+	adjusted := make([]byte, 65)
+	copy(adjusted, signature)
+	if adjusted[64] >= 27 {
+		adjusted[64] -= 27
+	}
+	pub, err := crypto.SigToPub(digest, adjusted)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
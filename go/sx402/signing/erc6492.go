@@ -0,0 +1,84 @@
+package signing
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/san-lab/sx402/evmbinding"
+)
+
+// MethodERC6492 is the signature of a wallet contract that is not deployed yet, checked against its deployment
+const MethodERC6492 SignatureMethod = "erc6492"
+
+// The suffix of EIP-6492 wrapped signatures
+var erc6492Magic = common.FromHex("0x6492649264926492649264926492649264926492649264926492649264926492")
+
+var erc6492Args = func() abi.Arguments {
+	addressType, _ := abi.NewType("address", "", nil)
+	bytesType, _ := abi.NewType("bytes", "", nil)
+	return abi.Arguments{{Type: addressType}, {Type: bytesType}, {Type: bytesType}}
+}()
+
+// Counterfactual is what an EIP-6492 signature carries: how to deploy the wallet and its own signature
+type Counterfactual struct {
+	Factory     common.Address
+	FactoryData []byte
+	Signature   []byte // what the deployed wallet's isValidSignature takes
+}
+
+// DeployCall is the factory call that deploys the wallet
+func (cf *Counterfactual) DeployCall() evmbinding.Call {
+	return evmbinding.Call{To: cf.Factory, Data: cf.FactoryData}
+}
+
+// ParseERC6492 unwraps abi.encode(factory, factoryCalldata, signature) ++ magic, ok is false for other signatures
+func ParseERC6492(signature []byte) (cf *Counterfactual, ok bool) {
+	if len(signature) < len(erc6492Magic) || !bytes.Equal(signature[len(signature)-len(erc6492Magic):], erc6492Magic) {
+		return nil, false
+	}
+	values, err := erc6492Args.Unpack(signature[:len(signature)-len(erc6492Magic)])
+	if err != nil || len(values) != 3 {
+		return nil, false
+	}
+	return &Counterfactual{Factory: values[0].(common.Address), FactoryData: values[1].([]byte), Signature: values[2].([]byte)}, true
+}
+
+// WrapERC6492 is the signature of an undeployed wallet the way its SDK sends it
+func WrapERC6492(cf *Counterfactual) ([]byte, error) {
+	packed, err := erc6492Args.Pack(cf.Factory, cf.FactoryData, cf.Signature)
+	if err != nil {
+		return nil, err
+	}
+	return append(packed, erc6492Magic...), nil
+}
+
+// verifyCounterfactual checks a wrapped signature: with the wallet's isValidSignature once it is deployed,
+// against a simulated deployment before
+func verifyCounterfactual(payer common.Address, digest []byte, cf *Counterfactual, chainID *big.Int) (SignatureMethod, error) {
+	network, ok := evmbinding.NetworkByChainID(chainID)
+	if !ok {
+		return "", fmt.Errorf("unsupported chainID: %v", chainID)
+	}
+	deployed, err := evmbinding.IsContract(network.Name, payer)
+	if err != nil {
+		return "", fmt.Errorf("unable to look for the code of %s: %w", payer.Hex(), err)
+	}
+	method := MethodERC6492
+	var valid bool
+	if deployed {
+		method = MethodERC1271
+		valid, err = evmbinding.IsValidERC1271Signature(network.Name, payer, [32]byte(digest), cf.Signature)
+	} else {
+		valid, err = evmbinding.IsValidCounterfactualSignature(network.Name, payer, [32]byte(digest), cf.DeployCall(), cf.Signature)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to check the signature of %s: %w", payer.Hex(), err)
+	}
+	if !valid {
+		return "", fmt.Errorf("wallet %s (deployed: %v) rejects the signature", payer.Hex(), deployed)
+	}
+	return method, nil
+}
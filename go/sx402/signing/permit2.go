@@ -0,0 +1,44 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/san-lab/sx402/all712"
+)
+
+// SignPermit2Transfer signs the message and puts the signature in it
+func SignPermit2Transfer(p2 *all712.Permit2Message, privateKey *ecdsa.PrivateKey) ([]byte, error) {
+	digest, err := p2.Digest()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := crypto.Sign(digest, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign digest: %v", err)
+	}
+	signature[64] += 27
+	p2.Signature = hexutil.Encode(signature)
+	return signature, nil
+}
+
+// VerifyPermit2Signature checks that the owner signed the transfer, the way Permit2 does:
+// ecrecover for EOAs, isValidSignature for wallet contracts, EIP-6492 for wallets yet to be deployed
+func VerifyPermit2Signature(p2 *all712.Permit2Message) (owner common.Address, method SignatureMethod, err error) {
+	digest, err := p2.Digest()
+	if err != nil {
+		err = fmt.Errorf("error hashing the Permit2 transfer: %w", err)
+		return
+	}
+	sig, err := hexutil.Decode(p2.Signature)
+	if err != nil {
+		err = fmt.Errorf("error decoding the Permit2 signature: %w", err)
+		return
+	}
+	owner = p2.Message.Owner
+	method, err = VerifyPayerSignature(owner, digest, sig, p2.Domain.ChainID)
+	return
+}
@@ -72,8 +72,6 @@ func TestQuoteSend(t *testing.T) {
 		log.Fatalf("Failed to connect to Ethereum node: %v", err)
 	}
 
-	defer client.Close()
-
 	// Define your contract's deployed address.
 
 	// Instantiate the contract binding.
@@ -202,8 +200,6 @@ func TestCrossChainAuthSend(t *testing.T) {
 		log.Fatalf("Failed to connect to Ethereum node: %v", err)
 	}
 
-	defer client.Close()
-
 	// Define your contract's deployed address.
 
 	// Instantiate the contract binding.
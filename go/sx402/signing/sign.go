@@ -94,7 +94,7 @@ func CreateAuthorizationWithSignature(paymentReqs *types.PaymentRequirements, fr
 
 	asset := common.HexToAddress(paymentReqs.Asset)
 
-	chainID, ok := evmbinding.ChainIDs[ppld.Network]
+	chainID, ok := evmbinding.ChainID(ppld.Network)
 	if !ok {
 		return nil, errors.New("Unknown network: " + ppld.Network)
 	}
@@ -170,6 +170,19 @@ func VerifyTransferWithAuthorizationSignature(
 	chainID *big.Int,
 	tokenAddress common.Address,
 ) (recovered common.Address, nonce [32]byte, signature []byte, err error) {
+	recovered, nonce, signature, _, err = VerifyTransferWithAuthorization(signatureHex, auth, name, version, chainID, tokenAddress)
+	return
+}
+
+// VerifyTransferWithAuthorization also tells how the signature was validated, wallet contracts of the payer included
+func VerifyTransferWithAuthorization(
+	signatureHex string,
+	auth types.ExactEvmPayloadAuthorization,
+	name string,
+	version string,
+	chainID *big.Int,
+	tokenAddress common.Address,
+) (payer common.Address, nonce [32]byte, signature []byte, method SignatureMethod, err error) {
 
 	// Hash type: keccak256("TransferWithAuthorization(address from,address to,uint256 value,uint256 validAfter,uint256 validBefore,bytes32 nonce)")
 
@@ -219,62 +232,37 @@ func VerifyTransferWithAuthorizationSignature(
 		err = fmt.Errorf("error decoding signature: %w", err)
 		return
 	}
-	if len(signature) != 65 {
-		err = fmt.Errorf("invalid signature length: %v", len(signature))
-		return
-	}
-
-	adjustedSignature := make([]byte, 65)
-	copy(adjustedSignature, signature)
-
-	// Adjust V if needed
-	if adjustedSignature[64] >= 27 {
-		adjustedSignature[64] -= 27
-	}
 
-	pubKey, err := crypto.SigToPub(digest, adjustedSignature)
-	if err != nil {
+	if !common.IsHexAddress(auth.From) {
+		err = fmt.Errorf("invalid from address: %s", auth.From)
 		return
 	}
-	recovered = crypto.PubkeyToAddress(*pubKey)
-
-	// Compare recovered address with `from`
-	isValid := strings.Compare(strings.ToLower(recovered.Hex()), strings.ToLower(auth.From)) == 0
-	log.Println("Recovered:", recovered)
-	log.Println("From:", auth.From)
-	if !isValid {
-		err = fmt.Errorf("Recovered address differ: %s expected %s", recovered, auth.From)
-		return
+	payer = common.HexToAddress(auth.From)
+	method, err = VerifyPayerSignature(payer, digest, signature, chainID)
+	if err == nil {
+		log.Printf("Payer %s, signature: %s", payer, method)
 	}
-
 	return
 }
 
 func VerifyCrossChainAuthSignature(ccmsg *all712.CrossChainTransferMessage) (recoveredAddress common.Address, err error) {
+	recoveredAddress, _, err = VerifyCrossChainAuthorization(ccmsg)
+	return
+}
+
+// VerifyCrossChainAuthorization also tells how the signature was validated, wallet contracts of the payer included
+func VerifyCrossChainAuthorization(ccmsg *all712.CrossChainTransferMessage) (payer common.Address, method SignatureMethod, err error) {
 	digest, err := ccmsg.Digest()
 	if err != nil {
 		return //TODO: wrap
 	}
 
-	//sigbytes := common.Hex2Bytes(ccmsg.Signature)
-	sig := strings.TrimPrefix(ccmsg.Signature, "0x")
-	sigbytes, err := hex.DecodeString(sig)
+	sigbytes, err := hex.DecodeString(strings.TrimPrefix(ccmsg.Signature, "0x"))
 	if err != nil {
 		return
 	}
-	fmt.Println(len(sigbytes))
-	if sigbytes[64] >= 27 {
-		sigbytes[64] -= 27
-	}
-	pub, err := crypto.SigToPub(digest, sigbytes)
-	if err != nil {
-		return
-	}
-	recoveredAddress = crypto.PubkeyToAddress(*pub)
-	if recoveredAddress.Cmp(ccmsg.Authorization.From) != 0 {
-		err = fmt.Errorf("Wrong payer recovered: %s", recoveredAddress.Hex())
-	}
-
+	payer = ccmsg.Authorization.From
+	method, err = VerifyPayerSignature(payer, digest, sigbytes, ccmsg.Domain.ChainID)
 	return
 }
 
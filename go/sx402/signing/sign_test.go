@@ -12,12 +12,12 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/san-lab/sx402/all712"
-	"github.com/san-lab/sx402/evmbinding"
-	"github.com/san-lab/sx402/schemes"
 )
 
 var boss = common.HexToAddress("0xaab05558448C8a9597287Db9F61e2d751645B12a")
 
+const amoyUSDC = "0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582"
+
 func TestSignature(t *testing.T) {
 
 	privkeyhex := "56c11c2fee673894e85151857339066cd244d4932f23e660ce8502c867d0927e"
@@ -45,7 +45,7 @@ func TestSignature(t *testing.T) {
 		Nonce:       nonce.Hex(),
 	}
 
-	sig, err := SignERC3009Authorization(&auth, privkey, big.NewInt(80002), tokenName, tokenVersion, common.HexToAddress(schemes.AMOY_USDC))
+	sig, err := SignERC3009Authorization(&auth, privkey, big.NewInt(80002), tokenName, tokenVersion, common.HexToAddress(amoyUSDC))
 	if err != nil {
 		t.Error(err)
 	}
@@ -73,7 +73,7 @@ func TestAddSignature(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	preq.Asset = schemes.AMOY_USDC
+	preq.Asset = amoyUSDC
 
 	preq.MaxAmountRequired = "1234"
 
@@ -150,7 +150,7 @@ func TestMessage(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	payer, _, _, err := VerifyTransferWithAuthorizationSignature(sig1, *auth, "USDC", "2", big.NewInt(80002), common.HexToAddress(schemes.AMOY_USDC))
+	payer, _, _, err := VerifyTransferWithAuthorizationSignature(sig1, *auth, "USDC", "2", big.NewInt(80002), common.HexToAddress(amoyUSDC))
 	fmt.Println(payer, err)
 
 	privkeyhex := "56c11c2fee673894e85151857339066cd244d4932f23e660ce8502c867d0927e"
@@ -163,8 +163,8 @@ func TestMessage(t *testing.T) {
 	nonce := crypto.Keccak256Hash([]byte("SixthNonce"))
 	auth.Nonce = nonce.Hex()
 
-	sig2, err := SignERC3009Authorization(auth, privkey, big.NewInt(80002), "USDC", "2", common.HexToAddress(schemes.AMOY_USDC))
-	payer, _, _, err = VerifyTransferWithAuthorizationSignature(fmt.Sprintf("0x%x", sig2), *auth, "USDC", "2", big.NewInt(80002), common.HexToAddress(schemes.AMOY_USDC))
+	sig2, err := SignERC3009Authorization(auth, privkey, big.NewInt(80002), "USDC", "2", common.HexToAddress(amoyUSDC))
+	payer, _, _, err = VerifyTransferWithAuthorizationSignature(fmt.Sprintf("0x%x", sig2), *auth, "USDC", "2", big.NewInt(80002), common.HexToAddress(amoyUSDC))
 	fmt.Println(payer, err)
 
 }
@@ -191,7 +191,7 @@ func TestJMH(t *testing.T) {
 }
 
 func TestDomain(t *testing.T) {
-	dsh := all712.MakeDomainSeparator("USDC", "2", evmbinding.ChainIDs["amoy"], common.HexToAddress(schemes.AMOY_USDC))
+	dsh := all712.MakeDomainSeparator("USDC", "2", big.NewInt(80002), common.HexToAddress(amoyUSDC))
 
 	fmt.Println(dsh.Hex())
 }
@@ -0,0 +1,158 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	settlementsBucket = []byte("settlements") // id -> json record
+	byTxBucket        = []byte("bytx")        // network/txhash -> id
+	byKeyBucket       = []byte("bykey")       // network/kind/asset/payer/nonce -> id
+)
+
+// BoltStore keeps the settlement ledger in an embedded BoltDB file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open the ledger %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{settlementsBucket, byTxBucket, byKeyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (bs *BoltStore) Save(rec *SettlementRecord) error {
+	if len(rec.ID) == 0 {
+		return fmt.Errorf("settlement without an id")
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(settlementsBucket).Put([]byte(rec.ID), data); err != nil {
+			return err
+		}
+		if len(rec.Key) > 0 {
+			if err := tx.Bucket(byKeyBucket).Put([]byte(rec.Key), []byte(rec.ID)); err != nil {
+				return err
+			}
+		}
+		if len(rec.TxHash) == 0 {
+			return nil
+		}
+		return tx.Bucket(byTxBucket).Put([]byte(txKey(rec.Network, common.HexToHash(rec.TxHash))), []byte(rec.ID))
+	})
+}
+
+func (bs *BoltStore) Get(id string) (rec *SettlementRecord, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		rec, err = getRecord(tx, []byte(id))
+		return err
+	})
+	return
+}
+
+func (bs *BoltStore) GetByTx(network string, hash common.Hash) (rec *SettlementRecord, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(byTxBucket).Get([]byte(txKey(network, hash)))
+		if id == nil {
+			return ErrNotFound
+		}
+		rec, err = getRecord(tx, id)
+		return err
+	})
+	return
+}
+
+func (bs *BoltStore) GetByKey(key string) (rec *SettlementRecord, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(byKeyBucket).Get([]byte(key))
+		if id == nil {
+			return ErrNotFound
+		}
+		rec, err = getRecord(tx, id)
+		return err
+	})
+	return
+}
+
+func getRecord(tx *bolt.Tx, id []byte) (*SettlementRecord, error) {
+	data := tx.Bucket(settlementsBucket).Get(id)
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	rec := new(SettlementRecord)
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, fmt.Errorf("corrupt ledger entry %s: %w", id, err)
+	}
+	return rec, nil
+}
+
+// List relies on the ids being time-ordered, see NewSettlementID
+func (bs *BoltStore) List(since time.Time, limit int) ([]*SettlementRecord, error) {
+	out := []*SettlementRecord{}
+	start := []byte(fmt.Sprintf("%016x", since.UnixNano()))
+	if since.IsZero() {
+		start = nil
+	}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(settlementsBucket).Cursor()
+		k, v := c.First()
+		if start != nil {
+			k, v = c.Seek(start)
+		}
+		for ; k != nil; k, v = c.Next() {
+			rec := new(SettlementRecord)
+			if err := json.Unmarshal(v, rec); err != nil {
+				return fmt.Errorf("corrupt ledger entry %s: %w", k, err)
+			}
+			out = append(out, rec)
+			if limit > 0 && len(out) == limit {
+				break
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (bs *BoltStore) Pending() ([]*SettlementRecord, error) {
+	out := []*SettlementRecord{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(settlementsBucket).ForEach(func(k, v []byte) error {
+			rec := new(SettlementRecord)
+			if err := json.Unmarshal(v, rec); err != nil {
+				return fmt.Errorf("corrupt ledger entry %s: %w", k, err)
+			}
+			if rec.Status == StatusPending || rec.Status == StatusQueued {
+				out = append(out, rec)
+			}
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
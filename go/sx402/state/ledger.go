@@ -0,0 +1,169 @@
+package state
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type SettlementStatus string
+
+const (
+	StatusFailed    SettlementStatus = "failed"    // never made it on-chain
+	StatusQueued    SettlementStatus = "queued"    // deferred, waiting for its batch to be sent
+	StatusPending   SettlementStatus = "pending"   // submitted, waiting for the receipt
+	StatusConfirmed SettlementStatus = "confirmed" // receipt with status 1
+	StatusReverted  SettlementStatus = "reverted"  // receipt with status 0
+	StatusTimeout   SettlementStatus = "timeout"   // no receipt within receiptTimeout
+)
+
+// SettlementRecord is one /settle attempt, successful or not
+type SettlementRecord struct {
+	ID           string           `json:"id"`
+	Key          string           `json:"key,omitempty"` // network/kind/asset/payer/nonce of the authorization
+	Network      string           `json:"network"`
+	Scheme       string           `json:"scheme"`
+	Asset        string           `json:"asset"`
+	Payer        string           `json:"payer,omitempty"`
+	PayTo        string           `json:"payTo"`
+	Amount       string           `json:"amount"`
+	TxHash       string           `json:"txHash,omitempty"`
+	Replacements []string         `json:"replacements,omitempty"` // re-priced versions of TxHash
+	Batched      bool             `json:"batched,omitempty"`      // TxHash is a Multicall3 batch, its success is not this one's
+	Envelope     json.RawMessage  `json:"envelope,omitempty"`
+	SubmittedAt  time.Time        `json:"submittedAt"`
+	Status       SettlementStatus `json:"status"`
+	Error        string           `json:"error,omitempty"`
+	TimeToSettle time.Duration    `json:"timeToSettle,omitempty"`
+	Receipt      *types.Receipt   `json:"receipt,omitempty"`
+}
+
+// SettlementStore persists the settlement ledger
+type SettlementStore interface {
+	// Save inserts the record or replaces the one with the same ID
+	Save(rec *SettlementRecord) error
+	Get(id string) (*SettlementRecord, error)
+	GetByTx(network string, tx common.Hash) (*SettlementRecord, error)
+	// GetByKey returns the latest attempt to settle the authorization
+	GetByKey(key string) (*SettlementRecord, error)
+	// List returns up to limit records submitted at or after since, oldest first
+	List(since time.Time, limit int) ([]*SettlementRecord, error)
+	// Pending returns the settlements still on their way, submitted or queued for a batch
+	Pending() ([]*SettlementRecord, error)
+	Close() error
+}
+
+var ErrNotFound = errors.New("settlement not found")
+
+// NewSettlementID returns a time-ordered, unique id
+func NewSettlementID(at time.Time) string {
+	rnd := make([]byte, 4)
+	rand.Read(rnd)
+	return fmt.Sprintf("%016x-%s", at.UnixNano(), hex.EncodeToString(rnd))
+}
+
+func txKey(network string, tx common.Hash) string {
+	return network + "/" + strings.ToLower(tx.Hex())
+}
+
+// MemoryStore is the non-persistent SettlementStore, used when no ledger file is configured
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]SettlementRecord
+	byTx    map[string]string
+	byKey   map[string]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]SettlementRecord{}, byTx: map[string]string{}, byKey: map[string]string{}}
+}
+
+func (ms *MemoryStore) Save(rec *SettlementRecord) error {
+	if len(rec.ID) == 0 {
+		return fmt.Errorf("settlement without an id")
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.records[rec.ID] = *rec
+	if len(rec.TxHash) > 0 {
+		ms.byTx[txKey(rec.Network, common.HexToHash(rec.TxHash))] = rec.ID
+	}
+	if len(rec.Key) > 0 {
+		ms.byKey[rec.Key] = rec.ID
+	}
+	return nil
+}
+
+func (ms *MemoryStore) Get(id string) (*SettlementRecord, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	rec, ok := ms.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &rec, nil
+}
+
+func (ms *MemoryStore) GetByTx(network string, tx common.Hash) (*SettlementRecord, error) {
+	ms.mu.RLock()
+	id, ok := ms.byTx[txKey(network, tx)]
+	ms.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ms.Get(id)
+}
+
+func (ms *MemoryStore) GetByKey(key string) (*SettlementRecord, error) {
+	ms.mu.RLock()
+	id, ok := ms.byKey[key]
+	ms.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ms.Get(id)
+}
+
+func (ms *MemoryStore) List(since time.Time, limit int) ([]*SettlementRecord, error) {
+	return ms.filter(func(r *SettlementRecord) bool { return !r.SubmittedAt.Before(since) }, limit), nil
+}
+
+func (ms *MemoryStore) Pending() ([]*SettlementRecord, error) {
+	return ms.filter(func(r *SettlementRecord) bool { return r.Status == StatusPending || r.Status == StatusQueued }, 0), nil
+}
+
+func (ms *MemoryStore) filter(keep func(*SettlementRecord) bool, limit int) []*SettlementRecord {
+	ms.mu.RLock()
+	ids := make([]string, 0, len(ms.records))
+	for id := range ms.records {
+		ids = append(ids, id)
+	}
+	ms.mu.RUnlock()
+	sort.Strings(ids)
+
+	out := []*SettlementRecord{}
+	for _, id := range ids {
+		rec, err := ms.Get(id)
+		if err != nil || !keep(rec) {
+			continue
+		}
+		out = append(out, rec)
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out
+}
+
+func (ms *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,110 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func testStore(t *testing.T, store SettlementStore) {
+	defer store.Close()
+	start := time.Now()
+	tx := "0x00000000000000000000000000000000000000000000000000000000000000aa"
+
+	failed := &SettlementRecord{ID: NewSettlementID(start), Network: "base-sepolia", SubmittedAt: start, Status: StatusFailed, Error: "boom"}
+	pending := &SettlementRecord{ID: NewSettlementID(start.Add(time.Second)), Key: "k", Network: "base-sepolia", SubmittedAt: start.Add(time.Second), Status: StatusPending, TxHash: tx}
+	for _, rec := range []*SettlementRecord{failed, pending} {
+		if err := store.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec, err := store.GetByTx("base-sepolia", common.HexToHash(tx))
+	if err != nil || rec.ID != pending.ID {
+		t.Fatal("lookup by tx failed:", rec, err)
+	}
+	if rec, err := store.GetByKey("k"); err != nil || rec.ID != pending.ID {
+		t.Error("lookup by key failed:", rec, err)
+	}
+	if _, err := store.GetByTx("amoy", common.HexToHash(tx)); err != ErrNotFound {
+		t.Error("tx lookup should be per network, got", err)
+	}
+
+	recs, _ := store.List(time.Time{}, 0)
+	if len(recs) != 2 || recs[0].ID != failed.ID {
+		t.Error("expected both records, oldest first:", recs)
+	}
+	recs, _ = store.List(start.Add(time.Second), 0)
+	if len(recs) != 1 || recs[0].ID != pending.ID {
+		t.Error("since was not honoured:", recs)
+	}
+	recs, _ = store.List(time.Time{}, 1)
+	if len(recs) != 1 {
+		t.Error("limit was not honoured:", recs)
+	}
+
+	recs, _ = store.Pending()
+	if len(recs) != 1 || recs[0].ID != pending.ID {
+		t.Error("wrong pending set:", recs)
+	}
+	pending.Status = StatusConfirmed
+	store.Save(pending)
+	if recs, _ = store.Pending(); len(recs) != 0 {
+		t.Error("confirmed settlement still pending")
+	}
+	rec, _ = store.Get(pending.ID)
+	if rec.Status != StatusConfirmed {
+		t.Error("update lost:", rec.Status)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	store, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	// records survive a restart
+	store, err = OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	recs, _ := store.List(time.Time{}, 0)
+	if len(recs) != 2 {
+		t.Error("expected 2 records after reopening, got", len(recs))
+	}
+}
+
+func TestResumeQueued(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	tx := "0x00000000000000000000000000000000000000000000000000000000000000bb"
+	queued := &SettlementRecord{ID: NewSettlementID(now), Network: "base-sepolia", SubmittedAt: now, Status: StatusQueued}
+	first := &SettlementRecord{ID: NewSettlementID(now), Network: "base-sepolia", SubmittedAt: now, Status: StatusPending, TxHash: tx}
+	second := &SettlementRecord{ID: NewSettlementID(now), Network: "base-sepolia", SubmittedAt: now, Status: StatusPending, TxHash: tx}
+	for _, rec := range []*SettlementRecord{queued, first, second} {
+		store.Save(rec)
+	}
+
+	rt := &ReceiptTracker{}
+	rt.Resume(store)
+	// the batch of the queued one is gone with the process
+	if rec, _ := store.Get(queued.ID); rec.Status != StatusFailed {
+		t.Error("queued settlement not failed on resume:", rec.Status)
+	}
+	// both settlements of the batch transaction are tracked
+	for _, rec := range []*SettlementRecord{first, second} {
+		if _, ok := rt.pending.Load(rec.ID); !ok {
+			t.Error("not tracked:", rec.ID)
+		}
+	}
+}
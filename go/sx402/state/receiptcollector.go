@@ -17,49 +17,57 @@ type OmniHash struct {
 	Network string
 }
 
-type PendingReceipt struct {
-	SubmittedAt  time.Time
-	TimeToSettle time.Duration
-	Receipt      *types.Receipt
-}
-
+// ReceiptTracker polls for the receipts of pending settlements and writes the outcome to the ledger
 type ReceiptTracker struct {
-	clients  map[string]*ethclient.Client
-	receipts sync.Map // map[OmniHash]*pendingReceipt
+	pending sync.Map   // map[settlement id]OmniHash; batched settlements share their tx
+	mu      sync.Mutex // one writer of the pending records at a time
 }
 
+// BatchOutcome tells from the receipt of a successful batch whether the batched settlement went through with it.
+// The calls of a batch fail on their own, so the tracker asks this before confirming one; set by the facilitator.
+var BatchOutcome func(rec *SettlementRecord, receipt *types.Receipt) error
+
 const receiptTimeout = 30 * time.Minute
 const pollInterval = 5 * time.Second
 
 func NewReceiptTracker() *ReceiptTracker {
-	rt := &ReceiptTracker{
-		clients: evmbinding.InitClients(),
-	}
+	rt := &ReceiptTracker{}
 	go rt.pollLoop()
 	return rt
 }
 
-// Submit a tx hash to begin tracking
-func (rt *ReceiptTracker) Submit(hash common.Hash, network string) {
-	key := OmniHash{Hash: hash, Network: network}
-	rt.receipts.Store(key, &PendingReceipt{
-		SubmittedAt: time.Now(),
-		Receipt:     nil,
-	})
-	log.Printf("📩 Submitted tx %s on %s", hash.Hex(), network)
+// Track a pending settlement until its receipt shows up
+func (rt *ReceiptTracker) Track(rec *SettlementRecord) {
+	if rec.Status != StatusPending || len(rec.TxHash) == 0 {
+		return
+	}
+	hash := common.HexToHash(rec.TxHash)
+	rt.pending.Store(rec.ID, OmniHash{Hash: hash, Network: rec.Network})
+	log.Printf("📩 Submitted tx %s on %s", hash.Hex(), rec.Network)
 }
 
-// Get retrieves a receipt if available
-func (rt *ReceiptTracker) Get(hash common.Hash, network string) (*PendingReceipt, bool) {
-	val, ok := rt.receipts.Load(OmniHash{Hash: hash, Network: network})
-	if !ok {
-		return nil, false
+// Resume picks up the settlements that were still pending when the ledger was last closed.
+// Batches live in memory only, the settlements queued for one never went out and may be retried.
+func (rt *ReceiptTracker) Resume(store SettlementStore) {
+	recs, err := store.Pending()
+	if err != nil {
+		log.Println("could not load pending settlements:", err)
+		return
 	}
-	pr := val.(*PendingReceipt)
-	if pr.Receipt == nil {
-		return nil, false
+	for _, rec := range recs {
+		if rec.Status == StatusQueued {
+			rec.Status = StatusFailed
+			rec.Error = "facilitator stopped before the batch of the settlement was sent"
+			if err := store.Save(rec); err != nil {
+				log.Printf("⚠️ Could not update settlement %s: %v", rec.ID, err)
+			}
+			continue
+		}
+		rt.Track(rec)
+	}
+	if len(recs) > 0 {
+		log.Printf("Resumed tracking of %v pending settlements", len(recs))
 	}
-	return pr, true
 }
 
 func (rt *ReceiptTracker) pollLoop() {
@@ -69,50 +77,123 @@ func (rt *ReceiptTracker) pollLoop() {
 	for range ticker.C {
 		now := time.Now()
 
-		rt.receipts.Range(func(key, value any) bool {
-			omni := key.(OmniHash)
-			pr := value.(*PendingReceipt)
-
-			// Already received
-			if pr.Receipt != nil {
+		rt.pending.Range(func(key, value any) bool {
+			omni := value.(OmniHash)
+			rt.mu.Lock()
+			defer rt.mu.Unlock()
+			rec, err := Ledger().Get(key.(string))
+			if err != nil {
+				log.Printf("⚠️ Settlement %s vanished from the ledger: %v", key, err)
+				rt.pending.Delete(key)
 				return true
 			}
 
 			// Timeout expired
-			if now.Sub(pr.SubmittedAt) > receiptTimeout {
-				log.Printf("⏱️ Timeout: %s (%s) exceeded %v, dropping", omni.Hash.Hex(), omni.Network, receiptTimeout)
-				rt.receipts.Delete(omni)
+			if now.Sub(rec.SubmittedAt) > receiptTimeout {
+				log.Printf("⏱️ Timeout: %s (%s) exceeded %v, giving up", omni.Hash.Hex(), omni.Network, receiptTimeout)
+				rec.Status = StatusTimeout
+				rt.finish(rec)
 				return true
 			}
 
 			// Try fetching receipt
-			client, ok := rt.clients[omni.Network]
-			if !ok {
+			client, err := evmbinding.GetClientByNetwork(omni.Network)
+			if err != nil {
 				log.Printf("⚠️ No client for network: %s", omni.Network)
 				return true
 			}
 
-			receipt, err := client.TransactionReceipt(context.Background(), omni.Hash)
-			if err != nil {
+			receipt := findReceipt(client, rec)
+			if receipt == nil {
 				log.Printf("🔄 Pending: %s (%s)", omni.Hash.Hex(), omni.Network)
 				return true
 			}
 
+			// Wait for the confirmation depth configured for the network
+			if n, ok := evmbinding.GetNetwork(omni.Network); ok && n.Confirmations > 0 {
+				head, err := client.BlockNumber(context.Background())
+				if err != nil || head < receipt.BlockNumber.Uint64()+n.Confirmations {
+					log.Printf("🔄 Awaiting %v confirmations: %s (%s)", n.Confirmations, omni.Hash.Hex(), omni.Network)
+					return true
+				}
+			}
+
 			// Fetch block to get timestamp
 			block, err := client.HeaderByNumber(context.Background(), receipt.BlockNumber)
 			if err == nil {
 
 				settleTime := time.Unix(int64(block.Time), 0)
-				pr.TimeToSettle = settleTime.Sub(pr.SubmittedAt)
+				rec.TimeToSettle = settleTime.Sub(rec.SubmittedAt)
 			} else {
 				log.Println("Failed to get block from ", omni.Network)
-				pr.TimeToSettle = time.Since(pr.SubmittedAt)
+				rec.TimeToSettle = time.Since(rec.SubmittedAt)
 			}
 
-			pr.Receipt = receipt
-			rt.receipts.Store(omni, pr)
+			rec.Receipt = receipt
+			rec.Status = StatusConfirmed
+			if receipt.Status != types.ReceiptStatusSuccessful {
+				rec.Status = StatusReverted
+			} else if rec.Batched && BatchOutcome != nil {
+				if err := BatchOutcome(rec, receipt); err != nil {
+					rec.Status = StatusReverted
+					rec.Error = err.Error()
+				}
+			}
+			rt.finish(rec)
 			log.Printf("✅ Receipt for %s (%s) stored", omni.Hash.Hex(), omni.Network)
 			return true
 		})
 	}
 }
+
+// Whichever version of the tx got mined
+func findReceipt(client *ethclient.Client, rec *SettlementRecord) *types.Receipt {
+	for _, tx := range append([]string{rec.TxHash}, rec.Replacements...) {
+		receipt, err := client.TransactionReceipt(context.Background(), common.HexToHash(tx))
+		if err == nil {
+			return receipt
+		}
+	}
+	return nil
+}
+
+// OnTxEvent takes the reports of the evmbinding tx manager. Mined transactions are left to the
+// poll loop, which also waits for the confirmations.
+func (rt *ReceiptTracker) OnTxEvent(ev evmbinding.TxEvent) {
+	omni := OmniHash{Hash: ev.Original, Network: ev.Network}
+	ids := []string{}
+	rt.pending.Range(func(key, value any) bool {
+		if value.(OmniHash) == omni {
+			ids = append(ids, key.(string))
+		}
+		return true
+	})
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for _, id := range ids {
+		rec, err := Ledger().Get(id)
+		if err != nil {
+			continue
+		}
+		switch ev.Kind {
+		case evmbinding.TxReplaced:
+			rec.Replacements = append(rec.Replacements, ev.Hash.Hex())
+			if err := Ledger().Save(rec); err != nil {
+				log.Printf("⚠️ Could not update settlement %s: %v", rec.ID, err)
+			}
+		case evmbinding.TxDropped:
+			log.Printf("❌ Dropped: %s (%s): %s", omni.Hash.Hex(), omni.Network, ev.Reason)
+			rec.Status = StatusFailed
+			rec.Error = "transaction dropped: " + ev.Reason
+			rt.finish(rec)
+		}
+	}
+}
+
+func (rt *ReceiptTracker) finish(rec *SettlementRecord) {
+	if err := Ledger().Save(rec); err != nil {
+		log.Printf("⚠️ Could not update settlement %s: %v", rec.ID, err)
+		return
+	}
+	rt.pending.Delete(rec.ID)
+}
@@ -1,18 +1,47 @@
 package state
 
 import (
+	"log"
+	"sync"
+
 	"github.com/ethereum/go-ethereum/common"
 )
 
+var ledgerMu sync.RWMutex
+var ledger SettlementStore = NewMemoryStore()
+
 var receiptCollector = NewReceiptTracker()
 
 func GetReceiptCollector() *ReceiptTracker {
 	return receiptCollector
 }
 
-func GetPendingReceipt(tx common.Hash, network string) (*PendingReceipt, bool) {
-	if receiptCollector == nil {
+// SetSettlementStore replaces the ledger and resumes tracking of its pending settlements
+func SetSettlementStore(store SettlementStore) {
+	ledgerMu.Lock()
+	ledger = store
+	ledgerMu.Unlock()
+	receiptCollector.Resume(store)
+}
+
+func Ledger() SettlementStore {
+	ledgerMu.RLock()
+	defer ledgerMu.RUnlock()
+	return ledger
+}
+
+// RecordSettlement writes the attempt to the ledger and, if it went on-chain, starts waiting for its receipt
+func RecordSettlement(rec *SettlementRecord) {
+	if err := Ledger().Save(rec); err != nil {
+		log.Printf("⚠️ Could not record settlement %s: %v", rec.ID, err)
+	}
+	receiptCollector.Track(rec)
+}
+
+func GetSettlement(tx common.Hash, network string) (*SettlementRecord, bool) {
+	rec, err := Ledger().GetByTx(network, tx)
+	if err != nil {
 		return nil, false
 	}
-	return receiptCollector.Get(tx, network)
+	return rec, true
 }
@@ -0,0 +1,207 @@
+// Package x402client pays for HTTP resources. Transport is an http.RoundTripper that answers
+// a 402 by signing one of the offered payments and sending the request again with X-Payment
+// (PAYMENT-SIGNATURE when the server only speaks x402 v2).
+package x402client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/policy"
+)
+
+// v1 headers
+const X_PAYMENT_HEADER = "X-Payment"
+const X_PAYMENT_RESPONSE_HEADER = "X-PAYMENT-RESPONSE"
+
+// v2 headers
+const PAYMENT_SIGNATURE_HEADER = "PAYMENT-SIGNATURE"
+const PAYMENT_REQUIRED_HEADER = "PAYMENT-REQUIRED"
+const PAYMENT_RESPONSE_HEADER = "PAYMENT-RESPONSE"
+
+// Payer is the key that signs the payments, evmbinding.KeySigner and KeystoreSigner fit
+type Payer interface {
+	Address() common.Address
+	SignHash(hash common.Hash) ([]byte, error) // 65 bytes, v in {0, 1}
+}
+
+var ErrNoPayableRequirement = errors.New("no payment option this client can pay")
+var ErrNoSettlement = errors.New("no payment response header")
+
+type Transport struct {
+	Base  http.RoundTripper // default - http.DefaultTransport
+	Payer Payer
+
+	// PermitNonce is the next EIP-2612 nonce of the payer; default - asked from the chain
+	PermitNonce func(network, asset, owner string) (*big.Int, error)
+	// How much of a cross-chain payment may go to the facilitator's markup, in basis points; default 100
+	MaxMarkupBps int64
+	// Policy, when set, has to let every payment through before it gets signed
+	Policy *policy.Engine
+	// Selector, when set, decides which of the offered payments are tried and in what order;
+	// without it they are tried in the order of the server
+	Selector *Selector
+}
+
+func NewClient(payer Payer) *http.Client {
+	return &http.Client{Transport: &Transport{Payer: payer}}
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the body is needed twice
+	if req.Body != nil && req.GetBody == nil {
+		bts, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(bts))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(bts)), nil }
+	}
+
+	resp, err := t.base().RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusPaymentRequired {
+		return resp, err
+	}
+
+	accepts, version, err := readPaymentRequired(resp)
+	if err != nil {
+		return nil, err
+	}
+	header, value, err := t.pay(req.URL.Host, accepts, version)
+	if err != nil {
+		return nil, err
+	}
+
+	paid := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if paid.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	paid.Header.Set(header, value)
+	// a second 402 goes back to the caller as it is
+	return t.base().RoundTrip(paid)
+}
+
+// readPaymentRequired takes the requirements from the v1 body, or from the PAYMENT-REQUIRED header
+func readPaymentRequired(resp *http.Response) ([]*types.PaymentRequirements, int, error) {
+	defer resp.Body.Close()
+	var body struct {
+		Accepts []*types.PaymentRequirements `json:"accepts"`
+	}
+	bts, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, 0, err
+	}
+	if json.Unmarshal(bts, &body) == nil && len(body.Accepts) > 0 {
+		return body.Accepts, 1, nil
+	}
+
+	header := resp.Header.Get(PAYMENT_REQUIRED_HEADER)
+	if len(header) == 0 {
+		return nil, 0, fmt.Errorf("402 without payment requirements: %s", bts)
+	}
+	if bts, err = base64.StdEncoding.DecodeString(header); err != nil {
+		return nil, 0, fmt.Errorf("bad PAYMENT-REQUIRED header: %w", err)
+	}
+	pr := new(all712.PaymentRequired)
+	if err := json.Unmarshal(bts, pr); err != nil {
+		return nil, 0, fmt.Errorf("bad PAYMENT-REQUIRED header: %w", err)
+	}
+	accepts := []*types.PaymentRequirements{}
+	for _, req := range pr.Accepts {
+		accepts = append(accepts, all712.FromV2(req, pr.Resource))
+	}
+	return accepts, 2, nil
+}
+
+// pay signs the first requirement it can and encodes the payment for the header of the version
+func (t *Transport) pay(host string, accepts []*types.PaymentRequirements, version int) (string, string, error) {
+	if t.Selector != nil && t.Payer != nil {
+		plan := t.Selector.Plan(t.Payer.Address(), accepts)
+		if len(plan.Options) == 0 {
+			return "", "", fmt.Errorf("%w: %s", ErrNoPayableRequirement, plan.reasons())
+		}
+		accepts = plan.Requirements()
+	}
+	var lastErr error = ErrNoPayableRequirement
+	for _, req := range accepts {
+		payload, err := t.guardedSign(host, req)
+		if err != nil {
+			lastErr = fmt.Errorf("%w: %s on %s: %w", ErrNoPayableRequirement, req.Scheme, req.Network, err)
+			continue
+		}
+		return EncodePayment(req, payload, version)
+	}
+	return "", "", lastErr
+}
+
+// EncodePayment wraps the signed payload for the payment header of the x402 version
+func EncodePayment(req *types.PaymentRequirements, payload json.RawMessage, version int) (string, string, error) {
+	if version < 2 {
+		bts, err := json.Marshal(all712.PaymentPayload{X402Version: 1, Scheme: req.Scheme, Network: req.Network, Payload: payload})
+		return X_PAYMENT_HEADER, base64.StdEncoding.EncodeToString(bts), err
+	}
+	accepted, resource := all712.ToV2(req)
+	bts, err := json.Marshal(all712.PaymentPayloadV2{X402Version: 2, Resource: resource, Accepted: accepted, Payload: payload})
+	return PAYMENT_SIGNATURE_HEADER, base64.StdEncoding.EncodeToString(bts), err
+}
+
+// guardedSign signs the requirement if the policy allows it
+func (t *Transport) guardedSign(host string, req *types.PaymentRequirements) (payload json.RawMessage, err error) {
+	sign := func() (err error) {
+		payload, err = t.Sign(req)
+		return
+	}
+	if t.Policy == nil {
+		err = sign()
+		return
+	}
+	amount, _ := new(big.Int).SetString(req.MaxAmountRequired, 10)
+	network, _ := evmbinding.ResolveNetwork(req.Network)
+	spend := &policy.Spend{Host: host, Network: network, Asset: req.Asset, PayTo: req.PayTo, Scheme: req.Scheme, Amount: amount}
+	err = t.Policy.Guard(spend, sign)
+	return
+}
+
+// Settlement is the settle response the server sent with a paid resource
+func Settlement(resp *http.Response) (*types.SettleResponse, error) {
+	header := resp.Header.Get(PAYMENT_RESPONSE_HEADER)
+	if len(header) == 0 {
+		header = resp.Header.Get(X_PAYMENT_RESPONSE_HEADER)
+	}
+	if len(header) == 0 {
+		return nil, ErrNoSettlement
+	}
+	bts, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return nil, fmt.Errorf("bad payment response header: %w", err)
+	}
+	settled := new(types.SettleResponse)
+	if err := json.Unmarshal(bts, settled); err != nil {
+		return nil, fmt.Errorf("bad payment response header: %w", err)
+	}
+	if network, ok := evmbinding.ResolveNetwork(settled.Network); ok {
+		settled.Network = network
+	}
+	return settled, nil
+}
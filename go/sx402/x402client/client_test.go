@@ -0,0 +1,187 @@
+package x402client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/policy"
+	"github.com/san-lab/sx402/signing"
+)
+
+const testAsset = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+const testPayTo = "0xCEF702Bd69926B13ab7150624daA7aFEE0300786"
+const testFacilitator = "0x567BB99cbF92d6C8E38DeB98014B2C52F149E81f"
+
+func requirement(scheme string, extra string) *types.PaymentRequirements {
+	raw := json.RawMessage(extra)
+	return &types.PaymentRequirements{Scheme: scheme, Network: "base-sepolia", MaxAmountRequired: "10000",
+		Asset: testAsset, PayTo: testPayTo, MaxTimeoutSeconds: 60, Extra: &raw}
+}
+
+// verifyPayment checks the payload the way the facilitator does
+func verifyPayment(t *testing.T, payer common.Address, ppld *all712.PaymentPayload, req *types.PaymentRequirements) {
+	chainID, _ := evmbinding.ChainID(req.Network)
+	switch Kind(req) {
+	case KindExact:
+		exact := new(types.ExactEvmPayload)
+		json.Unmarshal(ppld.Payload, exact)
+		if _, _, _, err := signing.VerifyTransferWithAuthorizationSignature(exact.Signature, *exact.Authorization, "USDC", "2", chainID, common.HexToAddress(req.Asset)); err != nil {
+			t.Errorf("exact: %v", err)
+		}
+		if exact.Authorization.Value != req.MaxAmountRequired || !strings.EqualFold(exact.Authorization.To, req.PayTo) {
+			t.Errorf("exact: wrong authorization %+v", exact.Authorization)
+		}
+	case KindPermit:
+		permit := new(all712.PermitMessage)
+		json.Unmarshal(ppld.Payload, permit)
+		if recovered, err := signing.VerifyPermitSignature(permit); err != nil || recovered != payer {
+			t.Errorf("permit: %v", err)
+		}
+		if permit.Message.Spender != common.HexToAddress(testFacilitator) || permit.Nonce.Int64() != 7 {
+			t.Errorf("permit: wrong message %+v", permit)
+		}
+	case KindPermit2, KindUpto:
+		p2 := new(all712.Permit2Message)
+		json.Unmarshal(ppld.Payload, p2)
+		if owner, _, err := signing.VerifyPermit2Signature(p2); err != nil || owner != payer {
+			t.Errorf("permit2: %v", err)
+		}
+		if p2.Message.Spender != common.HexToAddress(testFacilitator) || p2.Message.Witness.PayTo != common.HexToAddress(req.PayTo) ||
+			p2.Message.Witness.Resource != req.Resource || p2.Domain.VerifyingContract != evmbinding.Permit2Canonical {
+			t.Errorf("permit2: wrong message %+v", p2)
+		}
+	case KindCrossChain:
+		ccmsg := new(all712.CrossChainTransferMessage)
+		json.Unmarshal(ppld.Payload, ccmsg)
+		if recovered, err := signing.VerifyCrossChainAuthSignature(ccmsg); err != nil || recovered != payer {
+			t.Errorf("cross-chain: %v", err)
+		}
+		if ccmsg.Authorization.DestinationChain.Int64() != 40231 || ccmsg.Authorization.MinimalAmount.Int64() != 9900 {
+			t.Errorf("cross-chain: wrong authorization %+v", ccmsg.Authorization)
+		}
+	}
+}
+
+func TestPaysEachKind(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	payer := evmbinding.NewKeySigner(key)
+
+	for _, req := range []*types.PaymentRequirements{
+		requirement("exact", `{"name":"USDC","version":"2"}`),
+		requirement("permit_USDC", `{"name":"USDC","version":"2","facilitator":"`+testFacilitator+`"}`),
+		requirement("permit2_USDC", `{"name":"USDC","facilitator":"`+testFacilitator+`"}`),
+		requirement("upto_USDC", `{"name":"USDC","facilitator":"`+testFacilitator+`"}`),
+		requirement("PZ_toArbitrum", `{"name":"USDC","version":"2","dstEid":"40231"}`),
+	} {
+		var body string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get(X_PAYMENT_HEADER)
+			if header == "" {
+				w.WriteHeader(http.StatusPaymentRequired)
+				json.NewEncoder(w).Encode(map[string]any{"x402Version": 1, "accepts": []any{req}})
+				return
+			}
+			bts, _ := base64.StdEncoding.DecodeString(header)
+			ppld := new(all712.PaymentPayload)
+			json.Unmarshal(bts, ppld)
+			verifyPayment(t, payer.Address(), ppld, req)
+			b, _ := io.ReadAll(r.Body)
+			body = string(b)
+			settled, _ := json.Marshal(types.SettleResponse{Success: true, Transaction: "0xabc", Network: "base-sepolia"})
+			w.Header().Set(X_PAYMENT_RESPONSE_HEADER, base64.StdEncoding.EncodeToString(settled))
+			w.Write([]byte("content"))
+		}))
+
+		client := &http.Client{Transport: &Transport{Payer: payer, PermitNonce: func(network, asset, owner string) (*big.Int, error) {
+			return big.NewInt(7), nil
+		}}}
+		resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("the body"))
+		if err != nil {
+			t.Fatalf("%s: %v", req.Scheme, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || body != "the body" {
+			t.Errorf("%s: got %v, body %q", req.Scheme, resp.Status, body)
+		}
+		if settled, err := Settlement(resp); err != nil || settled.Transaction != "0xabc" {
+			t.Errorf("%s: no settlement: %v", req.Scheme, err)
+		}
+		srv.Close()
+	}
+}
+
+func TestV2AndUnpayable(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	payer := evmbinding.NewKeySigner(key)
+	unpayable := requirement("upto", `{}`)
+	exact := requirement("exact", `{"name":"USDC","version":"2"}`)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(PAYMENT_SIGNATURE_HEADER)
+		if header == "" {
+			pr := all712.PaymentRequired{X402Version: 2}
+			for _, req := range []*types.PaymentRequirements{unpayable, exact} {
+				v2, resource := all712.ToV2(req)
+				pr.Accepts, pr.Resource = append(pr.Accepts, v2), resource
+			}
+			bts, _ := json.Marshal(pr)
+			w.Header().Set(PAYMENT_REQUIRED_HEADER, base64.StdEncoding.EncodeToString(bts))
+			w.WriteHeader(http.StatusPaymentRequired)
+			return
+		}
+		bts, _ := base64.StdEncoding.DecodeString(header)
+		ppld, v2, err := all712.ParsePaymentPayload(bts)
+		if err != nil || v2 == nil || v2.Accepted.Network != "eip155:84532" {
+			t.Errorf("not a v2 payment: %v %s", err, bts)
+			return
+		}
+		verifyPayment(t, payer.Address(), ppld, exact)
+		w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+
+	resp, err := NewClient(payer).Get(srv.URL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("v2 payment failed: %v %v", err, resp)
+	}
+	resp.Body.Close()
+
+	if _, _, err := (&Transport{Payer: payer}).pay("", []*types.PaymentRequirements{unpayable}, 1); err == nil {
+		t.Error("paid an unknown scheme")
+	}
+}
+
+func TestPolicyDenies(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	engine := policy.NewEngine(&policy.Policy{Assets: map[string]policy.Caps{testAsset: {PerRequest: big.NewInt(5000)}}}, nil)
+	tr := &Transport{Payer: evmbinding.NewKeySigner(key), Policy: engine}
+
+	_, _, err := tr.pay("shop.example", []*types.PaymentRequirements{requirement("exact", `{"name":"USDC","version":"2"}`)}, 1)
+	if !errors.Is(err, policy.ErrDenied) {
+		t.Fatalf("expected a policy denial, got %v", err)
+	}
+	if spends, _ := engine.Ledger.Since(time.Time{}); len(spends) != 0 {
+		t.Errorf("denied payment booked: %v", spends)
+	}
+
+	engine.Policy.Assets[testAsset] = policy.Caps{PerRequest: big.NewInt(10000)}
+	if _, _, err := tr.pay("shop.example", []*types.PaymentRequirements{requirement("exact", `{"name":"USDC","version":"2"}`)}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if spends, _ := engine.Ledger.Since(time.Time{}); len(spends) != 1 || spends[0].Host != "shop.example" || spends[0].Network != "base-sepolia" {
+		t.Errorf("payment not booked: %v", spends)
+	}
+}
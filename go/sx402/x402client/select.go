@@ -0,0 +1,253 @@
+package x402client
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/schemes"
+)
+
+// Selector ranks the payment options of a 402 by what they cost the payer in the reference currency.
+// The facilitator pays the gas and the LayerZero fees, so the cost is the amount signed;
+// the markup of a cross-chain option is part of that amount.
+type Selector struct {
+	// Facilitator whose markups the cross-chain options are checked against; empty - not checked
+	Facilitator string
+	// Same as Transport.MaxMarkupBps
+	MaxMarkupBps int64
+
+	// Chain lookups, default - asked from the chains
+	Balance  func(network, asset, owner string) (*big.Int, error)
+	Supports func(kind, network, asset, owner string) error // can the token take the payment kind from the owner
+	Markup   func(network, asset string, dstEid uint32, facilitator string) (*big.Int, error)
+}
+
+// Option is one of the offered requirements as the selector saw it
+type Option struct {
+	Requirement *types.PaymentRequirements
+	Network     string
+	Kind        string
+	Amount      *big.Int
+	Cost        float64  // of the amount in the reference currency
+	Priced      bool     // false if the asset has no reference price, such options rank last
+	Markup      *big.Int // of a cross-chain option, nil if not checked
+	Balance     *big.Int
+	Reason      string // why the option was rejected
+}
+
+type Plan struct {
+	Options  []*Option // payable, cheapest first
+	Rejected []*Option
+}
+
+// Requirements in the order they should be tried
+func (p *Plan) Requirements() []*types.PaymentRequirements {
+	reqs := []*types.PaymentRequirements{}
+	for _, o := range p.Options {
+		reqs = append(reqs, o.Requirement)
+	}
+	return reqs
+}
+
+// Best is the cheapest payable option, nil if there is none
+func (p *Plan) Best() *Option {
+	if len(p.Options) == 0 {
+		return nil
+	}
+	return p.Options[0]
+}
+
+func (p *Plan) reasons() string {
+	reasons := []string{}
+	for _, o := range p.Rejected {
+		reasons = append(reasons, fmt.Sprintf("%s on %s: %s", o.Requirement.Scheme, o.Requirement.Network, o.Reason))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Plan checks every requirement against the chains, all of them at once, and ranks the payable ones
+func (s *Selector) Plan(owner common.Address, accepts []*types.PaymentRequirements) *Plan {
+	options := []*Option{}
+	for _, req := range accepts {
+		options = append(options, s.option(req))
+	}
+
+	balances := map[string]*balanceCall{}
+	wg := sync.WaitGroup{}
+	for _, o := range options {
+		if len(o.Reason) > 0 {
+			continue
+		}
+		key := o.Network + "/" + strings.ToLower(o.Requirement.Asset)
+		bc, ok := balances[key]
+		if !ok {
+			bc = &balanceCall{}
+			balances[key] = bc
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				bc.balance, bc.err = s.balance(o.Network, o.Requirement.Asset, owner.Hex())
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.check(o, owner)
+		}()
+	}
+	wg.Wait()
+
+	plan := &Plan{Options: []*Option{}, Rejected: []*Option{}}
+	for _, o := range options {
+		if len(o.Reason) == 0 {
+			bc := balances[o.Network+"/"+strings.ToLower(o.Requirement.Asset)]
+			switch {
+			case bc.err != nil:
+				o.Reason = fmt.Sprintf("no balance: %v", bc.err)
+			case bc.balance.Cmp(o.Amount) < 0:
+				o.Balance, o.Reason = bc.balance, fmt.Sprintf("balance of %v is below %v", bc.balance, o.Amount)
+			default:
+				o.Balance = bc.balance
+			}
+		}
+		if len(o.Reason) > 0 {
+			plan.Rejected = append(plan.Rejected, o)
+		} else {
+			plan.Options = append(plan.Options, o)
+		}
+	}
+	sort.SliceStable(plan.Options, func(i, j int) bool {
+		a, b := plan.Options[i], plan.Options[j]
+		if a.Priced != b.Priced {
+			return a.Priced
+		}
+		return a.Priced && a.Cost < b.Cost
+	})
+	return plan
+}
+
+type balanceCall struct {
+	balance *big.Int
+	err     error
+}
+
+// option sorts out what can be told without asking the chains
+func (s *Selector) option(req *types.PaymentRequirements) *Option {
+	o := &Option{Requirement: req, Kind: Kind(req)}
+	network, known := evmbinding.ResolveNetwork(req.Network)
+	o.Network = network
+	amount, ok := new(big.Int).SetString(req.MaxAmountRequired, 10)
+	switch {
+	case len(o.Kind) == 0:
+		o.Reason = "scheme not supported by the client"
+	case !known:
+		o.Reason = "unknown network"
+	case !ok || amount.Sign() <= 0:
+		o.Reason = fmt.Sprintf("bad amount %q", req.MaxAmountRequired)
+	case !common.IsHexAddress(req.Asset):
+		o.Reason = "bad asset address"
+	}
+	if len(o.Reason) > 0 {
+		return o
+	}
+	o.Amount = amount
+	// the price of a token we know, under the same scheme, at the same address
+	if scheme, err := schemes.GetScheme(req.Scheme, network); err == nil && strings.EqualFold(scheme.Asset, req.Asset) {
+		o.Cost, o.Priced = scheme.Value(amount)
+	}
+	return o
+}
+
+// check asks the token whether it takes the payment, and the facilitator's markup of a cross-chain one
+func (s *Selector) check(o *Option, owner common.Address) {
+	if err := s.supports(o.Kind, o.Network, o.Requirement.Asset, owner.Hex()); err != nil {
+		o.Reason = fmt.Sprintf("token does not take %s payments: %v", o.Kind, err)
+		return
+	}
+	if o.Kind != KindCrossChain || (len(s.Facilitator) == 0 && s.Markup == nil) {
+		return
+	}
+	dstEid, err := strconv.ParseUint(extraInfo(o.Requirement)["dstEid"], 10, 32)
+	if err != nil {
+		o.Reason = fmt.Sprintf("bad dstEid: %v", err)
+		return
+	}
+	if _, ok := evmbinding.NetworkByEid(uint32(dstEid)); !ok {
+		o.Reason = fmt.Sprintf("unknown destination %v", dstEid)
+		return
+	}
+	markup, err := s.markup(o.Network, o.Requirement.Asset, uint32(dstEid), s.Facilitator)
+	if err != nil {
+		o.Reason = fmt.Sprintf("no markup: %v", err)
+		return
+	}
+	o.Markup = markup
+	// the facilitator could not settle an authorization that leaves it less than its markup
+	if allowed := maxMarkup(o.Amount, s.MaxMarkupBps); markup.Cmp(allowed) > 0 {
+		o.Reason = fmt.Sprintf("markup of %v is above the %v allowed", markup, allowed)
+	}
+}
+
+func (s *Selector) balance(network, asset, owner string) (*big.Int, error) {
+	if s.Balance != nil {
+		return s.Balance(network, asset, owner)
+	}
+	client, err := evmbinding.GetClientByNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	return evmbinding.CheckTokenBalance(client, common.HexToAddress(asset), common.HexToAddress(owner))
+}
+
+func (s *Selector) supports(kind, network, asset, owner string) error {
+	if s.Supports != nil {
+		return s.Supports(kind, network, asset, owner)
+	}
+	switch kind {
+	case KindExact:
+		client, err := evmbinding.GetClientByNetwork(network)
+		if err != nil {
+			return err
+		}
+		nonce, err := randomNonce()
+		if err != nil {
+			return err
+		}
+		used, err := evmbinding.CheckAuthorizationState(client, common.HexToAddress(asset), common.HexToAddress(owner), nonce)
+		if err == nil && used {
+			err = fmt.Errorf("fresh nonce reported used")
+		}
+		return err
+	case KindPermit:
+		_, err := evmbinding.PermitNonce(network, asset, owner)
+		return err
+	case KindPermit2, KindUpto:
+		// the amount is checked by the facilitator, here it is about the approval being there at all
+		client, err := evmbinding.GetClientByNetwork(network)
+		if err != nil {
+			return err
+		}
+		permit2, _ := evmbinding.Permit2Address(network)
+		allowance, err := evmbinding.CheckAllowance(client, common.HexToAddress(asset), common.HexToAddress(owner), permit2)
+		if err == nil && allowance.Sign() == 0 {
+			err = fmt.Errorf("token not approved to Permit2")
+		}
+		return err
+	}
+	// cross-chain tokens answer the markup query
+	return nil
+}
+
+func (s *Selector) markup(network, asset string, dstEid uint32, facilitator string) (*big.Int, error) {
+	if s.Markup != nil {
+		return s.Markup(network, asset, dstEid, facilitator)
+	}
+	return evmbinding.GetDetailedMarkup(network, asset, dstEid, facilitator)
+}
@@ -0,0 +1,167 @@
+package x402client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+	"github.com/san-lab/sx402/schemes"
+)
+
+const testEURC = "0x08210F9170F89Ab7658F0B5E3fF39b0E03C594D4"
+const testEURSM = "0xd7A4537267741d00F9654856b81F0AEe409B7aD9"
+const testAmoyUSDC = "0x41E94Eb019C0762f9Bfcf9Fb1E58725BfB0e7582"
+const testEmpty = "0x89D5F29be7753E4c0ad43D08A5067Afc99231CC9"
+
+func loadTestSchemes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schemes.json")
+	err := os.WriteFile(path, []byte(`[
+		{"scheme":"exact","type":"exac","network":"base-sepolia","asset":"`+testAsset+`","extra":{"name":"USDC","version":"2"},"decimals":6,"refPrice":1},
+		{"scheme":"exact_EURC","type":"exac","network":"sepolia","asset":"`+testEURC+`","extra":{"name":"EURC","version":"2"},"decimals":6,"refPrice":1.08},
+		{"scheme":"PZ_toBase","type":"payer0","network":"arbitrum-sepolia","asset":"`+testEURSM+`","extra":{"name":"EURSM","version":"1"},"dstEid":"40245","decimals":6,"refPrice":1}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := schemes.LoadSchemes(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func offer(scheme, network, asset, amount, extra string) *types.PaymentRequirements {
+	req := requirement(scheme, extra)
+	req.Network, req.Asset, req.MaxAmountRequired = network, asset, amount
+	return req
+}
+
+// chain lookups of a payer holding 20000 of everything but testEmpty
+func testSelector(balanceCalls *atomic.Int32) *Selector {
+	return &Selector{
+		Facilitator: testFacilitator,
+		Balance: func(network, asset, owner string) (*big.Int, error) {
+			balanceCalls.Add(1)
+			if strings.EqualFold(asset, testEmpty) {
+				return big.NewInt(10), nil
+			}
+			return big.NewInt(20000), nil
+		},
+		Supports: func(kind, network, asset, owner string) error {
+			if kind == KindPermit {
+				return errors.New("execution reverted")
+			}
+			return nil
+		},
+		Markup: func(network, asset string, dstEid uint32, facilitator string) (*big.Int, error) {
+			if network == "op-sepolia" {
+				return big.NewInt(500), nil
+			}
+			return big.NewInt(50), nil
+		},
+	}
+}
+
+func TestPlan(t *testing.T) {
+	loadTestSchemes(t)
+	key, _ := crypto.GenerateKey()
+	var balanceCalls atomic.Int32
+
+	usdc := `{"name":"USDC","version":"2"}`
+	plan := testSelector(&balanceCalls).Plan(crypto.PubkeyToAddress(key.PublicKey), []*types.PaymentRequirements{
+		offer("exact", "amoy", testAmoyUSDC, "5000", usdc),
+		offer("exact", "base-sepolia", testAsset, "10000", usdc),
+		offer("exact_EURC", "eip155:11155111", testEURC, "10000", `{"name":"EURC","version":"2"}`),
+		offer("PZ_toBase", "arbitrum-sepolia", testEURSM, "9000", `{"name":"EURSM","version":"1","dstEid":"40245"}`),
+		offer("PZ_toBase", "op-sepolia", testEURSM, "9000", `{"name":"EURSM","version":"1","dstEid":"40245"}`),
+		offer("permit_USDC", "base-sepolia", testAsset, "10000", `{"name":"USDC","version":"2","facilitator":"`+testFacilitator+`"}`),
+		offer("exact_EURS", "base-sepolia", testEmpty, "10000", `{"name":"EURS","version":"1"}`),
+		offer("upto", "base-sepolia", testAsset, "10000", `{}`),
+		offer("exact", "moon", testAsset, "10000", usdc),
+	})
+
+	ranked := []string{}
+	for _, o := range plan.Options {
+		ranked = append(ranked, o.Requirement.Scheme+"@"+o.Network)
+	}
+	if strings.Join(ranked, " ") != "PZ_toBase@arbitrum-sepolia exact@base-sepolia exact_EURC@sepolia exact@amoy" {
+		t.Errorf("wrong ranking: %v", ranked)
+	}
+	if best := plan.Best(); best.Cost != 0.009 || best.Markup.Int64() != 50 || best.Balance.Int64() != 20000 {
+		t.Errorf("wrong best option: %+v", best)
+	}
+	if plan.Options[3].Priced {
+		t.Error("a token without a reference price got priced")
+	}
+
+	reasons := map[string]string{}
+	for _, o := range plan.Rejected {
+		reasons[o.Requirement.Scheme+"@"+o.Requirement.Network] = o.Reason
+	}
+	for option, reason := range map[string]string{
+		"PZ_toBase@op-sepolia":     "markup of 500 is above the 90 allowed",
+		"permit_USDC@base-sepolia": "token does not take permit payments: execution reverted",
+		"exact_EURS@base-sepolia":  "balance of 10 is below 10000",
+		"upto@base-sepolia":        "scheme not supported by the client",
+		"exact@moon":               "unknown network",
+	} {
+		if reasons[option] != reason {
+			t.Errorf("%s rejected for %q, expected %q", option, reasons[option], reason)
+		}
+	}
+	// one balance query per token
+	if n := balanceCalls.Load(); n != 6 {
+		t.Errorf("%v balance queries, expected 6", n)
+	}
+}
+
+func TestTransportSelects(t *testing.T) {
+	loadTestSchemes(t)
+	key, _ := crypto.GenerateKey()
+	payer := evmbinding.NewKeySigner(key)
+	var balanceCalls atomic.Int32
+	usdc := `{"name":"USDC","version":"2"}`
+	accepts := []*types.PaymentRequirements{
+		offer("exact", "amoy", testAmoyUSDC, "5000", usdc),
+		offer("exact", "base-sepolia", testAsset, "10000", usdc),
+	}
+
+	var paidOn string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(X_PAYMENT_HEADER)
+		if header == "" {
+			w.WriteHeader(http.StatusPaymentRequired)
+			json.NewEncoder(w).Encode(map[string]any{"x402Version": 1, "accepts": accepts})
+			return
+		}
+		bts, _ := base64.StdEncoding.DecodeString(header)
+		ppld := new(all712.PaymentPayload)
+		json.Unmarshal(bts, ppld)
+		paidOn = ppld.Network
+	}))
+	defer srv.Close()
+
+	tr := &Transport{Payer: payer, Selector: testSelector(&balanceCalls)}
+	resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if paidOn != "base-sepolia" {
+		t.Errorf("paid on %q instead of the priced option", paidOn)
+	}
+
+	accepts = []*types.PaymentRequirements{offer("exact_EURS", "base-sepolia", testEmpty, "10000", `{"name":"EURS","version":"1"}`)}
+	if _, _, err := tr.pay("", accepts, 1); !errors.Is(err, ErrNoPayableRequirement) || !strings.Contains(err.Error(), "balance of 10") {
+		t.Errorf("expected the rejection reasons, got %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package x402client
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coinbase/x402/go/pkg/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/san-lab/sx402/all712"
+	"github.com/san-lab/sx402/evmbinding"
+)
+
+// Payment kinds the client signs. The requirements do not say which contract call a scheme is,
+// so it goes by the naming of the schemes and what their extra info carries.
+const (
+	KindExact      = "exact"   // EIP-3009 transferWithAuthorization
+	KindPermit     = "permit"  // EIP-2612 permit to extra.facilitator
+	KindPermit2    = "permit2" // Uniswap Permit2 transfer by extra.facilitator, the token approved to Permit2 beforehand
+	KindUpto       = "upto"    // a Permit2 transfer of at most the amount, the resource server settles for what it served
+	KindCrossChain = "PZ"      // cross-chain authorization to extra.dstEid
+)
+
+// Kind of the requirement, "" if the client cannot pay it
+func Kind(req *types.PaymentRequirements) string {
+	extra := extraInfo(req)
+	switch {
+	case strings.HasPrefix(req.Scheme, "PZ_") && len(extra["dstEid"]) > 0:
+		return KindCrossChain
+	case strings.HasPrefix(req.Scheme, "permit2") && common.IsHexAddress(extra["facilitator"]):
+		return KindPermit2
+	case strings.HasPrefix(req.Scheme, "upto") && common.IsHexAddress(extra["facilitator"]):
+		return KindUpto
+	case strings.HasPrefix(req.Scheme, "permit") && common.IsHexAddress(extra["facilitator"]):
+		return KindPermit
+	case strings.HasPrefix(req.Scheme, "exact"):
+		return KindExact
+	}
+	return ""
+}
+
+func extraInfo(req *types.PaymentRequirements) map[string]string {
+	extra := map[string]string{}
+	if req.Extra != nil {
+		json.Unmarshal(*req.Extra, &extra)
+	}
+	return extra
+}
+
+// Sign makes the payload paying the requirement
+func (t *Transport) Sign(req *types.PaymentRequirements) (json.RawMessage, error) {
+	if t.Payer == nil {
+		return nil, fmt.Errorf("no payer key")
+	}
+	network, _ := evmbinding.ResolveNetwork(req.Network)
+	chainID, ok := evmbinding.ChainID(network)
+	if !ok {
+		return nil, fmt.Errorf("unknown network: %s", req.Network)
+	}
+	amount, ok := new(big.Int).SetString(req.MaxAmountRequired, 10)
+	if !ok || amount.Sign() <= 0 {
+		return nil, fmt.Errorf("bad amount: %q", req.MaxAmountRequired)
+	}
+	if !common.IsHexAddress(req.Asset) || !common.IsHexAddress(req.PayTo) {
+		return nil, fmt.Errorf("bad asset or payTo address")
+	}
+	extra := extraInfo(req)
+	domain := &all712.Domain{Name: extra["name"], Version: extra["version"], ChainID: chainID, VerifyingContract: common.HexToAddress(req.Asset)}
+
+	timeout := time.Duration(req.MaxTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	validAfter := big.NewInt(time.Now().Add(-10 * time.Minute).Unix()) // some slack for clocks
+	validBefore := big.NewInt(time.Now().Add(timeout).Unix())
+
+	switch Kind(req) {
+	case KindExact:
+		return t.signExact(domain, req, amount, validAfter, validBefore)
+	case KindPermit:
+		return t.signPermit(domain, network, req, amount, validBefore)
+	case KindPermit2, KindUpto:
+		return t.signPermit2(network, chainID, req, amount, validBefore)
+	case KindCrossChain:
+		return t.signCrossChain(domain, req, amount, validAfter, validBefore)
+	}
+	return nil, fmt.Errorf("unsupported scheme: %s", req.Scheme)
+}
+
+func (t *Transport) signExact(domain *all712.Domain, req *types.PaymentRequirements, amount, validAfter, validBefore *big.Int) (json.RawMessage, error) {
+	nonce, err := randomNonce()
+	if err != nil {
+		return nil, err
+	}
+	to := common.HexToAddress(req.PayTo)
+	digest, err := all712.EIP3009TransferHash(t.Payer.Address(), to, domain.VerifyingContract, amount, validAfter, validBefore,
+		domain.ChainID, nonce, domain.Name, domain.Version)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := t.sign(digest)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(types.ExactEvmPayload{
+		Signature: sig,
+		Authorization: &types.ExactEvmPayloadAuthorization{
+			From:        t.Payer.Address().Hex(),
+			To:          to.Hex(),
+			Value:       amount.String(),
+			ValidAfter:  validAfter.String(),
+			ValidBefore: validBefore.String(),
+			Nonce:       hexutil.Encode(nonce[:]),
+		},
+	})
+}
+
+func (t *Transport) signPermit(domain *all712.Domain, network string, req *types.PaymentRequirements, amount, deadline *big.Int) (json.RawMessage, error) {
+	nonceOf := t.PermitNonce
+	if nonceOf == nil {
+		nonceOf = evmbinding.PermitNonce
+	}
+	nonce, err := nonceOf(network, req.Asset, t.Payer.Address().Hex())
+	if err != nil {
+		return nil, fmt.Errorf("no permit nonce: %w", err)
+	}
+	permit := &all712.PermitMessage{
+		Domain: *domain,
+		Message: all712.ActualPermit{
+			Owner:    t.Payer.Address(),
+			Spender:  common.HexToAddress(extraInfo(req)["facilitator"]),
+			Value:    amount,
+			Deadline: deadline,
+		},
+		Nonce: nonce,
+	}
+	digest, err := permit.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if permit.Signature, err = t.sign(digest); err != nil {
+		return nil, err
+	}
+	return json.Marshal(permit)
+}
+
+func (t *Transport) signPermit2(network string, chainID *big.Int, req *types.PaymentRequirements, amount, deadline *big.Int) (json.RawMessage, error) {
+	permit2, _ := evmbinding.Permit2Address(network)
+	// Permit2 nonces are unordered, a random one is as good as any
+	nonce, err := randomNonce()
+	if err != nil {
+		return nil, err
+	}
+	p2 := &all712.Permit2Message{
+		Domain: all712.Domain{Name: "Permit2", ChainID: chainID, VerifyingContract: permit2},
+		Message: all712.Permit2Transfer{
+			Owner:    t.Payer.Address(),
+			Token:    common.HexToAddress(req.Asset),
+			Amount:   amount,
+			Spender:  common.HexToAddress(extraInfo(req)["facilitator"]),
+			Deadline: deadline,
+			Witness:  all712.PaymentWitness{PayTo: common.HexToAddress(req.PayTo), Resource: req.Resource},
+		},
+		Nonce: new(big.Int).SetBytes(nonce[:]),
+	}
+	digest, err := p2.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if p2.Signature, err = t.sign(digest); err != nil {
+		return nil, err
+	}
+	return json.Marshal(p2)
+}
+
+func (t *Transport) signCrossChain(domain *all712.Domain, req *types.PaymentRequirements, amount, validAfter, validBefore *big.Int) (json.RawMessage, error) {
+	dstEid, err := strconv.ParseUint(extraInfo(req)["dstEid"], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad dstEid: %w", err)
+	}
+	nonce, err := randomNonce()
+	if err != nil {
+		return nil, err
+	}
+	// whatever the facilitator keeps comes out of the amount, the rest is guaranteed on arrival
+	markup := maxMarkup(amount, t.MaxMarkupBps)
+	ccmsg := &all712.CrossChainTransferMessage{
+		Domain: domain,
+		Authorization: &all712.CrossChainTransferAuthorization{
+			From:             t.Payer.Address(),
+			To:               common.HexToAddress(req.PayTo),
+			Amount:           amount,
+			MinimalAmount:    new(big.Int).Sub(amount, markup),
+			DestinationChain: new(big.Int).SetUint64(dstEid),
+			ValidAfter:       validAfter,
+			ValidBefore:      validBefore,
+			Nonce:            hexutil.Encode(nonce[:]),
+		},
+	}
+	digest, err := ccmsg.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if ccmsg.Signature, err = t.sign(digest); err != nil {
+		return nil, err
+	}
+	return json.Marshal(ccmsg)
+}
+
+// sign returns the 0x hex signature with v in {27, 28}, the way the tokens take it
+func (t *Transport) sign(digest []byte) (string, error) {
+	sig, err := t.Payer.SignHash(common.BytesToHash(digest))
+	if err != nil {
+		return "", err
+	}
+	sig[64] += 27
+	return hexutil.Encode(sig), nil
+}
+
+// maxMarkup is the part of the amount the facilitator may keep, bps of 0 meaning 100
+func maxMarkup(amount *big.Int, bps int64) *big.Int {
+	if bps <= 0 {
+		bps = 100
+	}
+	return new(big.Int).Div(new(big.Int).Mul(amount, big.NewInt(bps)), big.NewInt(10000))
+}
+
+func randomNonce() (nonce [32]byte, err error) {
+	_, err = rand.Read(nonce[:])
+	return
+}